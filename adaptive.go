@@ -0,0 +1,90 @@
+package redkeep
+
+import (
+	"sync"
+	"time"
+)
+
+//defaultAdaptiveMaxFactor limits how far batches grow without MaxFactor
+const defaultAdaptiveMaxFactor = 8
+
+//AdaptiveBatchSettings trade latency for throughput while the agent lags.
+//At every watermark with a lag above LagThreshold the watermark interval,
+//and with it the time batch sinks collect events, and the size at which a
+//batch is committed early are doubled, up to MaxFactor (default 8) times
+//their configured values. Once the lag is below half the threshold they
+//are halved again. A zero threshold disables it.
+type AdaptiveBatchSettings struct {
+	LagThreshold Duration `json:"lagThreshold"`
+	MaxFactor    int      `json:"maxFactor" validate:"min=0"`
+}
+
+//adaptiveBatching scales batches by the lag of the agent,
+//all methods can be called on nil
+type adaptiveBatching struct {
+	sync.Mutex
+	settings AdaptiveBatchSettings
+	metrics  *metricRegistry
+	factor   int
+}
+
+func newAdaptiveBatching(settings AdaptiveBatchSettings, metrics *metricRegistry) *adaptiveBatching {
+	if settings.LagThreshold.Duration <= 0 {
+		return nil
+	}
+
+	if settings.MaxFactor == 0 {
+		settings.MaxFactor = defaultAdaptiveMaxFactor
+	}
+
+	metrics.set(MetricBatchFactor, 1)
+	return &adaptiveBatching{settings: settings, metrics: metrics, factor: 1}
+}
+
+//adjust returns the factor for batches at lag
+func (a *adaptiveBatching) adjust(lag time.Duration) int {
+	if a == nil {
+		return 1
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	factor := a.factor
+	switch {
+	case lag > a.settings.LagThreshold.Duration && factor < a.settings.MaxFactor:
+		factor *= 2
+		if factor > a.settings.MaxFactor {
+			factor = a.settings.MaxFactor
+		}
+	case lag < a.settings.LagThreshold.Duration/2 && factor > 1:
+		factor /= 2
+	}
+
+	if factor != a.factor {
+		logInfo("Batches scaled", Fields{"lag": lag, "factor": factor})
+		a.factor = factor
+		a.metrics.set(MetricBatchFactor, float64(factor))
+	}
+
+	return factor
+}
+
+//lag is the current lag of the agent
+func (a *adaptiveBatching) lag() time.Duration {
+	if a == nil {
+		return 0
+	}
+
+	return time.Duration(a.metrics.get(MetricLagSeconds) * float64(time.Second))
+}
+
+//scaleBatches lets the batch sinks commit early at factor times their size
+func (d *sinkDispatcher) scaleBatches(factor int) {
+	d.RLock()
+	defer d.RUnlock()
+	for _, s := range d.sinks {
+		if batch, ok := s.(*batchSink); ok {
+			batch.scale(factor)
+		}
+	}
+}
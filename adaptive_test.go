@@ -0,0 +1,47 @@
+package redkeep_test
+
+import (
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Adaptive batching", func() {
+	It("will grow batches while lagging and shrink them when caught up", func() {
+		settings := AdaptiveBatchSettings{LagThreshold: Duration{time.Minute}, MaxFactor: 4}
+		lags := []time.Duration{
+			2 * time.Minute,
+			2 * time.Minute,
+			2 * time.Minute,
+			45 * time.Second,
+			20 * time.Second,
+			20 * time.Second,
+			20 * time.Second,
+		}
+
+		factors, metric := AdaptiveFactors(settings, lags)
+		Expect(factors).To(Equal([]int{2, 4, 4, 4, 2, 1, 1}))
+		Expect(metric).To(Equal(1.0))
+	})
+
+	It("will not exceed the default factor", func() {
+		settings := AdaptiveBatchSettings{LagThreshold: Duration{time.Second}}
+		factors, metric := AdaptiveFactors(settings, []time.Duration{time.Hour, time.Hour, time.Hour, time.Hour})
+		Expect(factors).To(Equal([]int{2, 4, 8, 8}))
+		Expect(metric).To(Equal(8.0))
+	})
+
+	It("will keep batches without threshold", func() {
+		factors, _ := AdaptiveFactors(AdaptiveBatchSettings{}, []time.Duration{time.Hour})
+		Expect(factors).To(Equal([]int{1}))
+	})
+
+	It("will commit scaled batches later", func() {
+		Expect(BatchMaxEvents(0)).To(Equal(10000))
+		Expect(BatchMaxEvents(1)).To(Equal(10000))
+		Expect(BatchMaxEvents(4)).To(Equal(40000))
+	})
+})
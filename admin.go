@@ -0,0 +1,236 @@
+package redkeep
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//roles of the admin server, every role may do what the ones before may do
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var adminRoles = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+//AdminSettings configures the embedded http admin server,
+//it is disabled as long as Listen is empty.
+//EventLogSize is the number of significant events that are kept.
+//Without Access everybody who reaches Listen may use every endpoint.
+type AdminSettings struct {
+	Listen       string        `json:"listen"`
+	EventLogSize int           `json:"eventLogSize"`
+	TLS          *AdminTLS     `json:"tls"`
+	Access       []AdminAccess `json:"access"`
+}
+
+//AdminTLS serves the admin server with https. Client certificates
+//are optional and verified against ClientCAFile if it is set.
+type AdminTLS struct {
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+//AdminAccess grants a role to requests with the bearer Token or
+//with a client certificate with CommonName. Viewers may read,
+//operators may change the state (like adding tenants) and admins
+//may also use /debug endpoints.
+type AdminAccess struct {
+	Token      string `json:"token"`
+	CommonName string `json:"commonName"`
+	Role       string `json:"role"`
+}
+
+func checkAdminSettings(settings AdminSettings) error {
+	for _, access := range settings.Access {
+		if _, ok := adminRoles[access.Role]; !ok {
+			return fmt.Errorf("Unknown admin role %q, use viewer, operator or admin", access.Role)
+		}
+
+		if access.Token == "" && access.CommonName == "" {
+			return errors.New("Admin access needs a token or a commonName")
+		}
+
+		if access.CommonName != "" && (settings.TLS == nil || settings.TLS.ClientCAFile == "") {
+			return errors.New("Admin access by commonName needs tls with a clientCAFile")
+		}
+	}
+
+	return nil
+}
+
+type adminServer struct {
+	settings AdminSettings
+	mux      *http.ServeMux
+	server   *http.Server
+}
+
+func newAdminServer(settings AdminSettings) *adminServer {
+	return &adminServer{
+		settings: settings,
+		mux:      http.NewServeMux(),
+	}
+}
+
+func (a *adminServer) handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+//requiredRole is the role needed for r, reading needs viewer,
+//changing needs operator and /debug endpoints need admin.
+//GraphQL only reads, queries may be posted. The agents of a
+//supervisor need the same roles below /agents/<name>.
+func requiredRole(r *http.Request) string {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/agents/") {
+		if p := strings.Index(path[len("/agents/"):], "/"); p != -1 {
+			path = path[len("/agents/")+p:]
+		}
+	}
+
+	if strings.HasPrefix(path, "/debug/") {
+		return RoleAdmin
+	}
+
+	graphql := path == "/graphql" || strings.HasPrefix(path, "/graphql/")
+	if r.Method == "GET" || r.Method == "HEAD" || graphql {
+		return RoleViewer
+	}
+
+	return RoleOperator
+}
+
+//authenticate returns the role of the token or the client certificate
+//of r with the highest privileges and who it belongs to
+func (a *adminServer) authenticate(r *http.Request) (string, string) {
+	role, identity := "", ""
+	grant := func(access AdminAccess, name string) {
+		if adminRoles[access.Role] > adminRoles[role] {
+			role, identity = access.Role, name
+		}
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for i, access := range a.settings.Access {
+		if access.Token != "" && subtle.ConstantTimeCompare([]byte(access.Token), []byte(token)) == 1 {
+			grant(access, fmt.Sprintf("token %d", i))
+		}
+
+		if access.CommonName != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 &&
+			r.TLS.VerifiedChains[0][0].Subject.CommonName == access.CommonName {
+			grant(access, "certificate "+access.CommonName)
+		}
+	}
+
+	return role, identity
+}
+
+//ServeHTTP checks the access of r before it is served, changes are logged
+func (a *adminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(a.settings.Access) == 0 {
+		a.mux.ServeHTTP(w, r)
+		return
+	}
+
+	role, identity := a.authenticate(r)
+	if role == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="redkeep"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+		return
+	}
+
+	required := requiredRole(r)
+	if adminRoles[role] < adminRoles[required] {
+		logWarn("Admin request denied", Fields{"method": r.Method, "path": r.URL.Path, "identity": identity})
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Role " + required + " required"})
+		return
+	}
+
+	if required != RoleViewer {
+		logInfo("Admin request", Fields{"method": r.Method, "path": r.URL.Path, "identity": identity})
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+//tlsConfig loads the certificates of the admin server
+func (a *adminServer) tlsConfig() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(a.settings.TLS.CertFile, a.settings.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if a.settings.TLS.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(a.settings.TLS.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in %s", a.settings.TLS.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+//start listens on the configured address and serves in the
+//background, a closed http.Server cannot serve again so every
+//start uses a new one
+func (a *adminServer) start() error {
+	var config *tls.Config
+	if a.settings.TLS != nil {
+		var err error
+		if config, err = a.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", a.settings.Listen)
+	if err != nil {
+		return err
+	}
+
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+
+	logInfo("Admin server listening", Fields{"address": listener.Addr().String()})
+	server := &http.Server{Handler: a}
+	a.server = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logError("Admin server stopped", errorFields(err))
+		}
+	}()
+
+	return nil
+}
+
+func (a *adminServer) stop() {
+	if a.server != nil {
+		a.server.Close()
+		a.server = nil
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,100 @@
+package redkeep_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin server", func() {
+	It("serves again after it was stopped", func() {
+		codes, err := RestartAdminServer(2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(codes).To(Equal([]int{http.StatusOK, http.StatusOK}))
+	})
+})
+
+var _ = Describe("Admin access", func() {
+	handler := AdminHandler(AdminSettings{Access: []AdminAccess{
+		{Token: "view", Role: RoleViewer},
+		{Token: "operate", Role: RoleOperator},
+		{CommonName: "deploy", Role: RoleAdmin},
+	}})
+
+	serve := func(method, path, token string, commonName string) int {
+		request := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		if commonName != "" {
+			certificate := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	It("needs authentication", func() {
+		Expect(serve("GET", "/status", "", "")).To(Equal(http.StatusUnauthorized))
+		Expect(serve("GET", "/status", "wrong", "")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("lets viewers read only", func() {
+		Expect(serve("GET", "/status", "view", "")).To(Equal(http.StatusOK))
+		Expect(serve("POST", "/graphql", "view", "")).To(Equal(http.StatusOK))
+		Expect(serve("POST", "/tenants", "view", "")).To(Equal(http.StatusForbidden))
+		Expect(serve("POST", "/tenants/graphql", "view", "")).To(Equal(http.StatusForbidden))
+		Expect(serve("GET", "/debug/goroutines", "view", "")).To(Equal(http.StatusForbidden))
+	})
+
+	It("needs the same roles for the agents of a supervisor", func() {
+		Expect(serve("POST", "/agents/eu/graphql", "view", "")).To(Equal(http.StatusOK))
+		Expect(serve("POST", "/agents/eu/tenants", "view", "")).To(Equal(http.StatusForbidden))
+		Expect(serve("POST", "/agents/eu/tenants", "operate", "")).To(Equal(http.StatusOK))
+		Expect(serve("GET", "/agents/eu/debug/goroutines", "operate", "")).To(Equal(http.StatusForbidden))
+	})
+
+	It("lets operators change state", func() {
+		Expect(serve("POST", "/tenants", "operate", "")).To(Equal(http.StatusOK))
+		Expect(serve("GET", "/debug/goroutines", "operate", "")).To(Equal(http.StatusForbidden))
+	})
+
+	It("grants roles to verified client certificates", func() {
+		Expect(serve("GET", "/debug/goroutines", "", "deploy")).To(Equal(http.StatusOK))
+		Expect(serve("GET", "/status", "", "intruder")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("rejects unknown roles", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": { "connectionURI": "localhost:30000" },
+			"admin": { "listen": "localhost:8042", "access": [{ "token": "secret", "role": "root" }] },
+			"watches": [{
+				"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+				"targetNormalizedField": "meta", "triggerReference": "user"
+			}]
+		}`))
+		Expect(err).To(MatchError(`Unknown admin role "root", use viewer, operator or admin`))
+	})
+
+	It("needs client certificates for common names", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": { "connectionURI": "localhost:30000" },
+			"admin": { "listen": "localhost:8042", "access": [{ "commonName": "deploy", "role": "admin" }] },
+			"watches": [{
+				"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+				"targetNormalizedField": "meta", "triggerReference": "user"
+			}]
+		}`))
+		Expect(err).To(MatchError("Admin access by commonName needs tls with a clientCAFile"))
+	})
+})
@@ -0,0 +1,119 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//AlertRule notifies if Metric compared with Threshold holds for the
+//duration For. Operator is one of >, >=, <, <=, == and != and defaults
+//to >. With Rate the increase of the metric per evaluation interval
+//is compared. Severity is info, warning or critical (default warning).
+//Message is a format string that receives the value and the threshold.
+type AlertRule struct {
+	Name      string   `json:"name" validate:"required,min=1"`
+	Metric    string   `json:"metric" validate:"required,min=1"`
+	Operator  string   `json:"operator"`
+	Threshold float64  `json:"threshold"`
+	Rate      bool     `json:"rate"`
+	For       Duration `json:"for"`
+	Severity  string   `json:"severity"`
+	Message   string   `json:"message"`
+	Notify    []string `json:"notify" validate:"required,min=1"`
+}
+
+var alertOperators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+var alertSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+//withDefaults fills operator, severity and message
+func (r AlertRule) withDefaults() AlertRule {
+	if r.Operator == "" {
+		r.Operator = ">"
+	}
+
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+
+	if r.Message == "" {
+		r.Message = fmt.Sprintf("%s is %%.0f (%s %%.0f)", r.Metric, r.Operator)
+	}
+
+	return r
+}
+
+func checkAlertRule(r AlertRule) error {
+	r = r.withDefaults()
+	if _, ok := alertOperators[r.Operator]; !ok {
+		return fmt.Errorf("Unknown operator %s in rule %s", r.Operator, r.Name)
+	}
+
+	if !alertSeverities[r.Severity] {
+		return fmt.Errorf("Unknown severity %s in rule %s, use info, warning or critical", r.Severity, r.Name)
+	}
+
+	//fmt marks wrong verbs, missing and extra arguments with %!
+	if strings.Contains(fmt.Sprintf(r.Message, 0.0, 0.0), "%!") {
+		return fmt.Errorf("Message of rule %s needs one verb for the value and one for the threshold, like %%.0f", r.Name)
+	}
+
+	return nil
+}
+
+//conditionRule converts a built-in condition to a rule
+func conditionRule(name string, c ConditionSettings) AlertRule {
+	definition := conditionDefinitions[name]
+	return AlertRule{
+		Name:      name,
+		Metric:    definition.metric,
+		Threshold: c.Threshold,
+		Rate:      definition.growth,
+		Severity:  "critical",
+		Message:   definition.message,
+		Notify:    c.Notify,
+	}
+}
+
+//alertState tracks whether a rule is pending or firing
+type alertState struct {
+	rule    AlertRule
+	since   time.Time
+	pending bool
+	firing  bool
+}
+
+//update evaluates the rule with value and returns true
+//if the rule started firing or got resolved
+func (a *alertState) update(value float64, now time.Time) bool {
+	holds := alertOperators[a.rule.Operator](value, a.rule.Threshold)
+	if !holds {
+		a.pending = false
+		if a.firing {
+			a.firing = false
+			return true
+		}
+
+		return false
+	}
+
+	if !a.pending {
+		a.pending = true
+		a.since = now
+	}
+
+	if !a.firing && now.Sub(a.since) >= a.rule.For.Duration {
+		a.firing = true
+		return true
+	}
+
+	return false
+}
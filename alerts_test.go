@@ -0,0 +1,139 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+//recordingNotifier keeps all notifications it receives
+type recordingNotifier struct {
+	sync.Mutex
+	received []Notification
+}
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.Lock()
+	defer r.Unlock()
+	r.received = append(r.received, n)
+	return nil
+}
+
+var notificationRecorder = &recordingNotifier{}
+
+func init() {
+	RegisterNotifierType("recording", func(options json.RawMessage) (Notifier, error) {
+		return notificationRecorder, nil
+	})
+}
+
+var _ = Describe("Alert rules", func() {
+	transitions := []struct {
+		name        string
+		rule        AlertRule
+		values      []float64
+		transitions []string
+	}{
+		{
+			name:        "fires at once without for",
+			rule:        AlertRule{Metric: "m", Threshold: 10},
+			values:      []float64{5, 11, 12, 10},
+			transitions: []string{"", "firing", "", "resolved"},
+		},
+		{
+			name:        "waits for the duration of for",
+			rule:        AlertRule{Metric: "m", Threshold: 10, For: Duration{Duration: 2 * time.Minute}},
+			values:      []float64{11, 11, 11, 11},
+			transitions: []string{"", "", "firing", ""},
+		},
+		{
+			name:        "restarts for once the value recovered",
+			rule:        AlertRule{Metric: "m", Threshold: 10, For: Duration{Duration: time.Minute}},
+			values:      []float64{11, 9, 11, 11},
+			transitions: []string{"", "", "", "firing"},
+		},
+		{
+			name:        "compares with the operator",
+			rule:        AlertRule{Metric: "m", Operator: "<=", Threshold: 1},
+			values:      []float64{2, 1, 0, 3},
+			transitions: []string{"", "firing", "", "resolved"},
+		},
+		{
+			name:        "does not resolve what never fired",
+			rule:        AlertRule{Metric: "m", Operator: "==", Threshold: 1, For: Duration{Duration: time.Hour}},
+			values:      []float64{1, 0},
+			transitions: []string{"", ""},
+		},
+	}
+
+	for _, t := range transitions {
+		t := t
+		It(t.name, func() {
+			Expect(AlertTransitions(t.rule, t.values, time.Minute)).To(Equal(t.transitions))
+		})
+	}
+
+	messages := []struct {
+		message string
+		valid   bool
+	}{
+		{"lag is %.0f (limit %.0f)", true},
+		{"limit %[2]v exceeded by %[1]v", true},
+		{"lag is high", false},
+		{"lag is %.0f", false},
+		{"lag is %.0f of %.0f after %.0f", false},
+		{"lag is %d (limit %d)", false},
+	}
+
+	for _, m := range messages {
+		m := m
+		It(fmt.Sprintf("checks the verbs of the message %q", m.message), func() {
+			rule := fmt.Sprintf(`"rules": [{ "name": "lagging", "metric": "lag_seconds", "message": %q, "notify": ["ops"] }]`, m.message)
+			_, err := NewConfiguration([]byte(fmt.Sprintf(notificationConfig, rule)))
+			if m.valid {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring("Message of rule lagging")))
+			}
+		})
+	}
+
+	It("notifies when rules fire and resolve", func() {
+		notificationRecorder.Lock()
+		notificationRecorder.received = nil
+		notificationRecorder.Unlock()
+
+		settings := NotificationSettings{
+			Notifiers:  []NotifierConfig{{Name: "rec", Type: "recording"}},
+			Conditions: map[string]ConditionSettings{"lag": {Threshold: 60, Notify: []string{"rec"}}},
+			Rules: []AlertRule{
+				{Name: "failing", Metric: MetricWriteFailures, Rate: true, Threshold: 2, Message: "%.0f failures (limit %.0f)", Notify: []string{"rec"}},
+			},
+		}
+
+		Expect(EvaluateNotifications(settings, []map[string]float64{
+			{MetricLagSeconds: 30, MetricWriteFailures: 1},
+			{MetricLagSeconds: 90, MetricWriteFailures: 5},
+			{MetricLagSeconds: 120, MetricWriteFailures: 6},
+			{MetricLagSeconds: 0, MetricWriteFailures: 6},
+		}, time.Minute)).To(Succeed())
+
+		summary := []string{}
+		for _, n := range notificationRecorder.received {
+			summary = append(summary, fmt.Sprintf("%s %v %s", n.Condition, n.Resolved, n.Message))
+		}
+
+		Expect(summary).To(ConsistOf(
+			"lag false Agent is 90 seconds behind the oplog (threshold 60)",
+			"failing false 4 failures (limit 2)",
+			"failing true Resolved: 1 failures (limit 2)",
+			"lag true Resolved: Agent is 0 seconds behind the oplog (threshold 60)",
+		))
+	})
+})
@@ -0,0 +1,421 @@
+package redkeep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	defaultArchiveMaxEvents     = 10000
+	defaultArchiveFlushInterval = time.Minute
+	//archiveMaxSealedFiles is the number of files kept while
+	//the store is not writable
+	archiveMaxSealedFiles = 10
+)
+
+//ArchiveSinkSettings configures the archival sink. Change events are
+//written as gzip compressed NDJSON files, partitioned by namespace,
+//date and hour of the change:
+//prefix/ns=live.user/date=2016-02-01/hour=13/<first ts>-<sequence>.ndjson.gz
+//Files are never overwritten. Either Directory or S3 must be set.
+type ArchiveSinkSettings struct {
+	Directory     string        `json:"directory"`
+	S3            *ObjectBucket `json:"s3"`
+	Prefix        string        `json:"prefix"`
+	MaxEvents     int           `json:"maxEvents"`
+	FlushInterval Duration      `json:"flushInterval"`
+}
+
+//ObjectBucket is a bucket of a S3 compatible object storage.
+//For google cloud storage use the endpoint https://storage.googleapis.com,
+//region auto and HMAC keys.
+type ObjectBucket struct {
+	Bucket    string `json:"bucket" validate:"required,min=1"`
+	Region    string `json:"region" validate:"required,min=1"`
+	Endpoint  string `json:"endpoint"`
+	AccessKey string `json:"accessKey" validate:"required,min=1"`
+	SecretKey string `json:"secretKey" validate:"required,min=1"`
+}
+
+//objectStore stores immutable objects
+type objectStore interface {
+	put(key string, data []byte) error
+}
+
+type archivePartition struct {
+	key   string
+	name  string
+	first int64
+	lines bytes.Buffer
+	count int
+}
+
+//archiveSink collects partitions under its mutex, full partitions are
+//sealed and written in order while holding only flushing
+type archiveSink struct {
+	sync.Mutex
+	flushing   sync.Mutex
+	settings   ArchiveSinkSettings
+	store      objectStore
+	partitions map[string]*archivePartition
+	sealed     []*archivePartition
+	sequence   int
+	quit       chan bool
+	done       chan bool
+}
+
+func init() {
+	RegisterSinkType("archive", func(options json.RawMessage) (Sink, error) {
+		var settings ArchiveSinkSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewArchiveSink(settings)
+	})
+}
+
+//NewArchiveSink creates a sink that archives all change events
+func NewArchiveSink(settings ArchiveSinkSettings) (Sink, error) {
+	var store objectStore
+	switch {
+	case settings.S3 != nil:
+		validate := validator.New(&validator.Config{TagName: "validate"})
+		if err := validate.Struct(settings.S3); err != nil {
+			return nil, err
+		}
+		store = newS3Store(*settings.S3)
+	case settings.Directory != "":
+		store = fileStore{directory: settings.Directory}
+	default:
+		return nil, fmt.Errorf("Archive sink needs a directory or a s3 bucket")
+	}
+
+	if settings.MaxEvents <= 0 {
+		settings.MaxEvents = defaultArchiveMaxEvents
+	}
+
+	if settings.FlushInterval.Duration <= 0 {
+		settings.FlushInterval.Duration = defaultArchiveFlushInterval
+	}
+
+	sink := &archiveSink{
+		settings:   settings,
+		store:      store,
+		partitions: map[string]*archivePartition{},
+		quit:       make(chan bool),
+		done:       make(chan bool),
+	}
+
+	go sink.flushPeriodically()
+
+	return sink, nil
+}
+
+//partitionKey returns the partition path of the event
+func (s *archiveSink) partitionKey(e ChangeEvent) string {
+	moment := time.Now()
+	if e.Timestamp != 0 {
+		moment = time.Unix(int64(e.Timestamp>>32), 0)
+	}
+	moment = moment.UTC()
+
+	return strings.TrimPrefix(fmt.Sprintf(
+		"%s/ns=%s/date=%s/hour=%02d",
+		strings.Trim(s.settings.Prefix, "/"),
+		e.Namespace,
+		moment.Format("2006-01-02"),
+		moment.Hour(),
+	), "/")
+}
+
+func (s *archiveSink) Send(e ChangeEvent) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	key := s.partitionKey(e)
+	partition, ok := s.partitions[key]
+	if !ok {
+		partition = &archivePartition{key: key, first: int64(e.Timestamp)}
+		s.partitions[key] = partition
+	}
+
+	//a full partition that is still open could not be sealed
+	if partition.count >= s.settings.MaxEvents {
+		s.Unlock()
+		return errSinkFull
+	}
+
+	partition.lines.Write(line)
+	partition.lines.WriteByte('\n')
+	partition.count++
+	sealed := partition.count >= s.settings.MaxEvents && s.seal(partition)
+	s.Unlock()
+
+	if sealed {
+		return s.writeSealed()
+	}
+
+	return nil
+}
+
+//seal closes the partition for new events and names its file,
+//the caller must hold the lock. It returns false if too many
+//files are waiting to be written.
+func (s *archiveSink) seal(p *archivePartition) bool {
+	if len(s.sealed) >= archiveMaxSealedFiles {
+		return false
+	}
+
+	s.sequence++
+	p.name = fmt.Sprintf("%s/%020d-%d-%06d.ndjson.gz", p.key, p.first, time.Now().UnixNano(), s.sequence)
+	s.sealed = append(s.sealed, p)
+	delete(s.partitions, p.key)
+	return true
+}
+
+//writeSealed stores the sealed files in order, a failed
+//file is kept and written again with the same name
+func (s *archiveSink) writeSealed() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	for {
+		s.Lock()
+		if len(s.sealed) == 0 {
+			s.Unlock()
+			return nil
+		}
+		p := s.sealed[0]
+		s.Unlock()
+
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(p.lines.Bytes()); err != nil {
+			return err
+		}
+
+		if err := writer.Close(); err != nil {
+			return err
+		}
+
+		if err := s.store.put(p.name, compressed.Bytes()); err != nil {
+			return err
+		}
+
+		s.Lock()
+		s.sealed = s.sealed[1:]
+		s.Unlock()
+	}
+}
+
+func (s *archiveSink) flush() error {
+	s.Lock()
+	for _, p := range s.partitions {
+		s.seal(p)
+	}
+	s.Unlock()
+
+	return s.writeSealed()
+}
+
+func (s *archiveSink) flushPeriodically() {
+	ticker := time.NewTicker(s.settings.FlushInterval.Duration)
+	defer ticker.Stop()
+	defer close(s.done)
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				logError("Archive could not be written", errorFields(err))
+			}
+		}
+	}
+}
+
+func (s *archiveSink) Close() error {
+	close(s.quit)
+	<-s.done
+	return s.flush()
+}
+
+//fileStore writes objects below a local directory
+type fileStore struct {
+	directory string
+}
+
+func (f fileStore) put(key string, data []byte) error {
+	path := filepath.Join(f.directory, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	temporary := path + ".tmp"
+	if err := ioutil.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
+
+//s3Store writes objects with signature version 4 requests
+type s3Store struct {
+	bucket ObjectBucket
+	client *http.Client
+}
+
+func newS3Store(bucket ObjectBucket) *s3Store {
+	if bucket.Endpoint == "" {
+		bucket.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", bucket.Region)
+	}
+
+	return &s3Store{bucket: bucket, client: &http.Client{Timeout: time.Minute}}
+}
+
+func (s *s3Store) put(key string, data []byte) error {
+	target := strings.TrimRight(s.bucket.Endpoint, "/") + "/" + s3Escape(s.bucket.Bucket+"/"+key)
+	request, err := http.NewRequest("PUT", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	//the object is a gzip file, with Content-Encoding gzip clients
+	//would transparently decompress it on download
+	request.Header.Set("Content-Type", "application/gzip")
+	signS3Request(request, sha256Hex(data), s.bucket.AccessKey, s.bucket.SecretKey, s.bucket.Region, time.Now())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Object storage answered with %s: %s", response.Status, message)
+	}
+
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+//s3Escape encodes a path like S3 expects it, slashes are kept
+func s3Escape(path string) string {
+	var buffer bytes.Buffer
+	for _, b := range []byte(path) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			buffer.WriteByte(b)
+		default:
+			fmt.Fprintf(&buffer, "%%%02X", b)
+		}
+	}
+
+	return buffer.String()
+}
+
+//signS3Request adds an AWS signature version 4 to the request,
+//the host and all set headers are signed
+func signS3Request(r *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": r.URL.Host}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := r.URL.Query()
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	canonicalQuery := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
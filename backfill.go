@@ -0,0 +1,239 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//BackfillSettings configure how backfills read the tracked collections.
+//Snapshot reads every collection at one point in time with the snapshot
+//read concern (MongoDB 5.0 and newer). The server keeps a snapshot for
+//minSnapshotHistoryWindowInSeconds (default 5 minutes), longer backfills
+//fail with SnapshotTooOld. Servers without support fall back to a normal read.
+//ReadPreference is primary (default), primaryPreferred, secondary,
+//secondaryPreferred or nearest. Tags are tag sets like
+//{"workload": "analytics"}, the first set that matches members is used.
+//Documents are read and reported in batches of BatchSize (default 1000).
+type BackfillSettings struct {
+	Snapshot       bool                `json:"snapshot"`
+	ReadPreference string              `json:"readPreference"`
+	Tags           []map[string]string `json:"tags"`
+	BatchSize      int                 `json:"batchSize" validate:"min=0"`
+}
+
+const defaultBackfillBatchSize = 1000
+
+func (s BackfillSettings) batchSize() int {
+	if s.BatchSize <= 0 {
+		return defaultBackfillBatchSize
+	}
+
+	return s.BatchSize
+}
+
+var readPreferences = map[string]mgo.Mode{
+	"":                   mgo.Primary,
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+func checkBackfillSettings(settings BackfillSettings) error {
+	mode, ok := readPreferences[settings.ReadPreference]
+	if !ok {
+		return fmt.Errorf("Unknown read preference %s, use primary, primaryPreferred, secondary, secondaryPreferred or nearest", settings.ReadPreference)
+	}
+
+	if mode == mgo.Primary && len(settings.Tags) > 0 {
+		return errors.New("Backfill tags need a read preference other than primary")
+	}
+
+	return nil
+}
+
+//tagSets converts tags into the tag sets of mgo
+func tagSets(tags []map[string]string) []bson.D {
+	sets := []bson.D{}
+	for _, tag := range tags {
+		set := bson.D{}
+		for _, name := range sortedKeys(tag) {
+			set = append(set, bson.DocElem{Name: name, Value: tag[name]})
+		}
+		sets = append(sets, set)
+	}
+
+	return sets
+}
+
+//backfillSession is a copy of session that reads from
+//the members selected by settings
+func backfillSession(session *mgo.Session, settings BackfillSettings) *mgo.Session {
+	backfill := session.Copy()
+	if mode := readPreferences[settings.ReadPreference]; mode != mgo.Primary {
+		backfill.SetMode(mode, true)
+		backfill.SelectServers(tagSets(settings.Tags)...)
+	}
+
+	return backfill
+}
+
+//backfillIter iterates over all documents of the tracked collection of w,
+//the cluster time of the snapshot is zero without snapshot
+func backfillIter(session *mgo.Session, w Watch, settings BackfillSettings) (*mgo.Iter, bson.MongoTimestamp) {
+	collection := backfillCollection(session, w)
+	if !settings.Snapshot {
+		return collection.Find(nil).Batch(settings.batchSize()).Iter(), 0
+	}
+
+	iter, clusterTime, err := snapshotIter(session, collection, bson.D{{Name: "batchSize", Value: settings.batchSize()}})
+	if err != nil {
+		logWarn("Snapshot read not supported, reading without snapshot", watchFields(w).withError(err))
+		return collection.Find(nil).Batch(settings.batchSize()).Iter(), 0
+	}
+
+	return iter, clusterTime
+}
+
+//snapshotIter finds all documents of collection with the snapshot read
+//concern and returns the cluster time of the snapshot. options like
+//projection or sort are added to the find command.
+func snapshotIter(session *mgo.Session, collection *mgo.Collection, options bson.D) (*mgo.Iter, bson.MongoTimestamp, error) {
+	var result struct {
+		Cursor struct {
+			ID            int64               `bson:"id"`
+			FirstBatch    []bson.Raw          `bson:"firstBatch"`
+			AtClusterTime bson.MongoTimestamp `bson:"atClusterTime"`
+		} `bson:"cursor"`
+	}
+	command := append(bson.D{{Name: "find", Value: collection.Name}}, options...)
+	command = append(command, bson.DocElem{Name: "readConcern", Value: bson.M{"level": "snapshot"}})
+	if err := collection.Database.Run(command, &result); err != nil {
+		return nil, 0, err
+	}
+
+	return collection.NewIter(session, result.Cursor.FirstBatch, result.Cursor.ID, nil), result.Cursor.AtClusterTime, nil
+}
+
+func backfillCollection(session *mgo.Session, w Watch) *mgo.Collection {
+	p := strings.Index(w.TrackCollection, ".")
+	return session.DB(w.TrackCollection[:p]).C(w.TrackCollection[p+1:])
+}
+
+//errNotTailing stops backfills whose documents are handled by the
+//workers of an agent that stopped tailing
+var errNotTailing = errors.New("The agent is not tailing")
+
+//backfill writes the tracked fields of all existing documents of
+//watches to the targets, as if every document was updated. Progress is
+//reported after every batch in the backfill metrics. It stops when ctx
+//is done, a failed watch does not stop the others, the first error is
+//returned once all watches were backfilled. Without submit the
+//documents are written right away, otherwise submit hands the update
+//entry of every document to the workers, one batch at a time.
+func (t *TailAgent) backfill(ctx context.Context, watches []Watch, submit func(entry map[string]interface{}, job func(Tracker)) bool) error {
+	session := backfillSession(t.session, t.config.Backfill)
+	defer session.Close()
+
+	var failed error
+	batchSize := t.config.Backfill.batchSize()
+	for _, w := range watches {
+		total, err := backfillCollection(session, w).Count()
+		if err != nil {
+			logWarn("Documents not counted", watchFields(w).withError(err))
+		}
+		t.metrics.add(MetricBackfillTotal, float64(total))
+		t.events.record(EventLifecycle, w.Key(), fmt.Sprintf("Backfill of about %d documents started", total))
+		end := t.lineage.backfill(w)
+
+		iter, clusterTime := backfillIter(session, w, t.config.Backfill)
+		count := 0
+		pending := &sync.WaitGroup{}
+		document := map[string]interface{}{}
+		for iter.Next(&document) {
+			command, selector := backfillCommand(w, document), map[string]interface{}{"_id": document["_id"]}
+			if submit == nil {
+				t.tracker.HandleUpdate(w, command, selector)
+			} else {
+				pending.Add(1)
+				entry := map[string]interface{}{"op": "u", "ns": w.TrackCollection, "o2": selector, "o": command}
+				if !submit(entry, func(tracker Tracker) {
+					defer pending.Done()
+					tracker.HandleUpdate(w, command, selector)
+				}) {
+					pending.Done()
+					pending.Wait()
+					iter.Close()
+					end(errNotTailing)
+					return errNotTailing
+				}
+			}
+			document = map[string]interface{}{}
+			count++
+			if count%batchSize != 0 {
+				continue
+			}
+
+			pending.Wait()
+			t.metrics.add(MetricBackfillDocuments, float64(batchSize))
+			logInfo("Backfill progress", Fields{"watch": w.Key(), "documents": count, "total": total})
+			if ctx.Err() != nil {
+				iter.Close()
+				end(ctx.Err())
+				return ctx.Err()
+			}
+		}
+
+		pending.Wait()
+		//the count is an estimate, the totals match once it is done
+		t.metrics.add(MetricBackfillDocuments, float64(count%batchSize))
+		t.metrics.add(MetricBackfillTotal, float64(count-total))
+
+		if err := iter.Close(); err != nil {
+			end(err)
+			t.events.record(EventError, w.Key(), "Backfill failed: "+err.Error())
+			if failed == nil {
+				failed = fmt.Errorf("Backfill of %s failed: %s", w.Key(), err.Error())
+			}
+			continue
+		}
+
+		end(nil)
+		t.events.record(EventLifecycle, w.Key(), backfillDone(count, clusterTime))
+	}
+
+	return failed
+}
+
+//backfillAll backfills all watches and returns the newest oplog entry
+//before the backfill, tailing starts there so the changes during the
+//backfill are applied after it
+func (t *TailAgent) backfillAll(ctx context.Context, session *mgo.Session) (bson.MongoTimestamp, error) {
+	handoff, err := newestOplogEntry(session.DB("local").C("oplog.rs"))
+	if err != nil {
+		return 0, fmt.Errorf("Backfill needs the oplog to start tailing after it: %s", err.Error())
+	}
+
+	if err := t.backfill(ctx, t.watches.list(), nil); err != nil {
+		return 0, err
+	}
+
+	t.events.record(EventLifecycle, "", fmt.Sprintf("Backfill done, tailing from %d", handoff))
+	return handoff, nil
+}
+
+//backfillDone describes a finished backfill for the event log
+func backfillDone(count int, clusterTime bson.MongoTimestamp) string {
+	if clusterTime == 0 {
+		return fmt.Sprintf("Backfill of %d documents done", count)
+	}
+
+	return fmt.Sprintf("Backfill of %d documents at cluster time %d done", count, clusterTime)
+}
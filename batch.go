@@ -0,0 +1,144 @@
+package redkeep
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//defaultBatchInterval is the watermark interval of agents with
+	//batch sinks and without a configured interval
+	defaultBatchInterval = 10 * time.Second
+	//batchMaxEvents is the size at which a batch is written before
+	//the next watermark
+	batchMaxEvents = 10000
+	//batchMaxKept times the batch size is kept while the batch fails,
+	//further events are dropped
+	batchMaxKept = 10
+)
+
+//BatchSink is implemented by sinks that write change events in
+//transactions. Instead of Send they get micro batches: Begin, Add for
+//every event and Commit once all events up to the next watermark were
+//added. If Add or Commit fail the batch is ended with Rollback and all
+//its events are added again to the next batch. Watermarks, and with
+//them checkpoints, only advance after every batch sink committed.
+type BatchSink interface {
+	Sink
+	Begin() error
+	Add(e ChangeEvent) error
+	Commit() error
+	Rollback() error
+}
+
+//batchSink collects the events for a BatchSink until they are committed
+type batchSink struct {
+	sync.Mutex
+	sink    BatchSink
+	events  []ChangeEvent
+	metrics *metricRegistry
+	//factor scales batchMaxEvents, see AdaptiveBatchSettings
+	factor int
+	//failed is set while the last commit failed, the batch
+	//is then only retried with the watermarks
+	failed bool
+}
+
+//scale lets the batch grow to factor times batchMaxEvents
+func (b *batchSink) scale(factor int) {
+	b.Lock()
+	defer b.Unlock()
+	b.factor = factor
+}
+
+//maxEvents is the size at which the batch is committed early, b is locked
+func (b *batchSink) maxEvents() int {
+	if b.factor > 1 {
+		return batchMaxEvents * b.factor
+	}
+
+	return batchMaxEvents
+}
+
+//Send keeps e for the next batch. A full batch is committed early, once
+//that failed it is only retried with the next watermark, so Send does not
+//wait for a sink that is down. Failed batches keep up to batchMaxKept times
+//their size, further events are dropped and hold back the watermarks.
+func (b *batchSink) Send(e ChangeEvent) error {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.events) >= b.maxEvents() && !b.failed {
+		if err := b.commit(); err != nil {
+			logWarn("Batch could not be committed, events kept", Fields{"events": len(b.events)}.withError(err))
+		}
+	}
+
+	if len(b.events) >= b.maxEvents()*batchMaxKept {
+		return errSinkFull
+	}
+
+	b.events = append(b.events, e)
+	return nil
+}
+
+//commit writes the collected events as one batch, b is locked
+func (b *batchSink) commit() error {
+	if len(b.events) == 0 {
+		return nil
+	}
+
+	if err := b.write(); err != nil {
+		b.failed = true
+		b.metrics.add(MetricBatchFailures, 1)
+		if rollback := b.sink.Rollback(); rollback != nil {
+			logError("Batch could not be rolled back", errorFields(rollback))
+		}
+
+		return err
+	}
+
+	b.events = nil
+	b.failed = false
+	return nil
+}
+
+func (b *batchSink) write() error {
+	if err := b.sink.Begin(); err != nil {
+		return err
+	}
+
+	for _, e := range b.events {
+		if err := b.sink.Add(e); err != nil {
+			return err
+		}
+	}
+
+	return b.sink.Commit()
+}
+
+//flush commits the collected events before a watermark
+func (b *batchSink) flush() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.commit()
+}
+
+//Watermark passes ts to the sink if it wants watermarks as well
+func (b *batchSink) Watermark(ts bson.MongoTimestamp) error {
+	if sink, ok := b.sink.(WatermarkSink); ok {
+		return sink.Watermark(ts)
+	}
+
+	return nil
+}
+
+//Close commits the last events and closes the sink
+func (b *batchSink) Close() error {
+	if err := b.flush(); err != nil {
+		logError("Batch could not be committed", Fields{"events": len(b.events)}.withError(err))
+	}
+
+	return b.sink.Close()
+}
@@ -0,0 +1,127 @@
+package redkeep_test
+
+import (
+	"errors"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//batchRecorder records the calls of a batch sink, commits fail while failing is set
+type batchRecorder struct {
+	watermarkRecorder
+	calls   []string
+	failing bool
+}
+
+func (r *batchRecorder) Begin() error {
+	r.calls = append(r.calls, "begin")
+	return nil
+}
+
+func (r *batchRecorder) Add(e ChangeEvent) error {
+	r.calls = append(r.calls, "add "+e.ID.(string))
+	return nil
+}
+
+func (r *batchRecorder) Commit() error {
+	if r.failing {
+		return errors.New("Deadlock detected")
+	}
+
+	r.calls = append(r.calls, "commit")
+	return nil
+}
+
+func (r *batchRecorder) Rollback() error {
+	r.calls = append(r.calls, "rollback")
+	return nil
+}
+
+var _ = Describe("Batch sinks", func() {
+	It("commit the events before the watermark", func() {
+		recorder := &batchRecorder{}
+		tracker := NewWatermarkTracker(recorder)
+
+		tracker.Begin(10)
+		tracker.Send(ChangeEvent{ID: "1"})
+		tracker.Send(ChangeEvent{ID: "2"})
+		tracker.End(10)
+		Expect(recorder.calls).To(BeEmpty())
+
+		tracker.Emit()
+		Expect(recorder.calls).To(Equal([]string{"begin", "add 1", "add 2", "commit"}))
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{10}))
+
+		tracker.Emit()
+		Expect(recorder.calls).To(HaveLen(4))
+	})
+
+	It("hold back the watermark until a failed batch was committed", func() {
+		recorder := &batchRecorder{failing: true}
+		tracker := NewWatermarkTracker(recorder)
+
+		tracker.Begin(10)
+		tracker.Send(ChangeEvent{ID: "1"})
+		tracker.End(10)
+		tracker.Emit()
+		Expect(recorder.calls).To(Equal([]string{"begin", "add 1", "rollback"}))
+		Expect(recorder.watermarks).To(BeEmpty())
+
+		recorder.failing = false
+		recorder.calls = nil
+		tracker.Emit()
+		Expect(recorder.calls).To(Equal([]string{"begin", "add 1", "commit"}))
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{10}))
+	})
+
+	It("keep the events of full batches that failed", func() {
+		recorder := &batchRecorder{failing: true}
+		tracker := NewWatermarkTracker(recorder)
+
+		tracker.Begin(10)
+		for i := 0; i <= BatchMaxEvents(1); i++ {
+			tracker.Send(ChangeEvent{ID: "1"})
+		}
+		tracker.End(10)
+		tracker.Emit()
+		Expect(recorder.watermarks).To(BeEmpty())
+
+		recorder.failing = false
+		recorder.calls = nil
+		tracker.Emit()
+		Expect(recorder.calls).To(HaveLen(BatchMaxEvents(1) + 3))
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{10}))
+	})
+
+	It("retry failed batches only with the watermark and keep a bounded number of events", func() {
+		recorder := &batchRecorder{failing: true}
+		tracker := NewWatermarkTracker(recorder)
+
+		tracker.Begin(10)
+		for i := 0; i <= BatchMaxEvents(1); i++ {
+			tracker.Send(ChangeEvent{ID: "1", Timestamp: 10})
+		}
+		Expect(recorder.calls).To(HaveLen(BatchMaxEvents(1) + 2))
+
+		recorder.calls = nil
+		for i := BatchMaxEvents(1) + 1; i < BatchMaxEvents(1)*BatchMaxKept; i++ {
+			tracker.Send(ChangeEvent{ID: "1", Timestamp: 10})
+		}
+		tracker.End(10)
+		Expect(recorder.calls).To(BeEmpty())
+
+		tracker.Begin(11)
+		tracker.Send(ChangeEvent{ID: "2", Timestamp: 11})
+		tracker.End(11)
+
+		recorder.failing = false
+		tracker.Emit()
+		Expect(recorder.calls).To(HaveLen(BatchMaxEvents(1)*BatchMaxKept + 2))
+		Expect(recorder.calls).ToNot(ContainElement("add 2"))
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{10}))
+	})
+})
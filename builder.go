@@ -0,0 +1,178 @@
+package redkeep
+
+import "time"
+
+//ConfigBuilder creates a Configuration in code, Build checks it like
+//NewConfiguration checks configuration files
+//  config, err := redkeep.NewConfig().
+//  	Mongo("localhost:27017").
+//  	AddWatch(redkeep.Watch{...}).
+//  	Build()
+type ConfigBuilder struct {
+	config Configuration
+}
+
+//NewConfig starts an empty configuration
+func NewConfig() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+//Mongo sets the connection uri of the cluster
+func (b *ConfigBuilder) Mongo(connectionURI string) *ConfigBuilder {
+	b.config.Mongo.ConnectionURI = connectionURI
+	return b
+}
+
+//Router sends the writes to targets through the mongos routers of routerURI
+func (b *ConfigBuilder) Router(routerURI string) *ConfigBuilder {
+	b.config.Mongo.Router = routerURI
+	return b
+}
+
+//RetryWrites sends writes that failed with a transient error once more
+func (b *ConfigBuilder) RetryWrites() *ConfigBuilder {
+	b.config.Mongo.RetryWrites = true
+	return b
+}
+
+//Retry sends failed writes to targets again with policy
+func (b *ConfigBuilder) Retry(policy RetryPolicy) *ConfigBuilder {
+	b.config.Retry = &policy
+	return b
+}
+
+//AddWatch adds a watch
+func (b *ConfigBuilder) AddWatch(w Watch) *ConfigBuilder {
+	b.config.Watches = append(b.config.Watches, w)
+	return b
+}
+
+//AddSink adds a sink that receives the change events
+func (b *ConfigBuilder) AddSink(s SinkConfig) *ConfigBuilder {
+	b.config.Sinks = append(b.config.Sinks, s)
+	return b
+}
+
+//AddPlugin adds the path of a plugin to load
+func (b *ConfigBuilder) AddPlugin(path string) *ConfigBuilder {
+	b.config.Plugins = append(b.config.Plugins, path)
+	return b
+}
+
+//Tenant limits the writes of the watches of tenant
+func (b *ConfigBuilder) Tenant(tenant string, settings TenantSettings) *ConfigBuilder {
+	if b.config.Tenants == nil {
+		b.config.Tenants = map[string]TenantSettings{}
+	}
+
+	b.config.Tenants[tenant] = settings
+	return b
+}
+
+//AddTenantWatch adds a template for the watches of tenants
+func (b *ConfigBuilder) AddTenantWatch(w Watch) *ConfigBuilder {
+	b.config.TenantWatches = append(b.config.TenantWatches, w)
+	return b
+}
+
+//Admin configures the admin server
+func (b *ConfigBuilder) Admin(settings AdminSettings) *ConfigBuilder {
+	b.config.Admin = settings
+	return b
+}
+
+//Notifications configures the notifications
+func (b *ConfigBuilder) Notifications(settings NotificationSettings) *ConfigBuilder {
+	b.config.Notifications = settings
+	return b
+}
+
+//Diagnostics configures the capture of unknown operations
+func (b *ConfigBuilder) Diagnostics(settings DiagnosticsSettings) *ConfigBuilder {
+	b.config.Diagnostics = settings
+	return b
+}
+
+//PendingTargets limits the updates queued for missing targets
+func (b *ConfigBuilder) PendingTargets(retention RetentionSettings) *ConfigBuilder {
+	b.config.PendingTargets = retention
+	return b
+}
+
+//Verify configures the verification of writes
+func (b *ConfigBuilder) Verify(settings VerifySettings) *ConfigBuilder {
+	b.config.Verify = settings
+	return b
+}
+
+//ShutdownTimeout sets the time every subsystem gets to stop
+func (b *ConfigBuilder) ShutdownTimeout(timeout time.Duration) *ConfigBuilder {
+	b.config.ShutdownTimeout = Duration{timeout}
+	return b
+}
+
+//Workers bounds the goroutines that handle oplog entries
+func (b *ConfigBuilder) Workers(count, queue int) *ConfigBuilder {
+	b.config.Workers = WorkerSettings{Count: count, Queue: queue}
+	return b
+}
+
+//Source sets where changes are read from, oplog or changestream
+func (b *ConfigBuilder) Source(source string) *ConfigBuilder {
+	b.config.Source = source
+	return b
+}
+
+//Checkpoint persists the position in the oplog
+func (b *ConfigBuilder) Checkpoint(settings CheckpointSettings) *ConfigBuilder {
+	b.config.Checkpoint = settings
+	return b
+}
+
+//Metadata places the internal collections, see MetadataSettings
+func (b *ConfigBuilder) Metadata(settings MetadataSettings) *ConfigBuilder {
+	b.config.Metadata = settings
+	return b
+}
+
+//WatermarkInterval sets how often watermarks are sent to sinks
+func (b *ConfigBuilder) WatermarkInterval(interval time.Duration) *ConfigBuilder {
+	b.config.WatermarkInterval = Duration{interval}
+	return b
+}
+
+//CatchUp sets how the oplog since the start is handled, see CatchUpNewestFirst
+func (b *ConfigBuilder) CatchUp(catchUp string) *ConfigBuilder {
+	b.config.CatchUp = catchUp
+	return b
+}
+
+//Ordering sets how oplog entries are ordered, see OrderingStrict
+func (b *ConfigBuilder) Ordering(ordering string) *ConfigBuilder {
+	b.config.Ordering = ordering
+	return b
+}
+
+//Build checks the configuration and returns a copy of it,
+//the builder can be changed and built again afterwards
+func (b *ConfigBuilder) Build() (*Configuration, error) {
+	config := b.config
+	config.Watches = append([]Watch{}, b.config.Watches...)
+	config.Sinks = append([]SinkConfig{}, b.config.Sinks...)
+	config.Plugins = append([]string{}, b.config.Plugins...)
+	config.TenantWatches = append([]Watch{}, b.config.TenantWatches...)
+	config.Tenants = map[string]TenantSettings{}
+	for name, settings := range b.config.Tenants {
+		config.Tenants[name] = settings
+	}
+
+	fieldMapTrackFields(config.Watches)
+	fieldMapTrackFields(config.TenantWatches)
+	metadataCollections(&config)
+
+	if err := validateConfiguration(config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,73 @@
+package redkeep_test
+
+import (
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Configuration builder", func() {
+	watch := Watch{
+		TrackCollection:       "application.user",
+		TrackFields:           []string{"name", "username"},
+		TargetCollection:      "application.comment",
+		TargetNormalizedField: "user",
+		TriggerReference:      "user",
+	}
+
+	It("builds a configuration like a configuration file", func() {
+		config, err := NewConfig().
+			Mongo("localhost:27017").
+			Router("mongos:27017").
+			RetryWrites().
+			AddWatch(watch).
+			Tenant("shop", TenantSettings{RateLimit: 10}).
+			ShutdownTimeout(5 * time.Second).
+			CatchUp(CatchUpNewestFirst).
+			Build()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Mongo).To(Equal(Mongo{ConnectionURI: "localhost:27017", Router: "mongos:27017", RetryWrites: true}))
+		Expect(config.Watches).To(Equal([]Watch{watch}))
+		Expect(config.Tenants["shop"].RateLimit).To(Equal(10.0))
+		Expect(config.ShutdownTimeout.Duration).To(Equal(5 * time.Second))
+	})
+
+	It("checks the configuration like NewConfiguration", func() {
+		_, err := NewConfig().Mongo("localhost:27017").Build()
+		Expect(err).To(MatchError("Please add atleast one entry in watches"))
+
+		_, err = NewConfig().AddWatch(watch).Build()
+		Expect(err).To(MatchError("Mongo configuration must be defined"))
+
+		_, err = NewConfig().Mongo("localhost:27017").AddWatch(watch).AddSink(SinkConfig{Type: "unknown"}).Build()
+		Expect(err).To(MatchError("Unknown sink type unknown"))
+	})
+
+	It("tracks the fields of field maps like NewConfiguration", func() {
+		mapped := watch
+		mapped.TrackFields = nil
+		mapped.FieldMap = map[string]string{"username": "userName", "name": "userFullName"}
+		template := mapped
+		template.TrackCollection = "{tenant}.user"
+		template.TargetCollection = "{tenant}.comment"
+
+		config, err := NewConfig().Mongo("localhost:27017").AddWatch(mapped).AddTenantWatch(template).Build()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Watches[0].TrackFields).To(Equal([]string{"name", "username"}))
+		Expect(config.TenantWatches[0].TrackFields).To(Equal([]string{"name", "username"}))
+		Expect(mapped.TrackFields).To(BeNil())
+	})
+
+	It("does not change built configurations", func() {
+		builder := NewConfig().Mongo("localhost:27017").AddWatch(watch)
+		first, err := builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+
+		builder.AddWatch(watch)
+		Expect(first.Watches).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,188 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//FieldsTransform is a transform that needs all of its Fields (target
+//names) to compute its result. Updates only carry the changed fields,
+//the missing ones are read from the tracked document before it runs.
+type FieldsTransform interface {
+	Transform
+	Fields() []string
+}
+
+//BuiltinTransformOptions are the options of the builtin transforms.
+//Fields limits lowercase, truncate and dateFormat to some fields, they
+//change all fields without. concat joins Fields with Separator into
+//Target and drops them unless Keep is set, truncate cuts strings after
+//Length characters, dateFormat writes dates with the Go Layout in
+//Location (default UTC).
+type BuiltinTransformOptions struct {
+	Fields    []string `json:"fields"`
+	Length    int      `json:"length"`
+	Layout    string   `json:"layout"`
+	Location  string   `json:"location"`
+	Separator string   `json:"separator"`
+	Target    string   `json:"target"`
+	Keep      bool     `json:"keep"`
+}
+
+func init() {
+	RegisterTransformType("lowercase", func(options json.RawMessage) (Transform, error) {
+		o, err := builtinTransformOptions(options)
+		if err != nil {
+			return nil, err
+		}
+
+		return stringTransform{fields: o.Fields, change: strings.ToLower}, nil
+	})
+
+	RegisterTransformType("truncate", func(options json.RawMessage) (Transform, error) {
+		o, err := builtinTransformOptions(options)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.Length <= 0 {
+			return nil, errors.New("Truncate needs a length")
+		}
+
+		return stringTransform{fields: o.Fields, change: func(s string) string {
+			if runes := []rune(s); len(runes) > o.Length {
+				return string(runes[:o.Length])
+			}
+			return s
+		}}, nil
+	})
+
+	RegisterTransformType("dateFormat", func(options json.RawMessage) (Transform, error) {
+		o, err := builtinTransformOptions(options)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.Layout == "" {
+			return nil, errors.New("Date formats need a layout")
+		}
+
+		location := time.UTC
+		if o.Location != "" {
+			if location, err = time.LoadLocation(o.Location); err != nil {
+				return nil, err
+			}
+		}
+
+		return dateFormatTransform{fields: o.Fields, layout: o.Layout, location: location}, nil
+	})
+
+	RegisterTransformType("concat", func(options json.RawMessage) (Transform, error) {
+		o, err := builtinTransformOptions(options)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(o.Fields) == 0 || o.Target == "" {
+			return nil, errors.New("Concat needs fields and a target")
+		}
+
+		return concatTransform{fields: o.Fields, separator: o.Separator, target: o.Target, keep: o.Keep}, nil
+	})
+}
+
+func builtinTransformOptions(options json.RawMessage) (BuiltinTransformOptions, error) {
+	var o BuiltinTransformOptions
+	if options == nil {
+		return o, nil
+	}
+
+	err := json.Unmarshal(options, &o)
+	return o, err
+}
+
+//selected is true if field is one of fields or fields is empty
+func selected(fields []string, field string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+//stringTransform changes the string values of its fields
+type stringTransform struct {
+	fields []string
+	change func(string) string
+}
+
+func (t stringTransform) Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error) {
+	for field, value := range fields {
+		if s, ok := value.(string); ok && selected(t.fields, field) {
+			fields[field] = t.change(s)
+		}
+	}
+
+	return fields, nil
+}
+
+//dateFormatTransform writes the dates of its fields as strings
+type dateFormatTransform struct {
+	fields   []string
+	layout   string
+	location *time.Location
+}
+
+func (t dateFormatTransform) Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error) {
+	for field, value := range fields {
+		if date, ok := value.(time.Time); ok && selected(t.fields, field) {
+			fields[field] = date.In(t.location).Format(t.layout)
+		}
+	}
+
+	return fields, nil
+}
+
+//concatTransform joins its fields into target, missing and nil fields
+//are left out
+type concatTransform struct {
+	fields    []string
+	separator string
+	target    string
+	keep      bool
+}
+
+func (t concatTransform) Fields() []string {
+	return t.fields
+}
+
+func (t concatTransform) Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error) {
+	parts := []string{}
+	found := false
+	for _, field := range t.fields {
+		value, ok := fields[field]
+		found = found || ok
+		if !t.keep {
+			delete(fields, field)
+		}
+
+		if value != nil {
+			parts = append(parts, fmt.Sprint(value))
+		}
+	}
+
+	if found {
+		fields[t.target] = strings.Join(parts, t.separator)
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,191 @@
+package redkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//CacheInvalidationSettings invalidate the caches of applications after
+//redkeep wrote denormalized fields to targets. Every write is published as
+//CacheInvalidationMessage on Channel and/or the Keys are deleted. Keys are
+//templates like "user:{{._id}}" with the fields _id (the tracked document),
+//target (the target document if only one was written), watch, db and
+//collection (of the targets). Watches limits it to some watches.
+type CacheInvalidationSettings struct {
+	Redis   RedisSettings `json:"redis"`
+	Channel string        `json:"channel"`
+	Keys    []string      `json:"keys"`
+	Watches []string      `json:"watches"`
+}
+
+//CacheInvalidationMessage tells that the targets of a tracked document
+//with ID in Namespace were written. Target is set if only one target
+//was written, Fields are the written fields of the targets.
+type CacheInvalidationMessage struct {
+	Watch     string      `json:"watch"`
+	Namespace string      `json:"ns"`
+	ID        interface{} `json:"id"`
+	Target    interface{} `json:"target,omitempty"`
+	Fields    []string    `json:"fields,omitempty"`
+}
+
+func checkCacheInvalidationSettings(settings *CacheInvalidationSettings) error {
+	if settings == nil {
+		return nil
+	}
+
+	if settings.Channel == "" && len(settings.Keys) == 0 {
+		return errors.New("Cache invalidation needs a channel or keys")
+	}
+
+	_, err := parseCacheKeys(settings.Keys)
+	return err
+}
+
+//parseCacheKeys parses the key templates, fields they do not know fail
+func parseCacheKeys(keys []string) ([]*template.Template, error) {
+	templates := []*template.Template{}
+	for _, key := range keys {
+		parsed, err := template.New("key").Option("missingkey=error").Parse(key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cache key %q: %s", key, err.Error())
+		}
+
+		if err := parsed.Execute(&bytes.Buffer{}, cacheKeyData(Watch{TargetCollection: "db.collection"}, "", "")); err != nil {
+			return nil, fmt.Errorf("Invalid cache key %q: %s", key, err.Error())
+		}
+
+		templates = append(templates, parsed)
+	}
+
+	return templates, nil
+}
+
+//cacheKeyData are the fields of the key templates, target is empty if
+//many targets were written
+func cacheKeyData(w Watch, id, target interface{}) map[string]interface{} {
+	data := map[string]interface{}{"_id": idString(id), "target": "", "watch": w.Key(), "db": w.TargetCollection, "collection": ""}
+	if target != nil {
+		data["target"] = idString(target)
+	}
+
+	if p := strings.Index(w.TargetCollection, "."); p >= 0 {
+		data["db"], data["collection"] = w.TargetCollection[:p], w.TargetCollection[p+1:]
+	}
+
+	return data
+}
+
+//cacheInvalidation publishes and deletes keys after writes to targets,
+//all methods can be called on nil
+type cacheInvalidation struct {
+	settings CacheInvalidationSettings
+	redis    *redisClient
+	keys     []*template.Template
+	watches  map[string]bool
+	metrics  *metricRegistry
+}
+
+//newCacheInvalidation returns nil without settings, the settings
+//were checked with the configuration
+func newCacheInvalidation(settings *CacheInvalidationSettings, metrics *metricRegistry) *cacheInvalidation {
+	if settings == nil {
+		return nil
+	}
+
+	keys, _ := parseCacheKeys(settings.Keys)
+	c := &cacheInvalidation{settings: *settings, redis: newRedisClient(settings.Redis), keys: keys, metrics: metrics}
+	if len(settings.Watches) > 0 {
+		c.watches = map[string]bool{}
+		for _, key := range settings.Watches {
+			c.watches[key] = true
+		}
+	}
+
+	return c
+}
+
+//invalidate tells the caches that update was written to the targets of
+//the tracked document id of w, target if only one target was written.
+//Failures are counted and logged, the write itself succeeded.
+func (c *cacheInvalidation) invalidate(w Watch, id, target interface{}, update bson.M) {
+	if c == nil || (c.watches != nil && !c.watches[w.Key()]) {
+		return
+	}
+
+	if err := c.send(w, id, target, update); err != nil {
+		c.metrics.add(MetricCacheInvalidationFailures, 1)
+		logWarn("Cache could not be invalidated", watchFields(w).withError(err))
+		return
+	}
+
+	c.metrics.add(MetricCacheInvalidations, 1)
+}
+
+func (c *cacheInvalidation) send(w Watch, id, target interface{}, update bson.M) error {
+	if c.settings.Channel != "" {
+		message := CacheInvalidationMessage{Watch: w.Key(), Namespace: w.TargetCollection, ID: id, Target: target}
+		for _, fields := range update {
+			if fields, ok := fields.(bson.M); ok {
+				for field := range fields {
+					message.Fields = append(message.Fields, field)
+				}
+			}
+		}
+		sort.Strings(message.Fields)
+
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.redis.Do("PUBLISH", c.settings.Channel, string(data)); err != nil {
+			return err
+		}
+	}
+
+	if len(c.keys) == 0 {
+		return nil
+	}
+
+	command := []string{"DEL"}
+	data := cacheKeyData(w, id, target)
+	for _, key := range c.keys {
+		var resolved bytes.Buffer
+		if err := key.Execute(&resolved, data); err != nil {
+			return err
+		}
+		command = append(command, resolved.String())
+	}
+
+	_, err := c.redis.Do(command...)
+	return err
+}
+
+//stop closes the connection to redis
+func (c *cacheInvalidation) stop() {
+	if c == nil {
+		return
+	}
+
+	if err := c.redis.Close(); err != nil {
+		logWarn("Connection of the cache invalidation could not be closed", errorFields(err))
+	}
+}
+
+//invalidateCaches invalidates the caches of a successful write, nothing
+//is invalidated while target writes are not sent
+func (c changeTracker) invalidateCaches(w Watch, id, target interface{}, update bson.M) {
+	if c.dryRun != nil || c.mockTargets {
+		return
+	}
+
+	c.caches.invalidate(w, id, target, update)
+}
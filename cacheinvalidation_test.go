@@ -0,0 +1,143 @@
+package redkeep_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//fakeRedis records the commands it receives and answers every one with 1
+type fakeRedis struct {
+	sync.Mutex
+	listener net.Listener
+	commands [][]string
+}
+
+func newFakeRedis() *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	r := &fakeRedis{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		command := []string{}
+		for i := 0; i < count; i++ {
+			reader.ReadString('\n')
+			argument, _ := reader.ReadString('\n')
+			command = append(command, strings.TrimSuffix(argument, "\r\n"))
+		}
+
+		r.Lock()
+		r.commands = append(r.commands, command)
+		r.Unlock()
+		conn.Write([]byte(":1\r\n"))
+	}
+}
+
+func (r *fakeRedis) received() [][]string {
+	r.Lock()
+	defer r.Unlock()
+	return r.commands
+}
+
+var _ = Describe("Cache invalidation", func() {
+	var redis *fakeRedis
+	w := Watch{Name: "userComments", TrackCollection: "app.user", TargetCollection: "app.comments", TargetNormalizedField: "meta"}
+	update := bson.M{"$set": bson.M{"meta.name": "Anna"}, "$unset": bson.M{"meta.city": ""}}
+
+	BeforeEach(func() {
+		redis = newFakeRedis()
+	})
+
+	AfterEach(func() {
+		redis.listener.Close()
+	})
+
+	It("will publish the writes to targets", func() {
+		settings := CacheInvalidationSettings{Redis: RedisSettings{Address: redis.listener.Addr().String()}, Channel: "caches"}
+		invalidations, failures := InvalidateCaches(settings, w, "1", nil, update)
+		Expect(invalidations).To(Equal(1.0))
+		Expect(failures).To(BeZero())
+
+		commands := redis.received()
+		Expect(commands).To(HaveLen(1))
+		Expect(commands[0][:2]).To(Equal([]string{"PUBLISH", "caches"}))
+		message := CacheInvalidationMessage{}
+		Expect(json.Unmarshal([]byte(commands[0][2]), &message)).To(Succeed())
+		Expect(message.Watch).To(Equal("userComments"))
+		Expect(message.Namespace).To(Equal("app.comments"))
+		Expect(message.ID).To(Equal("1"))
+		Expect(message.Target).To(BeNil())
+		Expect(message.Fields).To(Equal([]string{"meta.city", "meta.name"}))
+	})
+
+	It("will delete the keys of the written documents", func() {
+		settings := CacheInvalidationSettings{
+			Redis: RedisSettings{Address: redis.listener.Addr().String()},
+			Keys:  []string{"user:{{._id}}", "{{.collection}}:{{.target}}"},
+		}
+		InvalidateCaches(settings, w, "1", "2", update)
+		Expect(redis.received()).To(Equal([][]string{{"DEL", "user:1", "comments:2"}}))
+
+		settings.Watches = []string{"other"}
+		invalidations, _ := InvalidateCaches(settings, w, "1", "2", update)
+		Expect(invalidations).To(BeZero())
+		Expect(redis.received()).To(HaveLen(1))
+	})
+
+	It("will count failed invalidations", func() {
+		address := redis.listener.Addr().String()
+		redis.listener.Close()
+		invalidations, failures := InvalidateCaches(CacheInvalidationSettings{Redis: RedisSettings{Address: address}, Channel: "caches"}, w, "1", nil, update)
+		Expect(invalidations).To(BeZero())
+		Expect(failures).To(Equal(1.0))
+	})
+
+	It("will validate the settings", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"cacheInvalidation": { "redis": { "address": "localhost:6379" } }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Cache invalidation needs a channel or keys"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"cacheInvalidation": { "redis": { "address": "localhost:6379" }, "keys": ["user:{{.user}}"] }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError(ContainSubstring(`Invalid cache key "user:{{.user}}"`)))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"cacheInvalidation": { "keys": ["user:{{._id}}"] }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(HaveOccurred())
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"cacheInvalidation": { "redis": { "address": "localhost:6379" }, "keys": ["user:{{._id}}"] }, "watches"`, 1)
+		parsed, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.CacheInvalidation.Keys).To(Equal([]string{"user:{{._id}}"}))
+	})
+})
@@ -0,0 +1,256 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//CatchUpNewestFirst tails the oplog from its newest entry and handles
+//the backlog since the start time in the background
+const CatchUpNewestFirst = "newestFirst"
+
+//catchUp keeps the handling of the backlog from overwriting newer
+//values. The live tail marks the fields it writes of every document it
+//handles, the backlog leaves out the marked fields of its entries.
+//All methods can be called on nil.
+type catchUp struct {
+	sync.Mutex
+	//live has the written fields per document, nil for the whole document
+	live    map[string]map[string]bool
+	running bool
+}
+
+func newCatchUp() *catchUp {
+	return &catchUp{live: map[string]map[string]bool{}, running: true}
+}
+
+//entryDocument identifies the document an oplog entry changed
+//as namespace/_id, it is empty for entries without document
+func entryDocument(entry map[string]interface{}) string {
+	ns, _ := entry["ns"].(string)
+	document, ok := entry["o2"].(map[string]interface{})
+	if !ok {
+		document, ok = entry["o"].(map[string]interface{})
+	}
+
+	if !ok || document["_id"] == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/%v", ns, document["_id"])
+}
+
+//entryFields returns the top level fields an update entry sets or
+//unsets, it is nil for entries that write the whole document:
+//inserts, replacements and deletes
+func entryFields(entry map[string]interface{}) map[string]bool {
+	command, ok := entry["o"].(map[string]interface{})
+	if entry["op"] != "u" || !ok {
+		return nil
+	}
+
+	fields := map[string]bool{}
+	for operator, value := range command {
+		if operator == "$v" {
+			continue
+		}
+
+		changed, ok := value.(map[string]interface{})
+		if !strings.HasPrefix(operator, "$") || !ok {
+			return nil
+		}
+
+		for field := range changed {
+			fields[strings.SplitN(field, ".", 2)[0]] = true
+		}
+	}
+
+	return fields
+}
+
+//withoutFields returns a copy of entry that does not write the fields
+//in written, it is nil if nothing is left to write. Deletes are always
+//left out, the document existed again when the live tail wrote it.
+func withoutFields(entry map[string]interface{}, written map[string]bool) map[string]interface{} {
+	command, ok := entry["o"].(map[string]interface{})
+	if written == nil || entry["op"] == "d" || !ok {
+		return nil
+	}
+
+	without := func(document map[string]interface{}) map[string]interface{} {
+		left := map[string]interface{}{}
+		for field, value := range document {
+			if field == "_id" || !written[strings.SplitN(field, ".", 2)[0]] {
+				left[field] = value
+			}
+		}
+		return left
+	}
+
+	remaining, left := map[string]interface{}{}, 0
+	if entryFields(entry) != nil {
+		for operator, value := range command {
+			changed, ok := value.(map[string]interface{})
+			if !ok {
+				remaining[operator] = value
+			} else if kept := without(changed); len(kept) > 0 {
+				remaining[operator] = kept
+				left += len(kept)
+			}
+		}
+	} else {
+		remaining = without(command)
+		left = len(remaining)
+		if _, ok := remaining["_id"]; ok {
+			left--
+		}
+	}
+
+	if left == 0 {
+		return nil
+	}
+
+	copied := map[string]interface{}{}
+	for k, v := range entry {
+		copied[k] = v
+	}
+	copied["o"] = remaining
+	return copied
+}
+
+//handledLive marks the fields an entry of the live tail writes, it
+//has to be called before the entry is handled
+func (c *catchUp) handledLive(entry map[string]interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	document := entryDocument(entry)
+	if !c.running || document == "" {
+		return
+	}
+
+	written, marked := c.live[document]
+	fields := entryFields(entry)
+	switch {
+	case fields == nil:
+		c.live[document] = nil
+	case !marked:
+		c.live[document] = fields
+	case written != nil:
+		for field := range fields {
+			written[field] = true
+		}
+	}
+}
+
+//handleBacklog calls handle with entry, without the fields the live
+//tail already wrote of its document. It returns false if nothing was
+//left to handle. The lock is held while handle runs, so the live tail
+//can not mark and write the document in between.
+func (c *catchUp) handleBacklog(entry map[string]interface{}, handle func(map[string]interface{})) bool {
+	c.Lock()
+	defer c.Unlock()
+	written, marked := c.live[entryDocument(entry)]
+	if marked {
+		entry = withoutFields(entry, written)
+	}
+
+	if entry == nil {
+		return false
+	}
+
+	handle(entry)
+	return true
+}
+
+//finish stops marking documents of the live tail
+func (c *catchUp) finish() {
+	c.Lock()
+	defer c.Unlock()
+	c.running = false
+	c.live = nil
+}
+
+//newestOplogEntry returns the timestamp of the newest entry of the oplog
+func newestOplogEntry(oplog *mgo.Collection) (bson.MongoTimestamp, error) {
+	var newest struct {
+		Ts bson.MongoTimestamp `bson:"ts"`
+	}
+	if err := oplog.Find(nil).Sort("-$natural").One(&newest); err != nil {
+		return 0, err
+	}
+
+	return newest.Ts, nil
+}
+
+//oldestOplogEntry returns the timestamp of the oldest entry of the oplog
+func oldestOplogEntry(oplog *mgo.Collection) (bson.MongoTimestamp, error) {
+	var oldest struct {
+		Ts bson.MongoTimestamp `bson:"ts"`
+	}
+	if err := oplog.Find(nil).Sort("$natural").One(&oldest); err != nil {
+		return 0, err
+	}
+
+	return oldest.Ts, nil
+}
+
+//catchUpBacklog handles the entries after from up to to one after another.
+//Commands are skipped, they are in the past of the watches. The watermark
+//is held at the entry that is handled.
+func (t TailAgent) catchUpBacklog(session *mgo.Session, from, to bson.MongoTimestamp, backlog *catchUp, stop chan bool) {
+	defer session.Close()
+	defer backlog.finish()
+
+	held := from + 1
+	t.watermarks.begin(held)
+	defer func() { t.watermarks.end(held) }()
+
+	t.events.record(EventLifecycle, "", fmt.Sprintf("Catching up on the oplog from %d to %d", from, to))
+	query := session.DB("local").C("oplog.rs").Find(bson.M{"ts": bson.M{"$gt": from, "$lte": to}})
+	iter := query.LogReplay().Sort("$natural").Iter()
+
+	handled, skipped := 0, 0
+	entry := map[string]interface{}{}
+	for iter.Next(&entry) {
+		select {
+		case <-stop:
+			iter.Close()
+			t.events.record(EventLifecycle, "", fmt.Sprintf("Catch up stopped after %d oplog entries", handled))
+			return
+		default:
+		}
+
+		if ts, ok := entry["ts"].(bson.MongoTimestamp); ok {
+			t.watermarks.begin(ts)
+			t.watermarks.end(held)
+			held = ts
+		}
+
+		if entry["op"] != "c" && !fromMigration(entry) {
+			if backlog.handleBacklog(entry, func(current map[string]interface{}) {
+				analyzeResult(current, t.watches.active(), t.tracker, t.sinks, t.unknown, nil)
+			}) {
+				handled++
+			} else {
+				skipped++
+			}
+		}
+
+		entry = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		t.events.record(EventError, "", "Catch up failed: "+err.Error())
+		return
+	}
+
+	t.events.record(EventLifecycle, "", fmt.Sprintf("Catch up done, %d oplog entries handled, %d skipped", handled, skipped))
+}
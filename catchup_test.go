@@ -0,0 +1,73 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Newest first catch up", func() {
+	set := func(id string, fields map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"op": "u",
+			"ns": "app.user",
+			"o2": map[string]interface{}{"_id": id},
+			"o":  map[string]interface{}{"$set": fields},
+		}
+	}
+
+	update := func(id string) map[string]interface{} {
+		return set(id, map[string]interface{}{"name": "old"})
+	}
+
+	It("identifies the document of an entry", func() {
+		Expect(EntryDocument(update("1"))).To(Equal("app.user/1"))
+		Expect(EntryDocument(map[string]interface{}{"op": "i", "ns": "app.user", "o": map[string]interface{}{"_id": "2"}})).To(Equal("app.user/2"))
+		Expect(EntryDocument(map[string]interface{}{"op": "n", "ns": "", "o": map[string]interface{}{"msg": "noop"}})).To(BeEmpty())
+	})
+
+	It("skips backlog entries of documents that were handled live", func() {
+		c := NewCatchUp()
+		c.HandledLive(update("1"))
+
+		handled := []string{}
+		for _, id := range []string{"1", "2"} {
+			current := id
+			c.HandleBacklog(update(id), func(map[string]interface{}) { handled = append(handled, current) })
+		}
+
+		Expect(handled).To(Equal([]string{"2"}))
+	})
+
+	It("handles the fields of backlog entries that were not written live", func() {
+		c := NewCatchUp()
+		c.HandledLive(set("1", map[string]interface{}{"name": "new"}))
+
+		var handled map[string]interface{}
+		backlog := set("1", map[string]interface{}{"name": "old", "address.city": "Berlin"})
+		Expect(c.HandleBacklog(backlog, func(entry map[string]interface{}) { handled = entry })).To(BeTrue())
+		Expect(handled["o"]).To(Equal(map[string]interface{}{"$set": map[string]interface{}{"address.city": "Berlin"}}))
+		Expect(backlog["o"].(map[string]interface{})["$set"]).To(HaveKey("name"))
+
+		c.HandledLive(set("1", map[string]interface{}{"address": "Hamburg"}))
+		Expect(c.HandleBacklog(backlog, func(map[string]interface{}) {})).To(BeFalse())
+	})
+
+	It("skips the backlog of documents that were replaced or deleted live", func() {
+		c := NewCatchUp()
+		c.HandledLive(set("1", map[string]interface{}{"name": "new"}))
+		c.HandledLive(map[string]interface{}{"op": "u", "ns": "app.user", "o2": map[string]interface{}{"_id": "1"}, "o": map[string]interface{}{"_id": "1", "name": "newer"}})
+
+		Expect(c.HandleBacklog(set("1", map[string]interface{}{"age": 3}), func(map[string]interface{}) {})).To(BeFalse())
+		Expect(c.HandleBacklog(map[string]interface{}{"op": "d", "ns": "app.user", "o": map[string]interface{}{"_id": "1"}}, func(map[string]interface{}) {})).To(BeFalse())
+	})
+
+	It("stops marking documents when the backlog is done", func() {
+		c := NewCatchUp()
+		c.Finish()
+		c.HandledLive(update("1"))
+
+		Expect(c.HandleBacklog(update("1"), func(map[string]interface{}) {})).To(BeTrue())
+	})
+})
@@ -0,0 +1,252 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//sources of the changes an agent handles, see Configuration.Source
+const (
+	SourceOplog        = "oplog"
+	SourceChangeStream = "changestream"
+)
+
+//changeStreamAwait is how long a getMore waits for new events,
+//the agent checks if it was stopped in between
+const changeStreamAwait = time.Second
+
+//changeStreamCursor is the reply of aggregate and getMore
+type changeStreamCursor struct {
+	Cursor struct {
+		ID         int64      `bson:"id"`
+		FirstBatch []bson.Raw `bson:"firstBatch"`
+		NextBatch  []bson.Raw `bson:"nextBatch"`
+	} `bson:"cursor"`
+}
+
+//changeStreamEntry converts a change event into the oplog entry with the
+//same effect, false for events that have none. Updates that truncated
+//arrays set the whole arrays from the looked up document.
+func changeStreamEntry(event map[string]interface{}) (map[string]interface{}, bool) {
+	ns, _ := event["ns"].(map[string]interface{})
+	db, _ := ns["db"].(string)
+	collection, _ := ns["coll"].(string)
+	key, _ := event["documentKey"].(map[string]interface{})
+	ts, ok := event["clusterTime"].(bson.MongoTimestamp)
+	if !ok {
+		return nil, false
+	}
+
+	entry := map[string]interface{}{"ts": ts, "ns": db + "." + collection}
+
+	switch event["operationType"] {
+	case "insert":
+		entry["op"] = "i"
+		entry["o"] = event["fullDocument"]
+	case "replace":
+		entry["op"] = "u"
+		entry["o2"] = key
+		entry["o"] = event["fullDocument"]
+	case "update":
+		description, _ := event["updateDescription"].(map[string]interface{})
+		set := map[string]interface{}{}
+		if fields, ok := description["updatedFields"].(map[string]interface{}); ok {
+			for field, value := range fields {
+				set[field] = value
+			}
+		}
+
+		if truncated, ok := description["truncatedArrays"].([]interface{}); ok {
+			document, _ := event["fullDocument"].(map[string]interface{})
+			for _, t := range truncated {
+				if t, ok := t.(map[string]interface{}); ok {
+					field := fmt.Sprint(t["field"])
+					set[field] = GetValue(field, document)
+				}
+			}
+		}
+
+		update := map[string]interface{}{}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+
+		if removed, ok := description["removedFields"].([]interface{}); ok && len(removed) > 0 {
+			unset := map[string]interface{}{}
+			for _, field := range removed {
+				unset[fmt.Sprint(field)] = true
+			}
+			update["$unset"] = unset
+		}
+
+		entry["op"] = "u"
+		entry["o2"] = key
+		entry["o"] = update
+	case "delete":
+		entry["op"] = "d"
+		entry["o"] = key
+	case "drop":
+		entry["op"] = "c"
+		entry["ns"] = db + ".$cmd"
+		entry["o"] = map[string]interface{}{"drop": collection}
+	case "dropDatabase":
+		entry["op"] = "c"
+		entry["ns"] = db + ".$cmd"
+		entry["o"] = map[string]interface{}{"dropDatabase": 1}
+	case "rename":
+		to, _ := event["to"].(map[string]interface{})
+		entry["op"] = "c"
+		entry["ns"] = "admin.$cmd"
+		entry["o"] = map[string]interface{}{"renameCollection": db + "." + collection, "to": fmt.Sprintf("%v.%v", to["db"], to["coll"])}
+	default:
+		return nil, false
+	}
+
+	return entry, true
+}
+
+//openChangeStream opens a change stream of the whole cluster, after
+//resume if it is set or else at the operation time start
+func openChangeStream(session *mgo.Session, start bson.MongoTimestamp, resume interface{}) (changeStreamCursor, error) {
+	options := bson.D{{Name: "allChangesForCluster", Value: true}, {Name: "fullDocument", Value: "updateLookup"}}
+	if resume != nil {
+		options = append(options, bson.DocElem{Name: "resumeAfter", Value: resume})
+	} else {
+		options = append(options, bson.DocElem{Name: "startAtOperationTime", Value: start})
+	}
+
+	var result changeStreamCursor
+	err := session.DB("admin").Run(bson.D{
+		{Name: "aggregate", Value: 1},
+		{Name: "pipeline", Value: []bson.M{{"$changeStream": options}}},
+		{Name: "cursor", Value: bson.M{}},
+	}, &result)
+	result.Cursor.NextBatch = result.Cursor.FirstBatch
+	return result, err
+}
+
+//tailChangeStream handles the changes of a change stream instead of the
+//oplog until ctx is done. Broken streams are resumed after the last event.
+func (t TailAgent) tailChangeStream(ctx context.Context, session *mgo.Session, start bson.MongoTimestamp, workers *sync.WaitGroup, pool *workerPool) error {
+	admin := session.DB("admin")
+	stream, err := openChangeStream(session, start, nil)
+	if err != nil {
+		t.events.record(EventError, "", "Change stream failed: "+err.Error())
+		return err
+	}
+
+	t.events.record(EventLifecycle, "", "Tailing the change stream")
+
+	var resume interface{}
+	for {
+		for _, raw := range stream.Cursor.NextBatch {
+			event := map[string]interface{}{}
+			if err := raw.Unmarshal(&event); err != nil {
+				t.events.record(EventError, "", "Change event could not be read: "+err.Error())
+				continue
+			}
+
+			resume = event["_id"]
+			if entry, ok := changeStreamEntry(event); ok {
+				//only strict ordering fails, once ctx is done, and it needs the oplog source
+				if err := t.dispatch(ctx, entry, workers, pool, nil); err != nil {
+					break
+				}
+			}
+		}
+
+		if len(stream.Cursor.NextBatch) == 0 {
+			t.metrics.set(MetricLagSeconds, 0)
+		}
+
+		//a cursor that timed out while paused is resumed below
+		t.pause.wait(ctx)
+
+		select {
+		case <-ctx.Done():
+			admin.Run(bson.D{{Name: "killCursors", Value: "$cmd.aggregate"}, {Name: "cursors", Value: []int64{stream.Cursor.ID}}}, nil)
+			t.events.record(EventLifecycle, "", "Agent stopped")
+			logInfo("Agent stopped, draining handled entries", nil)
+			return ctx.Err()
+		default:
+		}
+
+		if id := stream.Cursor.ID; id != 0 {
+			stream = changeStreamCursor{}
+			err = admin.Run(bson.D{
+				{Name: "getMore", Value: id},
+				{Name: "collection", Value: "$cmd.aggregate"},
+				{Name: "maxTimeMS", Value: int64(changeStreamAwait / time.Millisecond)},
+			}, &stream)
+			if err == nil {
+				continue
+			}
+		} else {
+			err = errors.New("cursor closed")
+		}
+
+		t.events.record(EventReconnect, "", "Change stream resumed after: "+err.Error())
+		session.Refresh()
+		if stream, err = openChangeStream(session, start, resume); err != nil {
+			t.events.record(EventError, "", "Change stream failed: "+err.Error())
+			return err
+		}
+	}
+}
+
+//ResumePoint is where a change stream continues after the changes an
+//agent handled up to Checkpoint. StartAtOperationTime is the first
+//operation after it, for the startAtOperationTime of $changeStream.
+type ResumePoint struct {
+	Checkpoint           bson.MongoTimestamp `json:"checkpoint"`
+	StartAtOperationTime bson.MongoTimestamp `json:"startAtOperationTime"`
+}
+
+//resumePoint converts the oplog checkpoint, change streams can only start
+//at operations that are still in the oplog, oldest is its first entry
+func resumePoint(checkpoint, oldest bson.MongoTimestamp) (ResumePoint, error) {
+	if checkpoint == 0 {
+		return ResumePoint{}, errors.New("No checkpoint stored yet")
+	}
+
+	if oldest > checkpoint {
+		return ResumePoint{}, fmt.Errorf("The oplog rotated past the checkpoint %d, a change stream can not start there", checkpoint)
+	}
+
+	return ResumePoint{Checkpoint: checkpoint, StartAtOperationTime: checkpoint + 1}, nil
+}
+
+//ChangeStreamResumePoint converts the stored checkpoint of c into the
+//point a change stream continues at, for consumers that take over the
+//changes of an agent. Agents share their checkpoints between both sources.
+func ChangeStreamResumePoint(session *mgo.Session, c Configuration) (ResumePoint, error) {
+	if !c.Checkpoint.enabled() {
+		return ResumePoint{}, errors.New("No checkpoint configured")
+	}
+
+	metadata, err := DialMetadata(c, session)
+	if err != nil {
+		return ResumePoint{}, err
+	}
+	if metadata != session {
+		defer metadata.Close()
+	}
+
+	checkpoint, err := newCheckpoint(c.Checkpoint, metadata).load()
+	if err != nil {
+		return ResumePoint{}, err
+	}
+
+	oldest, err := oldestOplogEntry(session.DB("local").C("oplog.rs"))
+	if err != nil && err != mgo.ErrNotFound {
+		return ResumePoint{}, err
+	}
+
+	return resumePoint(checkpoint, oldest)
+}
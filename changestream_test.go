@@ -0,0 +1,93 @@
+package redkeep_test
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Change streams", func() {
+	ts := bson.MongoTimestamp(6000000000000000001)
+	ns := map[string]interface{}{"db": "application", "coll": "user"}
+	key := map[string]interface{}{"_id": "1"}
+
+	It("converts inserts, replaces and deletes", func() {
+		document := map[string]interface{}{"_id": "1", "name": "Hans"}
+		entry, ok := ChangeStreamEntry(map[string]interface{}{"operationType": "insert", "clusterTime": ts, "ns": ns, "documentKey": key, "fullDocument": document})
+		Expect(ok).To(BeTrue())
+		Expect(entry).To(Equal(map[string]interface{}{"ts": ts, "ns": "application.user", "op": "i", "o": document}))
+
+		entry, _ = ChangeStreamEntry(map[string]interface{}{"operationType": "replace", "clusterTime": ts, "ns": ns, "documentKey": key, "fullDocument": document})
+		Expect(entry).To(Equal(map[string]interface{}{"ts": ts, "ns": "application.user", "op": "u", "o2": key, "o": document}))
+
+		entry, _ = ChangeStreamEntry(map[string]interface{}{"operationType": "delete", "clusterTime": ts, "ns": ns, "documentKey": key})
+		Expect(entry).To(Equal(map[string]interface{}{"ts": ts, "ns": "application.user", "op": "d", "o": key}))
+	})
+
+	It("converts updates like the oplog", func() {
+		entry, ok := ChangeStreamEntry(map[string]interface{}{
+			"operationType": "update",
+			"clusterTime":   ts,
+			"ns":            ns,
+			"documentKey":   key,
+			"updateDescription": map[string]interface{}{
+				"updatedFields":   map[string]interface{}{"name": "Hans"},
+				"removedFields":   []interface{}{"nickname"},
+				"truncatedArrays": []interface{}{map[string]interface{}{"field": "tags", "newSize": 1}},
+			},
+			"fullDocument": map[string]interface{}{"_id": "1", "name": "Hans", "tags": []interface{}{"admin"}},
+		})
+
+		Expect(ok).To(BeTrue())
+		Expect(entry["op"]).To(Equal("u"))
+		Expect(entry["o2"]).To(Equal(key))
+		Expect(entry["o"]).To(Equal(map[string]interface{}{
+			"$set":   map[string]interface{}{"name": "Hans", "tags": []interface{}{"admin"}},
+			"$unset": map[string]interface{}{"nickname": true},
+		}))
+	})
+
+	It("converts drops and renames into commands", func() {
+		entry, _ := ChangeStreamEntry(map[string]interface{}{"operationType": "drop", "clusterTime": ts, "ns": ns})
+		Expect(entry).To(Equal(map[string]interface{}{"ts": ts, "ns": "application.$cmd", "op": "c", "o": map[string]interface{}{"drop": "user"}}))
+
+		entry, _ = ChangeStreamEntry(map[string]interface{}{"operationType": "rename", "clusterTime": ts, "ns": ns, "to": map[string]interface{}{"db": "application", "coll": "member"}})
+		Expect(entry["o"]).To(Equal(map[string]interface{}{"renameCollection": "application.user", "to": "application.member"}))
+
+		_, ok := ChangeStreamEntry(map[string]interface{}{"operationType": "invalidate", "clusterTime": ts})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("checks the source", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"source": "binlog", "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Unknown source binlog, use oplog or changestream"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"source": "changestream", "catchUp": "newestFirst", "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Catch up needs the oplog source"))
+	})
+})
+
+var _ = Describe("Change stream resume points", func() {
+	It("starts after the checkpoint", func() {
+		point, err := ResumePointAfter(bson.MongoTimestamp(5<<32|2), bson.MongoTimestamp(1<<32))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(point).To(Equal(ResumePoint{Checkpoint: bson.MongoTimestamp(5<<32 | 2), StartAtOperationTime: bson.MongoTimestamp(5<<32 | 3)}))
+	})
+
+	It("fails once the oplog rotated past the checkpoint", func() {
+		_, err := ResumePointAfter(bson.MongoTimestamp(5<<32), bson.MongoTimestamp(6<<32))
+		Expect(err).To(MatchError(fmt.Sprintf("The oplog rotated past the checkpoint %d, a change stream can not start there", 5<<32)))
+	})
+
+	It("needs a checkpoint", func() {
+		_, err := ResumePointAfter(0, 0)
+		Expect(err).To(MatchError("No checkpoint stored yet"))
+	})
+})
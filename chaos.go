@@ -0,0 +1,73 @@
+package redkeep
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var errInjectedFault = errors.New("Write dropped by fault injection")
+
+//ChaosSettings enables fault injection for resilience tests in staging.
+//Never use it in production. DropWritesPercent drops that share of writes
+//to target collections, they are handled like failed writes. LookupDelay
+//delays every lookup of a referenced document. KillCursorEvery closes the
+//oplog cursor after that many entries, the agent has to reopen it.
+type ChaosSettings struct {
+	DropWritesPercent float64  `json:"dropWritesPercent" validate:"min=0,max=100"`
+	LookupDelay       Duration `json:"lookupDelay"`
+	KillCursorEvery   int      `json:"killCursorEvery" validate:"min=0"`
+}
+
+//faultInjector decides when to inject faults,
+//all methods can be called on nil
+type faultInjector struct {
+	sync.Mutex
+	settings ChaosSettings
+	random   *rand.Rand
+	entries  int
+}
+
+func newFaultInjector(settings *ChaosSettings) *faultInjector {
+	if settings == nil {
+		return nil
+	}
+
+	return &faultInjector{
+		settings: *settings,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+//dropWrite returns true if the next write should fail
+func (f *faultInjector) dropWrite() bool {
+	if f == nil || f.settings.DropWritesPercent <= 0 {
+		return false
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	return f.random.Float64()*100 < f.settings.DropWritesPercent
+}
+
+func (f *faultInjector) delayLookup() {
+	if f == nil || f.settings.LookupDelay.Duration <= 0 {
+		return
+	}
+
+	time.Sleep(f.settings.LookupDelay.Duration)
+}
+
+//killCursor counts an oplog entry and returns true
+//if the cursor should be closed now
+func (f *faultInjector) killCursor() bool {
+	if f == nil || f.settings.KillCursorEvery <= 0 {
+		return false
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.entries++
+	return f.entries%f.settings.KillCursorEvery == 0
+}
@@ -0,0 +1,242 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultCheckpointName     = "redkeep"
+	defaultCheckpointInterval = 10 * time.Second
+)
+
+//CheckpointSettings persist the position of the agent in the oplog, it
+//is the low watermark, so every entry up to it was handled. It is stored
+//in the document Name (default redkeep) of Collection (database.collection)
+//or in File. Checkpoints are written with the watermarks, every Interval
+//(default 10s) unless watermarkInterval is set, and after Entries handled
+//oplog entries. Durable checkpoint files are synced to disk and checkpoint
+//documents are written to a majority of the replica set.
+//With Watches every watch keeps a checkpoint of its own as well, so a
+//paused or failing watch can catch up without the other watches.
+type CheckpointSettings struct {
+	Collection string   `json:"collection"`
+	File       string   `json:"file"`
+	Name       string   `json:"name"`
+	Watches    bool     `json:"watches"`
+	Interval   Duration `json:"interval"`
+	Entries    int      `json:"entries" validate:"min=0"`
+	Durable    bool     `json:"durable"`
+}
+
+func (s CheckpointSettings) enabled() bool {
+	return s.Collection != "" || s.File != ""
+}
+
+func checkCheckpointSettings(settings CheckpointSettings) error {
+	if settings.Collection != "" && settings.File != "" {
+		return errors.New("Checkpoint needs either a collection or a file, not both")
+	}
+
+	if c := settings.Collection; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Checkpoint collection %s must be database.collection", c)
+	}
+
+	if settings.Watches && !settings.enabled() {
+		return errors.New("Watch checkpoints need a checkpoint collection or file")
+	}
+
+	tuned := settings.Interval.Duration != 0 || settings.Entries != 0 || settings.Durable
+	if tuned && !settings.enabled() {
+		return errors.New("Checkpoint interval, entries and durability need a checkpoint collection or file")
+	}
+
+	if settings.Interval.Duration < 0 {
+		return errors.New("Checkpoint interval must not be negative")
+	}
+
+	return nil
+}
+
+//watermarkInterval is the configured interval, checkpoints
+//need watermarks and use their interval or a default one
+func watermarkInterval(c Configuration) time.Duration {
+	if c.WatermarkInterval.Duration <= 0 && c.Checkpoint.enabled() {
+		if c.Checkpoint.Interval.Duration > 0 {
+			return c.Checkpoint.Interval.Duration
+		}
+		return defaultCheckpointInterval
+	}
+
+	return c.WatermarkInterval.Duration
+}
+
+//writeCheckpointFile replaces file with data through a temporary file,
+//durable files are synced to disk before the rename and their directory
+//after it, so a crash leaves either the old or the new checkpoint
+func writeCheckpointFile(file string, data []byte, durable bool) error {
+	temporary := file + ".tmp"
+	if !durable {
+		if err := ioutil.WriteFile(temporary, data, 0644); err != nil {
+			return err
+		}
+
+		return os.Rename(temporary, file)
+	}
+
+	f, err := os.OpenFile(temporary, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(temporary, file); err != nil {
+		return err
+	}
+
+	directory, err := os.Open(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
+
+//checkpointSession copies session for checkpoint writes, durable
+//writes wait until a majority of the replica set journaled them
+func checkpointSession(settings CheckpointSettings, session *mgo.Session) *mgo.Session {
+	copied := session.Copy()
+	if settings.Durable {
+		copied.SetSafe(&mgo.Safe{WMode: "majority", J: true})
+	}
+
+	return copied
+}
+
+//checkpoint is a sink that stores the watermarks it gets
+type checkpoint struct {
+	settings CheckpointSettings
+	session  *mgo.Session
+}
+
+type checkpointDocument struct {
+	Timestamp bson.MongoTimestamp `bson:"ts" json:"ts"`
+	Updated   time.Time           `bson:"updated" json:"updated"`
+}
+
+func newCheckpoint(settings CheckpointSettings, session *mgo.Session) *checkpoint {
+	if settings.Name == "" {
+		settings.Name = defaultCheckpointName
+	}
+
+	return &checkpoint{settings: settings, session: session}
+}
+
+//Send ignores change events, only watermarks are stored
+func (c *checkpoint) Send(e ChangeEvent) error {
+	return nil
+}
+
+//Close does nothing, the last watermark was stored before
+func (c *checkpoint) Close() error {
+	return nil
+}
+
+//Watermark stores ts as the checkpoint
+func (c *checkpoint) Watermark(ts bson.MongoTimestamp) error {
+	document := checkpointDocument{Timestamp: ts, Updated: time.Now()}
+	if c.settings.File != "" {
+		data, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+
+		return writeCheckpointFile(c.settings.File, data, c.settings.Durable)
+	}
+
+	session := checkpointSession(c.settings, c.session)
+	defer session.Close()
+	_, err := c.collection(session).UpsertId(c.settings.Name, document)
+	return err
+}
+
+//load returns the stored checkpoint, zero if none was stored yet
+func (c *checkpoint) load() (bson.MongoTimestamp, error) {
+	var document checkpointDocument
+	if c.settings.File != "" {
+		data, err := ioutil.ReadFile(c.settings.File)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		err = json.Unmarshal(data, &document)
+		return document.Timestamp, err
+	}
+
+	session := c.session.Copy()
+	defer session.Close()
+	err := c.collection(session).FindId(c.settings.Name).One(&document)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+
+	return document.Timestamp, err
+}
+
+func (c *checkpoint) collection(session *mgo.Session) *mgo.Collection {
+	p := strings.Index(c.settings.Collection, ".")
+	return session.DB(c.settings.Collection[:p]).C(c.settings.Collection[p+1:])
+}
+
+//NewTailAgentFromCheckpoint creates an agent that resumes after the stored
+//checkpoint, or starts now if there is none yet.
+func NewTailAgentFromCheckpoint(c Configuration) (*TailAgent, error) {
+	if !c.Checkpoint.enabled() {
+		return nil, errors.New("No checkpoint configured")
+	}
+
+	agent, err := NewTailAgent(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := agent.checkpoint.load()
+	if err == nil && agent.watchCheckpoints != nil {
+		err = agent.watchCheckpoints.load(ts)
+	}
+	if err != nil {
+		agent.sinks.close()
+		agent.Close()
+		return nil, err
+	}
+
+	if ts > 0 {
+		agent.startTime = time.Unix(int64(ts>>32), 0)
+		agent.resumeAfter = ts
+		agent.events.record(EventLifecycle, "", fmt.Sprintf("Resuming from checkpoint %d", ts))
+	}
+
+	return agent, nil
+}
@@ -0,0 +1,83 @@
+package redkeep_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Checkpoint", func() {
+	var directory string
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "redkeep-checkpoint")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	It("stores the last watermark in a file", func() {
+		checkpoint := NewFileCheckpoint(filepath.Join(directory, "checkpoint.json"))
+		Expect(checkpoint.Load()).To(BeZero())
+
+		Expect(checkpoint.Watermark(bson.MongoTimestamp(6000000000000000001))).To(Succeed())
+		Expect(checkpoint.Watermark(bson.MongoTimestamp(6000000000000000002))).To(Succeed())
+		Expect(checkpoint.Load()).To(Equal(bson.MongoTimestamp(6000000000000000002)))
+		_, err := os.Stat(filepath.Join(directory, "checkpoint.json.tmp"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("syncs durable checkpoint files", func() {
+		checkpoint := NewDurableFileCheckpoint(filepath.Join(directory, "checkpoint.json"))
+		Expect(checkpoint.Watermark(bson.MongoTimestamp(6000000000000000001))).To(Succeed())
+		Expect(checkpoint.Load()).To(Equal(bson.MongoTimestamp(6000000000000000001)))
+		_, err := os.Stat(filepath.Join(directory, "checkpoint.json.tmp"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("sends watermarks for checkpoints", func() {
+		Expect(WatermarkInterval(Configuration{})).To(BeZero())
+		Expect(WatermarkInterval(Configuration{Checkpoint: CheckpointSettings{File: "checkpoint.json"}})).To(Equal(10 * time.Second))
+		Expect(WatermarkInterval(Configuration{
+			Checkpoint:        CheckpointSettings{Collection: "redkeep.checkpoints"},
+			WatermarkInterval: Duration{time.Second},
+		})).To(Equal(time.Second))
+		Expect(WatermarkInterval(Configuration{
+			Checkpoint: CheckpointSettings{File: "checkpoint.json", Interval: Duration{time.Minute}},
+		})).To(Equal(time.Minute))
+	})
+
+	It("checks the checkpoint configuration", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"checkpoint": { "collection": "checkpoints" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Checkpoint collection checkpoints must be database.collection"))
+
+		config = strings.Replace(config, `"checkpoints"`, `"redkeep.checkpoints", "file": "checkpoint.json"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Checkpoint needs either a collection or a file, not both"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"checkpoint": { "entries": 1000 }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Checkpoint interval, entries and durability need a checkpoint collection or file"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"checkpoint": { "file": "checkpoint.json", "interval": "-1s" }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Checkpoint interval must not be negative"))
+	})
+
+	It("needs a checkpoint to resume from", func() {
+		_, err := NewTailAgentFromCheckpoint(Configuration{})
+		Expect(err).To(MatchError("No checkpoint configured"))
+	})
+})
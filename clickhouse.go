@@ -0,0 +1,304 @@
+package redkeep
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	defaultAnalyticsBatchSize     = 1000
+	defaultAnalyticsFlushInterval = 5 * time.Second
+	analyticsMaxPendingBatches    = 10
+	//analyticsDeduplicationWindow is the number of recent insert blocks
+	//clickhouse remembers, a MergeTree that is not replicated keeps none
+	//by default and would ignore insert_deduplication_token
+	analyticsDeduplicationWindow = 1000
+)
+
+//ClickHouseSinkSettings configures the analytical sink. Events are
+//appended in micro batches through the http interface of clickhouse.
+//Mode events stores every change, mode snapshots keeps the current
+//state per document with a ReplacingMergeTree.
+type ClickHouseSinkSettings struct {
+	URL           string   `json:"url" validate:"required,url"`
+	Database      string   `json:"database"`
+	Table         string   `json:"table" validate:"required,min=1"`
+	User          string   `json:"user"`
+	Password      string   `json:"password"`
+	Mode          string   `json:"mode"`
+	BatchSize     int      `json:"batchSize"`
+	FlushInterval Duration `json:"flushInterval"`
+}
+
+type analyticsRow struct {
+	BatchID   string `json:"batch_id"`
+	Timestamp int64  `json:"ts"`
+	Watch     string `json:"watch"`
+	Operation string `json:"op"`
+	Namespace string `json:"ns"`
+	ID        string `json:"id"`
+	Fields    string `json:"fields"`
+	Deleted   uint8  `json:"deleted"`
+}
+
+type analyticsBatch struct {
+	id   string
+	rows []analyticsRow
+}
+
+//clickHouseSink buffers rows under its mutex, writing holds only
+//flushing so Send is not blocked while clickhouse is slow
+type clickHouseSink struct {
+	sync.Mutex
+	flushing sync.Mutex
+	settings ClickHouseSinkSettings
+	client   *http.Client
+	buffer   []analyticsRow
+	pending  []analyticsBatch
+	quit     chan bool
+	done     chan bool
+}
+
+func init() {
+	RegisterSinkType("clickhouse", func(options json.RawMessage) (Sink, error) {
+		var settings ClickHouseSinkSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewClickHouseSink(settings)
+	})
+}
+
+//NewClickHouseSink creates the table if needed and starts flushing
+func NewClickHouseSink(settings ClickHouseSinkSettings) (Sink, error) {
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, err
+	}
+
+	if settings.Mode == "" {
+		settings.Mode = "events"
+	}
+
+	if settings.Mode != "events" && settings.Mode != "snapshots" {
+		return nil, fmt.Errorf("Unknown clickhouse mode %s, use events or snapshots", settings.Mode)
+	}
+
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = defaultAnalyticsBatchSize
+	}
+
+	if settings.FlushInterval.Duration <= 0 {
+		settings.FlushInterval.Duration = defaultAnalyticsFlushInterval
+	}
+
+	sink := &clickHouseSink{
+		settings: settings,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		quit:     make(chan bool),
+		done:     make(chan bool),
+	}
+
+	if err := sink.query(sink.createTableStatement(), "", nil); err != nil {
+		return nil, err
+	}
+
+	go sink.flushPeriodically()
+
+	return sink, nil
+}
+
+func (s *clickHouseSink) tableName() string {
+	if s.settings.Database == "" {
+		return s.settings.Table
+	}
+
+	return s.settings.Database + "." + s.settings.Table
+}
+
+func (s *clickHouseSink) createTableStatement() string {
+	engine := "MergeTree() ORDER BY (ns, id, ts)"
+	if s.settings.Mode == "snapshots" {
+		engine = "ReplacingMergeTree(ts) ORDER BY (ns, id)"
+	}
+
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	batch_id String,
+	ts UInt64,
+	watch String,
+	op String,
+	ns String,
+	id String,
+	fields String,
+	deleted UInt8,
+	inserted_at DateTime DEFAULT now()
+) ENGINE = %s
+SETTINGS non_replicated_deduplication_window = %d`, s.tableName(), engine, analyticsDeduplicationWindow)
+}
+
+func (s *clickHouseSink) query(statement string, deduplicationToken string, body []byte) error {
+	endpoint, err := url.Parse(s.settings.URL)
+	if err != nil {
+		return err
+	}
+
+	parameters := endpoint.Query()
+	parameters.Set("query", statement)
+	if deduplicationToken != "" {
+		parameters.Set("insert_deduplication_token", deduplicationToken)
+	}
+	endpoint.RawQuery = parameters.Encode()
+
+	request, err := http.NewRequest("POST", endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if s.settings.User != "" {
+		request.Header.Set("X-ClickHouse-User", s.settings.User)
+		request.Header.Set("X-ClickHouse-Key", s.settings.Password)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Clickhouse answered with %s: %s", response.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+func (s *clickHouseSink) Send(e ChangeEvent) error {
+	fields, err := json.Marshal(e.Fields)
+	if err != nil {
+		return err
+	}
+
+	row := analyticsRow{
+		Timestamp: int64(e.Timestamp),
+		Watch:     e.Watch,
+		Operation: e.Operation,
+		Namespace: e.Namespace,
+		ID:        idString(e.ID),
+		Fields:    string(fields),
+	}
+
+	if e.Operation == "d" {
+		row.Deleted = 1
+	}
+
+	s.Lock()
+	if len(s.buffer) >= s.settings.BatchSize && len(s.pending) >= analyticsMaxPendingBatches {
+		s.Unlock()
+		return errSinkFull
+	}
+
+	s.buffer = append(s.buffer, row)
+	full := len(s.buffer) >= s.settings.BatchSize
+	s.Unlock()
+
+	if full {
+		return s.flush()
+	}
+
+	return nil
+}
+
+//flush seals the buffer into a batch and writes all pending
+//batches in order. A failed batch keeps its id, clickhouse
+//deduplicates it if it was written before. At most
+//analyticsMaxPendingBatches are kept, after that the buffer
+//stays full and Send drops new events.
+func (s *clickHouseSink) flush() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	s.Lock()
+	if len(s.buffer) > 0 && len(s.pending) < analyticsMaxPendingBatches {
+		s.pending = append(s.pending, newAnalyticsBatch(s.buffer))
+		s.buffer = nil
+	}
+	s.Unlock()
+
+	for {
+		s.Lock()
+		if len(s.pending) == 0 {
+			s.Unlock()
+			return nil
+		}
+		batch := s.pending[0]
+		s.Unlock()
+
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, row := range batch.rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+
+		statement := fmt.Sprintf("INSERT INTO %s (batch_id, ts, watch, op, ns, id, fields, deleted) FORMAT JSONEachRow", s.tableName())
+		if err := s.query(statement, batch.id, body.Bytes()); err != nil {
+			return err
+		}
+
+		s.Lock()
+		s.pending = s.pending[1:]
+		s.Unlock()
+	}
+}
+
+//newAnalyticsBatch seals rows with an id derived from its content
+//so retries of the same batch produce the same id
+func newAnalyticsBatch(rows []analyticsRow) analyticsBatch {
+	hash := sha1.New()
+	for _, row := range rows {
+		fmt.Fprintf(hash, "%d|%s|%s|%s|%s\n", row.Timestamp, row.Watch, row.Operation, row.Namespace, row.ID)
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))
+	for i := range rows {
+		rows[i].BatchID = id
+	}
+
+	return analyticsBatch{id: id, rows: rows}
+}
+
+func (s *clickHouseSink) flushPeriodically() {
+	ticker := time.NewTicker(s.settings.FlushInterval.Duration)
+	defer ticker.Stop()
+	defer close(s.done)
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				logError("Clickhouse batch could not be written", errorFields(err))
+			}
+		}
+	}
+}
+
+func (s *clickHouseSink) Close() error {
+	close(s.quit)
+	<-s.done
+	return s.flush()
+}
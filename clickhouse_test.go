@@ -0,0 +1,127 @@
+package redkeep_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Clickhouse sink", func() {
+	type received struct {
+		query, token, body string
+	}
+
+	var (
+		server   *httptest.Server
+		mutex    sync.Mutex
+		requests []received
+		failing  bool
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		failing = false
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, received{
+				query: r.URL.Query().Get("query"),
+				token: r.URL.Query().Get("insert_deduplication_token"),
+				body:  string(body),
+			})
+
+			if failing {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("creates the table and writes full batches", func() {
+		sink, err := NewClickHouseSink(ClickHouseSinkSettings{
+			URL:       server.URL,
+			Database:  "analytics",
+			Table:     "changes",
+			Mode:      "snapshots",
+			BatchSize: 2,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].query).To(ContainSubstring("CREATE TABLE IF NOT EXISTS analytics.changes"))
+		Expect(requests[0].query).To(ContainSubstring("ReplacingMergeTree(ts)"))
+		Expect(requests[0].query).To(ContainSubstring("SETTINGS non_replicated_deduplication_window = "))
+
+		Expect(sink.Send(ChangeEvent{Operation: "u", Namespace: "live.user", ID: "a", Timestamp: 1})).To(Succeed())
+		Expect(requests).To(HaveLen(1))
+		Expect(sink.Send(ChangeEvent{Operation: "d", Namespace: "live.user", ID: "b", Timestamp: 2})).To(Succeed())
+		Expect(requests).To(HaveLen(2))
+
+		Expect(requests[1].query).To(HavePrefix("INSERT INTO analytics.changes"))
+		Expect(requests[1].token).ToNot(BeEmpty())
+		lines := strings.Split(strings.TrimSpace(requests[1].body), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[1]).To(ContainSubstring(`"deleted":1`))
+		Expect(sink.Close()).To(Succeed())
+	})
+
+	It("retries failed batches with the same batch id", func() {
+		sink, err := NewClickHouseSink(ClickHouseSinkSettings{URL: server.URL, Table: "changes", BatchSize: 1})
+		Expect(err).ToNot(HaveOccurred())
+
+		failing = true
+		Expect(sink.Send(ChangeEvent{Operation: "i", Namespace: "live.user", ID: "a"})).ToNot(Succeed())
+
+		failing = false
+		Expect(sink.Close()).To(Succeed())
+
+		Expect(requests).To(HaveLen(3))
+		Expect(requests[1].token).To(Equal(requests[2].token))
+		Expect(requests[1].body).To(Equal(requests[2].body))
+	})
+
+	It("only knows events and snapshots", func() {
+		_, err := NewClickHouseSink(ClickHouseSinkSettings{URL: server.URL, Table: "changes", Mode: "cubes"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("drops events once too many batches are pending", func() {
+		sink, err := NewClickHouseSink(ClickHouseSinkSettings{URL: server.URL, Table: "changes", BatchSize: 1})
+		Expect(err).ToNot(HaveOccurred())
+
+		mutex.Lock()
+		failing = true
+		mutex.Unlock()
+
+		var last error
+		for i := 0; i < 20; i++ {
+			last = sink.Send(ChangeEvent{Operation: "i", Namespace: "live.user", ID: fmt.Sprintf("%d", i)})
+		}
+		Expect(last).To(MatchError(ContainSubstring("full")))
+
+		mutex.Lock()
+		failing = false
+		mutex.Unlock()
+		Expect(sink.Close()).To(Succeed())
+
+		inserted := 0
+		for _, r := range requests {
+			if strings.HasPrefix(r.query, "INSERT") && r.body != "" {
+				inserted++
+			}
+		}
+		Expect(inserted).To(BeNumerically(">", 0))
+	})
+})
@@ -3,14 +3,100 @@ package redkeep
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	validator "gopkg.in/go-playground/validator.v8"
 )
 
 //Configuration for red keep
 type Configuration struct {
-	Mongo   Mongo   `json:"mongo" validate:"required"`
-	Watches []Watch `json:"watches" validate:"required,gt=0,dive"`
+	Mongo   Mongo         `json:"mongo" validate:"required"`
+	Watches []Watch       `json:"watches" validate:"required,gt=0,dive"`
+	Sinks   []SinkConfig  `json:"sinks" validate:"dive"`
+	Admin   AdminSettings `json:"admin"`
+	//Plugins are paths of Go plugins that are loaded before
+	//sink and transform types are checked, see LoadPlugin
+	Plugins []string `json:"plugins"`
+	//Tenants limit the writes of the watches of a tenant by tenant name
+	Tenants map[string]TenantSettings `json:"tenants"`
+	//TenantWatches are templates for the watches of tenants added with
+	//TailAgent.AddTenant, {tenant} in the collections and the name is
+	//replaced by the tenant name
+	TenantWatches []Watch `json:"tenantWatches" validate:"dive"`
+
+	Notifications NotificationSettings `json:"notifications"`
+	//Diagnostics configures the capture of oplog entries redkeep does not understand
+	Diagnostics DiagnosticsSettings `json:"diagnostics"`
+	//PendingTargets limits the updates queued for missing targets,
+	//see BehaviourSettings, default one hour and 10000 updates
+	PendingTargets RetentionSettings `json:"pendingTargets"`
+	//Features roll out gated transforms by feature name
+	Features map[string]FeatureFlag `json:"features" validate:"dive"`
+	//Backfill configures how and from which members the documents of new tenants are read
+	Backfill BackfillSettings `json:"backfill"`
+	//Verify reads back target documents after writes to find conflicting writes
+	Verify VerifySettings `json:"verify"`
+	//Chaos enables fault injection, it is meant for tests only
+	Chaos *ChaosSettings `json:"chaos"`
+	//ShutdownTimeout is the time every subsystem gets to stop, default 10s
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	//WatermarkInterval is how often watermarks are sent to sinks
+	//implementing WatermarkSink, zero disables them
+	WatermarkInterval Duration `json:"watermarkInterval"`
+	//Resources limit the cpu and memory of redkeepcli, see ApplyResourceSettings
+	Resources ResourceSettings `json:"resources"`
+	//Workers bound the goroutines that handle oplog entries
+	Workers WorkerSettings `json:"workers"`
+	//Source is oplog (default) or changestream to read the changes from a
+	//change stream of the cluster (MongoDB 4.0 and newer) instead of local.oplog.rs
+	Source string `json:"source"`
+	//Checkpoint persists the position in the oplog, see NewTailAgentFromCheckpoint
+	Checkpoint CheckpointSettings `json:"checkpoint"`
+	//Ordering is partitioned (default) or strict to handle the oplog
+	//entries one after another with synchronous sinks
+	Ordering string `json:"ordering"`
+	//CatchUp is empty to handle the oplog in order or newestFirst to
+	//handle new entries first and the backlog since the start in the background
+	CatchUp string `json:"catchUp"`
+	//Metrics serves the metrics for prometheus on their own listener
+	Metrics MetricsSettings `json:"metrics"`
+	//MockTargets discards all writes to target collections while the oplog
+	//is read and the tracked documents are looked up as usual, it measures
+	//the throughput of a host without changing data
+	MockTargets bool `json:"mockTargets"`
+	//DeadLetters stores the changes whose target writes failed to replay them later
+	DeadLetters DeadLetterSettings `json:"deadLetters"`
+	//Retry sends failed writes to targets again, it replaces Mongo.RetryWrites
+	Retry *RetryPolicy `json:"retry"`
+	//AdaptiveBatching grows the watermark interval and batches while the agent lags
+	AdaptiveBatching AdaptiveBatchSettings `json:"adaptiveBatching"`
+	//ReferenceCache keeps looked up tracked documents in memory
+	ReferenceCache ReferenceCacheSettings `json:"referenceCache"`
+	//HotKeys records the tracked documents whose updates change the most
+	//targets and handles the updates of hot keys with their own strategies
+	HotKeys HotKeySettings `json:"hotKeys"`
+	//Log sets the level of the default logger of redkeepcli, see ApplyLogSettings
+	Log LogSettings `json:"log"`
+	//DryRun reports the writes to targets instead of sending them, to
+	//validate watches against production data
+	DryRun *DryRunSettings `json:"dryRun"`
+	//OplogGap decides what happens if the oplog rotated past the start
+	OplogGap OplogGapSettings `json:"oplogGap"`
+	//CacheInvalidation tells the caches of applications in redis about
+	//the writes to targets
+	CacheInvalidation *CacheInvalidationSettings `json:"cacheInvalidation"`
+	//SchemaManifest is a file the JSON schema of the fields redkeep writes
+	//is kept in, it is written again whenever the watches change
+	SchemaManifest string `json:"schemaManifest"`
+	//Elasticsearch is the cluster watches with an Elasticsearch block mirror to
+	Elasticsearch *ElasticsearchSettings `json:"elasticsearch"`
+	//Lineage sends OpenLineage events of the runs of the watches
+	Lineage *LineageSettings `json:"lineage"`
+	//Orphans records the targets that reference tracked documents that do not exist
+	Orphans OrphanSettings `json:"orphans"`
+	//Metadata places the internal collections in their own database or cluster
+	Metadata MetadataSettings `json:"metadata"`
 }
 
 //Mongo is a config struct that changes the way the client
@@ -19,12 +105,27 @@ type Configuration struct {
 //if you have to slaves and one master it would be like
 //slave-01:27018,slave-02:27018,master:27018
 //where slave-01 is either a hostname or an ip
+//Router is optional and identifies the mongos routers of a sharded
+//cluster, writes to the targets go through them while the oplog is
+//read from ConnectionURI.
+//Sharded discovers the shards through the mongos routers of ConnectionURI
+//and tails the oplog of every shard, see NewShardedTailAgentWithStartDate.
+//RetryWrites sends a write that failed with a transient error once more,
+//see Configuration.Retry for more attempts with backoff.
 type Mongo struct {
 	ConnectionURI string `json:"connectionURI" validate:"required,gt=0"`
+	Router        string `json:"router"`
+	Sharded       bool   `json:"sharded"`
+	RetryWrites   bool   `json:"retryWrites"`
 }
 
 //Watch defines one watch that redkeep will do for you
 type Watch struct {
+	//Name is optional and identifies the watch, see Key
+	Name string `json:"name"`
+	//Tenant is optional, the writes of all watches of a tenant share its
+	//rate limit and are counted in metrics with a tenant label
+	Tenant string `json:"tenant"`
 	//TODO validate collections to be in this scheme: database.collection
 	TrackCollection       string            `json:"trackCollection" validate:"required,gt=0"`
 	TrackFields           []string          `json:"trackFields" validate:"required,min=1,dive,min=1"`
@@ -32,12 +133,92 @@ type Watch struct {
 	TargetNormalizedField string            `json:"targetNormalizedField" validate:"required,min=1"`
 	TriggerReference      string            `json:"triggerReference" validate:"required,min=1"`
 	BehaviourSettings     BehaviourSettings `json:"behaviourSettings"`
+	//Transforms change the tracked values before they are written
+	Transforms []TransformConfig `json:"transforms" validate:"dive"`
+	//Retry replaces the retry policy of the configuration for this watch
+	Retry *RetryPolicy `json:"retry"`
+	//History keeps the values of past changes of the tracked fields
+	History *HistorySettings `json:"history"`
+	//Temporal keeps every tracked value with the time it was valid
+	Temporal *TemporalSettings `json:"temporal"`
+	//Elasticsearch mirrors the tracked documents into an index
+	Elasticsearch *ElasticsearchIndex `json:"elasticsearch"`
+	//FieldMap names tracked fields on the targets, relative to the
+	//normalized field, like {"avatar.small": "avatar"}. Tracked fields
+	//without an entry keep their name, without trackFields exactly the
+	//fields of the map are tracked.
+	FieldMap map[string]string `json:"fieldMap"`
+}
+
+//Key identifies the watch. It is the configured name, if there is none
+//it will be trackCollection->targetCollection.targetNormalizedField
+func (w Watch) Key() string {
+	if w.Name != "" {
+		return w.Name
+	}
+
+	return w.TrackCollection + "->" + w.TargetCollection + "." + w.TargetNormalizedField
 }
 
 //BehaviourSettings can define how one specific
-//watch handles special cases
+//watch handles special cases.
+//FollowRenames updates the collections of the watch when they are renamed,
+//StopOnDrop stops the watch when one of its collections is dropped,
+//PauseDuringIndexBuilds delays writes to the target collection while
+//an index is built on it.
+//ConflictPolicy decides what happens when the application writes the
+//normalized field of a target: redkeepWins restores the tracked values,
+//applicationWins keeps the written values with an alert and
+//mergeByTimestamp keeps the later write. Empty leaves the last writer
+//winning without checking.
+//Generations stores the oplog timestamp of every write in the normalized
+//field as _generation and refuses writes of older changes.
+//QueueMissingTargets keeps updates that matched no target and writes
+//them when a target with the reference is inserted, see PendingTargets.
+//ReferenceArray is set when TriggerReference is an array of references,
+//the tracked fields are written into every element as TargetNormalizedField
+//of the element. Updates of a referenced document change the first
+//element that references it.
+//OnDelete decides what happens to the targets when a tracked document is
+//removed: ignore (default) keeps them, unset removes the normalized field,
+//nullify sets the tracked fields to null and delete removes the targets.
+//CascadeDelete without OnDelete is the same as delete.
 type BehaviourSettings struct {
-	CascadeDelete bool `json:"cascadeDelete"`
+	CascadeDelete          bool   `json:"cascadeDelete"`
+	FollowRenames          bool   `json:"followRenames"`
+	StopOnDrop             bool   `json:"stopOnDrop"`
+	PauseDuringIndexBuilds bool   `json:"pauseDuringIndexBuilds"`
+	ConflictPolicy         string `json:"conflictPolicy"`
+	Generations            bool   `json:"generations"`
+	QueueMissingTargets    bool   `json:"queueMissingTargets"`
+	ReferenceArray         bool   `json:"referenceArray"`
+	OnDelete               string `json:"onDelete"`
+}
+
+//Duration can be configured as a string like "1m30s"
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("Invalid duration %s, use a string like \"5s\"", string(data))
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+//MarshalJSON writes the duration as string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
 }
 
 //NewConfiguration loads a configuration from data
@@ -49,14 +230,190 @@ func NewConfiguration(configData []byte) (*Configuration, error) {
 		return nil, err
 	}
 
+	fieldMapTrackFields(config.Watches)
+	fieldMapTrackFields(config.TenantWatches)
+	metadataCollections(&config)
+
+	if err := validateConfiguration(config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+//validateConfiguration checks config like NewConfiguration
+//and loads the plugins it needs
+func validateConfiguration(config Configuration) error {
 	validate := validator.New(&validator.Config{TagName: "validate"})
-	err = validate.Struct(config)
+	if err := validate.Struct(config); err != nil {
+		return getValidationError(err.(validator.ValidationErrors))
+	}
 
-	if err != nil {
-		return nil, getValidationError(err.(validator.ValidationErrors))
+	for _, path := range config.Plugins {
+		if err := LoadPlugin(path); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range config.Sinks {
+		if _, ok := getSinkFactory(s.Type); !ok {
+			return fmt.Errorf("Unknown sink type %s", s.Type)
+		}
+	}
+
+	if err := checkSpoolSettings(config.Sinks); err != nil {
+		return err
+	}
+
+	for _, s := range config.Sinks {
+		if s.Filter == nil {
+			continue
+		}
+
+		if err := checkEventFilter(*s.Filter); err != nil {
+			return err
+		}
+	}
+
+	for _, watches := range [][]Watch{config.Watches, config.TenantWatches} {
+		for _, w := range watches {
+			for _, t := range w.Transforms {
+				if _, ok := getTransformFactory(t.Type); !ok {
+					return fmt.Errorf("Unknown transform type %s", t.Type)
+				}
+			}
+
+			if err := checkReferenceArray(w); err != nil {
+				return err
+			}
+
+			if err := checkOnDelete(w); err != nil {
+				return err
+			}
+
+			if err := checkRetryPolicy(w.Retry); err != nil {
+				return err
+			}
+
+			if err := checkHistorySettings(w); err != nil {
+				return err
+			}
+
+			if err := checkTemporalSettings(w); err != nil {
+				return err
+			}
+
+			if err := checkElasticsearchIndex(w, config.Elasticsearch); err != nil {
+				return err
+			}
+
+			if err := checkFieldMap(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := checkRetryPolicy(config.Retry); err != nil {
+		return err
+	}
+
+	if err := checkNotificationSettings(config.Notifications); err != nil {
+		return err
+	}
+
+	if err := checkDiagnosticsSettings(config.Diagnostics); err != nil {
+		return err
+	}
+
+	if config.CatchUp != "" && config.CatchUp != CatchUpNewestFirst {
+		return fmt.Errorf("Unknown catch up %s, use %s", config.CatchUp, CatchUpNewestFirst)
+	}
+
+	if config.Source != "" && config.Source != SourceOplog && config.Source != SourceChangeStream {
+		return fmt.Errorf("Unknown source %s, use %s or %s", config.Source, SourceOplog, SourceChangeStream)
+	}
+
+	if config.Source == SourceChangeStream && config.CatchUp != "" {
+		return errors.New("Catch up needs the oplog source")
+	}
+
+	if config.Source == SourceChangeStream && config.Mongo.Sharded {
+		return errors.New("Sharded tailing needs the oplog source, change streams of mongos cover all shards")
+	}
+
+	if err := checkConflictPolicies(append(append([]Watch{}, config.Watches...), config.TenantWatches...)); err != nil {
+		return err
+	}
+
+	if err := checkFeatures(config.Features, append(append([]Watch{}, config.Watches...), config.TenantWatches...)); err != nil {
+		return err
+	}
+
+	if err := checkBackfillSettings(config.Backfill); err != nil {
+		return err
+	}
+
+	if err := checkWorkerSettings(config.Workers); err != nil {
+		return err
+	}
+
+	if err := checkOrdering(config); err != nil {
+		return err
+	}
+
+	if err := checkMetadataSettings(config.Metadata); err != nil {
+		return err
+	}
+
+	if err := checkCheckpointSettings(config.Checkpoint); err != nil {
+		return err
+	}
+
+	if err := checkDeadLetterSettings(config.DeadLetters); err != nil {
+		return err
+	}
+
+	if err := checkOrphanSettings(config.Orphans); err != nil {
+		return err
+	}
+
+	if err := checkReferenceCacheSettings(config.ReferenceCache); err != nil {
+		return err
+	}
+
+	if err := checkHotKeySettings(config.HotKeys); err != nil {
+		return err
+	}
+
+	if err := checkLogSettings(config.Log); err != nil {
+		return err
+	}
+
+	if err := checkDryRunSettings(config.DryRun); err != nil {
+		return err
+	}
+
+	if err := checkOplogGapSettings(config.OplogGap, config.Notifications); err != nil {
+		return err
+	}
+
+	if err := checkCacheInvalidationSettings(config.CacheInvalidation); err != nil {
+		return err
+	}
+
+	if err := checkAdminSettings(config.Admin); err != nil {
+		return err
+	}
+
+	if err := checkTenantSettings(config.Tenants); err != nil {
+		return err
+	}
+
+	if err := checkTenantWatches(config.TenantWatches); err != nil {
+		return err
 	}
 
-	return &config, err
+	return nil
 }
 
 func getValidationError(allErrors validator.ValidationErrors) error {
@@ -78,6 +435,12 @@ func getValidationError(allErrors validator.ValidationErrors) error {
 			return errors.New("TrackFields must exactly have one non-empty field, more are currently not supported")
 		case "TargetNormalizedField":
 			return errors.New("TargetNormalizedField must not be empty")
+		case "Type":
+			return errors.New("Sink and transform types must not be empty")
+		case "Name":
+			return errors.New("Notifier and rule names must not be empty")
+		case "Notify":
+			return errors.New("Conditions and rules must notify atleast one notifier")
 		default:
 			return allErrors
 		}
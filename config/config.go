@@ -0,0 +1,25 @@
+//Package config has the configuration of redkeep agents. Its types are
+//aliases of the ones in package redkeep, so both can be mixed, and they
+//belong to the stable API.
+package config
+
+import "github.com/manyminds/redkeep"
+
+//Configuration is the configuration of one agent
+type Configuration = redkeep.Configuration
+
+//Watch denormalizes the tracked fields of one collection into a target
+type Watch = redkeep.Watch
+
+//Builder creates a Configuration in code
+type Builder = redkeep.ConfigBuilder
+
+//New reads a configuration file and checks it
+func New(configData []byte) (*Configuration, error) {
+	return redkeep.NewConfiguration(configData)
+}
+
+//NewBuilder starts an empty configuration
+func NewBuilder() *Builder {
+	return redkeep.NewConfig()
+}
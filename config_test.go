@@ -112,6 +112,87 @@ var _ = Describe("Config Testsuite", func() {
 			Expect(err.Error()).To(Equal("TrackFields must exactly have one non-empty field, more are currently not supported"))
 		})
 
+		It("will identify watches by name or by their collections", func() {
+			config, err := NewConfiguration([]byte(templateForTestsConfig))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Watches[0].Key()).To(Equal("xAx->xCx.xDx"))
+
+			config.Watches[0].Name = "userComments"
+			Expect(config.Watches[0].Key()).To(Equal("userComments"))
+		})
+
+		It("will reject fault injection with more than 100 percent dropped writes", func() {
+			config := strings.Replace(templateForTestsConfig, `"watches"`, `"chaos": { "dropWritesPercent": 150 }, "watches"`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(HaveOccurred())
+
+			config = strings.Replace(templateForTestsConfig, `"watches"`, `"chaos": { "dropWritesPercent": 5, "lookupDelay": "50ms", "killCursorEvery": 100 }, "watches"`, 1)
+			loaded, err := NewConfiguration([]byte(config))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Chaos.KillCursorEvery).To(Equal(100))
+		})
+
+		It("will only accept newestFirst as catch up", func() {
+			config := strings.Replace(templateForTestsConfig, `"watches"`, `"catchUp": "random", "watches"`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(HaveOccurred())
+
+			config = strings.Replace(templateForTestsConfig, `"watches"`, `"catchUp": "newestFirst", "watches"`, 1)
+			loaded, err := NewConfiguration([]byte(config))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.CatchUp).To(Equal(CatchUpNewestFirst))
+		})
+
+		It("will not combine reference arrays with generations", func() {
+			config := strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "behaviourSettings": { "referenceArray": true, "generations": true }`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(MatchError("Reference arrays can not be combined with generations, conflict policies or queued targets"))
+
+			config = strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "behaviourSettings": { "referenceArray": true }`, 1)
+			loaded, err := NewConfiguration([]byte(config))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Watches[0].BehaviourSettings.ReferenceArray).To(BeTrue())
+		})
+
+		It("will only accept dead letter collections with database", func() {
+			config := strings.Replace(templateForTestsConfig, `"watches"`, `"deadLetters": { "collection": "failed" }, "watches"`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(MatchError("Dead letter collection failed must be database.collection"))
+
+			config = strings.Replace(templateForTestsConfig, `"watches"`, `"deadLetters": { "collection": "redkeep.failed", "retention": { "maxSize": 100 } }, "watches"`, 1)
+			loaded, err := NewConfiguration([]byte(config))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.DeadLetters.Retention.MaxSize).To(Equal(100))
+		})
+
+		It("will track the fields of a field map", func() {
+			config := strings.Replace(templateForTestsConfig, `"trackFields": ["xBx"], `, `"fieldMap": {"name": "userName", "avatar.small": "userAvatar"},`, 1)
+			loaded, err := NewConfiguration([]byte(config))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Watches[0].TrackFields).To(Equal([]string{"avatar.small", "name"}))
+		})
+
+		It("will validate field maps", func() {
+			withMap := func(fieldMap string) error {
+				config := strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "fieldMap": `+fieldMap, 1)
+				_, err := NewConfiguration([]byte(config))
+				return err
+			}
+
+			Expect(withMap(`{"xBx": "renamed"}`)).To(Succeed())
+			Expect(withMap(`{"other": "renamed"}`)).To(MatchError("Field other of the field map of watch xAx->xCx.xDx is not tracked"))
+			Expect(withMap(`{"xBx": "$renamed"}`)).To(MatchError(`Invalid target field "$renamed" for xBx of watch xAx->xCx.xDx`))
+			Expect(withMap(`{"xBx": "_history"}`)).To(MatchError("Target field _history of watch xAx->xCx.xDx is reserved"))
+
+			config := strings.Replace(templateForTestsConfig, `"trackFields": ["xBx"], `, `"fieldMap": {"a": "user", "b": "user.name"},`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err.Error()).To(MatchRegexp("Target fields user(.name)? and user(.name)? of watch xAx->xCx.xDx overlap"))
+
+			config = strings.Replace(templateForTestsConfig, `"trackFields": ["xBx"], `, `"trackFields": ["a", "b"], "fieldMap": {"a": "b"},`, 1)
+			_, err = NewConfiguration([]byte(config))
+			Expect(err).To(MatchError("Fields a and b of watch xAx->xCx.xDx are both written to b"))
+		})
+
 		It("will load correctly", func() {
 			file, err := ioutil.ReadFile("./example-configuration.json")
 			Expect(err).ToNot(HaveOccurred())
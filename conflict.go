@@ -0,0 +1,190 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//conflict policies of a watch, see BehaviourSettings
+const (
+	ConflictRedkeepWins      = "redkeepWins"
+	ConflictApplicationWins  = "applicationWins"
+	ConflictMergeByTimestamp = "mergeByTimestamp"
+)
+
+//maxTrackedChanges limits the change times kept for mergeByTimestamp,
+//they are forgotten once the limit is reached
+const maxTrackedChanges = 100000
+
+var conflictPolicies = map[string]bool{
+	"":                       true,
+	ConflictRedkeepWins:      true,
+	ConflictApplicationWins:  true,
+	ConflictMergeByTimestamp: true,
+}
+
+func checkConflictPolicies(watches []Watch) error {
+	for _, w := range watches {
+		if !conflictPolicies[w.BehaviourSettings.ConflictPolicy] {
+			return fmt.Errorf("Unknown conflict policy %s of watch %s, use %s, %s or %s", w.BehaviourSettings.ConflictPolicy,
+				w.Key(), ConflictRedkeepWins, ConflictApplicationWins, ConflictMergeByTimestamp)
+		}
+	}
+
+	return nil
+}
+
+//ConflictTracker can be implemented by a Tracker to resolve writes of
+//the application to the normalized field of a target document.
+//HandleConflict is called for updates of a target that change the
+//normalized field, TrackedChange for updates of a tracked document.
+//at is the time of the oplog entry. It is only used for watches
+//with a conflict policy.
+type ConflictTracker interface {
+	HandleConflict(w Watch, command map[string]interface{}, selector map[string]interface{}, at time.Time)
+	TrackedChange(w Watch, selector map[string]interface{}, at time.Time)
+}
+
+//writesNormalizedField is true if an update command changes the normalized field of w
+func writesNormalizedField(w Watch, command map[string]interface{}) bool {
+	for key, value := range command {
+		fields, ok := value.(map[string]interface{})
+		if !strings.HasPrefix(key, "$") || !ok {
+			fields = map[string]interface{}{key: value}
+		}
+
+		for field := range fields {
+			if field == w.TargetNormalizedField || strings.HasPrefix(field, w.TargetNormalizedField+".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//trackedChanges remembers when tracked documents were changed last,
+//all methods can be called on nil
+type trackedChanges struct {
+	sync.Mutex
+	times map[string]time.Time
+}
+
+func newTrackedChanges() *trackedChanges {
+	return &trackedChanges{times: map[string]time.Time{}}
+}
+
+func (c *trackedChanges) record(document string, at time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if len(c.times) >= maxTrackedChanges {
+		c.times = map[string]time.Time{}
+	}
+
+	if at.After(c.times[document]) {
+		c.times[document] = at
+	}
+}
+
+func (c *trackedChanges) changed(document string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	at, ok := c.times[document]
+	return at, ok
+}
+
+//conflictResolution decides if the values of the tracked document are
+//restored after the application wrote the normalized field at written.
+//For mergeByTimestamp the later write wins, an unknown change of the
+//tracked document happened before the agent started.
+func conflictResolution(policy string, written, tracked time.Time, trackedKnown bool) bool {
+	switch policy {
+	case ConflictRedkeepWins:
+		return true
+	case ConflictMergeByTimestamp:
+		return trackedKnown && tracked.After(written)
+	default:
+		return false
+	}
+}
+
+func (c changeTracker) TrackedChange(w Watch, selector map[string]interface{}, at time.Time) {
+	c.changes.record(fmt.Sprintf("%s/%v", w.TrackCollection, selector["_id"]), at)
+}
+
+func (c changeTracker) HandleConflict(w Watch, command map[string]interface{}, selector map[string]interface{}, at time.Time) {
+	session, done := c.useSession()
+	defer done()
+
+	p := strings.Index(w.TargetCollection, ".")
+	targets := session.DB(w.TargetCollection[:p]).C(w.TargetCollection[p+1:])
+	target := map[string]interface{}{}
+	if err := targets.FindId(selector["_id"]).One(&target); err != nil {
+		logWarn("Target of conflict not found", watchFields(w).withError(err))
+		return
+	}
+
+	ref, ok := getReference(GetValue(w.TriggerReference, target), w.TargetCollection[:p])
+	if !ok {
+		return
+	}
+
+	tracked := map[string]interface{}{}
+	if err := session.DB(ref.Database).C(ref.Collection).FindId(ref.Id).One(&tracked); err != nil {
+		logWarn("Tracked document of conflict not found", watchFields(w).withError(err))
+		return
+	}
+
+	query := BuildInsertQuery(w, tracked)
+	if query == nil || !c.transform(w, ref.Id, query) {
+		return
+	}
+
+	fields := divergentFields(query, target)
+	if len(fields) == 0 {
+		return
+	}
+
+	c.metrics.add(MetricWriteConflicts, 1)
+	changed, known := c.changes.changed(fmt.Sprintf("%s.%s/%v", ref.Database, ref.Collection, ref.Id))
+	if !conflictResolution(w.BehaviourSettings.ConflictPolicy, at, changed, known) {
+		c.events.record(EventAlert, w.Key(), fmt.Sprintf("Application wrote %v of target %v, the value was kept", fields, selector["_id"]))
+		return
+	}
+
+	if err := c.hooks.beforeWrite(w, command, query); err != nil {
+		logInfo("Write skipped by hook", watchFields(w).withError(err))
+		return
+	}
+
+	c.builds.wait(w)
+	c.tenants.wait(w.Tenant)
+	err := errInjectedFault
+	if !c.chaos.dropWrite() {
+		restore := bson.M{"_id": selector["_id"]}
+		intended := IntendedWrite{Namespace: targets.FullName, Selector: restore, Update: query}
+		err = c.write(w, session, intended, func() error {
+			return targets.Update(restore, query)
+		})
+	}
+	c.hooks.afterWrite(w, command, query, err)
+	c.countWrite(w, err)
+	if err != nil {
+		c.events.record(EventError, w.Key(), "Restore of "+w.TargetCollection+" failed: "+err.Error())
+		return
+	}
+
+	c.events.record(EventAlert, w.Key(), fmt.Sprintf("Application wrote %v of target %v, the tracked values were restored", fields, selector["_id"]))
+}
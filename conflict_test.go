@@ -0,0 +1,51 @@
+package redkeep_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conflict policies", func() {
+	w := Watch{TargetNormalizedField: "meta", BehaviourSettings: BehaviourSettings{ConflictPolicy: ConflictRedkeepWins}}
+
+	It("finds updates of the normalized field", func() {
+		Expect(WritesNormalizedField(w, map[string]interface{}{"$set": map[string]interface{}{"meta.name": "Hans"}})).To(BeTrue())
+		Expect(WritesNormalizedField(w, map[string]interface{}{"$unset": map[string]interface{}{"meta": ""}})).To(BeTrue())
+		Expect(WritesNormalizedField(w, map[string]interface{}{"_id": "1", "meta": map[string]interface{}{"name": "Hans"}})).To(BeTrue())
+		Expect(WritesNormalizedField(w, map[string]interface{}{"$set": map[string]interface{}{"metadata": "x", "text": "hi"}})).To(BeFalse())
+	})
+
+	It("restores or keeps the written values by policy", func() {
+		written := time.Now()
+		Expect(RestoresAfterConflict(ConflictRedkeepWins, written, time.Time{}, false)).To(BeTrue())
+		Expect(RestoresAfterConflict(ConflictApplicationWins, written, written.Add(time.Minute), true)).To(BeFalse())
+		Expect(RestoresAfterConflict(ConflictMergeByTimestamp, written, written.Add(time.Minute), true)).To(BeTrue())
+		Expect(RestoresAfterConflict(ConflictMergeByTimestamp, written, written.Add(-time.Minute), true)).To(BeFalse())
+		Expect(RestoresAfterConflict(ConflictMergeByTimestamp, written, time.Time{}, false)).To(BeFalse())
+	})
+
+	It("keeps the latest change of a tracked document", func() {
+		changes := NewTrackedChanges()
+		now := time.Now()
+		changes.Record("app.user/1", now)
+		changes.Record("app.user/1", now.Add(-time.Minute))
+
+		changed, ok := changes.Changed("app.user/1")
+		Expect(ok).To(BeTrue())
+		Expect(changed).To(Equal(now))
+
+		_, ok = changes.Changed("app.user/2")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("rejects unknown policies", func() {
+		config := strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "behaviourSettings": { "conflictPolicy": "random" }`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError(ContainSubstring("Unknown conflict policy random")))
+	})
+})
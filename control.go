@@ -0,0 +1,299 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//pauseSwitch stops the agent from reading the oplog while it is paused,
+//entries that were already read are still handled
+type pauseSwitch struct {
+	sync.Mutex
+	metrics *metricRegistry
+	//resumed is closed on resume, nil while the agent is not paused
+	resumed chan bool
+}
+
+func newPauseSwitch(metrics *metricRegistry) *pauseSwitch {
+	metrics.set(MetricPaused, 0)
+	return &pauseSwitch{metrics: metrics}
+}
+
+//pause returns false if the agent was paused already
+func (p *pauseSwitch) pause() bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.resumed != nil {
+		return false
+	}
+
+	p.resumed = make(chan bool)
+	p.metrics.set(MetricPaused, 1)
+	return true
+}
+
+//resume returns false if the agent was not paused
+func (p *pauseSwitch) resume() bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.resumed == nil {
+		return false
+	}
+
+	close(p.resumed)
+	p.resumed = nil
+	p.metrics.set(MetricPaused, 0)
+	return true
+}
+
+func (p *pauseSwitch) paused() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.resumed != nil
+}
+
+//wait blocks while the agent is paused until it is resumed or ctx is done
+func (p *pauseSwitch) wait(ctx context.Context) {
+	p.Lock()
+	resumed := p.resumed
+	p.Unlock()
+	if resumed == nil {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+	}
+}
+
+//watchRescans runs the rescans of single watches while the agent
+//tails, at most one per watch. Stopping cancels the running ones.
+//While the oplog is read pool has the workers of the live entries.
+type watchRescans struct {
+	sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running map[string]bool
+	done    sync.WaitGroup
+	pool    *workerPool
+}
+
+func newWatchRescans() *watchRescans {
+	return &watchRescans{running: map[string]bool{}}
+}
+
+func (r *watchRescans) start() error {
+	r.Lock()
+	defer r.Unlock()
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+func (r *watchRescans) stop() {
+	r.Lock()
+	r.cancel()
+	r.Unlock()
+	r.done.Wait()
+}
+
+//usePool lets rescans submit their writes to pool, nil once it is closed
+func (r *watchRescans) usePool(pool *workerPool) {
+	r.Lock()
+	defer r.Unlock()
+	r.pool = pool
+}
+
+//submit hands job to the workers behind the queued jobs of document,
+//false if the agent does not read the oplog
+func (r *watchRescans) submit(document, partition string, job func(Tracker)) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.pool == nil {
+		return false
+	}
+
+	r.pool.submitPlaced(document, partition, job)
+	return true
+}
+
+//run starts rescan of the watch with key in the background
+func (r *watchRescans) run(key string, rescan func(ctx context.Context) error) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.ctx == nil || r.ctx.Err() != nil {
+		return errors.New("The agent is not tailing")
+	}
+
+	if r.running[key] {
+		return fmt.Errorf("Rescan of %s is already running", key)
+	}
+
+	r.running[key] = true
+	r.done.Add(1)
+	go func(ctx context.Context) {
+		defer r.done.Done()
+		if err := rescan(ctx); err != nil {
+			logError("Rescan failed", Fields{"watch": key}.withError(err))
+		}
+
+		r.Lock()
+		delete(r.running, key)
+		r.Unlock()
+	}(r.ctx)
+
+	return nil
+}
+
+//Pause stops reading the oplog until Resume, entries that were already
+//read are still handled. It returns false if the agent was paused already.
+func (t *TailAgent) Pause() bool {
+	if !t.pause.pause() {
+		return false
+	}
+
+	t.events.record(EventLifecycle, "", "Tailing paused")
+	return true
+}
+
+//Resume reads the oplog again after Pause, from the entry after the
+//last one that was read. It returns false if the agent was not paused.
+func (t *TailAgent) Resume() bool {
+	if !t.pause.resume() {
+		return false
+	}
+
+	t.events.record(EventLifecycle, "", "Tailing resumed")
+	return true
+}
+
+//Paused is true while the agent does not read the oplog
+func (t *TailAgent) Paused() bool {
+	return t.pause.paused()
+}
+
+//RescanWatch writes the tracked fields of all documents of the tracked
+//collection of the watch with key to its targets again, like a backfill
+//of only this watch. It runs in the background while the agent tails,
+//its progress is shown in the backfill metrics and the event log. Once
+//done the checkpoint of a watch that is not paused follows the watermarks.
+func (t *TailAgent) RescanWatch(key string) error {
+	for _, w := range t.watches.list() {
+		if w.Key() != key {
+			continue
+		}
+
+		return t.rescans.run(key, func(ctx context.Context) error {
+			if err := t.backfill(ctx, []Watch{w}, nil); err != nil {
+				return err
+			}
+
+			if !t.watches.paused(key) {
+				t.watchCheckpoints.release(key)
+			}
+			return nil
+		})
+	}
+
+	return fmt.Errorf("Unknown watch %s", key)
+}
+
+//CheckpointStatus is the stored checkpoint of an agent and its current lag
+type CheckpointStatus struct {
+	Enabled    bool                `json:"enabled"`
+	Checkpoint bson.MongoTimestamp `json:"checkpoint"`
+	Time       time.Time           `json:"time"`
+	LagSeconds float64             `json:"lagSeconds"`
+	//Watches are the checkpoints of the watches, see CheckpointSettings.Watches
+	Watches map[string]WatchCheckpoint `json:"watches"`
+}
+
+//Checkpoint returns the stored checkpoint, it is zero if none
+//is configured or none was stored yet
+func (t *TailAgent) Checkpoint() (CheckpointStatus, error) {
+	status := CheckpointStatus{LagSeconds: t.metrics.get(MetricLagSeconds), Watches: t.WatchCheckpoints()}
+	if t.checkpoint == nil {
+		return status, nil
+	}
+
+	ts, err := t.checkpoint.load()
+	if err != nil {
+		return status, err
+	}
+
+	status.Enabled, status.Checkpoint = true, ts
+	if ts > 0 {
+		status.Time = time.Unix(int64(ts>>32), 0)
+	}
+
+	return status, nil
+}
+
+//servePause pauses the agent with POST
+func (t *TailAgent) servePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		t.Pause()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": t.Paused()})
+}
+
+//serveResume resumes the agent with POST
+func (t *TailAgent) serveResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		t.Resume()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": t.Paused()})
+}
+
+func (t *TailAgent) serveCheckpoint(w http.ResponseWriter, r *http.Request) {
+	status, err := t.Checkpoint()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+//watchActions are the actions of POST /watches
+var watchActions = map[string]func(t *TailAgent, key string) error{
+	"rescan":   (*TailAgent).RescanWatch,
+	"pause":    (*TailAgent).PauseWatch,
+	"resume":   (*TailAgent).ResumeWatch,
+	"catch-up": (*TailAgent).CatchUpWatch,
+}
+
+//serveWatches lists the active watches, a POST with the watch parameter
+//applies the action parameter (default rescan) to that watch
+func (t *TailAgent) serveWatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusOK, t.watches.list())
+		return
+	}
+
+	key, action := r.URL.Query().Get("watch"), r.URL.Query().Get("action")
+	if action == "" {
+		action = "rescan"
+	}
+
+	apply, ok := watchActions[action]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown action " + action + ", use rescan, pause, resume or catch-up"})
+		return
+	}
+
+	if err := apply(t, key); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{action: key})
+}
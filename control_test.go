@@ -0,0 +1,96 @@
+package redkeep_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runtime control", func() {
+	watches := []Watch{{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username"},
+		TargetCollection:      "app.comment",
+		TargetNormalizedField: "meta.user",
+		TriggerReference:      "user",
+	}}
+
+	It("will pause and resume the agent", func() {
+		agent, handler, _, _ := ControlledAgent(watches)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		paused := func(path string) bool {
+			response, err := http.Post(server.URL+path, "application/json", nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer response.Body.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+			var state map[string]bool
+			Expect(json.NewDecoder(response.Body).Decode(&state)).To(Succeed())
+			return state["paused"]
+		}
+
+		Expect(paused("/pause")).To(BeTrue())
+		Expect(agent.Pause()).To(BeFalse())
+		Expect(agent.Status().Metrics[MetricPaused]).To(Equal(1.0))
+		Expect(paused("/resume")).To(BeFalse())
+		Expect(agent.Resume()).To(BeFalse())
+		Expect(agent.Status().Metrics[MetricPaused]).To(Equal(0.0))
+	})
+
+	It("will list the active watches", func() {
+		_, handler, _, _ := ControlledAgent(watches)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/watches")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+
+		var listed []Watch
+		Expect(json.NewDecoder(response.Body).Decode(&listed)).To(Succeed())
+		Expect(listed).To(HaveLen(1))
+		Expect(listed[0].Key()).To(Equal("userComments"))
+	})
+
+	It("will only rescan known watches while the agent tails", func() {
+		agent, handler, start, stop := ControlledAgent(watches)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		Expect(agent.RescanWatch("userComments")).To(MatchError("The agent is not tailing"))
+		response, err := http.Post(server.URL+"/watches?watch=itemReviews", "application/json", nil)
+		Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+
+		start()
+		running := make(chan bool)
+		Expect(RunRescan(agent, "userComments", func(ctx context.Context) error {
+			close(running)
+			<-ctx.Done()
+			return ctx.Err()
+		})).To(Succeed())
+		<-running
+		Expect(agent.RescanWatch("userComments")).To(MatchError("Rescan of userComments is already running"))
+
+		stop()
+		Expect(agent.RescanWatch("userComments")).To(MatchError("The agent is not tailing"))
+	})
+
+	It("will show that no checkpoint is configured", func() {
+		agent, _, _, _ := ControlledAgent(watches)
+		status, err := agent.Checkpoint()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Enabled).To(BeFalse())
+		Expect(status.Checkpoint).To(BeZero())
+	})
+})
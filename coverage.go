@@ -0,0 +1,108 @@
+package redkeep
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//CoverageReport shows which oplog traffic the watches cover
+type CoverageReport struct {
+	Entries int `json:"entries"`
+	//Unwatched counts the entries of namespaces no watch tracks or targets
+	Unwatched map[string]int `json:"unwatched"`
+	//Matched counts the entries of the tracked and target collection per watch
+	Matched map[string]int `json:"matched"`
+	//Unused lists the watches without any entry
+	Unused []string `json:"unused"`
+}
+
+type coverageCounter struct {
+	watches []Watch
+	report  CoverageReport
+}
+
+func newCoverageCounter(watches []Watch) *coverageCounter {
+	c := &coverageCounter{
+		watches: watches,
+		report:  CoverageReport{Unwatched: map[string]int{}, Matched: map[string]int{}, Unused: []string{}},
+	}
+	for _, w := range watches {
+		c.report.Matched[w.Key()] = 0
+	}
+
+	return c
+}
+
+//add counts an oplog entry, no-ops and commands are ignored
+func (c *coverageCounter) add(entry map[string]interface{}) {
+	ns, _ := entry["ns"].(string)
+	op, _ := entry["op"].(string)
+	if ns == "" || op == "n" || op == "c" {
+		return
+	}
+
+	c.report.Entries++
+	matched := false
+	for _, w := range c.watches {
+		if w.TrackCollection == ns || w.TargetCollection == ns {
+			c.report.Matched[w.Key()]++
+			matched = true
+		}
+	}
+
+	if !matched {
+		c.report.Unwatched[ns]++
+	}
+}
+
+func (c *coverageCounter) result() CoverageReport {
+	report := c.report
+	report.Unused = []string{}
+	for key, count := range report.Matched {
+		if count == 0 {
+			report.Unused = append(report.Unused, key)
+		}
+	}
+	sort.Strings(report.Unused)
+
+	return report
+}
+
+//ReportWatchCoverage tails the oplog for window and reports the namespaces
+//with traffic that no watch covers and the watches that saw no traffic
+func ReportWatchCoverage(session *mgo.Session, watches []Watch, window time.Duration) (CoverageReport, error) {
+	counter := newCoverageCounter(watches)
+	deadline := time.Now().Add(window)
+	last := mongoTimestamp{time.Now()}.MongoTimestamp()
+
+	oplog := session.DB("local").C("oplog.rs")
+	tail := func() *mgo.Iter {
+		return oplog.Find(bson.M{"ts": bson.M{"$gt": last}}).LogReplay().Sort("$natural").Tail(requeryDuration)
+	}
+
+	iter := tail()
+	for time.Now().Before(deadline) {
+		entry := map[string]interface{}{}
+		if iter.Next(&entry) {
+			if ts, ok := entry["ts"].(bson.MongoTimestamp); ok {
+				last = ts
+			}
+			counter.add(entry)
+			continue
+		}
+
+		if iter.Err() != nil {
+			return counter.result(), iter.Close()
+		}
+
+		if !iter.Timeout() {
+			iter.Close()
+			iter = tail()
+		}
+	}
+
+	return counter.result(), iter.Close()
+}
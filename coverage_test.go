@@ -0,0 +1,31 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watch coverage", func() {
+	watches := []Watch{
+		{TrackCollection: "live.user", TargetCollection: "live.comment", TargetNormalizedField: "user"},
+		{Name: "invoices", TrackCollection: "billing.customer", TargetCollection: "billing.invoice", TargetNormalizedField: "customer"},
+	}
+
+	It("reports unwatched namespaces and unused watches", func() {
+		report := CountCoverage(watches, []map[string]interface{}{
+			{"op": "u", "ns": "live.user"},
+			{"op": "i", "ns": "live.comment"},
+			{"op": "i", "ns": "live.session"},
+			{"op": "i", "ns": "live.session"},
+			{"op": "n", "ns": ""},
+			{"op": "c", "ns": "live.$cmd"},
+		})
+
+		Expect(report.Entries).To(Equal(4))
+		Expect(report.Unwatched).To(Equal(map[string]int{"live.session": 2}))
+		Expect(report.Matched).To(Equal(map[string]int{"live.user->live.comment.user": 2, "invoices": 0}))
+		Expect(report.Unused).To(Equal([]string{"invoices"}))
+	})
+})
@@ -0,0 +1,345 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//DeadLetterSettings store the changes whose target writes failed in
+//Collection (database.collection), they can be replayed later. Retention
+//prunes the collection whenever a dead letter is stored. Encryption seals
+//the documents of the oplog entries, o and o2, of stored dead letters.
+type DeadLetterSettings struct {
+	Collection string             `json:"collection"`
+	Retention  RetentionSettings  `json:"retention"`
+	Encryption *PayloadEncryption `json:"encryption"`
+}
+
+func checkDeadLetterSettings(settings DeadLetterSettings) error {
+	if c := settings.Collection; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Dead letter collection %s must be database.collection", c)
+	}
+
+	if settings.Encryption != nil && settings.Collection == "" {
+		return errors.New("Dead letter encryption needs a dead letter collection")
+	}
+
+	return checkPayloadEncryption(settings.Encryption)
+}
+
+//DeadLetter is a change whose target write failed. It has the fields of
+//its oplog entry: op is i for targets that were inserted or updated, their
+//values are looked up again, and u for updates of tracked documents. ts is
+//only set for watches with generations. With encryption o and o2 are
+//stored sealed and opened again when dead letters are listed.
+type DeadLetter struct {
+	ID         bson.ObjectId          `bson:"_id" json:"id"`
+	Watch      string                 `bson:"watch" json:"watch"`
+	Namespace  string                 `bson:"ns" json:"ns"`
+	Operation  string                 `bson:"op" json:"op"`
+	Command    map[string]interface{} `bson:"o" json:"o"`
+	Selector   map[string]interface{} `bson:"o2" json:"o2"`
+	Generation bson.MongoTimestamp    `bson:"ts" json:"ts"`
+	Error      string                 `bson:"error" json:"error"`
+	Attempts   int                    `bson:"attempts" json:"attempts"`
+	Failed     time.Time              `bson:"failed" json:"failed"`
+	Sealed     []byte                 `bson:"sealed,omitempty" json:"-"`
+}
+
+//deadLetterPayload are the fields of a dead letter that are encrypted
+type deadLetterPayload struct {
+	Command  map[string]interface{} `bson:"o"`
+	Selector map[string]interface{} `bson:"o2"`
+}
+
+//seal replaces the payload of letter with its encrypted form
+func (letter *DeadLetter) seal(encryption *PayloadEncryption) error {
+	sealed, err := encryption.seal(deadLetterPayload{Command: letter.Command, Selector: letter.Selector})
+	if err != nil {
+		return err
+	}
+
+	letter.Command, letter.Selector, letter.Sealed = nil, nil, sealed
+	return nil
+}
+
+//open restores the payload of a sealed letter
+func (letter *DeadLetter) open(encryption *PayloadEncryption) error {
+	if len(letter.Sealed) == 0 {
+		return nil
+	}
+
+	payload := deadLetterPayload{}
+	if err := encryption.open(letter.Sealed, &payload); err != nil {
+		return fmt.Errorf("Dead letter %s: %s", letter.ID.Hex(), err)
+	}
+
+	letter.Command, letter.Selector, letter.Sealed = payload.Command, payload.Selector, nil
+	return nil
+}
+
+//deadLetters stores failed changes, all methods can be called on nil
+type deadLetters struct {
+	metrics    *metricRegistry
+	encryption *PayloadEncryption
+	store      func(letter DeadLetter) error
+}
+
+func newDeadLetters(settings DeadLetterSettings, metrics *metricRegistry, session *mgo.Session) *deadLetters {
+	if settings.Collection == "" || session == nil {
+		return nil
+	}
+
+	return &deadLetters{
+		metrics:    metrics,
+		encryption: settings.Encryption,
+		store: func(letter DeadLetter) error {
+			s := session.Copy()
+			defer s.Close()
+			collection, err := deadLetterCollection(s, settings)
+			if err != nil {
+				return err
+			}
+
+			if err := collection.Insert(letter); err != nil {
+				return err
+			}
+
+			return pruneCollection(collection, "failed", settings.Retention, time.Now())
+		},
+	}
+}
+
+//add stores the change of w that failed with err
+func (d *deadLetters) add(w Watch, op, ns string, command, selector map[string]interface{}, generation bson.MongoTimestamp, err error) {
+	if d == nil {
+		return
+	}
+
+	letter := DeadLetter{
+		ID:         bson.NewObjectId(),
+		Watch:      w.Key(),
+		Namespace:  ns,
+		Operation:  op,
+		Command:    command,
+		Selector:   selector,
+		Generation: generation,
+		Error:      err.Error(),
+		Attempts:   1,
+		Failed:     time.Now(),
+	}
+
+	if d.encryption != nil {
+		if err := letter.seal(d.encryption); err != nil {
+			logError("Dead letter could not be encrypted", errorFields(err))
+			return
+		}
+	}
+
+	if err := d.store(letter); err != nil {
+		logError("Dead letter could not be stored", errorFields(err))
+		return
+	}
+
+	d.metrics.add(MetricDeadLetters, 1)
+}
+
+//replay writes the change of letter again with w
+func (c changeTracker) replay(w Watch, letter DeadLetter) error {
+	switch letter.Operation {
+	case "i":
+		p := strings.Index(letter.Namespace, ".")
+		if p < 1 {
+			return fmt.Errorf("Invalid namespace %s", letter.Namespace)
+		}
+
+		originRef := mgo.DBRef{Database: letter.Namespace[:p], Collection: letter.Namespace[p+1:], Id: letter.Selector["_id"]}
+		return c.insert(w, letter.Command, originRef, letter.Generation)
+	case "u":
+		return c.update(w, letter.Command, letter.Selector, letter.Generation)
+	case "d":
+		return c.remove(w, letter.Command, letter.Selector)
+	}
+
+	return fmt.Errorf("Unknown operation %s", letter.Operation)
+}
+
+//DeadLetterFilter selects stored dead letters by watch key and
+//failure time, empty fields select all
+type DeadLetterFilter struct {
+	Watch string
+	Since time.Time
+}
+
+func (f DeadLetterFilter) selector() bson.M {
+	selector := bson.M{}
+	if f.Watch != "" {
+		selector["watch"] = f.Watch
+	}
+
+	if !f.Since.IsZero() {
+		selector["failed"] = bson.M{"$gte": f.Since}
+	}
+
+	return selector
+}
+
+//DeadLetterReport counts the outcome of a replay of dead letters.
+//Failed dead letters are kept with one more attempt, the ones of
+//watches that are no longer configured are skipped.
+type DeadLetterReport struct {
+	Replayed int `json:"replayed"`
+	Failed   int `json:"failed"`
+	Skipped  int `json:"skipped"`
+}
+
+func deadLetterCollection(session *mgo.Session, settings DeadLetterSettings) (*mgo.Collection, error) {
+	p := strings.Index(settings.Collection, ".")
+	if p < 1 {
+		return nil, errors.New("No dead letter collection configured")
+	}
+
+	return session.DB(settings.Collection[:p]).C(settings.Collection[p+1:]), nil
+}
+
+//ListDeadLetters returns the stored dead letters selected by filter,
+//oldest first. limit zero returns all of them. Encrypted dead letters
+//are opened with the encryption of settings.
+func ListDeadLetters(session *mgo.Session, settings DeadLetterSettings, filter DeadLetterFilter, limit int) ([]DeadLetter, error) {
+	collection, err := deadLetterCollection(session, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	if err := collection.Find(filter.selector()).Sort("failed").Limit(limit).All(&letters); err != nil {
+		return nil, err
+	}
+
+	for i := range letters {
+		if err := letters[i].open(settings.Encryption); err != nil {
+			return nil, err
+		}
+	}
+
+	return letters, nil
+}
+
+//PurgeDeadLetters removes the stored dead letters selected
+//by filter and returns how many were removed
+func PurgeDeadLetters(session *mgo.Session, settings DeadLetterSettings, filter DeadLetterFilter) (int, error) {
+	collection, err := deadLetterCollection(session, settings)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := collection.RemoveAll(filter.selector())
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
+
+//ReplayDeadLetters writes up to limit dead letters selected by filter again
+//with session, oldest first, and removes the ones that succeed from the
+//dead letters of metadata, see DialMetadata. limit zero replays all of them.
+func ReplayDeadLetters(session, metadata *mgo.Session, settings DeadLetterSettings, watches []Watch, filter DeadLetterFilter, limit int) (DeadLetterReport, error) {
+	transforms, err := newWatchTransforms(watches, nil)
+	if err != nil {
+		return DeadLetterReport{}, err
+	}
+
+	tracker := &changeTracker{session: session, hooks: newHookRegistry(), transforms: transforms}
+	return replayDeadLetters(metadata, settings, tracker, watches, filter, limit)
+}
+
+//ReplayDeadLetters works like the function of the same name and writes
+//with the tracker of the agent, its hooks and transforms included
+func (t *TailAgent) ReplayDeadLetters(filter DeadLetterFilter, limit int) (DeadLetterReport, error) {
+	tracker, ok := t.tracker.(*changeTracker)
+	if !ok || t.session == nil {
+		return DeadLetterReport{}, errors.New("The agent is not connected")
+	}
+
+	return replayDeadLetters(t.metadata, t.config.DeadLetters, tracker, t.watches.list(), filter, limit)
+}
+
+func replayDeadLetters(session *mgo.Session, settings DeadLetterSettings, tracker *changeTracker, watches []Watch, filter DeadLetterFilter, limit int) (DeadLetterReport, error) {
+	report := DeadLetterReport{}
+	letters, err := ListDeadLetters(session, settings, filter, limit)
+	if err != nil {
+		return report, err
+	}
+
+	byKey := map[string]Watch{}
+	for _, w := range watches {
+		byKey[w.Key()] = w
+	}
+
+	collection, _ := deadLetterCollection(session, settings)
+	for _, letter := range letters {
+		w, ok := byKey[letter.Watch]
+		if !ok {
+			report.Skipped++
+			continue
+		}
+
+		if err := tracker.replay(w, letter); err != nil {
+			report.Failed++
+			update := bson.M{"$inc": bson.M{"attempts": 1}, "$set": bson.M{"error": err.Error()}}
+			if err := collection.UpdateId(letter.ID, update); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		report.Replayed++
+		if err := collection.RemoveId(letter.ID); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+//serveDeadLetters lists the dead letters of the watch parameter,
+//at most limit (default 20), or replays them with POST
+func (t *TailAgent) serveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	filter := DeadLetterFilter{Watch: r.URL.Query().Get("watch")}
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid limit " + value})
+			return
+		}
+		limit = parsed
+	}
+
+	if r.Method == "POST" {
+		report, err := t.ReplayDeadLetters(filter, limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	letters, err := ListDeadLetters(t.metadata, t.config.DeadLetters, filter, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, letters)
+}
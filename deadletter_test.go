@@ -0,0 +1,49 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dead letters", func() {
+	w := Watch{
+		Name:                  "userComments",
+		TrackCollection:       "live.user",
+		TrackFields:           []string{"username"},
+		TargetCollection:      "live.comment",
+		TargetNormalizedField: "meta",
+		TriggerReference:      "user",
+	}
+
+	It("will store failed updates with their oplog fields", func() {
+		command := map[string]interface{}{"$set": map[string]interface{}{"username": "nino"}}
+		selector := map[string]interface{}{"_id": "5735f4e4c6b7c3fd1b2a4e11"}
+		letters, err := FailedUpdate(w, command, selector)
+		Expect(err).To(HaveOccurred())
+		Expect(letters).To(HaveLen(1))
+		Expect(letters[0].Watch).To(Equal("userComments"))
+		Expect(letters[0].Operation).To(Equal("u"))
+		Expect(letters[0].Namespace).To(Equal("live.user"))
+		Expect(letters[0].Command).To(Equal(command))
+		Expect(letters[0].Selector).To(Equal(selector))
+		Expect(letters[0].Attempts).To(Equal(1))
+		Expect(letters[0].Error).ToNot(BeEmpty())
+	})
+
+	It("will not store updates without write", func() {
+		command := map[string]interface{}{"$set": map[string]interface{}{"other": "value"}}
+		letters, err := FailedUpdate(w, command, map[string]interface{}{"_id": "5735f4e4c6b7c3fd1b2a4e11"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(letters).To(BeEmpty())
+	})
+
+	It("will not retry unknown operations", func() {
+		err := RetryDeadLetter(w, DeadLetter{Operation: "n"})
+		Expect(err).To(MatchError("Unknown operation n"))
+
+		err = RetryDeadLetter(w, DeadLetter{Operation: "i", Namespace: "comment"})
+		Expect(err).To(MatchError("Invalid namespace comment"))
+	})
+})
@@ -0,0 +1,239 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultDiagnosticsMaxExamples = 10
+
+//knownOperations are the oplog operation types redkeep understands
+var knownOperations = map[string]bool{"i": true, "u": true, "d": true, "c": true, "n": true}
+
+//DiagnosticsSettings configure what is kept of oplog entries with
+//unknown operation types. They are always counted, if Collection
+//(database.collection) is set SamplePercent of them are stored there,
+//at most MaxExamples (default 10) per namespace and operation type.
+//The stored entries contain the changed documents, Encryption seals
+//them. Retention prunes the collection whenever an entry is stored.
+type DiagnosticsSettings struct {
+	Collection    string             `json:"collection"`
+	SamplePercent float64            `json:"samplePercent" validate:"min=0,max=100"`
+	MaxExamples   int                `json:"maxExamples" validate:"min=0"`
+	Retention     RetentionSettings  `json:"retention"`
+	Encryption    *PayloadEncryption `json:"encryption"`
+}
+
+func checkDiagnosticsSettings(settings DiagnosticsSettings) error {
+	if c := settings.Collection; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Diagnostics collection %s must be database.collection", c)
+	}
+
+	if settings.Encryption != nil && settings.Collection == "" {
+		return errors.New("Diagnostics encryption needs a diagnostics collection")
+	}
+
+	return checkPayloadEncryption(settings.Encryption)
+}
+
+//operationTelemetry counts and captures unknown operations,
+//all methods can be called on nil
+type operationTelemetry struct {
+	sync.Mutex
+	settings DiagnosticsSettings
+	metrics  *metricRegistry
+	random   *rand.Rand
+	seen     map[string]int
+	captured map[string]int
+	store    func(example bson.M) error
+}
+
+func newOperationTelemetry(settings DiagnosticsSettings, metrics *metricRegistry, session *mgo.Session) *operationTelemetry {
+	if settings.MaxExamples == 0 {
+		settings.MaxExamples = defaultDiagnosticsMaxExamples
+	}
+
+	t := &operationTelemetry{
+		settings: settings,
+		metrics:  metrics,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		seen:     map[string]int{},
+		captured: map[string]int{},
+	}
+
+	if p := strings.Index(settings.Collection, "."); p != -1 && session != nil {
+		t.store = func(example bson.M) error {
+			s := session.Copy()
+			defer s.Close()
+			collection := s.DB(settings.Collection[:p]).C(settings.Collection[p+1:])
+			if err := collection.Insert(example); err != nil {
+				return err
+			}
+
+			return pruneCollection(collection, "captured", settings.Retention, time.Now())
+		}
+	}
+
+	return t
+}
+
+func unknownOperationMetric(ns, op string) string {
+	return fmt.Sprintf("%s{ns=%q,op=%q}", MetricUnknownOperations, ns, op)
+}
+
+//record counts an entry with an unknown operation type
+//and captures it if it was sampled
+func (t *operationTelemetry) record(ns, op string, entry map[string]interface{}) {
+	if t == nil {
+		logWarn("Unsupported operation", Fields{"op": op, "ns": ns})
+		return
+	}
+
+	t.metrics.add(MetricUnknownOperations, 1)
+	t.metrics.add(unknownOperationMetric(ns, op), 1)
+
+	key := ns + " " + op
+	t.Lock()
+	t.seen[key]++
+	first := t.seen[key] == 1
+	capture := t.store != nil && t.captured[key] < t.settings.MaxExamples &&
+		t.random.Float64()*100 < t.settings.SamplePercent
+	if capture {
+		t.captured[key]++
+	}
+	t.Unlock()
+
+	if first {
+		logWarn("Unsupported operation, further ones are counted in "+MetricUnknownOperations, Fields{"op": op, "ns": ns})
+	}
+
+	if capture {
+		example := bson.M{"ns": ns, "op": op, "captured": time.Now(), "entry": entry}
+		if t.settings.Encryption != nil {
+			sealed, err := t.settings.Encryption.seal(bson.M{"entry": entry})
+			if err != nil {
+				logError("Unsupported operation could not be encrypted", Fields{"op": op, "ns": ns}.withError(err))
+				return
+			}
+
+			delete(example, "entry")
+			example["sealed"] = sealed
+		}
+
+		if err := t.store(example); err != nil {
+			logError("Unsupported operation could not be captured", Fields{"op": op, "ns": ns}.withError(err))
+		}
+	}
+}
+
+//DiagnosticsFilter selects stored unknown operations by namespace,
+//operation type and capture time, empty fields select all
+type DiagnosticsFilter struct {
+	Namespace string
+	Operation string
+	Since     time.Time
+}
+
+func (f DiagnosticsFilter) selector() bson.M {
+	selector := bson.M{}
+	if f.Namespace != "" {
+		selector["ns"] = f.Namespace
+	}
+
+	if f.Operation != "" {
+		selector["op"] = f.Operation
+	}
+
+	if !f.Since.IsZero() {
+		selector["captured"] = bson.M{"$gte": f.Since}
+	}
+
+	return selector
+}
+
+func diagnosticsCollection(session *mgo.Session, settings DiagnosticsSettings) (*mgo.Collection, error) {
+	p := strings.Index(settings.Collection, ".")
+	if p < 1 {
+		return nil, errors.New("No diagnostics collection configured")
+	}
+
+	return session.DB(settings.Collection[:p]).C(settings.Collection[p+1:]), nil
+}
+
+//openExample restores the entry of a sealed example
+func openExample(settings DiagnosticsSettings, example bson.M) error {
+	sealed, ok := example["sealed"].([]byte)
+	if !ok {
+		return nil
+	}
+
+	payload := bson.M{}
+	if err := settings.Encryption.open(sealed, &payload); err != nil {
+		return fmt.Errorf("Unknown operation %v: %s", example["_id"], err)
+	}
+
+	delete(example, "sealed")
+	example["entry"] = payload["entry"]
+	return nil
+}
+
+//ListUnknownOperations returns the stored unknown operations selected
+//by filter, newest first. limit zero returns all of them. Encrypted
+//examples are opened with the encryption of settings.
+func ListUnknownOperations(session *mgo.Session, settings DiagnosticsSettings, filter DiagnosticsFilter, limit int) ([]bson.M, error) {
+	collection, err := diagnosticsCollection(session, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	examples := []bson.M{}
+	if err := collection.Find(filter.selector()).Sort("-captured").Limit(limit).All(&examples); err != nil {
+		return nil, err
+	}
+
+	for _, example := range examples {
+		if err := openExample(settings, example); err != nil {
+			return nil, err
+		}
+	}
+
+	return examples, nil
+}
+
+//InspectUnknownOperation returns the stored unknown operation with the id
+func InspectUnknownOperation(session *mgo.Session, settings DiagnosticsSettings, id bson.ObjectId) (bson.M, error) {
+	collection, err := diagnosticsCollection(session, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	example := bson.M{}
+	if err := collection.FindId(id).One(&example); err != nil {
+		return nil, err
+	}
+
+	return example, openExample(settings, example)
+}
+
+//PurgeUnknownOperations removes the stored unknown operations
+//selected by filter and returns how many were removed
+func PurgeUnknownOperations(session *mgo.Session, settings DiagnosticsSettings, filter DiagnosticsFilter) (int, error) {
+	collection, err := diagnosticsCollection(session, settings)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := collection.RemoveAll(filter.selector())
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
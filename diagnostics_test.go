@@ -0,0 +1,63 @@
+package redkeep_test
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unknown operations", func() {
+	entries := []map[string]interface{}{
+		{"ns": "live.user", "op": "x"},
+		{"ns": "live.user", "op": "x"},
+		{"ns": "live.user", "op": "x"},
+		{"ns": "live.comment", "op": "x"},
+	}
+
+	It("counts them per namespace and operation", func() {
+		metrics, captured := CaptureUnknownOperations(DiagnosticsSettings{}, entries)
+		Expect(metrics).To(HaveKeyWithValue("unknown_operations_total", 4.0))
+		Expect(metrics).To(HaveKeyWithValue(`unknown_operations_total{ns="live.user",op="x"}`, 3.0))
+		Expect(metrics).To(HaveKeyWithValue(`unknown_operations_total{ns="live.comment",op="x"}`, 1.0))
+		Expect(captured).To(BeEmpty())
+	})
+
+	It("captures a limited number of examples", func() {
+		_, captured := CaptureUnknownOperations(DiagnosticsSettings{SamplePercent: 100, MaxExamples: 2}, entries)
+		Expect(captured).To(HaveLen(3))
+		Expect(captured[0]["ns"]).To(Equal("live.user"))
+		Expect(captured[0]["entry"]).To(Equal(entries[0]))
+		Expect(captured[2]["ns"]).To(Equal("live.comment"))
+	})
+
+	It("needs a namespace as collection", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": { "connectionURI": "localhost:30000" },
+			"diagnostics": { "collection": "diagnostics" },
+			"watches": [{
+				"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+				"targetNormalizedField": "meta", "triggerReference": "user"
+			}]
+		}`))
+		Expect(err).To(MatchError("Diagnostics collection diagnostics must be database.collection"))
+	})
+	It("selects stored entries by namespace, operation and capture time", func() {
+		since := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+		Expect(DiagnosticsSelector(DiagnosticsFilter{})).To(BeEmpty())
+		Expect(DiagnosticsSelector(DiagnosticsFilter{Namespace: "shop.user", Operation: "x", Since: since})).To(Equal(bson.M{
+			"ns":       "shop.user",
+			"op":       "x",
+			"captured": bson.M{"$gte": since},
+		}))
+	})
+
+	It("needs a diagnostics collection to list entries", func() {
+		_, err := ListUnknownOperations(nil, DiagnosticsSettings{}, DiagnosticsFilter{}, 10)
+		Expect(err).To(MatchError("No diagnostics collection configured"))
+	})
+})
@@ -0,0 +1,28 @@
+//Package redkeep keeps denormalized fields of MongoDB documents up to date
+//by tailing the oplog.
+//
+//Applications embedding redkeep can rely on this part of the API, it is
+//only changed in a compatible way within a major version:
+//
+//  - configuration: Configuration, Watch, NewConfiguration and ConfigBuilder
+//  - the agent: NewTailAgent, NewTailAgentWithStartDate,
+//    NewTailAgentFromCheckpoint, TailAgent.Tail, TailAgent.TailContext,
+//    TailAgent.Close, TailAgent.AddSink, TailAgent.RegisterHooks,
+//    TailAgent.AddTenant, TailAgent.Subscribe and TailAgent.SyncOnce
+//  - extension points: Sink, WatermarkSink, BatchSink, Transform, Notifier, the
+//    Register*Type functions and LoadPlugin
+//  - trackers: Tracker and its optional ConflictTracker and GenerationTracker
+//  - the oplog: ChangeEvent, Query and NewOplogQuery
+//
+//New optional interfaces are added next to existing ones instead of
+//changing them, new configuration fields are optional. Everything else
+//that is exported, like the settings of single sinks, supporting tools
+//like ReportWatchCoverage or CheckReplayDeterminism and the helpers
+//BuildInsertQuery, BuildUpdateQuery and GetValue, may still change
+//between minor versions.
+//
+//The packages redkeep/config, redkeep/sink and redkeep/oplog group the
+//configuration, the sink extension points and the oplog types of the
+//stable API. Their types are aliases of the ones in this package, so
+//importers of either can be mixed and keep working.
+package redkeep
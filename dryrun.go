@@ -0,0 +1,144 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//DryRunSettings compute every write to targets without sending it. The
+//intended writes are reported as json lines on stdout, appended to File or
+//inserted into Collection (database.collection).
+type DryRunSettings struct {
+	File       string `json:"file"`
+	Collection string `json:"collection"`
+}
+
+func checkDryRunSettings(settings *DryRunSettings) error {
+	if settings == nil {
+		return nil
+	}
+
+	if settings.File != "" && settings.Collection != "" {
+		return errors.New("Dry runs report to either a file or a collection")
+	}
+
+	if c := settings.Collection; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Dry run collection %s must be database.collection", c)
+	}
+
+	return nil
+}
+
+//IntendedWrite is a write to the targets in Namespace that a dry run did
+//not send. Multi writes change every target that matches Selector, Matched
+//is the number of targets it matched when the write was reported. Remove
+//writes delete the targets instead of updating them.
+type IntendedWrite struct {
+	Watch     string    `bson:"watch" json:"watch"`
+	Namespace string    `bson:"ns" json:"ns"`
+	Selector  bson.M    `bson:"selector" json:"selector"`
+	Update    bson.M    `bson:"update" json:"update"`
+	Multi     bool      `bson:"multi" json:"multi"`
+	Remove    bool      `bson:"remove,omitempty" json:"remove,omitempty"`
+	Matched   int       `bson:"matched" json:"matched"`
+	Time      time.Time `bson:"time" json:"time"`
+}
+
+//dryRunReport reports the intended writes of a dry run, the
+//file is opened on start and closed on stop
+type dryRunReport struct {
+	sync.Mutex
+	settings DryRunSettings
+	metrics  *metricRegistry
+	session  *mgo.Session
+	output   io.Writer
+	file     *os.File
+	//count counts the targets an intended write matches
+	count func(session *mgo.Session, intended IntendedWrite) (int, error)
+}
+
+func newDryRunReport(settings *DryRunSettings, metrics *metricRegistry, session *mgo.Session) *dryRunReport {
+	if settings == nil {
+		return nil
+	}
+
+	return &dryRunReport{settings: *settings, metrics: metrics, session: session, output: os.Stdout, count: countTargets}
+}
+
+//countTargets counts the targets intended matches with session
+func countTargets(session *mgo.Session, intended IntendedWrite) (int, error) {
+	p := strings.Index(intended.Namespace, ".")
+	return session.DB(intended.Namespace[:p]).C(intended.Namespace[p+1:]).Find(intended.Selector).Count()
+}
+
+func (d *dryRunReport) start() error {
+	if d.settings.File == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(d.settings.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.file, d.output = file, file
+	return nil
+}
+
+func (d *dryRunReport) stop() {
+	d.Lock()
+	defer d.Unlock()
+	if d.file == nil {
+		return
+	}
+
+	if err := d.file.Close(); err != nil {
+		logError("Dry run report could not be closed", errorFields(err))
+	}
+	d.file, d.output = nil, os.Stdout
+}
+
+//report counts the targets the intended write of w matches with session and reports it
+func (d *dryRunReport) report(session *mgo.Session, w Watch, intended IntendedWrite) {
+	intended.Watch, intended.Time = w.Key(), time.Now()
+	matched, err := d.count(session, intended)
+	if err != nil {
+		logWarn("Targets of intended write not counted", watchFields(w).withError(err))
+	}
+	intended.Matched = matched
+
+	d.metrics.add(MetricDryRunWrites, 1)
+	if err := d.store(intended); err != nil {
+		logError("Intended write could not be reported", watchFields(w).withError(err))
+	}
+}
+
+func (d *dryRunReport) store(intended IntendedWrite) error {
+	if d.settings.Collection != "" {
+		session := d.session.Copy()
+		defer session.Close()
+		p := strings.Index(d.settings.Collection, ".")
+		return session.DB(d.settings.Collection[:p]).C(d.settings.Collection[p+1:]).Insert(intended)
+	}
+
+	line, err := json.Marshal(intended)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	_, err = d.output.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,98 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Dry runs", func() {
+	var directory string
+	w := Watch{Name: "userComments", TrackCollection: "app.user", TargetCollection: "app.comments"}
+	intended := IntendedWrite{
+		Namespace: "app.comments",
+		Selector:  bson.M{"user.$id": "system"},
+		Update:    bson.M{"$set": bson.M{"meta.user.username": "root"}},
+		Multi:     true,
+	}
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "redkeep-dryrun")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	reported := func(output string) []map[string]interface{} {
+		writes := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			write := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(line), &write)).To(Succeed())
+			writes = append(writes, write)
+		}
+
+		return writes
+	}
+
+	It("will report the writes to targets instead of sending them", func() {
+		attempts, counted, output, err := DryRunWrite(DryRunSettings{}, w, intended)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(BeZero())
+		Expect(counted).To(Equal(1.0))
+
+		writes := reported(output)
+		Expect(writes).To(HaveLen(1))
+		Expect(writes[0]["watch"]).To(Equal("userComments"))
+		Expect(writes[0]["ns"]).To(Equal("app.comments"))
+		Expect(writes[0]["selector"]).To(Equal(map[string]interface{}{"user.$id": "system"}))
+		Expect(writes[0]["update"]).To(Equal(map[string]interface{}{"$set": map[string]interface{}{"meta.user.username": "root"}}))
+		Expect(writes[0]["multi"]).To(BeTrue())
+		Expect(writes[0]["matched"]).To(Equal(3.0))
+	})
+
+	It("will append the report to a file", func() {
+		file := filepath.Join(directory, "report.jsonl")
+		for i := 0; i < 2; i++ {
+			_, _, output, err := DryRunWrite(DryRunSettings{File: file}, w, intended)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(BeEmpty())
+		}
+
+		data, err := ioutil.ReadFile(file)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reported(string(data))).To(HaveLen(2))
+	})
+
+	It("will report to either a file or a collection", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"dryRun": { "file": "report.jsonl", "collection": "redkeep.dryRun" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Dry runs report to either a file or a collection"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"dryRun": { "collection": "dryRun" }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Dry run collection dryRun must be database.collection"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"dryRun": {}, "watches"`, 1)
+		parsed, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.DryRun).To(Equal(&DryRunSettings{}))
+	})
+
+	It("will report every shard to its own file", func() {
+		c := Configuration{Mongo: Mongo{ConnectionURI: "mongos-01:27017", Sharded: true}, DryRun: &DryRunSettings{File: "report.jsonl"}}
+		shard := ShardConfiguration(c, Shard{ID: "rs0", Host: "rs0/shard-01:27018"})
+		Expect(shard.DryRun.File).To(Equal("report.jsonl.rs0"))
+		Expect(c.DryRun.File).To(Equal("report.jsonl"))
+	})
+})
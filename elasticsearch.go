@@ -0,0 +1,263 @@
+package redkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	//ElasticsearchTimestampField keeps the oplog timestamp of the last
+	//change in every mirrored document, older changes are not applied
+	ElasticsearchTimestampField = "_redkeepTs"
+	//elasticsearchScript applies a change unless the document already has
+	//a newer one, inserts replace the document and deletes remove it
+	elasticsearchScript = `if (ctx._source.containsKey(params.field) && ctx._source[params.field] >= params.ts) { ctx.op = 'none'; return; }
+if (params.op == 'd') { ctx.op = 'delete'; return; }
+if (params.op == 'i') { ctx._source.clear(); }
+ctx._source.putAll(params.doc);
+ctx._source[params.field] = params.ts;`
+	defaultElasticsearchTimeout = 30 * time.Second
+)
+
+//ElasticsearchSettings connect to the cluster the watches with an
+//Elasticsearch block mirror their tracked documents to, see
+//ElasticsearchIndex. User and Password are sent with basic authentication.
+type ElasticsearchSettings struct {
+	URL      string   `json:"url" validate:"required,url"`
+	User     string   `json:"user"`
+	Password string   `json:"password"`
+	Timeout  Duration `json:"timeout"`
+}
+
+//ElasticsearchIndex mirrors the tracked fields of the tracked documents of
+//a watch into Index, one document per tracked document with the same id.
+//Fields renames tracked fields in the index, the others keep their name.
+//{tenant} in Index is replaced by the lowercased tenant name.
+type ElasticsearchIndex struct {
+	Index  string            `json:"index" validate:"required,min=1"`
+	Fields map[string]string `json:"fields"`
+}
+
+func checkElasticsearchIndex(w Watch, settings *ElasticsearchSettings) error {
+	if w.Elasticsearch == nil {
+		return nil
+	}
+
+	if settings == nil {
+		return fmt.Errorf("Watch %s mirrors to elasticsearch, configure elasticsearch", w.Key())
+	}
+
+	if index := w.Elasticsearch.Index; strings.ToLower(index) != index {
+		return fmt.Errorf("Elasticsearch index %s must be lowercase", index)
+	}
+
+	tracked := map[string]bool{}
+	for _, field := range w.TrackFields {
+		tracked[field] = true
+	}
+
+	for field := range w.Elasticsearch.Fields {
+		if !tracked[field] {
+			return fmt.Errorf("Elasticsearch field %s of watch %s is not tracked", field, w.Key())
+		}
+	}
+
+	return nil
+}
+
+//elasticsearchDocument renames the fields of e like index
+func elasticsearchDocument(index ElasticsearchIndex, e ChangeEvent) map[string]interface{} {
+	document := map[string]interface{}{}
+	for field, value := range e.Fields {
+		if renamed, ok := index.Fields[field]; ok {
+			field = renamed
+		}
+		document[field] = value
+	}
+
+	return document
+}
+
+//elasticsearchSink mirrors the changes of the watches with an index with
+//the bulk API. Every change is a scripted update that compares the oplog
+//timestamps, so retried batches and replays never overwrite newer values.
+type elasticsearchSink struct {
+	settings ElasticsearchSettings
+	watches  *watchSet
+	client   *http.Client
+	bulk     bytes.Buffer
+	actions  []string
+}
+
+func newElasticsearchSink(settings ElasticsearchSettings, watches *watchSet) *elasticsearchSink {
+	timeout := settings.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultElasticsearchTimeout
+	}
+
+	return &elasticsearchSink{settings: settings, watches: watches, client: &http.Client{Timeout: timeout}}
+}
+
+//NewElasticsearchSink creates the sink for the watches with an
+//Elasticsearch block, the agent adds it if elasticsearch is configured
+func NewElasticsearchSink(settings ElasticsearchSettings, watches []Watch) (Sink, error) {
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, err
+	}
+
+	return newElasticsearchSink(settings, newWatchSet(watches)), nil
+}
+
+//index returns the index of the watch with key, false if it has none
+func (s *elasticsearchSink) index(key string) (ElasticsearchIndex, bool) {
+	for _, w := range s.watches.list() {
+		if w.Key() == key && w.Elasticsearch != nil {
+			return *w.Elasticsearch, true
+		}
+	}
+
+	return ElasticsearchIndex{}, false
+}
+
+//Send mirrors e on its own, it is used with a spool
+func (s *elasticsearchSink) Send(e ChangeEvent) error {
+	if err := s.Begin(); err != nil {
+		return err
+	}
+
+	if err := s.Add(e); err != nil {
+		s.Rollback()
+		return err
+	}
+
+	if err := s.Commit(); err != nil {
+		s.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (s *elasticsearchSink) Begin() error {
+	return s.Rollback()
+}
+
+func (s *elasticsearchSink) Add(e ChangeEvent) error {
+	index, ok := s.index(e.Watch)
+	if !ok {
+		return nil
+	}
+
+	action := map[string]interface{}{"update": map[string]interface{}{
+		"_index":            index.Index,
+		"_id":               idString(e.ID),
+		"retry_on_conflict": 3,
+	}}
+	update := map[string]interface{}{
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": elasticsearchScript,
+			"params": map[string]interface{}{
+				"field": ElasticsearchTimestampField,
+				"ts":    int64(e.Timestamp),
+				"op":    e.Operation,
+				"doc":   elasticsearchDocument(index, e),
+			},
+		},
+	}
+	//deletes of documents that are not mirrored must not create them
+	if e.Operation != "d" {
+		update["scripted_upsert"] = true
+		update["upsert"] = map[string]interface{}{}
+	}
+
+	encoder := json.NewEncoder(&s.bulk)
+	if err := encoder.Encode(action); err != nil {
+		return err
+	}
+	if err := encoder.Encode(update); err != nil {
+		return err
+	}
+
+	s.actions = append(s.actions, e.Operation)
+	return nil
+}
+
+//Commit sends the batch to the bulk API, it fails if one change was
+//rejected. Deletes of documents that were never mirrored are fine.
+func (s *elasticsearchSink) Commit() error {
+	if len(s.actions) == 0 {
+		return nil
+	}
+
+	request, err := http.NewRequest("POST", strings.TrimSuffix(s.settings.URL, "/")+"/_bulk", bytes.NewReader(s.bulk.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if s.settings.User != "" {
+		request.SetBasicAuth(s.settings.User, s.settings.Password)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	message, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Elasticsearch answered with %s: %s", response.Status, bytes.TrimSpace(message))
+	}
+
+	result := struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal(message, &result); err != nil {
+		return err
+	}
+
+	if !result.Errors {
+		return s.Rollback()
+	}
+
+	if len(result.Items) != len(s.actions) {
+		return errors.New("Elasticsearch answered a bulk request with a different number of items")
+	}
+
+	for i, item := range result.Items {
+		for _, answer := range item {
+			if answer.Error == nil || (answer.Status == http.StatusNotFound && s.actions[i] == "d") {
+				continue
+			}
+
+			return fmt.Errorf("Elasticsearch rejected a change: %s", answer.Error)
+		}
+	}
+
+	return s.Rollback()
+}
+
+func (s *elasticsearchSink) Rollback() error {
+	s.bulk.Reset()
+	s.actions = nil
+	return nil
+}
+
+func (s *elasticsearchSink) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package redkeep_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Elasticsearch sink", func() {
+	var (
+		server *httptest.Server
+		lines  []map[string]interface{}
+		answer string
+	)
+
+	watches := []Watch{{
+		Name:                  "users",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username", "email"},
+		TargetCollection:      "app.comment",
+		TargetNormalizedField: "user",
+		TriggerReference:      "user",
+		Elasticsearch:         &ElasticsearchIndex{Index: "users", Fields: map[string]string{"username": "name"}},
+	}, {
+		Name:                  "unmirrored",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username"},
+		TargetCollection:      "app.review",
+		TargetNormalizedField: "user",
+		TriggerReference:      "user",
+	}}
+
+	BeforeEach(func() {
+		lines = nil
+		answer = `{"errors": false, "items": []}`
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/_bulk"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+			body, _ := ioutil.ReadAll(r.Body)
+			scanner := bufio.NewScanner(bytes.NewReader(body))
+			for scanner.Scan() {
+				line := map[string]interface{}{}
+				Expect(json.Unmarshal(scanner.Bytes(), &line)).To(Succeed())
+				lines = append(lines, line)
+			}
+			w.Write([]byte(answer))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newSink := func() BatchSink {
+		sink, err := NewElasticsearchSink(ElasticsearchSettings{URL: server.URL}, watches)
+		Expect(err).ToNot(HaveOccurred())
+		return sink.(BatchSink)
+	}
+
+	params := func(line map[string]interface{}) map[string]interface{} {
+		return line["script"].(map[string]interface{})["params"].(map[string]interface{})
+	}
+
+	It("will mirror the changes of watches with an index in one bulk request", func() {
+		sink := newSink()
+		Expect(sink.Begin()).To(Succeed())
+		Expect(sink.Add(ChangeEvent{Watch: "users", Operation: "i", ID: "a", Fields: map[string]interface{}{"username": "alice", "email": "a@x"}, Timestamp: bson.MongoTimestamp(1 << 32)})).To(Succeed())
+		Expect(sink.Add(ChangeEvent{Watch: "unmirrored", Operation: "i", ID: "a", Fields: map[string]interface{}{"username": "alice"}})).To(Succeed())
+		Expect(sink.Add(ChangeEvent{Watch: "users", Operation: "d", ID: "b", Timestamp: bson.MongoTimestamp(2 << 32)})).To(Succeed())
+		Expect(sink.Commit()).To(Succeed())
+
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0]["update"]).To(HaveKeyWithValue("_index", "users"))
+		Expect(lines[0]["update"]).To(HaveKeyWithValue("_id", "a"))
+		Expect(lines[1]).To(HaveKeyWithValue("scripted_upsert", true))
+		Expect(params(lines[1])["doc"]).To(Equal(map[string]interface{}{"name": "alice", "email": "a@x"}))
+		Expect(params(lines[1])["ts"]).To(Equal(float64(1 << 32)))
+		Expect(params(lines[1])["field"]).To(Equal(ElasticsearchTimestampField))
+		Expect(lines[3]).ToNot(HaveKey("upsert"))
+		Expect(params(lines[3])["op"]).To(Equal("d"))
+	})
+
+	It("will not send empty batches", func() {
+		sink := newSink()
+		Expect(sink.Begin()).To(Succeed())
+		Expect(sink.Add(ChangeEvent{Watch: "unmirrored", Operation: "i", ID: "a"})).To(Succeed())
+		Expect(sink.Commit()).To(Succeed())
+		Expect(lines).To(BeEmpty())
+	})
+
+	It("will fail if a change was rejected", func() {
+		answer = `{"errors": true, "items": [{"update": {"status": 400, "error": {"type": "mapper_parsing_exception"}}}]}`
+		err := newSink().Send(ChangeEvent{Watch: "users", Operation: "u", ID: "a", Fields: map[string]interface{}{"email": "a@y"}})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("mapper_parsing_exception"))
+	})
+
+	It("will accept deletes of documents that were never mirrored", func() {
+		answer = `{"errors": true, "items": [{"update": {"status": 404, "error": {"type": "document_missing_exception"}}}]}`
+		Expect(newSink().Send(ChangeEvent{Watch: "users", Operation: "d", ID: "a"})).To(Succeed())
+	})
+
+	It("will validate the indexes of watches", func() {
+		mirrored := strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "elasticsearch": {"index": "users"}`, 1)
+		_, err := NewConfiguration([]byte(mirrored))
+		Expect(err).To(MatchError("Watch xAx->xCx.xDx mirrors to elasticsearch, configure elasticsearch"))
+
+		configured := strings.Replace(mirrored, `"watches"`, `"elasticsearch": {"url": "http://localhost:9200"}, "watches"`, 1)
+		_, err = NewConfiguration([]byte(configured))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = NewConfiguration([]byte(strings.Replace(configured, `"index": "users"`, `"index": "Users"`, 1)))
+		Expect(err).To(MatchError("Elasticsearch index Users must be lowercase"))
+
+		_, err = NewConfiguration([]byte(strings.Replace(configured, `"index": "users"`, `"index": "users", "fields": {"email": "mail"}`, 1)))
+		Expect(err).To(MatchError("Elasticsearch field email of watch xAx->xCx.xDx is not tracked"))
+	})
+})
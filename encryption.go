@@ -0,0 +1,86 @@
+package redkeep
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//PayloadEncryption seals the documents of oplog entries stored in
+//internal collections with AES-GCM. Key is the base64 encoded key of
+//16, 24 or 32 bytes, without it the stored documents can not be read.
+type PayloadEncryption struct {
+	Key string `json:"key"`
+}
+
+func checkPayloadEncryption(e *PayloadEncryption) error {
+	if e == nil {
+		return nil
+	}
+
+	_, err := e.aead()
+	return err
+}
+
+func (e *PayloadEncryption) aead() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(e.Key)
+	if err != nil {
+		return nil, errors.New("Encryption key must be base64 encoded")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("Encryption key must have 16, 24 or 32 bytes")
+	}
+
+	return cipher.NewGCM(block)
+}
+
+//seal encrypts the bson of payload, the nonce is stored in front of it
+func (e *PayloadEncryption) seal(payload interface{}) ([]byte, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bson.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+//open decrypts sealed into payload, a nil e can not open anything
+func (e *PayloadEncryption) open(sealed []byte, payload interface{}) error {
+	if e == nil {
+		return errors.New("The payload is encrypted, configure its encryption key")
+	}
+
+	aead, err := e.aead()
+	if err != nil {
+		return err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return errors.New("The encrypted payload is too short")
+	}
+
+	nonce := sealed[:aead.NonceSize()]
+	data, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return errors.New("The payload could not be decrypted with the encryption key")
+	}
+
+	return bson.Unmarshal(data, payload)
+}
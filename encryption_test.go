@@ -0,0 +1,77 @@
+package redkeep_test
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Payload encryption", func() {
+	encryption := &PayloadEncryption{Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}
+
+	It("seals the payload of dead letters", func() {
+		letter := DeadLetter{
+			ID:       bson.NewObjectId(),
+			Watch:    "userComments",
+			Command:  map[string]interface{}{"$set": map[string]interface{}{"username": "nino"}},
+			Selector: map[string]interface{}{"_id": "5735f4e4c6b7c3fd1b2a4e11"},
+		}
+
+		sealed, err := SealDeadLetter(letter, encryption)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sealed.Command).To(BeNil())
+		Expect(sealed.Selector).To(BeNil())
+		Expect(sealed.Watch).To(Equal("userComments"))
+		Expect(string(sealed.Sealed)).ToNot(ContainSubstring("nino"))
+
+		opened, err := OpenDeadLetter(sealed, encryption)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened.Sealed).To(BeEmpty())
+		Expect(opened.Selector).To(Equal(letter.Selector))
+		Expect(opened.Command["$set"]).To(BeEquivalentTo(letter.Command["$set"]))
+	})
+
+	It("can not open dead letters without the key", func() {
+		sealed, err := SealDeadLetter(DeadLetter{ID: bson.NewObjectId()}, encryption)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = OpenDeadLetter(sealed, nil)
+		Expect(err).To(MatchError(ContainSubstring("The payload is encrypted, configure its encryption key")))
+
+		_, err = OpenDeadLetter(sealed, &PayloadEncryption{Key: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="})
+		Expect(err).To(MatchError(ContainSubstring("The payload could not be decrypted with the encryption key")))
+	})
+
+	It("seals captured unknown operations", func() {
+		entries := []map[string]interface{}{{"ns": "live.user", "op": "x", "o": map[string]interface{}{"email": "nino@example.com"}}}
+		settings := DiagnosticsSettings{SamplePercent: 100, Encryption: encryption}
+		_, captured := CaptureUnknownOperations(settings, entries)
+		Expect(captured).To(HaveLen(1))
+		Expect(captured[0]).ToNot(HaveKey("entry"))
+		Expect(captured[0]["ns"]).To(Equal("live.user"))
+
+		Expect(OpenUnknownOperation(settings, captured[0])).To(Succeed())
+		Expect(captured[0]).ToNot(HaveKey("sealed"))
+		Expect(captured[0]["entry"].(bson.M)["o"]).To(BeEquivalentTo(bson.M{"email": "nino@example.com"}))
+	})
+
+	It("checks the key", func() {
+		for key, message := range map[string]string{
+			"not base64": "Encryption key must be base64 encoded",
+			"c2hvcnQ=":   "Encryption key must have 16, 24 or 32 bytes",
+		} {
+			config := strings.Replace(templateForTestsConfig, `"watches"`, `"deadLetters": { "collection": "redkeep.failed", "encryption": { "key": "`+key+`" } }, "watches"`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(MatchError(message))
+		}
+
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"diagnostics": { "encryption": { "key": "`+encryption.Key+`" } }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Diagnostics encryption needs a diagnostics collection"))
+	})
+})
@@ -0,0 +1,103 @@
+package redkeep
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultEventLogSize = 256
+
+//kinds of significant agent events
+const (
+	EventError     = "error"
+	EventReconnect = "reconnect"
+	EventAlert     = "alert"
+	EventLifecycle = "lifecycle"
+	EventSchema    = "schema"
+)
+
+//AgentEvent is one significant event of the agent
+type AgentEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Watch   string    `json:"watch,omitempty"`
+	Message string    `json:"message"`
+}
+
+//eventLog keeps the last events in a ring buffer,
+//all methods can be called on nil
+type eventLog struct {
+	sync.Mutex
+	events []AgentEvent
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+
+	return &eventLog{events: make([]AgentEvent, size)}
+}
+
+func (l *eventLog) record(kind, watch, message string) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.events[l.next] = AgentEvent{Time: time.Now(), Kind: kind, Watch: watch, Message: message}
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+//list returns up to limit events of kind, newest first.
+//An empty kind matches all events, limit <= 0 returns all.
+func (l *eventLog) list(kind string, limit int) []AgentEvent {
+	result := []AgentEvent{}
+	if l == nil {
+		return result
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.events)
+	}
+
+	for i := 1; i <= count; i++ {
+		e := l.events[(l.next-i+len(l.events))%len(l.events)]
+		if kind != "" && e.Kind != kind {
+			continue
+		}
+
+		result = append(result, e)
+		if len(result) == limit {
+			break
+		}
+	}
+
+	return result
+}
+
+//ServeHTTP lists events, the query parameters kind and limit are optional
+func (l *eventLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a number"})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, l.list(r.URL.Query().Get("kind"), limit))
+}
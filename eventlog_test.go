@@ -0,0 +1,67 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Event log", func() {
+	messages := func(events []AgentEvent) []string {
+		result := []string{}
+		for _, e := range events {
+			result = append(result, e.Message)
+		}
+
+		return result
+	}
+
+	It("lists the events newest first", func() {
+		list, _ := FilledEventLog(4, EventError, EventAlert)
+		Expect(messages(list("", 0))).To(Equal([]string{"1", "0"}))
+	})
+
+	It("keeps only the newest events once it wrapped around", func() {
+		list, _ := FilledEventLog(3, EventError, EventAlert, EventError, EventReconnect, EventAlert)
+		Expect(messages(list("", 0))).To(Equal([]string{"4", "3", "2"}))
+	})
+
+	It("filters by kind and limit", func() {
+		list, _ := FilledEventLog(8, EventError, EventAlert, EventError, EventReconnect, EventError)
+		Expect(messages(list(EventError, 0))).To(Equal([]string{"4", "2", "0"}))
+		Expect(messages(list(EventError, 2))).To(Equal([]string{"4", "2"}))
+		Expect(messages(list("", 1))).To(Equal([]string{"4"}))
+		Expect(list(EventLifecycle, 0)).To(BeEmpty())
+	})
+
+	It("serves the events", func() {
+		_, handler := FilledEventLog(8, EventError, EventAlert, EventError)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/events?kind=error&limit=1")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		var events []AgentEvent
+		Expect(json.NewDecoder(response.Body).Decode(&events)).To(Succeed())
+		Expect(messages(events)).To(Equal([]string{"2"}))
+	})
+
+	It("rejects limits that are not numbers", func() {
+		_, handler := FilledEventLog(8)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/events?limit=ten")
+		Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,131 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultRowGroupSize = 100000
+
+//newestOplogTimestamp returns the timestamp of the last oplog entry
+func newestOplogTimestamp(session *mgo.Session) (bson.MongoTimestamp, error) {
+	var entry struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+
+	err := session.DB("local").C("oplog.rs").Find(nil).Sort("-$natural").One(&entry)
+	return entry.Timestamp, err
+}
+
+//ExportParquet writes the tracked fields of every document in the tracked
+//collection of w as a parquet file with the columns _id and the tracked
+//fields, dots are replaced by underscores. Values that are not strings
+//are stored as json. The collection is read with the snapshot read
+//concern, the cluster time of the snapshot is returned and stored as
+//redkeep.oplogTimestamp in the file metadata. Servers without snapshot
+//reads are read normally, the newest oplog timestamp before the export
+//started is stored then and documents changed while it runs may already
+//contain these changes. Replaying the changes after the timestamp onto
+//the snapshot yields the current state in both cases, because applying
+//the latest values of the tracked fields again does not change them.
+func ExportParquet(session *mgo.Session, w Watch, out io.Writer, rowGroupSize int) (bson.MongoTimestamp, int, error) {
+	p := strings.Index(w.TrackCollection, ".")
+	if p == -1 {
+		return 0, 0, errors.New("Invalid namespace given, must contain dot")
+	}
+
+	if rowGroupSize <= 0 {
+		rowGroupSize = defaultRowGroupSize
+	}
+
+	selection := bson.M{"_id": 1}
+	for _, f := range w.TrackFields {
+		selection[f] = 1
+	}
+
+	collection := session.DB(w.TrackCollection[:p]).C(w.TrackCollection[p+1:])
+	options := bson.D{{Name: "projection", Value: selection}, {Name: "sort", Value: bson.M{"_id": 1}}}
+	iter, ts, err := snapshotIter(session, collection, options)
+	if err != nil {
+		logWarn("Snapshot read not supported, exporting without snapshot", watchFields(w).withError(err))
+		if ts, err = newestOplogTimestamp(session); err != nil {
+			return 0, 0, fmt.Errorf("Oplog position could not be read: %s", err.Error())
+		}
+		iter = collection.Find(nil).Select(selection).Sort("_id").Iter()
+	}
+
+	columns := []string{"_id"}
+	for _, f := range w.TrackFields {
+		columns = append(columns, strings.Replace(f, ".", "_", -1))
+	}
+
+	writer, err := newParquetWriter(out, columns)
+	if err != nil {
+		iter.Close()
+		return 0, 0, err
+	}
+	writer.metadata = exportMetadata(w, ts)
+
+	count := 0
+	document := map[string]interface{}{}
+	for iter.Next(&document) {
+		row := []*string{parquetString(document["_id"])}
+		for _, f := range w.TrackFields {
+			row = append(row, parquetString(GetValue(f, document)))
+		}
+
+		writer.add(row)
+		count++
+		if count%rowGroupSize == 0 {
+			if err := writer.flushRowGroup(); err != nil {
+				iter.Close()
+				return ts, count, err
+			}
+		}
+
+		document = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return ts, count, err
+	}
+
+	return ts, count, writer.close()
+}
+
+//exportMetadata is the key value metadata of a snapshot of w
+func exportMetadata(w Watch, ts bson.MongoTimestamp) map[string]string {
+	return map[string]string{
+		"redkeep.oplogTimestamp": fmt.Sprintf("%d", ts),
+		"redkeep.namespace":      w.TrackCollection,
+		"redkeep.watch":          w.Key(),
+	}
+}
+
+//parquetString converts a value for an utf8 column
+func parquetString(value interface{}) *string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		s = v
+	case bson.ObjectId:
+		s = v.Hex()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(data)
+		}
+	}
+
+	return &s
+}
@@ -0,0 +1,1224 @@
+package redkeep
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//fakeAgent runs tail instead of tailing the oplog
+type fakeAgent struct {
+	tail func(quit chan bool) error
+}
+
+func (f fakeAgent) Tail(quit chan bool, forceRescan bool) error {
+	return f.tail(quit)
+}
+
+func (f fakeAgent) Status() AgentStatus {
+	return AgentStatus{}
+}
+
+func (f fakeAgent) handlers() map[string]http.Handler {
+	return map[string]http.Handler{}
+}
+
+//AddFakeAgent adds an agent that runs tail
+func (s *Supervisor) AddFakeAgent(name string, tail func(quit chan bool) error) error {
+	return s.add(name, fakeAgent{tail})
+}
+
+//RestartAdminServer starts and stops an admin server on a free port
+//times times and returns the status of /ping of every run
+func RestartAdminServer(times int) ([]int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	admin := newAdminServer(AdminSettings{Listen: address})
+	admin.handle("/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := []int{}
+	for i := 0; i < times; i++ {
+		if err := admin.start(); err != nil {
+			return codes, err
+		}
+
+		response, err := http.Get("http://" + address + "/ping")
+		admin.stop()
+		if err != nil {
+			return codes, err
+		}
+		response.Body.Close()
+		codes = append(codes, response.StatusCode)
+	}
+
+	return codes, nil
+}
+
+//SignS3Request adds an AWS signature version 4 to the request
+var SignS3Request = signS3Request
+
+//WriteParquet writes rows like ExportParquet does for w at ts,
+//a row group is written every rowGroupSize rows
+func WriteParquet(out io.Writer, w Watch, ts bson.MongoTimestamp, rows [][]*string, rowGroupSize int) error {
+	columns := []string{"_id"}
+	for _, f := range w.TrackFields {
+		columns = append(columns, strings.Replace(f, ".", "_", -1))
+	}
+
+	writer, err := newParquetWriter(out, columns)
+	if err != nil {
+		return err
+	}
+	writer.metadata = exportMetadata(w, ts)
+
+	for i, row := range rows {
+		writer.add(row)
+		if (i+1)%rowGroupSize == 0 {
+			if err := writer.flushRowGroup(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writer.close()
+}
+
+//AlertTransitions feeds values into a new state of rule, one
+//every step, and returns firing, resolved or an empty string
+//for each value
+func AlertTransitions(rule AlertRule, values []float64, step time.Duration) []string {
+	state := &alertState{rule: rule.withDefaults()}
+	now := time.Now()
+	transitions := []string{}
+	for i, value := range values {
+		switch {
+		case !state.update(value, now.Add(time.Duration(i)*step)):
+			transitions = append(transitions, "")
+		case state.firing:
+			transitions = append(transitions, "firing")
+		default:
+			transitions = append(transitions, "resolved")
+		}
+	}
+
+	return transitions
+}
+
+//EvaluateNotifications sets the metrics of every step and
+//evaluates the notification settings once per step
+func EvaluateNotifications(settings NotificationSettings, steps []map[string]float64, interval time.Duration) error {
+	metrics := newMetricRegistry()
+	center, err := newNotificationCenter(settings, metrics, newEventLog(0))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, step := range steps {
+		for name, value := range step {
+			metrics.set(name, value)
+		}
+		center.evaluate(now.Add(time.Duration(i) * interval))
+	}
+
+	return nil
+}
+
+//FilledEventLog records one event per kind into an event log of size,
+//the message of an event is its position. It returns the list function
+//and the http handler of the log.
+func FilledEventLog(size int, kinds ...string) (func(kind string, limit int) []AgentEvent, http.Handler) {
+	log := newEventLog(size)
+	for i, kind := range kinds {
+		log.record(kind, "", strconv.Itoa(i))
+	}
+
+	return log.list, log
+}
+
+//RemapDatabases prefixes the databases of the namespaces in value
+var RemapDatabases = remapDatabases
+
+//AgentWrite is true if the oplog entry is an update the agent made
+var AgentWrite = agentWrite
+
+//CompareStates builds the report of two replays, keys
+//are namespace and id, values hashes of the documents
+func CompareStates(first, second map[string]string) DeterminismReport {
+	report := DeterminismReport{}
+	compareStates(&report, first, second)
+	return report
+}
+
+//TestComponent describes a component for RunLifecycle
+type TestComponent struct {
+	Name      string
+	DependsOn []string
+	FailStart bool
+	BlockStop bool
+}
+
+//RunLifecycle starts the components and stops them again if that worked.
+//It returns the start and stop calls in the order they happened.
+func RunLifecycle(components []TestComponent, timeout time.Duration) ([]string, error) {
+	var mutex sync.Mutex
+	calls := []string{}
+	call := func(name string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, name)
+	}
+
+	release := make(chan bool)
+	defer close(release)
+
+	l := &lifecycle{}
+	for _, c := range components {
+		c := c
+		l.add(component{
+			name:      c.Name,
+			dependsOn: c.DependsOn,
+			timeout:   timeout,
+			start: func() error {
+				call("start " + c.Name)
+				if c.FailStart {
+					return errors.New("failed")
+				}
+				return nil
+			},
+			stop: func() {
+				call("stop " + c.Name)
+				if c.BlockStop {
+					<-release
+				}
+			},
+		})
+	}
+
+	err := l.start()
+	if err == nil {
+		l.stop()
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]string{}, calls...), err
+}
+
+//ApplyTransforms runs the configured transforms of w on update
+func ApplyTransforms(w Watch, update bson.M) error {
+	transforms, err := newWatchTransforms([]Watch{w}, nil)
+	if err != nil {
+		return err
+	}
+
+	return transforms.apply(w, nil, update)
+}
+
+//UnpackWASMResult splits the result of redkeep_transform
+var UnpackWASMResult = unpackWASMResult
+
+//DecodeWASMOutput decodes the output of redkeep_transform
+var DecodeWASMOutput = decodeWASMOutput
+
+//ReserveTenantWrites returns the delays of writes of a tenant with
+//settings that happen at the given offsets
+func ReserveTenantWrites(settings TenantSettings, offsets []time.Duration) []time.Duration {
+	bucket := newTokenBucket(settings)
+	start := time.Now()
+	delays := []time.Duration{}
+	for _, offset := range offsets {
+		delays = append(delays, bucket.reserve(start.Add(offset)))
+	}
+
+	return delays
+}
+
+//TenantMetric is the name of a metric of one tenant
+var TenantMetric = tenantMetric
+
+//AddTenantWatches adds the tenant watches of c for every tenant like
+//TailAgent.AddTenant without backfilling and returns all watch keys
+func AddTenantWatches(c Configuration, tenants ...string) ([]string, error) {
+	watches := newWatchSet(c.Watches)
+	for _, tenant := range tenants {
+		if err := watches.addTenant(tenant, expandTenantWatches(c.TenantWatches, tenant)); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := []string{}
+	for _, w := range watches.list() {
+		keys = append(keys, w.Key())
+	}
+
+	return keys, nil
+}
+
+//BackfillCommand is the update command backfilling document
+var BackfillCommand = backfillCommand
+
+//AdminHandler is the handler of an admin server with settings
+//that answers every request with 200
+func AdminHandler(settings AdminSettings) http.Handler {
+	admin := newAdminServer(settings)
+	admin.handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	return admin
+}
+
+//PlanWatchRescan estimates the rescan of w from operations counted by
+//namespace and operation and the number of tracked and target documents
+func PlanWatchRescan(w Watch, operations map[string]map[string]int, tracked, targets int) WatchRescanPlan {
+	return planWatchRescan(w, operations, tracked, targets)
+}
+
+//SumRescanPlans sums the plans of watches
+var SumRescanPlans = planRescan
+
+//CountCoverage reports the coverage of watches for entries
+func CountCoverage(watches []Watch, entries []map[string]interface{}) CoverageReport {
+	counter := newCoverageCounter(watches)
+	for _, entry := range entries {
+		counter.add(entry)
+	}
+
+	return counter.result()
+}
+
+//CaptureUnknownOperations records the entries as unknown operations
+//and returns the metrics and the captured examples
+func CaptureUnknownOperations(settings DiagnosticsSettings, entries []map[string]interface{}) (map[string]float64, []bson.M) {
+	metrics := newMetricRegistry()
+	telemetry := newOperationTelemetry(settings, metrics, nil)
+	captured := []bson.M{}
+	telemetry.store = func(example bson.M) error {
+		captured = append(captured, example)
+		return nil
+	}
+
+	for _, entry := range entries {
+		telemetry.record(entry["ns"].(string), entry["op"].(string), entry)
+	}
+
+	return metrics.snapshot(), captured
+}
+
+//ApplyNamespaceChange applies the command of database db to watches
+//and returns the watches and the messages by watch key
+func ApplyNamespaceChange(watches []Watch, db string, command map[string]interface{}) ([]Watch, map[string]string) {
+	change, ok := parseNamespaceChange(db, command)
+	if !ok {
+		return watches, nil
+	}
+
+	set := newWatchSet(watches)
+	messages := set.applyNamespaceChange(change)
+	return set.list(), messages
+}
+
+//HandleCommands handles command entries like Tail for watches, it returns
+//the recorded events oldest first and whether writes of w would wait
+func HandleCommands(watches []Watch, entries []map[string]interface{}, w Watch) ([]AgentEvent, bool) {
+	agent := TailAgent{watches: newWatchSet(watches), events: newEventLog(0), indexBuilds: newIndexBuilds()}
+	for _, entry := range entries {
+		agent.handleCommand(entry, &sync.WaitGroup{})
+	}
+
+	events := agent.events.list("", 0)
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	waited := make(chan bool)
+	go func() {
+		agent.indexBuilds.wait(w)
+		waited <- true
+	}()
+
+	select {
+	case <-waited:
+		return events, false
+	case <-time.After(50 * time.Millisecond):
+		return events, true
+	}
+}
+
+//FromMigration is true for entries of chunk migrations
+var FromMigration = fromMigration
+
+//WatermarkTracker exposes the watermarks of an agent with one sink
+type WatermarkTracker struct {
+	watermarks *watermarks
+}
+
+//NewWatermarkTracker sends the events and watermarks to sinks
+func NewWatermarkTracker(sinks ...Sink) WatermarkTracker {
+	dispatcher := &sinkDispatcher{}
+	for _, s := range sinks {
+		dispatcher.add(s)
+	}
+	return WatermarkTracker{newWatermarks(dispatcher, time.Second)}
+}
+
+//Begin marks an entry as read
+func (t WatermarkTracker) Begin(ts bson.MongoTimestamp) {
+	t.watermarks.begin(ts)
+}
+
+//End marks an entry as handled
+func (t WatermarkTracker) End(ts bson.MongoTimestamp) {
+	t.watermarks.end(ts)
+}
+
+//Emit sends the watermark if it advanced
+func (t WatermarkTracker) Emit() {
+	t.watermarks.emit()
+}
+
+//Send passes e to the sinks
+func (t WatermarkTracker) Send(e ChangeEvent) {
+	t.watermarks.sinks.send(e)
+}
+
+//EmitAfter sends the watermark after entries handled entries
+func (t WatermarkTracker) EmitAfter(entries int) {
+	t.watermarks.entries = entries
+}
+
+//Due is true if enough entries were handled to send the watermark
+func (t WatermarkTracker) Due() bool {
+	select {
+	case <-t.watermarks.due:
+		return true
+	default:
+		return false
+	}
+}
+
+//LatencyQuantiles records seconds as latencies of watch and
+//returns the published metrics
+func LatencyQuantiles(watch string, seconds []float64) map[string]float64 {
+	metrics := newMetricRegistry()
+	recorder := newLatencyRecorder(metrics)
+	now := time.Now()
+	for _, s := range seconds {
+		recorder.add(watch, s, now)
+	}
+	recorder.Lock()
+	recorder.publish()
+	recorder.Unlock()
+
+	return metrics.snapshot()
+}
+
+//EventTime is the wall clock time of an oplog entry
+var EventTime = eventTime
+
+//CatchUp exposes the backlog handling of newestFirst
+type CatchUp struct {
+	catchUp *catchUp
+}
+
+//NewCatchUp starts a catch up
+func NewCatchUp() CatchUp {
+	return CatchUp{newCatchUp()}
+}
+
+//HandledLive marks the document of entry as handled by the live tail
+func (c CatchUp) HandledLive(entry map[string]interface{}) {
+	c.catchUp.handledLive(entry)
+}
+
+//HandleBacklog calls handle unless the document was handled live
+func (c CatchUp) HandleBacklog(entry map[string]interface{}, handle func(map[string]interface{})) bool {
+	return c.catchUp.handleBacklog(entry, handle)
+}
+
+//Finish ends the catch up
+func (c CatchUp) Finish() {
+	c.catchUp.finish()
+}
+
+//EntryDocument identifies the document of an oplog entry
+var EntryDocument = entryDocument
+
+//DivergentFields are the fields of update a target document does not match
+var DivergentFields = divergentFields
+
+//VerifyDocuments checks documents read back after update was
+//written and returns the metrics
+func VerifyDocuments(w Watch, update bson.M, documents []map[string]interface{}) map[string]float64 {
+	metrics := newMetricRegistry()
+	verifier := newWriteVerifier(VerifySettings{SamplePercent: 100}, metrics, newEventLog(0))
+	for _, document := range documents {
+		verifier.check(w, document, update)
+	}
+
+	return metrics.snapshot()
+}
+
+//WritesNormalizedField is true if command changes the normalized field of w
+var WritesNormalizedField = writesNormalizedField
+
+//RestoresAfterConflict is true if the tracked values are restored after
+//the application wrote at written and the tracked document changed at tracked
+var RestoresAfterConflict = conflictResolution
+
+//TrackedChanges remembers the change times of tracked documents
+type TrackedChanges struct {
+	changes *trackedChanges
+}
+
+//NewTrackedChanges creates empty change times
+func NewTrackedChanges() TrackedChanges {
+	return TrackedChanges{newTrackedChanges()}
+}
+
+//Record remembers a change of document at
+func (c TrackedChanges) Record(document string, at time.Time) {
+	c.changes.record(document, at)
+}
+
+//Changed returns the last change of document
+func (c TrackedChanges) Changed(document string) (time.Time, bool) {
+	return c.changes.changed(document)
+}
+
+//WithGeneration limits selector and update to targets with an older generation
+var WithGeneration = withGeneration
+
+//WithHistory appends the values of update to the history of the targets
+var WithHistory = withHistory
+
+//HistorySelector guards the selector of a write that adds a history entry
+var HistorySelector = historySelector
+
+//TemporalQuery selects the values of a tracked document that were valid at a time
+var TemporalQuery = temporalQuery
+
+//HandleUpdateWith lets t handle an update of w like the agent
+var HandleUpdateWith = handleUpdate
+
+//PendingTargets queues updates of missing targets
+type PendingTargets struct {
+	pending *pendingTargets
+	metrics *metricRegistry
+}
+
+//NewPendingTargets creates an empty queue
+func NewPendingTargets(retention RetentionSettings) PendingTargets {
+	metrics := newMetricRegistry()
+	return PendingTargets{newPendingTargets(retention, metrics), metrics}
+}
+
+//Add queues update for the targets of w referencing reference
+func (p PendingTargets) Add(w Watch, reference interface{}, update bson.M, now time.Time) {
+	p.pending.add(w, reference, update, 0, now)
+}
+
+//Take returns the queued updates for the targets of w referencing reference
+func (p PendingTargets) Take(w Watch, reference interface{}, now time.Time) []bson.M {
+	updates := []bson.M{}
+	for _, pending := range p.pending.take(w, reference, now) {
+		updates = append(updates, pending.update)
+	}
+
+	return updates
+}
+
+//Metrics returns the metrics of the queue
+func (p PendingTargets) Metrics() map[string]float64 {
+	return p.metrics.snapshot()
+}
+
+//DiagnosticsSelector is the query of filter
+func DiagnosticsSelector(filter DiagnosticsFilter) bson.M {
+	return filter.selector()
+}
+
+//NewSubscriptions creates the sink behind TailAgent.Subscribe and its subscribe func
+func NewSubscriptions() (Sink, func(EventFilter) (<-chan ChangeEvent, func())) {
+	s := newSubscriptions()
+	return s, s.subscribe
+}
+
+//SyncDocument lets tracker write the tracked fields of document like SyncOnce
+func SyncDocument(tracker Tracker, w Watch, document map[string]interface{}) error {
+	agent := &TailAgent{tracker: tracker}
+	return agent.syncDocument(w, document)
+}
+
+//FeatureFlags decides which documents get a feature
+type FeatureFlags struct {
+	flags *featureFlags
+}
+
+//NewFeatureFlags creates the configured flags
+func NewFeatureFlags(flags map[string]FeatureFlag) FeatureFlags {
+	return FeatureFlags{newFeatureFlags(flags)}
+}
+
+//Enabled is true if feature is rolled out to the document with id of w
+func (f FeatureFlags) Enabled(feature string, w Watch, id interface{}) bool {
+	return f.flags.enabled(feature, w, id)
+}
+
+//Set changes the rollout of feature
+func (f FeatureFlags) Set(feature string, percent float64) error {
+	return f.flags.set(feature, percent)
+}
+
+//ApplyFeatureTransforms runs the transforms of w that are rolled
+//out to the document with id on update
+func ApplyFeatureTransforms(w Watch, f FeatureFlags, id interface{}, update bson.M) error {
+	transforms, err := newWatchTransforms([]Watch{w}, f.flags)
+	if err != nil {
+		return err
+	}
+
+	return transforms.apply(w, id, update)
+}
+
+//BackfillDone is the event of a finished backfill
+var BackfillDone = backfillDone
+
+//BackfillBatchSize is the number of documents a backfill reads at once
+func BackfillBatchSize(settings BackfillSettings) int {
+	return settings.batchSize()
+}
+
+//TagSets converts backfill tags into tag sets
+var TagSets = tagSets
+
+//RetryableWrite is true for errors of writes that can be sent again
+var RetryableWrite = retryableWrite
+
+//RetryWrite runs a write that fails with errs in order through a
+//tracker, it returns the number of attempts, the counted retries and the error
+func RetryWrite(retryWrites bool, errs []error) (int, float64, error) {
+	return RetryWritePolicy(Configuration{Mongo: Mongo{RetryWrites: retryWrites}}, Watch{}, errs)
+}
+
+//RetryWritePolicy runs a write of w that fails with errs in order through
+//a tracker of config, it returns the number of attempts, the counted
+//retries and the error
+func RetryWritePolicy(config Configuration, w Watch, errs []error) (int, float64, error) {
+	metrics := newMetricRegistry()
+	tracker := changeTracker{metrics: metrics, retry: trackerRetryPolicy(config)}
+	attempts := 0
+	err := tracker.write(w, &mgo.Session{}, IntendedWrite{}, func() error {
+		attempts++
+		return errs[attempts-1]
+	})
+
+	return attempts, metrics.get(MetricWriteRetries), err
+}
+
+//MockWrite runs a failing write through a tracker with mock targets,
+//it returns the number of attempts and the error
+func MockWrite() (int, error) {
+	tracker := changeTracker{mockTargets: true}
+	attempts := 0
+	err := tracker.write(Watch{}, &mgo.Session{}, IntendedWrite{}, func() error {
+		attempts++
+		return io.EOF
+	})
+
+	return attempts, err
+}
+
+//DryRunWrite reports intended of w through a tracker with a dry run of
+//settings, matched targets are counted as 3. It returns the number of
+//attempts, the counted intended writes and the lines written to stdout.
+func DryRunWrite(settings DryRunSettings, w Watch, intended IntendedWrite) (int, float64, string, error) {
+	metrics := newMetricRegistry()
+	report := newDryRunReport(&settings, metrics, nil)
+	report.count = func(session *mgo.Session, intended IntendedWrite) (int, error) {
+		return 3, nil
+	}
+	output := &bytes.Buffer{}
+	report.output = output
+	if err := report.start(); err != nil {
+		return 0, 0, "", err
+	}
+	defer report.stop()
+
+	tracker := changeTracker{metrics: metrics, dryRun: report}
+	attempts := 0
+	err := tracker.write(w, &mgo.Session{}, intended, func() error {
+		attempts++
+		return nil
+	})
+
+	return attempts, metrics.get(MetricDryRunWrites), output.String(), err
+}
+
+//DryRunRemove removes the tracked document with id of w through a tracker
+//with a dry run and returns the reported intended writes
+func DryRunRemove(w Watch, id interface{}) (string, error) {
+	report := newDryRunReport(&DryRunSettings{}, newMetricRegistry(), nil)
+	report.count = func(session *mgo.Session, intended IntendedWrite) (int, error) {
+		return 1, nil
+	}
+	output := &bytes.Buffer{}
+	report.output = output
+	if err := report.start(); err != nil {
+		return "", err
+	}
+	defer report.stop()
+
+	//the zero session can not be copied, it is never used for writes in a dry run
+	tracker := changeTracker{session: &mgo.Session{}, reuseSession: true, metrics: newMetricRegistry(), dryRun: report}
+	err := tracker.remove(w, map[string]interface{}{"_id": id}, map[string]interface{}{"_id": id})
+	return output.String(), err
+}
+
+//InvalidateCaches invalidates the caches of a write of update to the
+//targets of id of w with settings, it returns the counted invalidations
+//and failures
+func InvalidateCaches(settings CacheInvalidationSettings, w Watch, id, target interface{}, update bson.M) (float64, float64) {
+	metrics := newMetricRegistry()
+	caches := newCacheInvalidation(&settings, metrics)
+	defer caches.stop()
+	tracker := changeTracker{caches: caches}
+	tracker.invalidateCaches(w, id, target, update)
+	return metrics.get(MetricCacheInvalidations), metrics.get(MetricCacheInvalidationFailures)
+}
+
+//DeleteQuery is the update of the targets of w by its delete policy
+var DeleteQuery = deleteQuery
+
+//FileCheckpoint stores watermarks in a file
+type FileCheckpoint struct {
+	*checkpoint
+}
+
+//NewFileCheckpoint stores checkpoints in path
+func NewFileCheckpoint(path string) FileCheckpoint {
+	return FileCheckpoint{newCheckpoint(CheckpointSettings{File: path}, nil)}
+}
+
+//NewDurableFileCheckpoint stores checkpoints in path and syncs them to disk
+func NewDurableFileCheckpoint(path string) FileCheckpoint {
+	return FileCheckpoint{newCheckpoint(CheckpointSettings{File: path, Durable: true}, nil)}
+}
+
+//Load returns the stored checkpoint
+func (c FileCheckpoint) Load() (bson.MongoTimestamp, error) {
+	return c.load()
+}
+
+//WatermarkInterval is the interval watermarks are sent in
+var WatermarkInterval = watermarkInterval
+
+//RunWorkerPool runs jobs on a worker pool with settings and waits for
+//them, it returns the most jobs that ran at the same time and the
+//trackers the jobs got
+func RunWorkerPool(settings WorkerSettings, tracker Tracker, jobs int) (int, []Tracker) {
+	var lock sync.Mutex
+	running, most := 0, 0
+	trackers := []Tracker{}
+
+	workers := &sync.WaitGroup{}
+	pool := newWorkerPool(settings, tracker, workers, newMetricRegistry())
+	for i := 0; i < jobs; i++ {
+		pool.submit(func(tracker Tracker) {
+			lock.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			trackers = append(trackers, tracker)
+			lock.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+		})
+	}
+
+	pool.close()
+	workers.Wait()
+	return most, trackers
+}
+
+//RunOrdered runs a job for every document of documents on a worker pool
+//with settings and returns the order the jobs of each document ran in
+func RunOrdered(settings WorkerSettings, documents []string) map[string][]int {
+	var lock sync.Mutex
+	order := map[string][]int{}
+
+	workers := &sync.WaitGroup{}
+	pool := newWorkerPool(settings, nil, workers, newMetricRegistry())
+	for i, document := range documents {
+		i, document := i, document
+		pool.submitOrdered(document, func(tracker Tracker) {
+			time.Sleep(time.Duration(len(documents)-i) * 100 * time.Microsecond)
+			lock.Lock()
+			order[document] = append(order[document], i)
+			lock.Unlock()
+		})
+	}
+
+	workers.Wait()
+	pool.close()
+	return order
+}
+
+//RunPartitioned handles entries on a worker pool with settings like the
+//tail and returns the order the entries of each document were handled in
+func RunPartitioned(settings WorkerSettings, watches []Watch, entries []map[string]interface{}) map[string][]int {
+	var lock sync.Mutex
+	order := map[string][]int{}
+
+	workers := &sync.WaitGroup{}
+	set := newWatchSet(watches)
+	pool := newWorkerPool(settings, nil, workers, newMetricRegistry())
+	for i, entry := range entries {
+		i, document := i, documentKey(entry)
+		pool.submitPlaced(document, partitionKey(settings.Partition, set, entry), func(tracker Tracker) {
+			time.Sleep(time.Duration(len(entries)-i) * time.Millisecond)
+			lock.Lock()
+			order[document] = append(order[document], i)
+			lock.Unlock()
+		})
+	}
+
+	workers.Wait()
+	pool.close()
+	return order
+}
+
+//ResumePointAfter converts checkpoint while oldest is the first oplog entry
+var ResumePointAfter = resumePoint
+
+//DocumentKey identifies the document of an oplog entry
+var DocumentKey = documentKey
+
+//PartitionKey is the ordering domain of entry by settings for watches
+func PartitionKey(settings PartitionSettings, watches []Watch, entry map[string]interface{}) string {
+	return partitionKey(settings, newWatchSet(watches), entry)
+}
+
+//ChangeStreamEntry converts a change event into an oplog entry
+var ChangeStreamEntry = changeStreamEntry
+
+//ScaleWorkers returns the number of workers for the next interval
+var ScaleWorkers = scaleWorkers
+
+//ResourceLimits returns GOMAXPROCS and the memory limit for the cgroup below root
+var ResourceLimits = resourceLimits
+
+//SpoolSink is a sink that spools the events its sink fails to take
+type SpoolSink struct {
+	*spoolSink
+}
+
+//NewSpoolSink spools the events sink fails to take
+func NewSpoolSink(sink Sink, settings SpoolSettings) (SpoolSink, error) {
+	s, err := newSpoolSink(sink, settings)
+	return SpoolSink{s}, err
+}
+
+//Drain sends the spooled events
+func (s SpoolSink) Drain() {
+	s.drain()
+}
+
+//ErrSinkFull is returned by sinks that had to drop an event
+var ErrSinkFull = errSinkFull
+
+//SendFiltered sends events to s like an agent with a sink filter
+func SendFiltered(s Sink, filter *EventFilter, events ...ChangeEvent) {
+	sinks := &sinkDispatcher{}
+	sinks.addFiltered(s, filter)
+	for _, e := range events {
+		sinks.send(e)
+	}
+}
+
+//PrometheusText writes values and a histogram of observed
+//handler durations in the prometheus text format
+func PrometheusText(values map[string]float64, observed ...float64) string {
+	metrics := newMetricRegistry()
+	for name, value := range values {
+		metrics.set(name, value)
+	}
+	for _, seconds := range observed {
+		metrics.observe(MetricHandlerDuration, seconds)
+	}
+
+	var text bytes.Buffer
+	writePrometheus(&text, metrics.snapshot())
+	return text.String()
+}
+
+//IntermediatePaths are the parents of the fields set by update
+var IntermediatePaths = intermediatePaths
+
+//ChangedFields are the tracked fields changed by command
+var ChangedFields = changedFields
+
+//ElementQuery moves the fields of query into the element at position
+//of the reference array of w
+var ElementQuery = elementQuery
+
+//FailedUpdate lets a tracker that drops all writes handle the update,
+//it returns the stored dead letters and the error of retrying the first
+func FailedUpdate(w Watch, command, selector map[string]interface{}) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	tracker := changeTracker{
+		reuseSession: true,
+		chaos:        newFaultInjector(&ChaosSettings{DropWritesPercent: 100}),
+		deadLetters: &deadLetters{store: func(letter DeadLetter) error {
+			letters = append(letters, letter)
+			return nil
+		}},
+	}
+
+	tracker.HandleUpdate(w, command, selector)
+	if len(letters) == 0 {
+		return letters, nil
+	}
+
+	return letters, tracker.replay(w, letters[0])
+}
+
+//SealDeadLetter encrypts the payload of letter with encryption
+func SealDeadLetter(letter DeadLetter, encryption *PayloadEncryption) (DeadLetter, error) {
+	err := letter.seal(encryption)
+	return letter, err
+}
+
+//OpenDeadLetter decrypts the payload of letter with encryption
+func OpenDeadLetter(letter DeadLetter, encryption *PayloadEncryption) (DeadLetter, error) {
+	err := letter.open(encryption)
+	return letter, err
+}
+
+//OpenUnknownOperation decrypts the entry of a captured example
+var OpenUnknownOperation = openExample
+
+//RetryDeadLetter retries letter with a tracker without session
+func RetryDeadLetter(w Watch, letter DeadLetter) error {
+	return changeTracker{}.replay(w, letter)
+}
+
+//LoadReferences picks n of count tracked documents like a load test
+func LoadReferences(n, count int, skewed bool) []int {
+	random := rand.New(rand.NewSource(1))
+	picks := []int{}
+	for i := 0; i < n; i++ {
+		picks = append(picks, loadReference(count, skewed, random))
+	}
+
+	return picks
+}
+
+//SetField sets the dotted field of document
+var SetField = setField
+
+//RetryBackoff is the wait of policy before attempt with the jitter of random
+func RetryBackoff(policy RetryPolicy, attempt int, random float64) time.Duration {
+	return policy.backoff(attempt, func() float64 { return random })
+}
+
+//RetryableError is true if policy sends a write that failed with err again
+func RetryableError(policy RetryPolicy, err error) bool {
+	return policy.retryable(err)
+}
+
+//AdaptiveFactors returns the batch factors of settings after each lag
+//and the last value of the factor metric
+func AdaptiveFactors(settings AdaptiveBatchSettings, lags []time.Duration) ([]int, float64) {
+	metrics := newMetricRegistry()
+	adaptive := newAdaptiveBatching(settings, metrics)
+	factors := []int{}
+	for _, lag := range lags {
+		factors = append(factors, adaptive.adjust(lag))
+	}
+
+	return factors, metrics.get(MetricBatchFactor)
+}
+
+//BatchMaxEvents is the size at which a batch sink scaled
+//by factor commits early
+func BatchMaxEvents(factor int) int {
+	batch := &batchSink{}
+	batch.scale(factor)
+	return batch.maxEvents()
+}
+
+//BatchMaxKept times the batch size is kept by failed batch sinks
+const BatchMaxKept = batchMaxKept
+
+//ReferenceCache exposes the reference cache of an agent
+type ReferenceCache struct {
+	cache *referenceCache
+}
+
+//NewReferenceCache keeps up to size documents
+func NewReferenceCache(size int) ReferenceCache {
+	return ReferenceCache{newReferenceCache(ReferenceCacheSettings{Size: size}, nil, nil, nil)}
+}
+
+//Get returns the cached document of ref
+func (c ReferenceCache) Get(ref mgo.DBRef) (map[string]interface{}, bool) {
+	return c.cache.get(ref)
+}
+
+//Put caches the looked up document of ref
+func (c ReferenceCache) Put(ref mgo.DBRef, document map[string]interface{}) {
+	c.cache.put(ref, document)
+}
+
+//Invalidate removes the document with the key of an oplog entry
+func (c ReferenceCache) Invalidate(key string) {
+	c.cache.invalidate(key)
+}
+
+//Clear removes all documents
+func (c ReferenceCache) Clear() {
+	c.cache.clear()
+}
+
+//ShardURI is the connection uri of the members of a shard
+func ShardURI(router, host string) string {
+	return shardURI(router, host)
+}
+
+//ShardConfiguration is the configuration of the agent of shard
+func ShardConfiguration(c Configuration, shard Shard) Configuration {
+	return shardConfiguration(c, shard)
+}
+
+//MergedSinks returns the sinks of the agents of shards that pass their events to sink
+func MergedSinks(sink Sink, shards int) []Sink {
+	merged := newMergedSink(sink, shards)
+	sinks := []Sink{}
+	for i := 0; i < shards; i++ {
+		sinks = append(sinks, shardSink{merged: merged, shard: i})
+	}
+
+	return sinks
+}
+
+//ControlledAgent is an agent of watches that is not connected, the
+//handler serves its control endpoints. start lets it take rescans
+//until stop is called.
+func ControlledAgent(watches []Watch) (agent *TailAgent, handler http.Handler, start func(), stop func()) {
+	metrics := newMetricRegistry()
+	transforms, _ := newWatchTransforms(watches, nil)
+	agent = &TailAgent{
+		config:     Configuration{Mongo: Mongo{ConnectionURI: "localhost:30000"}, Watches: watches},
+		watches:    newWatchSet(watches),
+		transforms: transforms,
+		metrics:    metrics,
+		events:     newEventLog(0),
+		pause:      newPauseSwitch(metrics),
+		rescans:    newWatchRescans(),
+		graphql:    NewGraphQLBridge(watches),
+	}
+
+	mux := http.NewServeMux()
+	for pattern, handler := range agent.handlers() {
+		mux.Handle(pattern, handler)
+	}
+
+	return agent, mux, func() { agent.rescans.start() }, agent.rescans.stop
+}
+
+//RunRescan runs rescan for the watch key with the rescans of agent
+func RunRescan(agent *TailAgent, key string, rescan func(ctx context.Context) error) error {
+	return agent.rescans.run(key, rescan)
+}
+
+//HotKeyRecorder records the fan-outs of updates of watches with settings
+func HotKeyRecorder(settings HotKeySettings) (record func(w Watch, id interface{}, targets int), report func(limit int) []HotKey) {
+	h := newHotKeys(settings, nil, nil)
+	return h.record, h.report
+}
+
+//DiffWatches compares the watches before and after a reload
+var DiffWatches = diffWatches
+
+//HotKeyStrategies applies the strategies of settings, divert is true if an
+//update was not written right away. Events lists the recorded alerts.
+func HotKeyStrategies(settings HotKeySettings) (divert func(w Watch, id interface{}, write, refresh func()) bool, record func(w Watch, id interface{}, targets int), start func() error, stop func(), events func() []AgentEvent) {
+	log := newEventLog(0)
+	h := newHotKeys(settings, newMetricRegistry(), log)
+	divert = func(w Watch, id interface{}, write, refresh func()) bool {
+		return h.divert(w, id, 0, write, refresh)
+	}
+	start = func() error {
+		return h.start(nil)
+	}
+	return divert, h.record, start, h.stop, func() []AgentEvent { return log.list(EventAlert, 0) }
+}
+
+//HotKeyWatermarks applies the strategies of settings to updates of oplog
+//entries, deferred updates hold the watermarks of tracker
+func HotKeyWatermarks(settings HotKeySettings, tracker WatermarkTracker) (divert func(w Watch, id interface{}, ts bson.MongoTimestamp, write, refresh func()) bool, start func() error, stop func()) {
+	h := newHotKeys(settings, newMetricRegistry(), newEventLog(0))
+	start = func() error {
+		return h.start(tracker.watermarks)
+	}
+	return h.divert, start, h.stop
+}
+
+//UseWatchCheckpoints stores the checkpoints of the watches of agent in file
+func UseWatchCheckpoints(agent *TailAgent, file string) {
+	agent.watchCheckpoints = newWatchCheckpoints(CheckpointSettings{File: file, Watches: true}, nil, agent.watches)
+}
+
+//WatchCheckpointSink is the sink of the watch checkpoints of agent
+func WatchCheckpointSink(agent *TailAgent) WatermarkSink {
+	return agent.watchCheckpoints
+}
+
+//FailWatchWrite holds the checkpoint of the watch with key like a failed write
+func FailWatchWrite(agent *TailAgent, key string) {
+	agent.watchCheckpoints.hold(key)
+}
+
+//LoadWatchCheckpoints reads the stored checkpoints of the watches of agent
+//in file, watches behind the checkpoint of the agent are held
+func LoadWatchCheckpoints(agent *TailAgent, file string, ts bson.MongoTimestamp) error {
+	UseWatchCheckpoints(agent, file)
+	return agent.watchCheckpoints.load(ts)
+}
+
+//ActiveWatches are the keys of the watches of agent that are tracked
+func ActiveWatches(agent *TailAgent) []string {
+	keys := []string{}
+	for _, w := range agent.watches.active() {
+		keys = append(keys, w.Key())
+	}
+
+	return keys
+}
+
+//HandleOplogGap applies settings to an agent of watches that starts at start
+//while the oldest oplog entry is oldest, notifications go to notifier
+func HandleOplogGap(settings OplogGapSettings, notifier Notifier, start, oldest bson.MongoTimestamp) (agent *TailAgent, backfill bool, err error) {
+	agent, _, _, _ = ControlledAgent(nil)
+	agent.config.OplogGap = settings
+	agent.notifications = &notificationCenter{notifiers: map[string]Notifier{"pager": notifier}}
+	backfill, err = agent.handleOplogGap(start, oldest, time.Unix(int64(oldest>>32), 0))
+	return agent, backfill, err
+}
+
+//RescanFilter returns whether the entries of a scoped rescan are handled,
+//it has to be called in oplog order
+func RescanFilter(scope RescanScope, watches []Watch) (func(entry map[string]interface{}) bool, error) {
+	filter, err := newRescanFilter(scope, watches)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.matches, nil
+}
+
+//SetSchemaManifest sets the file the agent keeps its schema manifest in
+func (t *TailAgent) SetSchemaManifest(file string) {
+	t.config.SchemaManifest = file
+}
+
+//LineageRuns starts, reloads and ends runs like an agent does
+type LineageRuns struct {
+	emitter *lineageEmitter
+}
+
+//NewLineageRuns sends the events of the runs with settings
+func NewLineageRuns(settings LineageSettings, mongo Mongo) LineageRuns {
+	return LineageRuns{emitter: newLineageEmitter(&settings, mongo, newMetricRegistry())}
+}
+
+//Start starts the tailing runs of watches
+func (r LineageRuns) Start(watches []Watch) {
+	r.emitter.startRuns(watches)
+}
+
+//Reload replaces the runs of changed watches
+func (r LineageRuns) Reload(watches []Watch) {
+	r.emitter.reloadRuns(watches)
+}
+
+//End ends the tailing runs with err
+func (r LineageRuns) End(err error) {
+	r.emitter.endRuns(err)
+}
+
+//Backfill starts a backfill run of w
+func (r LineageRuns) Backfill(w Watch) func(err error) {
+	return r.emitter.backfill(w)
+}
+
+//Queue sends the events from a queue like a tailing agent, the returned
+//function waits until they are sent
+func (r LineageRuns) Queue() func() {
+	r.emitter.start()
+	return r.emitter.stop
+}
+
+//LineageDatasetNamespace is the dataset namespace of a connection uri
+var LineageDatasetNamespace = lineageDatasetNamespace
+
+//FailedLookups lets a tracker record that the lookups of refs for the
+//target originRef failed with err, it returns the stored orphaned
+//references and the metrics
+func FailedLookups(w Watch, originRef mgo.DBRef, refs []mgo.DBRef, err error) ([]OrphanedReference, map[string]float64) {
+	stored := []OrphanedReference{}
+	metrics := newMetricRegistry()
+	tracker := changeTracker{orphans: &orphans{metrics: metrics, store: func(orphan OrphanedReference) error {
+		stored = append(stored, orphan)
+		return nil
+	}}}
+
+	for _, ref := range refs {
+		tracker.lookupFailed(w, originRef, ref, err)
+	}
+
+	return stored, metrics.snapshot()
+}
+
+//TargetReferences are the ids of the tracked documents of w document references
+var TargetReferences = targetReferences
+
+//DispatchStrictly dispatches entry like the tail of an agent with strict
+//ordering for watches and sinks, sinks are retried after pause
+func DispatchStrictly(ctx context.Context, watches []Watch, sinks []Sink, entry map[string]interface{}, pause time.Duration) error {
+	defer func(previous time.Duration) { strictRetryPause = previous }(strictRetryPause)
+	strictRetryPause = pause
+
+	metrics := newMetricRegistry()
+	agent := &TailAgent{
+		config:  Configuration{Ordering: OrderingStrict, Watches: watches},
+		watches: newWatchSet(watches),
+		metrics: metrics,
+		sinks:   &sinkDispatcher{metrics: metrics},
+	}
+	for _, s := range sinks {
+		agent.sinks.add(s)
+	}
+
+	return agent.dispatch(ctx, entry, &sync.WaitGroup{}, nil, nil)
+}
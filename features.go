@@ -0,0 +1,130 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+)
+
+//FeatureFlag rolls out a feature to Percent of the documents of Watches
+//(watch keys), without watches to the documents of all watches. A
+//document keeps its decision while the percentage grows, so a rollout
+//only ever adds documents. Transforms are gated by a feature with the
+//feature field of their configuration.
+type FeatureFlag struct {
+	Percent float64  `json:"percent" validate:"min=0,max=100"`
+	Watches []string `json:"watches"`
+}
+
+func checkFeatures(features map[string]FeatureFlag, watches []Watch) error {
+	for _, w := range watches {
+		for _, t := range w.Transforms {
+			if _, ok := features[t.Feature]; t.Feature != "" && !ok {
+				return fmt.Errorf("Transform %s of watch %s needs feature %s in features", t.Type, w.Key(), t.Feature)
+			}
+		}
+	}
+
+	return nil
+}
+
+//featureBucket places a document of a feature in one of 10000 buckets
+func featureBucket(feature string, id interface{}) uint32 {
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%s/%v", feature, id)
+	return hash.Sum32() % 10000
+}
+
+//featureFlags decides which documents get a feature, flags can be
+//changed while the agent runs. All methods can be called on nil.
+type featureFlags struct {
+	sync.RWMutex
+	flags map[string]FeatureFlag
+}
+
+func newFeatureFlags(flags map[string]FeatureFlag) *featureFlags {
+	f := &featureFlags{flags: map[string]FeatureFlag{}}
+	for name, flag := range flags {
+		f.flags[name] = flag
+	}
+
+	return f
+}
+
+//enabled is true if feature is rolled out to the document with id of w,
+//features that are not configured are disabled, an empty feature is enabled
+func (f *featureFlags) enabled(feature string, w Watch, id interface{}) bool {
+	if feature == "" {
+		return true
+	}
+
+	if f == nil {
+		return false
+	}
+
+	f.RLock()
+	flag, ok := f.flags[feature]
+	f.RUnlock()
+	if !ok || !matchesAny(flag.Watches, w.Key()) {
+		return false
+	}
+
+	return float64(featureBucket(feature, id)) < flag.Percent*100
+}
+
+//set changes the rollout of a configured feature
+func (f *featureFlags) set(feature string, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("Percent of feature %s must be between 0 and 100", feature)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	flag, ok := f.flags[feature]
+	if !ok {
+		return fmt.Errorf("No feature %s configured", feature)
+	}
+
+	flag.Percent = percent
+	f.flags[feature] = flag
+	return nil
+}
+
+func (f *featureFlags) list() map[string]FeatureFlag {
+	f.RLock()
+	defer f.RUnlock()
+	flags := map[string]FeatureFlag{}
+	for name, flag := range f.flags {
+		flags[name] = flag
+	}
+
+	return flags
+}
+
+//serveFeatures lists the features, a POST with {"name": "...", "percent": 10}
+//changes the rollout of one until the agent restarts
+func (t *TailAgent) serveFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusOK, t.features.list())
+		return
+	}
+
+	var request struct {
+		Name    string  `json:"name"`
+		Percent float64 `json:"percent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid json: " + err.Error()})
+		return
+	}
+
+	if err := t.features.set(request.Name, request.Percent); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	t.events.record(EventLifecycle, "", fmt.Sprintf("Feature %s rolled out to %g%%", request.Name, request.Percent))
+	writeJSON(w, http.StatusOK, t.features.list()[request.Name])
+}
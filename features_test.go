@@ -0,0 +1,84 @@
+package redkeep_test
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Feature flags", func() {
+	w := Watch{Name: "userComments", TargetNormalizedField: "meta", Transforms: []TransformConfig{{Type: "upper", Feature: "shout"}}}
+
+	enabledDocuments := func(flags FeatureFlags, feature string) map[int]bool {
+		enabled := map[int]bool{}
+		for id := 0; id < 1000; id++ {
+			if flags.Enabled(feature, w, id) {
+				enabled[id] = true
+			}
+		}
+
+		return enabled
+	}
+
+	It("rolls out a feature to a share of the documents", func() {
+		flags := NewFeatureFlags(map[string]FeatureFlag{"none": {}, "all": {Percent: 100}, "half": {Percent: 50}})
+		Expect(enabledDocuments(flags, "none")).To(BeEmpty())
+		Expect(enabledDocuments(flags, "all")).To(HaveLen(1000))
+		Expect(len(enabledDocuments(flags, "half"))).To(BeNumerically("~", 500, 60))
+		Expect(enabledDocuments(flags, "unknown")).To(BeEmpty())
+		Expect(flags.Enabled("", w, 1)).To(BeTrue())
+	})
+
+	It("keeps the documents of a rollout when it grows", func() {
+		flags := NewFeatureFlags(map[string]FeatureFlag{"shout": {Percent: 10}})
+		before := enabledDocuments(flags, "shout")
+
+		Expect(flags.Set("shout", 30)).To(Succeed())
+		after := enabledDocuments(flags, "shout")
+		for id := range before {
+			Expect(after).To(HaveKey(id))
+		}
+
+		Expect(flags.Set("shout", 0)).To(Succeed())
+		Expect(enabledDocuments(flags, "shout")).To(BeEmpty())
+		Expect(flags.Set("shout", 101)).ToNot(Succeed())
+		Expect(flags.Set("whisper", 10)).To(MatchError("No feature whisper configured"))
+	})
+
+	It("limits a feature to its watches", func() {
+		flags := NewFeatureFlags(map[string]FeatureFlag{"shout": {Percent: 100, Watches: []string{"other"}}})
+		Expect(enabledDocuments(flags, "shout")).To(BeEmpty())
+	})
+
+	It("only runs gated transforms for the documents of the rollout", func() {
+		flags := NewFeatureFlags(map[string]FeatureFlag{"shout": {Percent: 50}})
+		changed := 0
+		for id := 0; id < 100; id++ {
+			update := bson.M{"$set": bson.M{"meta.name": "alice"}}
+			Expect(ApplyFeatureTransforms(w, flags, id, update)).To(Succeed())
+			if update["$set"].(bson.M)["meta.name"] == "ALICE" {
+				changed++
+				Expect(flags.Enabled("shout", w, id)).To(BeTrue())
+			}
+		}
+
+		Expect(changed).To(BeNumerically(">", 0))
+		Expect(changed).To(BeNumerically("<", 100))
+	})
+
+	It("needs the features of gated transforms to be configured", func() {
+		transform := `"triggerReference": "xEx", "transforms": [{ "type": "upper", "feature": "shout" }]`
+		config := strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, transform, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError(ContainSubstring("needs feature shout")))
+
+		config = strings.Replace(config, `"watches"`, `"features": { "shout": { "percent": 5 } }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,71 @@
+package redkeep
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//targetField is the name of the tracked field on the targets of w,
+//relative to the normalized field
+func (w Watch) targetField(field string) string {
+	if target, ok := w.FieldMap[field]; ok {
+		return target
+	}
+
+	return field
+}
+
+//fieldMapTrackFields tracks the fields of the field map of watches
+//without trackFields
+func fieldMapTrackFields(watches []Watch) {
+	for i, w := range watches {
+		if len(w.TrackFields) > 0 || len(w.FieldMap) == 0 {
+			continue
+		}
+
+		for field := range w.FieldMap {
+			watches[i].TrackFields = append(watches[i].TrackFields, field)
+		}
+		sort.Strings(watches[i].TrackFields)
+	}
+}
+
+//checkFieldMap makes sure the field map only renames tracked fields and
+//every tracked field gets its own place on the targets
+func checkFieldMap(w Watch) error {
+	tracked := map[string]bool{}
+	for _, field := range w.TrackFields {
+		tracked[field] = true
+	}
+
+	for field, target := range w.FieldMap {
+		if !tracked[field] {
+			return fmt.Errorf("Field %s of the field map of watch %s is not tracked", field, w.Key())
+		}
+
+		if target == "" || strings.HasPrefix(target, "$") || strings.Contains(target, "..") {
+			return fmt.Errorf("Invalid target field %q for %s of watch %s", target, field, w.Key())
+		}
+	}
+
+	targets := map[string]string{}
+	for _, field := range w.TrackFields {
+		target := w.targetField(field)
+		if target == historyField || target == generationField {
+			return fmt.Errorf("Target field %s of watch %s is reserved", target, w.Key())
+		}
+
+		for other, source := range targets {
+			switch {
+			case other == target:
+				return fmt.Errorf("Fields %s and %s of watch %s are both written to %s", source, field, w.Key(), target)
+			case strings.HasPrefix(other, target+"."), strings.HasPrefix(target, other+"."):
+				return fmt.Errorf("Target fields %s and %s of watch %s overlap", other, target, w.Key())
+			}
+		}
+		targets[target] = field
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//actions on an oplog gap
+const (
+	//OplogGapFail does not start tailing, it is the default
+	OplogGapFail = "fail"
+	//OplogGapBackfill backfills all watches before tailing, like -backfill
+	OplogGapBackfill = "backfill"
+	//OplogGapAlert tails from the oldest entry with an alert
+	OplogGapAlert = "alert"
+)
+
+//OplogGapSettings decide what happens if the oplog rotated past the start
+//of the agent, like its checkpoint after a long downtime, and changes were
+//missed. Action is fail (default), backfill or alert. Notify are the
+//notifiers that are told about the gap.
+type OplogGapSettings struct {
+	Action string   `json:"action"`
+	Notify []string `json:"notify"`
+}
+
+func checkOplogGapSettings(settings OplogGapSettings, notifications NotificationSettings) error {
+	switch settings.Action {
+	case "", OplogGapFail, OplogGapBackfill, OplogGapAlert:
+	default:
+		return fmt.Errorf("Unknown oplog gap action %s, use fail, backfill or alert", settings.Action)
+	}
+
+	names := map[string]bool{}
+	for _, n := range notifications.Notifiers {
+		names[n.Name] = true
+	}
+
+	for _, name := range settings.Notify {
+		if !names[name] {
+			return fmt.Errorf("Oplog gap uses unknown notifier %s", name)
+		}
+	}
+
+	return nil
+}
+
+//checkOplogGap compares start with the oldest entry of oplog, it returns
+//true if the watches have to be backfilled
+func (t *TailAgent) checkOplogGap(oplog *mgo.Collection, start bson.MongoTimestamp) (bool, error) {
+	oldest, err := oldestOplogEntry(oplog)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return t.handleOplogGap(start, oldest, time.Now())
+}
+
+//handleOplogGap applies the oplog gap action if oldest is after start
+func (t *TailAgent) handleOplogGap(start, oldest bson.MongoTimestamp, now time.Time) (bool, error) {
+	if start == 0 || oldest <= start {
+		t.metrics.set(MetricOplogGapSeconds, 0)
+		return false, nil
+	}
+
+	from, to := time.Unix(int64(start>>32), 0), time.Unix(int64(oldest>>32), 0)
+	missed := to.Sub(from)
+	t.metrics.set(MetricOplogGapSeconds, missed.Seconds())
+	message := fmt.Sprintf("The oplog rotated past the start %s, changes of up to %s were missed", from.UTC().Format(time.RFC3339), missed)
+	if t.notifications != nil {
+		t.notifications.notify(t.config.OplogGap.Notify, Notification{
+			Condition: "oplogGap",
+			Severity:  "critical",
+			Value:     missed.Seconds(),
+			Message:   message,
+			Time:      now,
+		})
+	}
+
+	switch t.config.OplogGap.Action {
+	case OplogGapBackfill:
+		t.events.record(EventAlert, "", message+", backfilling all watches")
+		logWarn(message+", backfilling all watches", nil)
+		return true, nil
+	case OplogGapAlert:
+		t.events.record(EventAlert, "", message)
+		logError(message, nil)
+		return false, nil
+	}
+
+	t.events.record(EventError, "", message)
+	return false, errors.New(message + ", start with -backfill or configure oplogGap")
+}
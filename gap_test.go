@@ -0,0 +1,62 @@
+package redkeep_test
+
+import (
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Oplog gaps", func() {
+	//the oplog starts an hour after the checkpoint of 2024-05-02T10:00:00Z
+	checkpoint := bson.MongoTimestamp(int64(1714644000)<<32 | 7)
+	oldest := bson.MongoTimestamp(int64(1714647600)<<32 | 1)
+	missed := "The oplog rotated past the start 2024-05-02T10:00:00Z, changes of up to 1h0m0s were missed"
+
+	It("will not start tailing after a gap", func() {
+		notifier := &recordingNotifier{}
+		agent, backfill, err := HandleOplogGap(OplogGapSettings{Notify: []string{"pager"}}, notifier, checkpoint, oldest)
+		Expect(err).To(MatchError(missed + ", start with -backfill or configure oplogGap"))
+		Expect(backfill).To(BeFalse())
+		Expect(agent.Status().Metrics[MetricOplogGapSeconds]).To(Equal(3600.0))
+		Expect(agent.Events(EventError, 1)[0].Message).To(Equal(missed))
+
+		Expect(notifier.received).To(HaveLen(1))
+		Expect(notifier.received[0].Condition).To(Equal("oplogGap"))
+		Expect(notifier.received[0].Message).To(Equal(missed))
+	})
+
+	It("will backfill or alert after a gap", func() {
+		agent, backfill, err := HandleOplogGap(OplogGapSettings{Action: OplogGapBackfill}, &recordingNotifier{}, checkpoint, oldest)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backfill).To(BeTrue())
+		Expect(agent.Events(EventAlert, 1)[0].Message).To(Equal(missed + ", backfilling all watches"))
+
+		agent, backfill, err = HandleOplogGap(OplogGapSettings{Action: OplogGapAlert}, &recordingNotifier{}, checkpoint, oldest)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backfill).To(BeFalse())
+		Expect(agent.Events(EventAlert, 1)[0].Message).To(Equal(missed))
+	})
+
+	It("will tail if the oplog reaches back to the start", func() {
+		notifier := &recordingNotifier{}
+		agent, backfill, err := HandleOplogGap(OplogGapSettings{Notify: []string{"pager"}}, notifier, oldest, checkpoint)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backfill).To(BeFalse())
+		Expect(agent.Status().Metrics[MetricOplogGapSeconds]).To(BeZero())
+		Expect(notifier.received).To(BeEmpty())
+	})
+
+	It("checks the oplog gap settings", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"oplogGap": { "action": "ignore" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Unknown oplog gap action ignore, use fail, backfill or alert"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"oplogGap": { "action": "alert", "notify": ["pager"] }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Oplog gap uses unknown notifier pager"))
+	})
+})
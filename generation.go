@@ -0,0 +1,68 @@
+package redkeep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//generationField is stored in the normalized field of watches with generations
+const generationField = "_generation"
+
+//GenerationTracker can be implemented by a Tracker to write generations.
+//The generation of a write is the timestamp of the oplog entry it is
+//made for, a target is only written if its generation is older. Every
+//agent and every replay derives the same generation from the oplog, so
+//an older change can never overwrite a newer one. It is only used for
+//watches with generations.
+type GenerationTracker interface {
+	HandleUpdateGeneration(w Watch, command map[string]interface{}, selector map[string]interface{}, generation bson.MongoTimestamp)
+	HandleInsertGeneration(w Watch, command map[string]interface{}, originRef mgo.DBRef, generation bson.MongoTimestamp)
+}
+
+//withGeneration limits selector to targets with an older generation and
+//sets the generation with update, nothing is changed for generation 0
+func withGeneration(w Watch, selector bson.M, update bson.M, generation bson.MongoTimestamp) {
+	if generation == 0 {
+		return
+	}
+
+	field := w.TargetNormalizedField + "." + generationField
+	selector["$or"] = []bson.M{
+		{field: bson.M{"$lt": generation}},
+		{field: bson.M{"$exists": false}},
+	}
+
+	set, ok := update["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set[field] = generation
+}
+
+//handleInsert lets t handle an insert, with the generation if w has generations
+func handleInsert(t Tracker, w Watch, command map[string]interface{}, originRef mgo.DBRef, generation bson.MongoTimestamp) {
+	if generations, ok := t.(GenerationTracker); ok && w.BehaviourSettings.Generations {
+		generations.HandleInsertGeneration(w, command, originRef, generation)
+		return
+	}
+
+	t.HandleInsert(w, command, originRef)
+}
+
+//handleUpdate lets t handle an update, with the generation if w has
+//generations. The change tracker gets the time of the entry as well,
+//deferred updates of hot keys hold the watermarks before it.
+func handleUpdate(t Tracker, w Watch, command map[string]interface{}, selector map[string]interface{}, generation bson.MongoTimestamp) {
+	if generations, ok := t.(GenerationTracker); ok && w.BehaviourSettings.Generations {
+		generations.HandleUpdateGeneration(w, command, selector, generation)
+		return
+	}
+
+	if c, ok := t.(*changeTracker); ok {
+		c.handleUpdate(w, command, selector, 0, generation)
+		return
+	}
+
+	t.HandleUpdate(w, command, selector)
+}
@@ -0,0 +1,67 @@
+package redkeep_test
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type generationRecorder struct {
+	updates     int
+	generations []bson.MongoTimestamp
+}
+
+func (r *generationRecorder) HandleRemove(w Watch, command map[string]interface{}, selector map[string]interface{}) {
+}
+
+func (r *generationRecorder) HandleInsert(w Watch, command map[string]interface{}, originRef mgo.DBRef) {
+}
+
+func (r *generationRecorder) HandleUpdate(w Watch, command map[string]interface{}, selector map[string]interface{}) {
+	r.updates++
+}
+
+func (r *generationRecorder) HandleInsertGeneration(w Watch, command map[string]interface{}, originRef mgo.DBRef, generation bson.MongoTimestamp) {
+}
+
+func (r *generationRecorder) HandleUpdateGeneration(w Watch, command map[string]interface{}, selector map[string]interface{}, generation bson.MongoTimestamp) {
+	r.generations = append(r.generations, generation)
+}
+
+var _ = Describe("Generations", func() {
+	w := Watch{TargetNormalizedField: "meta", BehaviourSettings: BehaviourSettings{Generations: true}}
+
+	It("only writes targets with an older generation", func() {
+		selector := bson.M{"user.$id": "1"}
+		update := bson.M{"$unset": bson.M{"meta.name": ""}}
+		WithGeneration(w, selector, update, 42)
+
+		Expect(selector["$or"]).To(Equal([]bson.M{
+			{"meta._generation": bson.M{"$lt": bson.MongoTimestamp(42)}},
+			{"meta._generation": bson.M{"$exists": false}},
+		}))
+		Expect(update["$set"]).To(Equal(bson.M{"meta._generation": bson.MongoTimestamp(42)}))
+	})
+
+	It("leaves writes without generation unchanged", func() {
+		selector := bson.M{"user.$id": "1"}
+		update := bson.M{"$set": bson.M{"meta.name": "Hans"}}
+		WithGeneration(w, selector, update, 0)
+
+		Expect(selector).To(Equal(bson.M{"user.$id": "1"}))
+		Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.name": "Hans"}}))
+	})
+
+	It("passes generations only for watches with generations", func() {
+		recorder := &generationRecorder{}
+		HandleUpdateWith(recorder, w, map[string]interface{}{}, map[string]interface{}{}, 7)
+		HandleUpdateWith(recorder, Watch{}, map[string]interface{}{}, map[string]interface{}{}, 8)
+
+		Expect(recorder.generations).To(Equal([]bson.MongoTimestamp{7}))
+		Expect(recorder.updates).To(Equal(1))
+	})
+})
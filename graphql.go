@@ -0,0 +1,490 @@
+package redkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const graphqlSubscriberBuffer = 64
+
+//fixed fields every change type has
+var graphqlEventFields = []string{"op", "ns", "id", "ts", "watch"}
+
+//GraphQLBridge exposes change events as GraphQL subscriptions.
+//Every watch gets its own root subscription field with a type that
+//contains the tracked fields. Subscriptions are streamed as
+//server-sent events following the graphql-sse protocol.
+//The bridge is a Sink, it has to receive the events it should publish.
+type GraphQLBridge struct {
+	sync.RWMutex
+	roots       map[string]*graphqlType
+	subscribers map[*graphqlSubscriber]bool
+}
+
+type graphqlType struct {
+	root     string
+	name     string
+	watch    string
+	fields   map[string]string
+	ordering []string
+}
+
+type graphqlSubscriber struct {
+	selections map[string][]string
+	events     chan ChangeEvent
+}
+
+//NewGraphQLBridge generates the subscription schema for watches
+func NewGraphQLBridge(watches []Watch) *GraphQLBridge {
+	return &GraphQLBridge{
+		roots:       graphqlRoots(watches),
+		subscribers: map[*graphqlSubscriber]bool{},
+	}
+}
+
+//graphqlRoots generates the root subscription fields of watches
+func graphqlRoots(watches []Watch) map[string]*graphqlType {
+	roots := map[string]*graphqlType{}
+	for _, w := range watches {
+		root := graphqlName(watchLabel(w), false)
+		for i := 2; roots[root] != nil; i++ {
+			root = fmt.Sprintf("%s%d", graphqlName(watchLabel(w), false), i)
+		}
+
+		t := &graphqlType{
+			root:   root,
+			name:   graphqlName(root, true) + "Change",
+			watch:  w.Key(),
+			fields: map[string]string{},
+		}
+
+		for _, f := range graphqlEventFields {
+			t.fields[f] = ""
+			t.ordering = append(t.ordering, f)
+		}
+
+		for _, f := range w.TrackFields {
+			name := strings.Replace(f, ".", "_", -1)
+			name = graphqlName(name, false)
+			if _, taken := t.fields[name]; taken {
+				name = "field_" + name
+			}
+
+			t.fields[name] = f
+			t.ordering = append(t.ordering, name)
+		}
+
+		roots[root] = t
+	}
+
+	return roots
+}
+
+//reload generates the schema for the watches the agent tracks now,
+//running subscriptions of removed watches get no more events
+func (b *GraphQLBridge) reload(watches []Watch) {
+	if b == nil {
+		return
+	}
+
+	roots := graphqlRoots(watches)
+	b.Lock()
+	defer b.Unlock()
+	b.roots = roots
+}
+
+//watchLabel is a human readable label for the watch
+func watchLabel(w Watch) string {
+	if w.Name != "" {
+		return w.Name
+	}
+
+	parts := []string{}
+	for _, namespace := range []string{w.TrackCollection, w.TargetCollection} {
+		if p := strings.Index(namespace, "."); p != -1 {
+			namespace = namespace[p+1:]
+		}
+		parts = append(parts, namespace)
+	}
+
+	return strings.Join(append(parts, w.TargetNormalizedField), " ")
+}
+
+//graphqlName converts s into a valid GraphQL name in camel case
+func graphqlName(s string, upperFirst bool) string {
+	var buffer bytes.Buffer
+	upperNext := upperFirst
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			upperNext = buffer.Len() > 0 || upperFirst
+			continue
+		}
+
+		if upperNext {
+			r = unicode.ToUpper(r)
+			upperNext = false
+		} else if buffer.Len() == 0 && !upperFirst {
+			r = unicode.ToLower(r)
+		}
+
+		buffer.WriteRune(r)
+	}
+
+	name := buffer.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+
+	return name
+}
+
+//Schema returns the schema in the GraphQL schema definition language
+func (b *GraphQLBridge) Schema() string {
+	b.RLock()
+	defer b.RUnlock()
+	roots := []string{}
+	for root := range b.roots {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	var buffer bytes.Buffer
+	buffer.WriteString("scalar JSON\n\ntype Query {\n  watches: [String!]!\n}\n\ntype Subscription {\n")
+	for _, root := range roots {
+		fmt.Fprintf(&buffer, "  %s: %s!\n", root, b.roots[root].name)
+	}
+	buffer.WriteString("}\n")
+
+	for _, root := range roots {
+		t := b.roots[root]
+		fmt.Fprintf(&buffer, "\n# changes of watch %s\ntype %s {\n", t.watch, t.name)
+		for _, f := range t.ordering {
+			switch f {
+			case "op", "ns", "watch":
+				fmt.Fprintf(&buffer, "  %s: String!\n", f)
+			case "ts":
+				fmt.Fprintf(&buffer, "  %s: String\n", f)
+			default:
+				fmt.Fprintf(&buffer, "  %s: JSON\n", f)
+			}
+		}
+		buffer.WriteString("}\n")
+	}
+
+	return buffer.String()
+}
+
+//Send publishes the event to all matching subscriptions
+func (b *GraphQLBridge) Send(e ChangeEvent) error {
+	b.RLock()
+	defer b.RUnlock()
+
+	for s := range b.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			logWarn("GraphQL subscriber too slow, event dropped", Fields{"watch": e.Watch, "ns": e.Namespace})
+		}
+	}
+
+	return nil
+}
+
+//Close ends all subscriptions
+func (b *GraphQLBridge) Close() error {
+	b.Lock()
+	defer b.Unlock()
+
+	for s := range b.subscribers {
+		close(s.events)
+		delete(b.subscribers, s)
+	}
+
+	return nil
+}
+
+func (b *GraphQLBridge) subscribe(selections map[string][]string) *graphqlSubscriber {
+	s := &graphqlSubscriber{
+		selections: selections,
+		events:     make(chan ChangeEvent, graphqlSubscriberBuffer),
+	}
+
+	b.Lock()
+	b.subscribers[s] = true
+	b.Unlock()
+
+	return s
+}
+
+func (b *GraphQLBridge) unsubscribe(s *graphqlSubscriber) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+//ServeHTTP serves the schema on */schema, queries and
+//subscriptions on all other paths
+func (b *GraphQLBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/schema") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, b.Schema())
+		return
+	}
+
+	query := r.URL.Query().Get("query")
+	if r.Method == "POST" {
+		var request struct {
+			Query string `json:"query"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeGraphQLError(w, err)
+			return
+		}
+		query = request.Query
+	}
+
+	if tokens := graphqlTokens(query); len(tokens) > 0 && (tokens[0] == "query" || tokens[0] == "{") {
+		b.serveQuery(w, tokens)
+		return
+	}
+
+	selections, err := b.parseSubscription(query)
+	if err != nil {
+		writeGraphQLError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeGraphQLError(w, errors.New("Streaming is not supported"))
+		return
+	}
+
+	subscriber := b.subscribe(selections)
+	defer b.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-subscriber.events:
+			if !ok {
+				fmt.Fprint(w, "event: complete\ndata:\n\n")
+				flusher.Flush()
+				return
+			}
+
+			data := b.payload(subscriber.selections, e)
+			if data == nil {
+				continue
+			}
+
+			message, err := json.Marshal(map[string]interface{}{"data": data})
+			if err != nil {
+				logError("GraphQL event could not be encoded", errorFields(err))
+				continue
+			}
+
+			fmt.Fprintf(w, "event: next\ndata: %s\n\n", message)
+			flusher.Flush()
+		}
+	}
+}
+
+//serveQuery answers query operations, the only query field is watches
+func (b *GraphQLBridge) serveQuery(w http.ResponseWriter, tokens []string) {
+	if tokens[0] == "query" {
+		tokens = tokens[1:]
+		if len(tokens) > 0 && tokens[0] != "{" {
+			tokens = tokens[1:]
+		}
+	}
+
+	if len(tokens) < 2 || tokens[0] != "{" || tokens[len(tokens)-1] != "}" {
+		writeGraphQLError(w, errors.New("Expected selection set"))
+		return
+	}
+
+	data := map[string]interface{}{}
+	for _, field := range tokens[1 : len(tokens)-1] {
+		if field != "watches" {
+			writeGraphQLError(w, fmt.Errorf("Unknown query field %s", field))
+			return
+		}
+
+		watches := []string{}
+		b.RLock()
+		for _, t := range b.roots {
+			watches = append(watches, t.watch)
+		}
+		b.RUnlock()
+		sort.Strings(watches)
+		data[field] = watches
+	}
+
+	if len(data) == 0 {
+		writeGraphQLError(w, errors.New("Empty query"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+//payload builds the data object of the event for the selections,
+//returns nil if the subscription is not interested in it
+func (b *GraphQLBridge) payload(selections map[string][]string, e ChangeEvent) map[string]interface{} {
+	b.RLock()
+	defer b.RUnlock()
+	for root, fields := range selections {
+		t, ok := b.roots[root]
+		if !ok || t.watch != e.Watch {
+			continue
+		}
+
+		values := map[string]interface{}{}
+		for _, f := range fields {
+			switch f {
+			case "op":
+				values[f] = e.Operation
+			case "ns":
+				values[f] = e.Namespace
+			case "id":
+				values[f] = e.ID
+			case "ts":
+				values[f] = fmt.Sprintf("%d", e.Timestamp)
+			case "watch":
+				values[f] = e.Watch
+			default:
+				values[f] = e.Fields[t.fields[f]]
+			}
+		}
+
+		return map[string]interface{}{root: values}
+	}
+
+	return nil
+}
+
+func writeGraphQLError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"errors": []map[string]string{{"message": err.Error()}},
+	})
+}
+
+//parseSubscription understands the subset of GraphQL needed to select
+//fields of change types: subscription Name { root { field field } }
+func (b *GraphQLBridge) parseSubscription(query string) (map[string][]string, error) {
+	tokens := graphqlTokens(query)
+	if len(tokens) == 0 || tokens[0] != "subscription" {
+		return nil, errors.New("Only subscription operations are supported")
+	}
+
+	tokens = tokens[1:]
+	if len(tokens) > 0 && tokens[0] != "{" {
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 || tokens[0] != "{" {
+		return nil, errors.New("Expected selection set")
+	}
+	tokens = tokens[1:]
+
+	b.RLock()
+	defer b.RUnlock()
+	selections := map[string][]string{}
+	for len(tokens) > 0 && tokens[0] != "}" {
+		root := tokens[0]
+		t, ok := b.roots[root]
+		if !ok {
+			return nil, fmt.Errorf("Unknown subscription field %s", root)
+		}
+
+		if len(tokens) < 2 || tokens[1] != "{" {
+			return nil, fmt.Errorf("Field %s needs a selection set", root)
+		}
+		tokens = tokens[2:]
+
+		for len(tokens) > 0 && tokens[0] != "}" {
+			if _, ok := t.fields[tokens[0]]; !ok {
+				return nil, fmt.Errorf("Unknown field %s on type %s", tokens[0], t.name)
+			}
+
+			selections[root] = append(selections[root], tokens[0])
+			tokens = tokens[1:]
+		}
+
+		if len(tokens) == 0 {
+			return nil, errors.New("Unexpected end of query")
+		}
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("Unexpected end of query")
+	}
+
+	if len(selections) == 0 {
+		return nil, errors.New("Empty subscription")
+	}
+
+	return selections, nil
+}
+
+//graphqlTokens splits a query into names and braces,
+//comments, commas and arguments are skipped
+func graphqlTokens(query string) []string {
+	tokens := []string{}
+	current := ""
+	depth := 0
+	inComment := false
+
+	flush := func() {
+		if current != "" && depth == 0 {
+			tokens = append(tokens, current)
+		}
+		current = ""
+	}
+
+	for _, r := range query {
+		switch {
+		case inComment:
+			inComment = r != '\n'
+		case r == '#':
+			flush()
+			inComment = true
+		case r == '(':
+			flush()
+			depth++
+		case r == ')':
+			depth--
+		case depth > 0:
+		case r == '{' || r == '}':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r) || r == ',':
+			flush()
+		default:
+			current += string(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
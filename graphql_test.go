@@ -0,0 +1,108 @@
+package redkeep_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GraphQL bridge", func() {
+	var (
+		bridge *GraphQLBridge
+		server *httptest.Server
+	)
+
+	watches := []Watch{
+		{
+			TrackCollection:       "live.user",
+			TrackFields:           []string{"username", "name.first"},
+			TargetCollection:      "live.comment",
+			TargetNormalizedField: "meta",
+			TriggerReference:      "user",
+		},
+		{
+			Name:                  "order items",
+			TrackCollection:       "live.item",
+			TrackFields:           []string{"price"},
+			TargetCollection:      "live.order",
+			TargetNormalizedField: "information",
+			TriggerReference:      "item",
+		},
+	}
+
+	BeforeEach(func() {
+		bridge = NewGraphQLBridge(watches)
+		server = httptest.NewServer(bridge)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("generates one subscription type per watch", func() {
+		schema := bridge.Schema()
+		Expect(schema).To(ContainSubstring("userCommentMeta: UserCommentMetaChange!"))
+		Expect(schema).To(ContainSubstring("orderItems: OrderItemsChange!"))
+		Expect(schema).To(ContainSubstring("name_first: JSON"))
+		Expect(schema).To(ContainSubstring("price: JSON"))
+	})
+
+	It("rejects unknown fields", func() {
+		response, err := http.Get(server.URL + "/graphql?query=" + url.QueryEscape("subscription { orderItems { username } }"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("streams matching changes", func() {
+		query := url.QueryEscape(`subscription Prices { orderItems(first: 1) { id price } }`)
+		response, err := http.Get(server.URL + "/graphql?query=" + query)
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		bridge.Send(ChangeEvent{Watch: watches[0].Key(), ID: "ignored"})
+		bridge.Send(ChangeEvent{
+			Watch:  "order items",
+			ID:     "item-1",
+			Fields: map[string]interface{}{"price": 2.3},
+		})
+
+		reader := bufio.NewReader(response.Body)
+		event, err := reader.ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event).To(Equal("event: next\n"))
+
+		data, err := reader.ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.TrimSpace(data)).To(Equal(`data: {"data":{"orderItems":{"id":"item-1","price":2.3}}}`))
+	})
+
+	It("answers the watches query", func() {
+		response, err := http.Get(server.URL + "/graphql?query=" + url.QueryEscape("query { watches }"))
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		var result struct {
+			Data struct {
+				Watches []string `json:"watches"`
+			} `json:"data"`
+		}
+		Expect(json.NewDecoder(response.Body).Decode(&result)).To(Succeed())
+		Expect(result.Data.Watches).To(Equal([]string{watches[0].Key(), "order items"}))
+	})
+
+	It("rejects unknown query fields", func() {
+		response, err := http.Get(server.URL + "/graphql?query=" + url.QueryEscape("{ orderItems }"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
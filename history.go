@@ -0,0 +1,169 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//historyField is the array of past values in the normalized field of targets
+	historyField = "_history"
+	//defaultHistorySize is the number of values kept on targets without Size
+	defaultHistorySize = 10
+)
+
+//HistorySettings keep the values of the tracked fields of every change of a
+//watch. Without Collection the last Size (default 10) changes are kept in
+//the array _history of the normalized field of the targets. With Collection
+//(database.collection) every change of a tracked document is stored there
+//once instead, Retention prunes it.
+type HistorySettings struct {
+	Size       int               `json:"size" validate:"min=0"`
+	Collection string            `json:"collection"`
+	Retention  RetentionSettings `json:"retention"`
+}
+
+//HistoryEntry is a change of the tracked fields of a watch. In targets it
+//has the changed Fields, the time it was written and the id of the Write
+//that added it, entries of a history collection also have the watch and
+//the id of the tracked document.
+type HistoryEntry struct {
+	Watch   string                 `bson:"watch,omitempty" json:"watch,omitempty"`
+	ID      interface{}            `bson:"id,omitempty" json:"id,omitempty"`
+	Fields  map[string]interface{} `bson:"fields" json:"fields"`
+	Changed time.Time              `bson:"changed" json:"changed"`
+	Write   bson.ObjectId          `bson:"write,omitempty" json:"write,omitempty"`
+}
+
+func checkHistorySettings(w Watch) error {
+	if w.History == nil {
+		return nil
+	}
+
+	if c := w.History.Collection; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("History collection %s must be database.collection", c)
+	}
+
+	if w.History.Collection == "" && w.BehaviourSettings.ReferenceArray {
+		return errors.New("Reference arrays keep their history in a collection")
+	}
+
+	return nil
+}
+
+//historyFields returns the tracked values of a $set or $unset update of w,
+//removed fields are nil
+func historyFields(w Watch, update bson.M) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for operator, values := range update {
+		changes, ok := values.(bson.M)
+		if !ok || (operator != "$set" && operator != "$unset") {
+			continue
+		}
+
+		for field, value := range changes {
+			if operator == "$unset" {
+				value = nil
+			}
+			field = strings.TrimPrefix(field, w.TargetNormalizedField+".")
+			if field != generationField {
+				fields[field] = value
+			}
+		}
+	}
+
+	return fields
+}
+
+//withHistory appends the values of update to the history of the targets,
+//nothing is changed for watches without history or with a history collection
+func withHistory(w Watch, update bson.M, changed time.Time) {
+	if w.History == nil || w.History.Collection != "" {
+		return
+	}
+
+	size := w.History.Size
+	if size == 0 {
+		size = defaultHistorySize
+	}
+
+	entry := HistoryEntry{Fields: historyFields(w, update), Changed: changed, Write: bson.NewObjectId()}
+	update["$push"] = bson.M{
+		w.TargetNormalizedField + "." + historyField: bson.M{"$each": []HistoryEntry{entry}, "$slice": -size},
+	}
+}
+
+//historySelector returns selector for the write of update. If update adds
+//a history entry, targets that already have it are skipped, so a write that
+//is sent again does not add the entry twice. guarded is true in that case.
+func historySelector(w Watch, selector bson.M, update bson.M) (bson.M, bool) {
+	field := w.TargetNormalizedField + "." + historyField
+	push, _ := update["$push"].(bson.M)
+	history, _ := push[field].(bson.M)
+	entries, _ := history["$each"].([]HistoryEntry)
+	if len(entries) == 0 {
+		return selector, false
+	}
+
+	guarded := bson.M{field + ".write": bson.M{"$ne": entries[0].Write}}
+	for key, value := range selector {
+		guarded[key] = value
+	}
+
+	return guarded, true
+}
+
+//historyUpdate returns the selector and the write of update to the single
+//target of selector. A guarded write that is sent again and finds no target
+//was already applied by an earlier attempt.
+func historyUpdate(collection *mgo.Collection, w Watch, selector bson.M, update bson.M) (bson.M, func() error) {
+	writeSelector, guarded := historySelector(w, selector, update)
+	attempts := 0
+	return writeSelector, func() error {
+		attempts++
+		err := collection.Update(writeSelector, update)
+		if err == mgo.ErrNotFound && guarded && attempts > 1 {
+			return nil
+		}
+
+		return err
+	}
+}
+
+//recordHistory stores the values of update of the tracked document with id
+//in the history collection of w, for watches with a history collection
+func recordHistory(session *mgo.Session, w Watch, id interface{}, update bson.M, changed time.Time) error {
+	if w.History == nil || w.History.Collection == "" {
+		return nil
+	}
+
+	p := strings.Index(w.History.Collection, ".")
+	collection := session.DB(w.History.Collection[:p]).C(w.History.Collection[p+1:])
+	entry := HistoryEntry{Watch: w.Key(), ID: id, Fields: historyFields(w, update), Changed: changed}
+	if err := collection.Insert(entry); err != nil {
+		return err
+	}
+
+	return pruneCollection(collection, "changed", w.History.Retention, changed)
+}
+
+//history stores the update of the tracked document with id in the history
+//and temporal collections of w, nothing is stored while target writes are not sent
+func (c changeTracker) history(session *mgo.Session, w Watch, id interface{}, update bson.M, changed time.Time) {
+	if c.dryRun != nil || c.mockTargets {
+		return
+	}
+
+	if err := recordHistory(session, w, id, update, changed); err != nil {
+		logError("History could not be stored", watchFields(w).withError(err))
+	}
+
+	if err := recordTemporal(session, w, id, update, changed); err != nil {
+		logError("Temporal values could not be stored", watchFields(w).withError(err))
+	}
+}
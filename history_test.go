@@ -0,0 +1,94 @@
+package redkeep_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("History", func() {
+	changed := time.Date(2024, 5, 2, 10, 15, 4, 0, time.UTC)
+	w := Watch{
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"name", "email"},
+		TargetCollection:      "app.comments",
+		TargetNormalizedField: "meta",
+		TriggerReference:      "user",
+		History:               &HistorySettings{},
+	}
+
+	//entries returns the pushed history entries of update without their write id
+	entries := func(update bson.M) bson.M {
+		history := update["$push"].(bson.M)["meta._history"].(bson.M)
+		each := append([]HistoryEntry{}, history["$each"].([]HistoryEntry)...)
+		for i := range each {
+			Expect(each[i].Write.Valid()).To(BeTrue())
+			each[i].Write = ""
+		}
+
+		return bson.M{"meta._history": bson.M{"$each": each, "$slice": history["$slice"]}}
+	}
+
+	It("keeps the last values on the targets", func() {
+		update := bson.M{"$set": bson.M{"meta.name": "Hans"}}
+		WithHistory(w, update, changed)
+		WithGeneration(w, bson.M{}, update, 42)
+
+		Expect(entries(update)).To(Equal(bson.M{"meta._history": bson.M{
+			"$each":  []HistoryEntry{{Fields: map[string]interface{}{"name": "Hans"}, Changed: changed}},
+			"$slice": -10,
+		}}))
+		Expect(update["$set"]).To(Equal(bson.M{"meta.name": "Hans", "meta._generation": bson.MongoTimestamp(42)}))
+	})
+
+	It("keeps removed values as nil", func() {
+		sized := w
+		sized.History = &HistorySettings{Size: 3}
+		update := bson.M{"$unset": bson.M{"meta.email": ""}}
+		WithHistory(sized, update, changed)
+
+		Expect(entries(update)).To(Equal(bson.M{"meta._history": bson.M{
+			"$each":  []HistoryEntry{{Fields: map[string]interface{}{"email": nil}, Changed: changed}},
+			"$slice": -3,
+		}}))
+	})
+
+	It("does not add an entry twice when a write is sent again", func() {
+		update := bson.M{"$set": bson.M{"meta.name": "Hans"}}
+		WithHistory(w, update, changed)
+		write := update["$push"].(bson.M)["meta._history"].(bson.M)["$each"].([]HistoryEntry)[0].Write
+
+		selector, guarded := HistorySelector(w, bson.M{"user.$id": 1}, update)
+		Expect(guarded).To(BeTrue())
+		Expect(selector).To(Equal(bson.M{"user.$id": 1, "meta._history.write": bson.M{"$ne": write}}))
+
+		selector, guarded = HistorySelector(w, bson.M{"user.$id": 1}, bson.M{"$set": bson.M{"meta.name": "Hans"}})
+		Expect(guarded).To(BeFalse())
+		Expect(selector).To(Equal(bson.M{"user.$id": 1}))
+	})
+
+	It("leaves the targets of watches without history or with a history collection unchanged", func() {
+		for _, settings := range []*HistorySettings{nil, {Collection: "app.history"}} {
+			other := w
+			other.History = settings
+			update := bson.M{"$set": bson.M{"meta.name": "Hans"}}
+			WithHistory(other, update, changed)
+			Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.name": "Hans"}}))
+		}
+	})
+
+	It("checks the history of watches", func() {
+		config := strings.Replace(templateForTestsConfig, `"trackCollection"`, `"history": { "collection": "history" }, "trackCollection"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("History collection history must be database.collection"))
+
+		config = strings.Replace(templateForTestsConfig, `"trackCollection"`, `"history": {}, "behaviourSettings": { "referenceArray": true }, "trackCollection"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Reference arrays keep their history in a collection"))
+	})
+})
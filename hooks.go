@@ -0,0 +1,74 @@
+package redkeep
+
+import (
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//BeforeWriteHook is called before a fan-out write of a watch.
+//trigger is the document that caused the write: the inserted target
+//for inserts, the tracked document as it is after the update for
+//updates. update is the computed update query. Returning an error
+//skips the write.
+type BeforeWriteHook func(w Watch, trigger map[string]interface{}, update bson.M) error
+
+//AfterWriteHook is called after a fan-out write of a watch,
+//err is the result of the write
+type AfterWriteHook func(w Watch, trigger map[string]interface{}, update bson.M, err error)
+
+//WatchHooks are callbacks that are tied to one specific watch
+type WatchHooks struct {
+	BeforeWrite BeforeWriteHook
+	AfterWrite  AfterWriteHook
+}
+
+//hookRegistry holds all hooks by watch key
+type hookRegistry struct {
+	sync.RWMutex
+	hooks map[string][]WatchHooks
+}
+
+func newHookRegistry() *hookRegistry {
+	return &hookRegistry{hooks: map[string][]WatchHooks{}}
+}
+
+func (r *hookRegistry) register(watch string, h WatchHooks) {
+	r.Lock()
+	defer r.Unlock()
+	r.hooks[watch] = append(r.hooks[watch], h)
+}
+
+func (r *hookRegistry) get(w Watch) []WatchHooks {
+	if r == nil {
+		return nil
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	return r.hooks[w.Key()]
+}
+
+//beforeWrite runs all BeforeWrite hooks of the watch, the first
+//error stops the chain
+func (r *hookRegistry) beforeWrite(w Watch, trigger map[string]interface{}, update bson.M) error {
+	for _, h := range r.get(w) {
+		if h.BeforeWrite == nil {
+			continue
+		}
+
+		if err := h.BeforeWrite(w, trigger, update); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *hookRegistry) afterWrite(w Watch, trigger map[string]interface{}, update bson.M, err error) {
+	for _, h := range r.get(w) {
+		if h.AfterWrite != nil {
+			h.AfterWrite(w, trigger, update, err)
+		}
+	}
+}
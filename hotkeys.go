@@ -0,0 +1,398 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//defaultHotKeyMinFanOut is the smallest recorded fan-out without MinFanOut
+	defaultHotKeyMinFanOut = 100
+	//defaultHotKeyDebounce is how long updates of a hot key are coalesced without Debounce
+	defaultHotKeyDebounce = 10 * time.Second
+	//hotKeyQueueSize is the number of updates waiting for the background fan-out
+	hotKeyQueueSize = 1024
+)
+
+//strategies for the updates of hot keys
+const (
+	//HotKeyDebounce coalesces the updates of a hot key, once Debounce
+	//passed the current tracked fields are written to the targets
+	HotKeyDebounce = "debounce"
+	//HotKeyBackground writes the updates of a hot key one after another
+	//on a background queue, away from the workers of the oplog
+	HotKeyBackground = "background"
+	//HotKeySkip does not write the updates of a hot key, an alert is
+	//recorded for the first one
+	HotKeySkip = "skip"
+)
+
+//HotKeySettings keep statistics of up to Size tracked documents whose
+//updates changed the most targets, zero disables them. Only updates that
+//changed at least MinFanOut (default 100) targets are recorded. Once Size
+//documents are kept, the one with the fewest changed targets is replaced.
+//Strategies handle the updates of hot keys differently.
+type HotKeySettings struct {
+	Size       int              `json:"size" validate:"min=0"`
+	MinFanOut  int              `json:"minFanOut" validate:"min=0"`
+	Strategies []HotKeyStrategy `json:"strategies"`
+}
+
+//HotKeyStrategy applies Strategy to the updates of the tracked document
+//with ID of Watch or, with FanOut, of all its documents that were recorded
+//with a fan-out of at least FanOut targets. Debounce is the time updates
+//are coalesced with the debounce strategy, default 10s.
+type HotKeyStrategy struct {
+	Watch    string   `json:"watch"`
+	ID       string   `json:"id"`
+	FanOut   int      `json:"fanOut"`
+	Strategy string   `json:"strategy"`
+	Debounce Duration `json:"debounce"`
+}
+
+func checkHotKeySettings(settings HotKeySettings) error {
+	for _, strategy := range settings.Strategies {
+		switch strategy.Strategy {
+		case HotKeyDebounce, HotKeyBackground, HotKeySkip:
+		default:
+			return fmt.Errorf("Unknown hot key strategy %q, use debounce, background or skip", strategy.Strategy)
+		}
+
+		if strategy.Watch == "" || (strategy.ID == "") == (strategy.FanOut == 0) {
+			return errors.New("Hot key strategies need a watch and either an id or a fanOut")
+		}
+
+		if strategy.FanOut > 0 && settings.Size == 0 {
+			return errors.New("Hot key strategies with fanOut need a size to record hot keys")
+		}
+	}
+
+	return nil
+}
+
+//HotKey is a tracked document whose updates fan out to many targets
+type HotKey struct {
+	Watch     string    `json:"watch"`
+	Namespace string    `json:"ns"`
+	ID        string    `json:"id"`
+	Updates   int       `json:"updates"`
+	Targets   int       `json:"targets"`
+	MaxFanOut int       `json:"maxFanOut"`
+	Last      time.Time `json:"last"`
+}
+
+//debouncedKey is a hot key whose updates are coalesced,
+//held has the oplog entries of the updates
+type debouncedKey struct {
+	timer   *time.Timer
+	refresh func()
+	held    []bson.MongoTimestamp
+}
+
+//hotKeys records the fan-outs of updates and applies the strategies
+//to the updates of hot keys, all methods can be called on nil
+type hotKeys struct {
+	sync.Mutex
+	settings HotKeySettings
+	metrics  *metricRegistry
+	events   *eventLog
+	keys     map[string]*HotKey
+	alerted  map[string]bool
+	//debounced are the keys with a pending refresh, pending counts them
+	debounced map[string]*debouncedKey
+	pending   sync.WaitGroup
+	stopped   bool
+	//watermarks are held before the entries of updates that
+	//are not written yet
+	watermarks *watermarks
+	//queue guards background, it is closed while queue is locked
+	queue      sync.RWMutex
+	background chan func()
+	done       chan bool
+}
+
+func newHotKeys(settings HotKeySettings, metrics *metricRegistry, events *eventLog) *hotKeys {
+	if settings.Size == 0 && len(settings.Strategies) == 0 {
+		return nil
+	}
+
+	if settings.MinFanOut == 0 {
+		settings.MinFanOut = defaultHotKeyMinFanOut
+	}
+
+	return &hotKeys{
+		settings:  settings,
+		metrics:   metrics,
+		events:    events,
+		keys:      map[string]*HotKey{},
+		alerted:   map[string]bool{},
+		debounced: map[string]*debouncedKey{},
+	}
+}
+
+//record counts an update of the tracked document with id that changed targets documents
+func (h *hotKeys) record(w Watch, id interface{}, targets int) {
+	if h == nil || h.settings.Size == 0 || targets < h.settings.MinFanOut {
+		return
+	}
+
+	hotID := idString(id)
+	key := w.Key() + "/" + hotID
+	h.Lock()
+	defer h.Unlock()
+	hot, ok := h.keys[key]
+	if !ok {
+		if len(h.keys) >= h.settings.Size && !h.evict(targets) {
+			return
+		}
+
+		hot = &HotKey{Watch: w.Key(), Namespace: w.TrackCollection, ID: hotID}
+		h.keys[key] = hot
+	}
+
+	hot.Updates++
+	hot.Targets += targets
+	if targets > hot.MaxFanOut {
+		hot.MaxFanOut = targets
+	}
+	hot.Last = time.Now()
+}
+
+//evict removes the key with the fewest targets if it has fewer than targets, h is locked
+func (h *hotKeys) evict(targets int) bool {
+	coldest := ""
+	for key, hot := range h.keys {
+		if coldest == "" || hot.Targets < h.keys[coldest].Targets {
+			coldest = key
+		}
+	}
+
+	if coldest == "" || h.keys[coldest].Targets >= targets {
+		return false
+	}
+
+	delete(h.keys, coldest)
+	return true
+}
+
+//report returns up to limit hot keys with the most changed targets first,
+//limit zero returns all of them
+func (h *hotKeys) report(limit int) []HotKey {
+	report := []HotKey{}
+	if h == nil {
+		return report
+	}
+
+	h.Lock()
+	for _, hot := range h.keys {
+		report = append(report, *hot)
+	}
+	h.Unlock()
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Targets != report[j].Targets {
+			return report[i].Targets > report[j].Targets
+		}
+
+		return report[i].Watch+report[i].ID < report[j].Watch+report[j].ID
+	})
+
+	if limit > 0 && len(report) > limit {
+		report = report[:limit]
+	}
+
+	return report
+}
+
+//strategy returns the strategy for the updates of the tracked document
+//with key of w, h is locked
+func (h *hotKeys) strategy(w Watch, id, key string) (HotKeyStrategy, bool) {
+	for _, strategy := range h.settings.Strategies {
+		if strategy.Watch != w.Key() {
+			continue
+		}
+
+		if strategy.ID == id {
+			return strategy, true
+		}
+
+		if hot, ok := h.keys[key]; ok && strategy.FanOut > 0 && hot.MaxFanOut >= strategy.FanOut {
+			return strategy, true
+		}
+	}
+
+	return HotKeyStrategy{}, false
+}
+
+//divert applies the strategy of the tracked document with id of w to an
+//update of the oplog entry with ts, write writes the update and refresh
+//writes the current tracked fields. It returns false if the update has to
+//be written right away. Until a deferred update is written the watermarks
+//stay before ts, so checkpoints do not pass it.
+func (h *hotKeys) divert(w Watch, id interface{}, ts bson.MongoTimestamp, write, refresh func()) bool {
+	if h == nil || len(h.settings.Strategies) == 0 {
+		return false
+	}
+
+	hotID := idString(id)
+	key := w.Key() + "/" + hotID
+	h.Lock()
+	strategy, ok := h.strategy(w, hotID, key)
+	if !ok || h.stopped {
+		h.Unlock()
+		return false
+	}
+
+	switch strategy.Strategy {
+	case HotKeySkip:
+		h.metrics.add(MetricHotKeySkipped, 1)
+		if !h.alerted[key] {
+			h.alerted[key] = true
+			h.events.record(EventAlert, w.Key(), fmt.Sprintf("Updates of hot key %s are skipped", hotID))
+		}
+		h.Unlock()
+		return true
+	case HotKeyDebounce:
+		h.debounce(key, strategy.Debounce.Duration, ts, refresh)
+		h.Unlock()
+		return true
+	}
+
+	h.Unlock()
+	return h.enqueue(ts, write)
+}
+
+//hold keeps the watermarks before ts, zero is an update without entry
+func (h *hotKeys) hold(ts bson.MongoTimestamp) {
+	if ts > 0 {
+		h.watermarks.hold(ts)
+	}
+}
+
+func (h *hotKeys) release(held ...bson.MongoTimestamp) {
+	for _, ts := range held {
+		if ts > 0 {
+			h.watermarks.release(ts)
+		}
+	}
+}
+
+//debounce refreshes key once delay passed, h is locked
+func (h *hotKeys) debounce(key string, delay time.Duration, ts bson.MongoTimestamp, refresh func()) {
+	h.metrics.add(MetricHotKeyDebounced, 1)
+	h.hold(ts)
+	if debounced, ok := h.debounced[key]; ok {
+		debounced.held = append(debounced.held, ts)
+		return
+	}
+
+	if delay <= 0 {
+		delay = defaultHotKeyDebounce
+	}
+
+	h.pending.Add(1)
+	debounced := &debouncedKey{refresh: refresh, held: []bson.MongoTimestamp{ts}}
+	debounced.timer = time.AfterFunc(delay, func() {
+		defer h.pending.Done()
+		h.Lock()
+		delete(h.debounced, key)
+		h.Unlock()
+		refresh()
+		h.release(debounced.held...)
+	})
+	h.debounced[key] = debounced
+}
+
+//enqueue writes on the background queue, it returns false
+//if the queue is not running
+func (h *hotKeys) enqueue(ts bson.MongoTimestamp, write func()) bool {
+	h.queue.RLock()
+	defer h.queue.RUnlock()
+	if h.background == nil {
+		return false
+	}
+
+	h.hold(ts)
+	h.background <- func() {
+		write()
+		h.release(ts)
+	}
+	h.metrics.set(MetricHotKeyQueue, float64(len(h.background)))
+	return true
+}
+
+//start runs the background queue, deferred updates hold watermarks
+func (h *hotKeys) start(watermarks *watermarks) error {
+	h.queue.Lock()
+	defer h.queue.Unlock()
+	h.watermarks = watermarks
+	h.background = make(chan func(), hotKeyQueueSize)
+	h.done = make(chan bool)
+	go func(background chan func()) {
+		defer close(h.done)
+		for write := range background {
+			write()
+			h.metrics.set(MetricHotKeyQueue, float64(len(background)))
+		}
+	}(h.background)
+
+	return nil
+}
+
+//stop refreshes the debounced keys right away and waits until
+//the background queue is empty, it is stopped before the watermarks
+func (h *hotKeys) stop() {
+	h.Lock()
+	h.stopped = true
+	debounced := h.debounced
+	h.debounced = map[string]*debouncedKey{}
+	h.Unlock()
+
+	for _, key := range debounced {
+		if key.timer.Stop() {
+			key.refresh()
+			h.release(key.held...)
+			h.pending.Done()
+		}
+	}
+	h.pending.Wait()
+
+	h.queue.Lock()
+	close(h.background)
+	h.background = nil
+	h.queue.Unlock()
+	<-h.done
+}
+
+//HotKeys returns up to limit tracked documents whose updates changed
+//the most targets, see HotKeySettings. limit zero returns all of them.
+func (t *TailAgent) HotKeys(limit int) []HotKey {
+	return t.hotKeys.report(limit)
+}
+
+//serveHotKeys lists the hot keys, at most limit (default 20)
+func (t *TailAgent) serveHotKeys(w http.ResponseWriter, r *http.Request) {
+	if t.hotKeys == nil || t.config.HotKeys.Size == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Hot keys are not recorded, configure hotKeys"})
+		return
+	}
+
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid limit " + value})
+			return
+		}
+		limit = parsed
+	}
+
+	writeJSON(w, http.StatusOK, t.HotKeys(limit))
+}
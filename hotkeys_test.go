@@ -0,0 +1,162 @@
+package redkeep_test
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hot keys", func() {
+	comments := Watch{Name: "userComments", TrackCollection: "app.user"}
+	reviews := Watch{Name: "userReviews", TrackCollection: "app.user"}
+
+	ids := func(report []HotKey) []string {
+		result := []string{}
+		for _, hot := range report {
+			result = append(result, hot.Watch+"/"+hot.ID)
+		}
+
+		return result
+	}
+
+	It("will report the documents with the most changed targets first", func() {
+		record, report := HotKeyRecorder(HotKeySettings{Size: 10, MinFanOut: 5})
+		record(comments, "system", 1000)
+		record(comments, "system", 500)
+		record(comments, "nino", 3)
+		record(reviews, "nino", 2000)
+
+		Expect(ids(report(0))).To(Equal([]string{"userReviews/nino", "userComments/system"}))
+		system := report(0)[1]
+		Expect(system.Namespace).To(Equal("app.user"))
+		Expect(system.Updates).To(Equal(2))
+		Expect(system.Targets).To(Equal(1500))
+		Expect(system.MaxFanOut).To(Equal(1000))
+		Expect(ids(report(1))).To(Equal([]string{"userReviews/nino"}))
+	})
+
+	It("will replace the coldest document once it is full", func() {
+		record, report := HotKeyRecorder(HotKeySettings{Size: 2})
+		record(comments, "system", 1000)
+		record(comments, "rick", 200)
+		record(comments, "nino", 150)
+		Expect(ids(report(0))).To(Equal([]string{"userComments/system", "userComments/rick"}))
+
+		record(comments, "nino", 300)
+		Expect(ids(report(0))).To(Equal([]string{"userComments/system", "userComments/nino"}))
+	})
+
+	It("will not record without size", func() {
+		record, report := HotKeyRecorder(HotKeySettings{})
+		record(comments, "system", 1000)
+		Expect(report(0)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Hot key strategies", func() {
+	comments := Watch{Name: "userComments", TrackCollection: "app.user"}
+	reviews := Watch{Name: "userReviews", TrackCollection: "app.user"}
+	nothing := func() {}
+
+	It("will skip the updates of a hot key and alert once", func() {
+		divert, _, _, _, events := HotKeyStrategies(HotKeySettings{Strategies: []HotKeyStrategy{
+			{Watch: "userComments", ID: "system", Strategy: HotKeySkip},
+		}})
+
+		Expect(divert(comments, "system", nothing, nothing)).To(BeTrue())
+		Expect(divert(comments, "system", nothing, nothing)).To(BeTrue())
+		Expect(divert(comments, "nino", nothing, nothing)).To(BeFalse())
+		Expect(divert(reviews, "system", nothing, nothing)).To(BeFalse())
+		Expect(events()).To(HaveLen(1))
+		Expect(events()[0].Message).To(Equal("Updates of hot key system are skipped"))
+	})
+
+	It("will apply strategies to keys recorded with a large fan-out", func() {
+		divert, record, _, _, _ := HotKeyStrategies(HotKeySettings{Size: 10, Strategies: []HotKeyStrategy{
+			{Watch: "userComments", FanOut: 1000, Strategy: HotKeySkip},
+		}})
+
+		record(comments, "system", 500)
+		Expect(divert(comments, "system", nothing, nothing)).To(BeFalse())
+		record(comments, "system", 1500)
+		Expect(divert(comments, "system", nothing, nothing)).To(BeTrue())
+	})
+
+	It("will coalesce the updates of a hot key into one refresh", func() {
+		divert, _, start, stop, _ := HotKeyStrategies(HotKeySettings{Strategies: []HotKeyStrategy{
+			{Watch: "userComments", ID: "system", Strategy: HotKeyDebounce, Debounce: Duration{time.Hour}},
+		}})
+		Expect(start()).To(Succeed())
+
+		refreshes := 0
+		refresh := func() { refreshes++ }
+		for i := 0; i < 3; i++ {
+			Expect(divert(comments, "system", nothing, refresh)).To(BeTrue())
+		}
+		Expect(refreshes).To(BeZero())
+
+		stop()
+		Expect(refreshes).To(Equal(1))
+		Expect(divert(comments, "system", nothing, refresh)).To(BeFalse())
+	})
+
+	It("will write the updates of a hot key in the background", func() {
+		divert, _, start, stop, _ := HotKeyStrategies(HotKeySettings{Strategies: []HotKeyStrategy{
+			{Watch: "userComments", ID: "system", Strategy: HotKeyBackground},
+		}})
+		Expect(divert(comments, "system", nothing, nothing)).To(BeFalse())
+		Expect(start()).To(Succeed())
+
+		written := []int{}
+		for i := 0; i < 3; i++ {
+			i := i
+			Expect(divert(comments, "system", func() { written = append(written, i) }, nothing)).To(BeTrue())
+		}
+
+		stop()
+		Expect(written).To(Equal([]int{0, 1, 2}))
+	})
+
+	It("will hold the watermarks until deferred updates were written", func() {
+		recorder := &watermarkRecorder{}
+		tracker := NewWatermarkTracker(recorder)
+		divert, start, stop := HotKeyWatermarks(HotKeySettings{Strategies: []HotKeyStrategy{
+			{Watch: "userComments", ID: "system", Strategy: HotKeyDebounce, Debounce: Duration{time.Hour}},
+			{Watch: "userReviews", ID: "system", Strategy: HotKeyBackground},
+		}}, tracker)
+		Expect(start()).To(Succeed())
+
+		written := make(chan bool)
+		tracker.Begin(10)
+		Expect(divert(comments, "system", 10, nothing, nothing)).To(BeTrue())
+		tracker.End(10)
+		tracker.Begin(11)
+		Expect(divert(reviews, "system", 11, func() { <-written }, nothing)).To(BeTrue())
+		tracker.End(11)
+		tracker.Begin(12)
+		tracker.End(12)
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{9}))
+
+		close(written)
+		stop()
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{9, 12}))
+	})
+
+	It("checks the strategies", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"hotKeys": { "strategies": [{ "watch": "userComments", "id": "system", "strategy": "drop" }] }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError(`Unknown hot key strategy "drop", use debounce, background or skip`))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"hotKeys": { "strategies": [{ "watch": "userComments", "fanOut": 1000, "strategy": "skip" }] }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Hot key strategies with fanOut need a size to record hot keys"))
+	})
+})
@@ -0,0 +1,107 @@
+package redkeep
+
+import (
+	"sync"
+	"time"
+)
+
+//maxIndexBuildPause is the longest time a write waits for an index build
+const maxIndexBuildPause = 10 * time.Minute
+
+//indexBuilds tracks the running index builds by namespace,
+//all methods can be called on nil
+type indexBuilds struct {
+	sync.Mutex
+	running map[string]int
+	done    map[string]chan struct{}
+}
+
+func newIndexBuilds() *indexBuilds {
+	return &indexBuilds{running: map[string]int{}, done: map[string]chan struct{}{}}
+}
+
+func (b *indexBuilds) start(ns string) {
+	b.Lock()
+	defer b.Unlock()
+	if b.running[ns] == 0 {
+		b.done[ns] = make(chan struct{})
+	}
+	b.running[ns]++
+}
+
+func (b *indexBuilds) finish(ns string) {
+	b.Lock()
+	defer b.Unlock()
+	if b.running[ns] == 0 {
+		return
+	}
+
+	b.running[ns]--
+	if b.running[ns] == 0 {
+		close(b.done[ns])
+		delete(b.done, ns)
+		delete(b.running, ns)
+	}
+}
+
+//wait blocks writes of w to its target collection while an index is
+//built on it, if the watch pauses during index builds
+func (b *indexBuilds) wait(w Watch) {
+	if b == nil || !w.BehaviourSettings.PauseDuringIndexBuilds {
+		return
+	}
+
+	b.Lock()
+	done, ok := b.done[w.TargetCollection]
+	b.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(maxIndexBuildPause):
+		logWarn("Index build takes too long, writes continue", watchFields(w))
+	}
+}
+
+//collectionCommands are the commands on watched collections that
+//are recorded, with the change of running index builds
+var collectionCommands = map[string]int{
+	"collMod":          0,
+	"convertToCapped":  0,
+	"createIndexes":    0,
+	"dropIndexes":      0,
+	"startIndexBuild":  1,
+	"commitIndexBuild": -1,
+	"abortIndexBuild":  -1,
+}
+
+//handleCollectionCommand records collMod and index commands on watched
+//collections and tracks index builds, it returns false for other commands
+func (t TailAgent) handleCollectionCommand(db string, command map[string]interface{}) bool {
+	for name, builds := range collectionCommands {
+		collection, ok := command[name].(string)
+		if !ok {
+			continue
+		}
+
+		ns := db + "." + collection
+		switch builds {
+		case 1:
+			t.indexBuilds.start(ns)
+		case -1:
+			t.indexBuilds.finish(ns)
+		}
+
+		for _, w := range t.watches.list() {
+			if w.TrackCollection == ns || w.TargetCollection == ns {
+				t.events.record(EventSchema, w.Key(), name+" on "+ns)
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
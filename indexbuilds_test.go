@@ -0,0 +1,65 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Index builds", func() {
+	w := Watch{
+		TrackCollection:       "live.user",
+		TargetCollection:      "live.comment",
+		TargetNormalizedField: "user",
+		BehaviourSettings:     BehaviourSettings{PauseDuringIndexBuilds: true},
+	}
+
+	command := func(o map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"op": "c", "ns": "live.$cmd", "o": o}
+	}
+
+	It("records schema events for watched collections", func() {
+		events, _ := HandleCommands([]Watch{w}, []map[string]interface{}{
+			command(map[string]interface{}{"collMod": "user", "validator": map[string]interface{}{}}),
+			command(map[string]interface{}{"collMod": "session"}),
+		}, w)
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Kind).To(Equal(EventSchema))
+		Expect(events[0].Message).To(Equal("collMod on live.user"))
+	})
+
+	It("pauses writes to the target collection while an index is built", func() {
+		start := command(map[string]interface{}{"startIndexBuild": "comment"})
+		commit := command(map[string]interface{}{"commitIndexBuild": "comment"})
+
+		_, paused := HandleCommands([]Watch{w}, []map[string]interface{}{start}, w)
+		Expect(paused).To(BeTrue())
+
+		_, paused = HandleCommands([]Watch{w}, []map[string]interface{}{start, commit}, w)
+		Expect(paused).To(BeFalse())
+	})
+
+	It("does not pause watches without the setting", func() {
+		other := w
+		other.BehaviourSettings = BehaviourSettings{}
+		_, paused := HandleCommands([]Watch{other}, []map[string]interface{}{
+			command(map[string]interface{}{"startIndexBuild": "comment"}),
+		}, other)
+		Expect(paused).To(BeFalse())
+	})
+
+	It("records collections converted to capped ones", func() {
+		events, _ := HandleCommands([]Watch{w}, []map[string]interface{}{
+			command(map[string]interface{}{"convertToCapped": "comment", "size": 1024}),
+		}, w)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Message).To(Equal("convertToCapped on live.comment"))
+	})
+
+	It("detects entries of chunk migrations", func() {
+		Expect(FromMigration(map[string]interface{}{"op": "i", "fromMigrate": true})).To(BeTrue())
+		Expect(FromMigration(map[string]interface{}{"op": "i"})).To(BeFalse())
+	})
+})
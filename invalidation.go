@@ -0,0 +1,175 @@
+package redkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const defaultInvalidationChannel = "redkeep.invalidation"
+
+//InvalidationSettings configures the invalidation sink,
+//at least one of Redis or HTTP must be set
+type InvalidationSettings struct {
+	Redis *InvalidationRedis `json:"redis"`
+	HTTP  *InvalidationHTTP  `json:"http"`
+}
+
+//InvalidationRedis publishes invalidation messages on Channel,
+//it may be a route template like "invalidation.{{.Collection}}"
+type InvalidationRedis struct {
+	RedisSettings
+	Channel string `json:"channel"`
+}
+
+//InvalidationHTTP sends every invalidation message to URL,
+//Method defaults to POST
+type InvalidationHTTP struct {
+	URL     string            `json:"url" validate:"required,url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Timeout Duration          `json:"timeout"`
+}
+
+//InvalidationMessage is the compact message for caches,
+//it only tells what changed, not the new values
+type InvalidationMessage struct {
+	Namespace string      `json:"ns"`
+	ID        interface{} `json:"id"`
+	Fields    []string    `json:"fields,omitempty"`
+}
+
+type invalidationSink struct {
+	redis   *redisClient
+	channel *Route
+	http    *InvalidationHTTP
+	client  *http.Client
+}
+
+func init() {
+	RegisterSinkType("invalidation", func(options json.RawMessage) (Sink, error) {
+		var settings InvalidationSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewInvalidationSink(settings)
+	})
+}
+
+//NewInvalidationSink creates a sink that broadcasts an invalidation
+//message for every change, so caches can drop stale entries
+func NewInvalidationSink(settings InvalidationSettings) (Sink, error) {
+	if settings.Redis == nil && settings.HTTP == nil {
+		return nil, errors.New("Invalidation sink needs a redis or http target")
+	}
+
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, err
+	}
+
+	sink := &invalidationSink{http: settings.HTTP}
+	if settings.Redis != nil {
+		channel := settings.Redis.Channel
+		if channel == "" {
+			channel = defaultInvalidationChannel
+		}
+
+		route, err := NewRoute(channel)
+		if err != nil {
+			return nil, err
+		}
+
+		sink.channel = route
+		sink.redis = newRedisClient(settings.Redis.RedisSettings)
+	}
+
+	if settings.HTTP != nil {
+		timeout := settings.HTTP.Timeout.Duration
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+
+		sink.client = &http.Client{Timeout: timeout}
+	}
+
+	return sink, nil
+}
+
+func newInvalidationMessage(e ChangeEvent) InvalidationMessage {
+	message := InvalidationMessage{Namespace: e.Namespace, ID: e.ID}
+	for field := range e.Fields {
+		message.Fields = append(message.Fields, field)
+	}
+	sort.Strings(message.Fields)
+
+	return message
+}
+
+func (s *invalidationSink) Send(e ChangeEvent) error {
+	data, err := json.Marshal(newInvalidationMessage(e))
+	if err != nil {
+		return err
+	}
+
+	if s.redis != nil {
+		channel, err := s.channel.Resolve(e)
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.redis.Do("PUBLISH", channel, string(data)); err != nil {
+			return err
+		}
+	}
+
+	if s.http != nil {
+		return s.purge(data)
+	}
+
+	return nil
+}
+
+func (s *invalidationSink) purge(data []byte) error {
+	method := s.http.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	request, err := http.NewRequest(method, s.http.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range s.http.Headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("Purge endpoint answered with %s", response.Status)
+	}
+
+	return nil
+}
+
+func (s *invalidationSink) Close() error {
+	if s.redis != nil {
+		return s.redis.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Invalidation sink", func() {
+	var (
+		server   *httptest.Server
+		requests chan *http.Request
+		bodies   chan []byte
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 1)
+		bodies = make(chan []byte, 1)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			requests <- r
+			bodies <- data
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("needs at least one target", func() {
+		_, err := NewInvalidationSink(InvalidationSettings{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("will send compact messages to the purge endpoint", func() {
+		sink, err := NewInvalidationSink(InvalidationSettings{
+			HTTP: &InvalidationHTTP{URL: server.URL, Method: "PURGE"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = sink.Send(ChangeEvent{
+			Namespace: "live.user",
+			ID:        "someone",
+			Operation: "u",
+			Fields:    map[string]interface{}{"username": "naan", "gender": "male"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		request := <-requests
+		Expect(request.Method).To(Equal("PURGE"))
+
+		var message InvalidationMessage
+		Expect(json.Unmarshal(<-bodies, &message)).To(Succeed())
+		Expect(message.Namespace).To(Equal("live.user"))
+		Expect(message.ID).To(Equal("someone"))
+		Expect(message.Fields).To(Equal([]string{"gender", "username"}))
+		Expect(sink.Close()).To(Succeed())
+	})
+
+	It("can be created from the configuration", func() {
+		config, err := NewConfiguration([]byte(`{
+			"mongo": {"connectionURI": "localhost"},
+			"watches": [{
+				"trackCollection": "live.user",
+				"trackFields": ["username"],
+				"targetCollection": "live.comment",
+				"targetNormalizedField": "meta",
+				"triggerReference": "user"
+			}],
+			"sinks": [{"type": "invalidation", "options": {"http": {"url": "` + server.URL + `"}}}]
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		sink, err := NewSink(config.Sinks[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sink.Send(ChangeEvent{Namespace: "live.user", ID: "id"})).To(Succeed())
+		Expect((<-requests).Method).To(Equal("POST"))
+	})
+
+	It("will not accept unknown sink types", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": {"connectionURI": "localhost"},
+			"watches": [{
+				"trackCollection": "live.user",
+				"trackFields": ["username"],
+				"targetCollection": "live.comment",
+				"targetNormalizedField": "meta",
+				"triggerReference": "user"
+			}],
+			"sinks": [{"type": "carrier-pigeon"}]
+		}`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("Unknown sink type carrier-pigeon"))
+	})
+})
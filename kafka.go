@@ -0,0 +1,270 @@
+package redkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//kafkaContentType are records with json values of the kafka rest proxy
+	kafkaContentType = "application/vnd.kafka.json.v2+json"
+	//kafkaMaxKnownDocuments limits the documents whose values are kept for
+	//the before values, they are forgotten once the limit is reached
+	kafkaMaxKnownDocuments = 100000
+)
+
+//KafkaSinkSettings configures a sink that produces every change to a kafka
+//topic through the REST proxy at URL. Topic may be a route template, User
+//and Password are sent with basic authentication.
+type KafkaSinkSettings struct {
+	URL      string `json:"url" validate:"required,url"`
+	Topic    string `json:"topic" validate:"required,min=1"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+//KafkaChange is the value of a message of the kafka sink, its key is the
+//id. ID is the tracked document that the targets reference, Fields are the
+//changed tracked fields. Before has their values before the change if the
+//sink saw them since it started, After the new ones. Deletes have the last
+//known values in Before.
+type KafkaChange struct {
+	Watch     string                 `json:"watch"`
+	Operation string                 `json:"op"`
+	Namespace string                 `json:"ns"`
+	ID        interface{}            `json:"id"`
+	Fields    []string               `json:"fields"`
+	Before    map[string]interface{} `json:"before"`
+	After     map[string]interface{} `json:"after"`
+	Timestamp bson.MongoTimestamp    `json:"ts"`
+}
+
+type kafkaRecord struct {
+	Key   string      `json:"key"`
+	Value KafkaChange `json:"value"`
+}
+
+//kafkaSink produces every batch when it is committed. The values of the
+//documents are kept in known once they were produced, staged has those
+//of the current batch, nil for deleted documents.
+type kafkaSink struct {
+	settings KafkaSinkSettings
+	topic    *Route
+	client   *http.Client
+	records  map[string][]kafkaRecord
+	known    map[string]map[string]interface{}
+	staged   map[string]map[string]interface{}
+}
+
+func init() {
+	RegisterSinkType("kafka", func(options json.RawMessage) (Sink, error) {
+		var settings KafkaSinkSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewKafkaSink(settings)
+	})
+}
+
+//NewKafkaSink creates the sink, it connects to the proxy with the first batch
+func NewKafkaSink(settings KafkaSinkSettings) (Sink, error) {
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, err
+	}
+
+	topic, err := NewRoute(settings.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaSink{
+		settings: settings,
+		topic:    topic,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		records:  map[string][]kafkaRecord{},
+		known:    map[string]map[string]interface{}{},
+		staged:   map[string]map[string]interface{}{},
+	}, nil
+}
+
+//Send produces e on its own, it is used with a spool
+func (s *kafkaSink) Send(e ChangeEvent) error {
+	if err := s.Begin(); err != nil {
+		return err
+	}
+
+	if err := s.Add(e); err != nil {
+		s.Rollback()
+		return err
+	}
+
+	if err := s.Commit(); err != nil {
+		s.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (s *kafkaSink) Begin() error {
+	return s.Rollback()
+}
+
+func (s *kafkaSink) Add(e ChangeEvent) error {
+	topic, err := s.topic.Resolve(e)
+	if err != nil {
+		return err
+	}
+
+	s.records[topic] = append(s.records[topic], kafkaRecord{Key: idString(e.ID), Value: s.change(e)})
+	return nil
+}
+
+//Commit produces the records of every topic, the values of the batch
+//are only known once all were produced
+func (s *kafkaSink) Commit() error {
+	topics := []string{}
+	for topic := range s.records {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		if err := s.produce(topic, s.records[topic]); err != nil {
+			return err
+		}
+	}
+
+	for key, values := range s.staged {
+		if _, ok := s.known[key]; !ok && len(s.known) >= kafkaMaxKnownDocuments {
+			s.known = map[string]map[string]interface{}{}
+		}
+
+		if values == nil {
+			delete(s.known, key)
+			continue
+		}
+		s.known[key] = values
+	}
+
+	return s.Rollback()
+}
+
+func (s *kafkaSink) Rollback() error {
+	s.records = map[string][]kafkaRecord{}
+	s.staged = map[string]map[string]interface{}{}
+	return nil
+}
+
+func (s *kafkaSink) Close() error {
+	return nil
+}
+
+//change describes e with the values of the document before e
+func (s *kafkaSink) change(e ChangeEvent) KafkaChange {
+	change := KafkaChange{
+		Watch:     e.Watch,
+		Operation: e.Operation,
+		Namespace: e.Namespace,
+		ID:        e.ID,
+		Fields:    []string{},
+		Before:    map[string]interface{}{},
+		Timestamp: e.Timestamp,
+	}
+
+	key := e.Watch + "\x00" + idString(e.ID)
+	previous, ok := s.staged[key]
+	if !ok {
+		previous = s.known[key]
+	}
+
+	if e.Operation == "d" {
+		for field, value := range previous {
+			change.Before[field] = value
+		}
+		s.staged[key] = nil
+		return change
+	}
+
+	values := map[string]interface{}{}
+	if e.Operation != "i" {
+		for field, value := range previous {
+			values[field] = value
+		}
+	}
+
+	change.After = map[string]interface{}{}
+	for field, value := range e.Fields {
+		change.Fields = append(change.Fields, field)
+		if before, ok := values[field]; ok {
+			change.Before[field] = before
+		}
+		change.After[field] = value
+		values[field] = value
+	}
+	sort.Strings(change.Fields)
+	s.staged[key] = values
+
+	return change
+}
+
+//produce sends records to topic, it fails if the proxy rejected one
+func (s *kafkaSink) produce(topic string, records []kafkaRecord) error {
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(s.settings.URL, "/") + "/topics/" + url.PathEscape(topic)
+	request, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", kafkaContentType)
+	request.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if s.settings.User != "" {
+		request.SetBasicAuth(s.settings.User, s.settings.Password)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	message, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka answered with %s: %s", response.Status, bytes.TrimSpace(message))
+	}
+
+	produced := struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}{}
+	if err := json.Unmarshal(message, &produced); err != nil {
+		return err
+	}
+
+	for _, offset := range produced.Offsets {
+		if offset.ErrorCode != nil && *offset.ErrorCode != 0 {
+			return fmt.Errorf("Kafka rejected a record of topic %s: %s", topic, offset.Error)
+		}
+	}
+
+	return nil
+}
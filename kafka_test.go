@@ -0,0 +1,131 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Kafka sink", func() {
+	type record struct {
+		Key   string      `json:"key"`
+		Value KafkaChange `json:"value"`
+	}
+
+	type produced struct {
+		path, contentType string
+		records           []record
+	}
+
+	var (
+		server   *httptest.Server
+		mutex    sync.Mutex
+		requests []produced
+		answer   string
+		status   int
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		answer = `{"offsets": [{"partition": 0, "offset": 1}]}`
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			request := struct {
+				Records []record `json:"records"`
+			}{}
+			json.Unmarshal(body, &request)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, produced{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), records: request.Records})
+			w.WriteHeader(status)
+			w.Write([]byte(answer))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newSink := func(topic string) BatchSink {
+		sink, err := NewKafkaSink(KafkaSinkSettings{URL: server.URL, Topic: topic})
+		Expect(err).ToNot(HaveOccurred())
+		return sink.(BatchSink)
+	}
+
+	commit := func(sink BatchSink, events ...ChangeEvent) error {
+		Expect(sink.Begin()).To(Succeed())
+		for _, e := range events {
+			Expect(sink.Add(e)).To(Succeed())
+		}
+
+		return sink.Commit()
+	}
+
+	insert := ChangeEvent{Watch: "userComments", Operation: "i", Namespace: "app.user", ID: "1", Fields: map[string]interface{}{"name": "Hans", "city": "Berlin"}, Timestamp: 1}
+	update := ChangeEvent{Watch: "userComments", Operation: "u", Namespace: "app.user", ID: "1", Fields: map[string]interface{}{"name": "Anna"}, Timestamp: 2}
+	remove := ChangeEvent{Watch: "userComments", Operation: "d", Namespace: "app.user", ID: "1", Timestamp: 3}
+
+	It("will produce the changes of a batch to the routed topics", func() {
+		sink := newSink("cdc.{{.DB}}.{{.Collection}}")
+		Expect(commit(sink, insert, update)).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].path).To(Equal("/topics/cdc.app.user"))
+		Expect(requests[0].contentType).To(Equal("application/vnd.kafka.json.v2+json"))
+		Expect(requests[0].records).To(HaveLen(2))
+
+		first := requests[0].records[0]
+		Expect(first.Key).To(Equal("1"))
+		Expect(first.Value.Watch).To(Equal("userComments"))
+		Expect(first.Value.Fields).To(Equal([]string{"city", "name"}))
+		Expect(first.Value.Before).To(BeEmpty())
+		Expect(first.Value.After).To(Equal(map[string]interface{}{"name": "Hans", "city": "Berlin"}))
+
+		second := requests[0].records[1]
+		Expect(second.Value.Before).To(Equal(map[string]interface{}{"name": "Hans"}))
+		Expect(second.Value.After).To(Equal(map[string]interface{}{"name": "Anna"}))
+	})
+
+	It("will only know the values of committed batches", func() {
+		sink := newSink("changes")
+		Expect(commit(sink, insert)).To(Succeed())
+
+		status = http.StatusServiceUnavailable
+		Expect(commit(sink, update)).To(MatchError(ContainSubstring("Kafka answered with 503")))
+		Expect(sink.Rollback()).To(Succeed())
+
+		status = http.StatusOK
+		Expect(commit(sink, remove)).To(Succeed())
+		deleted := requests[2].records[0].Value
+		Expect(deleted.Before).To(Equal(map[string]interface{}{"name": "Hans", "city": "Berlin"}))
+		Expect(deleted.After).To(BeNil())
+
+		Expect(sink.Send(update)).To(Succeed())
+		Expect(requests[3].records[0].Value.Before).To(BeEmpty())
+	})
+
+	It("will fail if a record was rejected", func() {
+		answer = `{"offsets": [{"partition": null, "offset": null, "error_code": 40403, "error": "Schema not found"}]}`
+		Expect(commit(newSink("changes"), insert)).To(MatchError("Kafka rejected a record of topic changes: Schema not found"))
+	})
+
+	It("will be configured as sink", func() {
+		_, err := NewSink(SinkConfig{Type: "kafka", Options: json.RawMessage(`{"url": "http://localhost:8082", "topic": "changes"}`)})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = NewSink(SinkConfig{Type: "kafka", Options: json.RawMessage(`{"url": "http://localhost:8082", "topic": "{{.Unknown}}"}`)})
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewSink(SinkConfig{Type: "kafka", Options: json.RawMessage(`{"topic": "changes"}`)})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,85 @@
+package redkeep
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//latencySamples is the number of latest samples per watch
+	//the quantiles are calculated of
+	latencySamples         = 1024
+	latencyPublishInterval = time.Second
+)
+
+var latencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+//latencyRecorder measures the time from the oplog entry to the
+//handled write per watch, all methods can be called on nil
+type latencyRecorder struct {
+	sync.Mutex
+	metrics   *metricRegistry
+	samples   map[string][]float64
+	next      map[string]int
+	published time.Time
+}
+
+func newLatencyRecorder(metrics *metricRegistry) *latencyRecorder {
+	return &latencyRecorder{metrics: metrics, samples: map[string][]float64{}, next: map[string]int{}}
+}
+
+func latencyMetric(watch string, quantile float64) string {
+	return fmt.Sprintf("%s{watch=%q,quantile=\"%g\"}", MetricWriteLatency, watch, quantile)
+}
+
+//eventTime is the wall clock time of an oplog entry, entries of
+//mongodb before 3.6 only have the timestamp with seconds
+func eventTime(entry map[string]interface{}) time.Time {
+	if wall, ok := entry["wall"].(time.Time); ok {
+		return wall
+	}
+
+	ts, _ := entry["ts"].(bson.MongoTimestamp)
+	return time.Unix(int64(ts>>32), 0)
+}
+
+//observe records the latency of w for an entry that was just handled
+func (r *latencyRecorder) observe(w Watch, entry map[string]interface{}) {
+	if r == nil {
+		return
+	}
+
+	r.add(w.Key(), time.Since(eventTime(entry)).Seconds(), time.Now())
+}
+
+func (r *latencyRecorder) add(key string, seconds float64, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.samples[key]) < latencySamples {
+		r.samples[key] = append(r.samples[key], seconds)
+	} else {
+		r.samples[key][r.next[key]] = seconds
+		r.next[key] = (r.next[key] + 1) % latencySamples
+	}
+
+	if now.Sub(r.published) >= latencyPublishInterval {
+		r.published = now
+		r.publish()
+	}
+}
+
+//publish sets the quantile gauges of all watches
+func (r *latencyRecorder) publish() {
+	for key, samples := range r.samples {
+		sorted := append([]float64{}, samples...)
+		sort.Float64s(sorted)
+		for _, q := range latencyQuantiles {
+			r.metrics.set(latencyMetric(key, q), sorted[int(q*float64(len(sorted)-1))])
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package redkeep_test
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Write latency", func() {
+	It("publishes quantiles per watch", func() {
+		seconds := []float64{}
+		for i := 1; i <= 100; i++ {
+			seconds = append(seconds, float64(i)/100)
+		}
+
+		metrics := LatencyQuantiles("comments", seconds)
+		Expect(metrics).To(HaveKeyWithValue(`write_latency_seconds{watch="comments",quantile="0.5"}`, 0.5))
+		Expect(metrics).To(HaveKeyWithValue(`write_latency_seconds{watch="comments",quantile="0.95"}`, 0.95))
+		Expect(metrics).To(HaveKeyWithValue(`write_latency_seconds{watch="comments",quantile="0.99"}`, 0.99))
+	})
+
+	It("keeps the latest samples only", func() {
+		seconds := []float64{}
+		for i := 0; i < 2000; i++ {
+			seconds = append(seconds, 10)
+		}
+		for i := 0; i < 1024; i++ {
+			seconds = append(seconds, 1)
+		}
+
+		metrics := LatencyQuantiles("comments", seconds)
+		Expect(metrics).To(HaveKeyWithValue(`write_latency_seconds{watch="comments",quantile="0.99"}`, 1.0))
+	})
+
+	It("prefers the wall clock time of entries", func() {
+		wall := time.Date(2026, 10, 16, 8, 0, 0, 250000000, time.UTC)
+		Expect(EventTime(map[string]interface{}{"ts": bson.MongoTimestamp(1 << 32), "wall": wall})).To(Equal(wall))
+		Expect(EventTime(map[string]interface{}{"ts": bson.MongoTimestamp(1 << 32)})).To(Equal(time.Unix(1, 0)))
+	})
+})
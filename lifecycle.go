@@ -0,0 +1,138 @@
+package redkeep
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+//component is a subsystem of the agent. It is started after all
+//components it depends on and stopped before them. start and stop
+//may be nil.
+type component struct {
+	name      string
+	dependsOn []string
+	start     func() error
+	stop      func()
+	timeout   time.Duration
+}
+
+//lifecycle starts and stops components in dependency order
+type lifecycle struct {
+	components []component
+	started    []component
+}
+
+func (l *lifecycle) add(c component) {
+	l.components = append(l.components, c)
+}
+
+//order sorts the components so that every component comes after its
+//dependencies, independent components keep the order they were added in
+func (l *lifecycle) order() ([]component, error) {
+	byName := map[string]component{}
+	for _, c := range l.components {
+		byName[c.name] = c
+	}
+
+	ordered := []component{}
+	state := map[string]int{}
+	var visit func(c component) error
+	visit = func(c component) error {
+		switch state[c.name] {
+		case 1:
+			return fmt.Errorf("Component %s is part of a dependency cycle", c.name)
+		case 2:
+			return nil
+		}
+
+		state[c.name] = 1
+		for _, name := range c.dependsOn {
+			dependency, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("Component %s depends on unknown component %s", c.name, name)
+			}
+
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[c.name] = 2
+		ordered = append(ordered, c)
+		return nil
+	}
+
+	for _, c := range l.components {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+//start starts all components, if one fails the
+//already started ones are stopped again
+func (l *lifecycle) start() error {
+	ordered, err := l.order()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range ordered {
+		if c.start != nil {
+			if err := c.start(); err != nil {
+				l.stop()
+				return fmt.Errorf("Component %s could not be started: %s", c.name, err.Error())
+			}
+		}
+
+		l.started = append(l.started, c)
+	}
+
+	return nil
+}
+
+//stop stops the started components in reverse order. A component
+//that does not stop within its timeout is left behind and so are
+//all components it depends on, they might still be in use.
+func (l *lifecycle) stop() {
+	abandoned := map[string]bool{}
+	for i := len(l.started) - 1; i >= 0; i-- {
+		c := l.started[i]
+		if abandoned[c.name] {
+			logWarn("Component is not stopped, it might still be in use", Fields{"component": c.name})
+			for _, name := range c.dependsOn {
+				abandoned[name] = true
+			}
+			continue
+		}
+
+		if c.stop == nil {
+			continue
+		}
+
+		timeout := c.timeout
+		if timeout <= 0 {
+			timeout = defaultStopTimeout
+		}
+
+		done := make(chan bool)
+		go func() {
+			c.stop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			logWarn("Component did not stop in time", Fields{"component": c.name, "timeout": timeout})
+			for _, name := range c.dependsOn {
+				abandoned[name] = true
+			}
+		}
+	}
+
+	l.started = nil
+}
@@ -0,0 +1,64 @@
+package redkeep_test
+
+import (
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lifecycle", func() {
+	It("starts dependencies first and stops them last", func() {
+		calls, err := RunLifecycle([]TestComponent{
+			{Name: "admin", DependsOn: []string{"workers"}},
+			{Name: "workers", DependsOn: []string{"sinks"}},
+			{Name: "sinks"},
+			{Name: "lag"},
+		}, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal([]string{
+			"start sinks", "start workers", "start admin", "start lag",
+			"stop lag", "stop admin", "stop workers", "stop sinks",
+		}))
+	})
+
+	It("rejects dependency cycles", func() {
+		calls, err := RunLifecycle([]TestComponent{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"a"}},
+		}, time.Second)
+		Expect(err).To(MatchError("Component a is part of a dependency cycle"))
+		Expect(calls).To(BeEmpty())
+	})
+
+	It("rejects unknown dependencies", func() {
+		_, err := RunLifecycle([]TestComponent{{Name: "a", DependsOn: []string{"ghost"}}}, time.Second)
+		Expect(err).To(MatchError("Component a depends on unknown component ghost"))
+	})
+
+	It("stops the started components if one fails to start", func() {
+		calls, err := RunLifecycle([]TestComponent{
+			{Name: "sinks"},
+			{Name: "workers", DependsOn: []string{"sinks"}},
+			{Name: "admin", DependsOn: []string{"workers"}, FailStart: true},
+		}, time.Second)
+		Expect(err).To(MatchError("Component admin could not be started: failed"))
+		Expect(calls).To(Equal([]string{"start sinks", "start workers", "start admin", "stop workers", "stop sinks"}))
+	})
+
+	It("leaves components behind that do not stop in time, with their dependencies", func() {
+		calls, err := RunLifecycle([]TestComponent{
+			{Name: "sinks"},
+			{Name: "workers", DependsOn: []string{"sinks"}, BlockStop: true},
+			{Name: "admin", DependsOn: []string{"workers"}},
+			{Name: "lag"},
+		}, 50*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal([]string{
+			"start sinks", "start workers", "start admin", "start lag",
+			"stop lag", "stop admin", "stop workers",
+		}))
+	})
+})
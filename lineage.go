@@ -0,0 +1,385 @@
+package redkeep
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//lineageProducer identifies redkeep as producer of the lineage events
+	lineageProducer = "https://github.com/manyminds/redkeep"
+	//lineageSchemaURL is the OpenLineage version of the events
+	lineageSchemaURL = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/$defs/RunEvent"
+	//lineageColumnFacetURL is the version of the column lineage facet
+	lineageColumnFacetURL   = "https://openlineage.io/spec/facets/1-1-0/ColumnLineageDatasetFacet.json#/$defs/ColumnLineageDatasetFacet"
+	defaultLineageNamespace = "redkeep"
+	defaultLineageTimeout   = 5 * time.Second
+	//lineageQueueSize is the number of events waiting to be sent
+	lineageQueueSize = 100
+)
+
+//OpenLineage states of a run
+const (
+	LineageStart    = "START"
+	LineageComplete = "COMPLETE"
+	LineageFail     = "FAIL"
+	LineageAbort    = "ABORT"
+)
+
+//LineageSettings send OpenLineage run events to URL, the lineage endpoint
+//of a backend like Marquez (http://marquez:5000/api/v1/lineage). Every
+//watch is a job in Namespace (default redkeep) with one run while the
+//agent tails and one run per backfill as job <watch>.backfill. Datasets
+//are in DatasetNamespace, by default mongodb://<first host of the
+//connection uri>. APIKey is sent as bearer token.
+type LineageSettings struct {
+	URL              string   `json:"url" validate:"required,url"`
+	Namespace        string   `json:"namespace"`
+	DatasetNamespace string   `json:"datasetNamespace"`
+	APIKey           string   `json:"apiKey"`
+	Timeout          Duration `json:"timeout"`
+}
+
+//LineageEvent is an OpenLineage run event
+type LineageEvent struct {
+	EventType string           `json:"eventType"`
+	EventTime time.Time        `json:"eventTime"`
+	Run       LineageRun       `json:"run"`
+	Job       LineageJob       `json:"job"`
+	Inputs    []LineageDataset `json:"inputs"`
+	Outputs   []LineageDataset `json:"outputs"`
+	Producer  string           `json:"producer"`
+	SchemaURL string           `json:"schemaURL"`
+}
+
+//LineageRun identifies a run of a job
+type LineageRun struct {
+	RunID string `json:"runId"`
+}
+
+//LineageJob is the job of a watch
+type LineageJob struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+//LineageDataset is a collection, outputs have the columnLineage facet
+//with the tracked fields every written field comes from
+type LineageDataset struct {
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Facets    map[string]interface{} `json:"facets,omitempty"`
+}
+
+//LineageColumns is the columnLineage facet of a target collection
+type LineageColumns struct {
+	Producer  string                        `json:"_producer"`
+	SchemaURL string                        `json:"_schemaURL"`
+	Fields    map[string]LineageColumnInput `json:"fields"`
+}
+
+//LineageColumnInput are the fields a written field comes from, with the
+//transforms of the watch if it has any
+type LineageColumnInput struct {
+	InputFields               []LineageInputField `json:"inputFields"`
+	TransformationDescription string              `json:"transformationDescription,omitempty"`
+	TransformationType        string              `json:"transformationType,omitempty"`
+}
+
+//LineageInputField is a tracked field
+type LineageInputField struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Field     string `json:"field"`
+}
+
+//lineageDatasetNamespace is mongodb://<first host> of a connection uri
+func lineageDatasetNamespace(uri string) string {
+	hosts := strings.TrimPrefix(uri, "mongodb://")
+	if p := strings.LastIndex(hosts, "@"); p >= 0 {
+		hosts = hosts[p+1:]
+	}
+	if p := strings.IndexAny(hosts, "/?"); p >= 0 {
+		hosts = hosts[:p]
+	}
+
+	return "mongodb://" + strings.Split(hosts, ",")[0]
+}
+
+//newRunID returns a random uuid
+func newRunID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+//lineageDatasets are the tracked collection of w as input and its target
+//collection with the lineage of the written fields as output
+func lineageDatasets(namespace string, w Watch) ([]LineageDataset, []LineageDataset) {
+	transforms := []string{}
+	for _, t := range w.Transforms {
+		transforms = append(transforms, t.Type)
+	}
+
+	prefix := w.TargetNormalizedField + "."
+	if w.BehaviourSettings.ReferenceArray {
+		prefix = w.TriggerReference + ".$." + prefix
+	}
+
+	columns := LineageColumns{Producer: lineageProducer, SchemaURL: lineageColumnFacetURL, Fields: map[string]LineageColumnInput{}}
+	for _, field := range w.TrackFields {
+		input := LineageColumnInput{
+			InputFields:        []LineageInputField{{Namespace: namespace, Name: w.TrackCollection, Field: field}},
+			TransformationType: "IDENTITY",
+		}
+		if len(transforms) > 0 {
+			input.TransformationType = ""
+			input.TransformationDescription = "Transformed by " + strings.Join(transforms, ", ")
+		}
+		columns.Fields[prefix+w.targetField(field)] = input
+	}
+
+	inputs := []LineageDataset{{Namespace: namespace, Name: w.TrackCollection}}
+	outputs := []LineageDataset{{Namespace: namespace, Name: w.TargetCollection, Facets: map[string]interface{}{"columnLineage": columns}}}
+	return inputs, outputs
+}
+
+//lineageRun is a running tailing run of a watch
+type lineageRun struct {
+	id    string
+	watch Watch
+}
+
+//lineageEmitter sends the run events, all methods can be called on nil.
+//Runs are the tailing runs by watch key. While it is started the events
+//are sent from a queue, away from the oplog.
+type lineageEmitter struct {
+	sync.Mutex
+	settings LineageSettings
+	datasets string
+	client   *http.Client
+	metrics  *metricRegistry
+	runs     map[string]lineageRun
+	//queue guards events, it is closed while queue is locked
+	queue  sync.RWMutex
+	events chan LineageEvent
+	done   chan bool
+}
+
+//newLineageEmitter returns nil without settings
+func newLineageEmitter(settings *LineageSettings, mongo Mongo, metrics *metricRegistry) *lineageEmitter {
+	if settings == nil {
+		return nil
+	}
+
+	l := &lineageEmitter{settings: *settings, datasets: settings.DatasetNamespace, metrics: metrics, runs: map[string]lineageRun{}}
+	if l.settings.Namespace == "" {
+		l.settings.Namespace = defaultLineageNamespace
+	}
+	if l.datasets == "" {
+		l.datasets = lineageDatasetNamespace(mongo.ConnectionURI)
+	}
+
+	timeout := settings.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultLineageTimeout
+	}
+	l.client = &http.Client{Timeout: timeout}
+
+	return l
+}
+
+//event describes a run of w in state eventType
+func (l *lineageEmitter) event(eventType, runID, job string, w Watch) LineageEvent {
+	inputs, outputs := lineageDatasets(l.datasets, w)
+	return LineageEvent{
+		EventType: eventType,
+		EventTime: time.Now().UTC(),
+		Run:       LineageRun{RunID: runID},
+		Job:       LineageJob{Namespace: l.settings.Namespace, Name: job},
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Producer:  lineageProducer,
+		SchemaURL: lineageSchemaURL,
+	}
+}
+
+//emit queues e, it is sent right away while the queue is not started.
+//Events that find the queue full are dropped and counted as failures.
+func (l *lineageEmitter) emit(e LineageEvent) {
+	l.queue.RLock()
+	defer l.queue.RUnlock()
+	if l.events == nil {
+		l.deliver(e)
+		return
+	}
+
+	select {
+	case l.events <- e:
+	default:
+		l.metrics.add(MetricLineageFailures, 1)
+		logWarn("Lineage queue is full, event dropped", Fields{"job": e.Job.Name, "event": e.EventType})
+	}
+}
+
+//deliver sends e, failures are counted and logged
+func (l *lineageEmitter) deliver(e LineageEvent) {
+	if err := l.send(e); err != nil {
+		l.metrics.add(MetricLineageFailures, 1)
+		logWarn("Lineage event could not be sent", Fields{"job": e.Job.Name, "event": e.EventType}.withError(err))
+		return
+	}
+
+	l.metrics.add(MetricLineageEvents, 1)
+}
+
+func (l *lineageEmitter) send(e LineageEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", l.settings.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if l.settings.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+l.settings.APIKey)
+	}
+
+	response, err := l.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Lineage backend answered with %s: %s", response.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+//start sends the events from the queue
+func (l *lineageEmitter) start() error {
+	l.queue.Lock()
+	defer l.queue.Unlock()
+	l.events = make(chan LineageEvent, lineageQueueSize)
+	l.done = make(chan bool)
+	go func(events chan LineageEvent) {
+		defer close(l.done)
+		for e := range events {
+			l.deliver(e)
+		}
+	}(l.events)
+
+	return nil
+}
+
+//stop waits until the queued events are sent, each one takes at most
+//the timeout of the backend
+func (l *lineageEmitter) stop() {
+	l.queue.Lock()
+	close(l.events)
+	l.events = nil
+	l.queue.Unlock()
+	<-l.done
+}
+
+//startRuns starts the tailing runs of the watches that have none
+func (l *lineageEmitter) startRuns(watches []Watch) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	for _, w := range watches {
+		if _, ok := l.runs[w.Key()]; ok {
+			continue
+		}
+
+		run := lineageRun{id: newRunID(), watch: w}
+		l.runs[w.Key()] = run
+		l.emit(l.event(LineageStart, run.id, w.Key(), w))
+	}
+}
+
+//reloadRuns completes the runs of removed and changed watches and
+//starts new ones for added and changed watches
+func (l *lineageEmitter) reloadRuns(watches []Watch) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	current := map[string]Watch{}
+	for _, w := range watches {
+		current[w.Key()] = w
+	}
+
+	for key, run := range l.runs {
+		if w, ok := current[key]; !ok || !reflect.DeepEqual(w, run.watch) {
+			l.emit(l.event(LineageComplete, run.id, key, run.watch))
+			delete(l.runs, key)
+		}
+	}
+	l.Unlock()
+
+	l.startRuns(watches)
+}
+
+//endRuns ends all tailing runs, they failed if err is not nil
+func (l *lineageEmitter) endRuns(err error) {
+	if l == nil {
+		return
+	}
+
+	eventType := LineageComplete
+	if err != nil {
+		eventType = LineageFail
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	for key, run := range l.runs {
+		l.emit(l.event(eventType, run.id, key, run.watch))
+		delete(l.runs, key)
+	}
+}
+
+//backfill starts a backfill run of w, the returned function ends it with
+//the error of the backfill. Canceled backfills are aborted.
+func (l *lineageEmitter) backfill(w Watch) func(err error) {
+	if l == nil {
+		return func(error) {}
+	}
+
+	id, job := newRunID(), w.Key()+".backfill"
+	l.emit(l.event(LineageStart, id, job, w))
+	return func(err error) {
+		eventType := LineageComplete
+		switch {
+		case err == context.Canceled || err == context.DeadlineExceeded:
+			eventType = LineageAbort
+		case err != nil:
+			eventType = LineageFail
+		}
+
+		l.emit(l.event(eventType, id, job, w))
+	}
+}
@@ -0,0 +1,145 @@
+package redkeep_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenLineage events", func() {
+	var (
+		server *httptest.Server
+		mutex  sync.Mutex
+		events []LineageEvent
+	)
+
+	comments := Watch{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username"},
+		TargetCollection:      "app.comment",
+		TargetNormalizedField: "meta.user",
+		TriggerReference:      "user",
+	}
+	reviews := comments
+	reviews.Name = "userReviews"
+	reviews.TargetCollection = "app.review"
+
+	BeforeEach(func() {
+		events = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer secret"))
+			var event LineageEvent
+			Expect(json.NewDecoder(r.Body).Decode(&event)).To(Succeed())
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, event)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	runs := func() LineageRuns {
+		return NewLineageRuns(LineageSettings{URL: server.URL, APIKey: "secret"}, Mongo{ConnectionURI: "mongodb://user:pw@db-1:27017,db-2:27017/app?replicaSet=rs"})
+	}
+
+	It("will describe the field lineage of a watch run", func() {
+		r := runs()
+		r.Start([]Watch{comments})
+		r.End(nil)
+
+		Expect(events).To(HaveLen(2))
+		start := events[0]
+		Expect(start.EventType).To(Equal(LineageStart))
+		Expect(start.Job).To(Equal(LineageJob{Namespace: "redkeep", Name: "userComments"}))
+		Expect(start.Inputs).To(Equal([]LineageDataset{{Namespace: "mongodb://db-1:27017", Name: "app.user"}}))
+		Expect(start.Outputs).To(HaveLen(1))
+		Expect(start.Outputs[0].Name).To(Equal("app.comment"))
+
+		columns := start.Outputs[0].Facets["columnLineage"].(map[string]interface{})["fields"].(map[string]interface{})
+		Expect(columns).To(HaveKey("meta.user.username"))
+		input := columns["meta.user.username"].(map[string]interface{})["inputFields"].([]interface{})[0]
+		Expect(input).To(Equal(map[string]interface{}{"namespace": "mongodb://db-1:27017", "name": "app.user", "field": "username"}))
+
+		Expect(events[1].EventType).To(Equal(LineageComplete))
+		Expect(events[1].Run).To(Equal(start.Run))
+	})
+
+	It("will end the runs of reloaded watches", func() {
+		r := runs()
+		r.Start([]Watch{comments, reviews})
+		changed := comments
+		changed.TrackFields = []string{"email"}
+		r.Reload([]Watch{changed})
+		r.End(errors.New("Oplog cursor failed"))
+
+		types := map[string][]string{}
+		for _, e := range events {
+			types[e.Job.Name] = append(types[e.Job.Name], e.EventType)
+		}
+		Expect(types["userReviews"]).To(Equal([]string{LineageStart, LineageComplete}))
+		Expect(types["userComments"]).To(Equal([]string{LineageStart, LineageComplete, LineageStart, LineageFail}))
+	})
+
+	It("will send the events of a tailing agent from a queue", func() {
+		release := make(chan bool)
+		received := make(chan string, 10)
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event LineageEvent
+			Expect(json.NewDecoder(r.Body).Decode(&event)).To(Succeed())
+			<-release
+			received <- event.Job.Name + " " + event.EventType
+		}))
+		defer slow.Close()
+
+		r := NewLineageRuns(LineageSettings{URL: slow.URL}, Mongo{ConnectionURI: "localhost:30000"})
+		stop := r.Queue()
+		reloaded := make(chan bool)
+		go func() {
+			r.Start([]Watch{comments})
+			changed := comments
+			changed.TrackFields = []string{"email"}
+			r.Reload([]Watch{changed})
+			r.End(nil)
+			close(reloaded)
+		}()
+
+		Eventually(reloaded).Should(BeClosed())
+		Expect(received).To(BeEmpty())
+
+		close(release)
+		stop()
+		Expect(received).To(HaveLen(4))
+		Expect(<-received).To(Equal("userComments START"))
+		Expect(<-received).To(Equal("userComments COMPLETE"))
+		Expect(<-received).To(Equal("userComments START"))
+		Expect(<-received).To(Equal("userComments COMPLETE"))
+	})
+
+	It("will report backfills as their own job", func() {
+		r := runs()
+		r.Backfill(comments)(nil)
+		r.Backfill(comments)(context.Canceled)
+
+		Expect(events).To(HaveLen(4))
+		Expect(events[0].Job.Name).To(Equal("userComments.backfill"))
+		Expect(events[1].EventType).To(Equal(LineageComplete))
+		Expect(events[3].EventType).To(Equal(LineageAbort))
+		Expect(events[2].Run).ToNot(Equal(events[0].Run))
+	})
+
+	It("will name the datasets after the first host", func() {
+		Expect(LineageDatasetNamespace("localhost:30000,localhost:30001")).To(Equal("mongodb://localhost:30000"))
+		Expect(LineageDatasetNamespace("mongodb://db-1/app")).To(Equal("mongodb://db-1"))
+	})
+})
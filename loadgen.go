@@ -0,0 +1,228 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//maxLoadRate is the most operations per second a load test writes
+const maxLoadRate = 100000
+
+//LoadSettings describe synthetic traffic for capacity tests. Documents
+//tracked documents are created per watch, then Rate operations per second
+//are written for Duration: Inserts percent of them insert targets that
+//reference a tracked document, Updates percent change the tracked fields
+//of a tracked document and Deletes percent remove a target. With Skewed
+//80% of the references go to 20% of the tracked documents, otherwise
+//they are spread evenly.
+type LoadSettings struct {
+	ScratchPrefix string
+	Rate          int
+	Duration      time.Duration
+	Documents     int
+	Inserts       int
+	Updates       int
+	Deletes       int
+	Skewed        bool
+}
+
+func checkLoadSettings(settings LoadSettings) error {
+	if settings.ScratchPrefix == "" {
+		return errors.New("A scratch prefix is needed, generating load without would change the live databases")
+	}
+
+	if settings.Rate <= 0 || settings.Duration <= 0 || settings.Documents <= 0 {
+		return errors.New("Rate, duration and documents must be positive")
+	}
+
+	if settings.Rate > maxLoadRate {
+		return fmt.Errorf("Rate can be at most %d operations per second", maxLoadRate)
+	}
+
+	if settings.Inserts < 0 || settings.Updates < 0 || settings.Deletes < 0 ||
+		settings.Inserts+settings.Updates+settings.Deletes != 100 {
+		return fmt.Errorf("Inserts, updates and deletes must add up to 100 percent, not %d", settings.Inserts+settings.Updates+settings.Deletes)
+	}
+
+	return nil
+}
+
+//LoadReport counts the written operations of a load test
+type LoadReport struct {
+	Inserts  int
+	Updates  int
+	Deletes  int
+	Failures int
+}
+
+//ScratchConfiguration returns c for the agent of a load test: the databases
+//of the watches are prefixed with scratchPrefix like GenerateLoad does and
+//writes to the targets are discarded, see Configuration.MockTargets. The
+//watches do not stop when GenerateLoad drops the scratch databases.
+func ScratchConfiguration(c Configuration, scratchPrefix string) Configuration {
+	c.Watches = scratchWatches(c.Watches, scratchPrefix)
+	for i := range c.Watches {
+		c.Watches[i].BehaviourSettings.StopOnDrop = false
+	}
+	c.TenantWatches = nil
+	c.MockTargets = true
+	return c
+}
+
+//loadGenerator writes the traffic of a load test
+type loadGenerator struct {
+	session  *mgo.Session
+	settings LoadSettings
+	random   *rand.Rand
+	watches  []Watch
+	tracked  [][]bson.ObjectId
+	targets  [][]bson.ObjectId
+	report   LoadReport
+}
+
+//GenerateLoad drops the scratch databases of watches and writes synthetic
+//traffic into them until the duration is over or ctx is done
+func GenerateLoad(ctx context.Context, session *mgo.Session, watches []Watch, settings LoadSettings) (LoadReport, error) {
+	if err := checkLoadSettings(settings); err != nil {
+		return LoadReport{}, err
+	}
+
+	g := &loadGenerator{
+		session:  session,
+		settings: settings,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		watches:  scratchWatches(watches, settings.ScratchPrefix),
+	}
+
+	if err := dropScratchDatabases(session, g.watches); err != nil {
+		return g.report, err
+	}
+
+	if err := g.seed(); err != nil {
+		return g.report, err
+	}
+
+	deadline := time.NewTimer(settings.Duration)
+	defer deadline.Stop()
+	ticker := time.NewTicker(time.Second / time.Duration(settings.Rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return g.report, nil
+		case <-deadline.C:
+			return g.report, nil
+		case <-ticker.C:
+			if err := g.write(g.random.Intn(len(g.watches))); err != nil {
+				g.report.Failures++
+			}
+		}
+	}
+}
+
+//seed inserts the tracked documents of every watch
+func (g *loadGenerator) seed() error {
+	for i, w := range g.watches {
+		g.tracked = append(g.tracked, nil)
+		g.targets = append(g.targets, nil)
+		collection := g.collection(w.TrackCollection)
+		for n := 0; n < g.settings.Documents; n++ {
+			id := bson.NewObjectId()
+			document := bson.M{"_id": id}
+			for _, field := range w.TrackFields {
+				setField(document, field, g.value())
+			}
+
+			if err := collection.Insert(document); err != nil {
+				return err
+			}
+			g.tracked[i] = append(g.tracked[i], id)
+		}
+	}
+
+	return nil
+}
+
+//write writes one operation of the mix for the watch at index i,
+//deletes without targets insert one instead
+func (g *loadGenerator) write(i int) error {
+	w := g.watches[i]
+	operation := g.random.Intn(100)
+	if operation >= g.settings.Inserts+g.settings.Updates && len(g.targets[i]) > 0 {
+		g.report.Deletes++
+		n := g.random.Intn(len(g.targets[i]))
+		id := g.targets[i][n]
+		g.targets[i] = append(g.targets[i][:n], g.targets[i][n+1:]...)
+		return g.collection(w.TargetCollection).RemoveId(id)
+	}
+
+	tracked := g.tracked[i][loadReference(len(g.tracked[i]), g.settings.Skewed, g.random)]
+	if operation >= g.settings.Inserts && operation < g.settings.Inserts+g.settings.Updates {
+		g.report.Updates++
+		set := bson.M{}
+		for _, field := range w.TrackFields {
+			set[field] = g.value()
+		}
+
+		return g.collection(w.TrackCollection).UpdateId(tracked, bson.M{"$set": set})
+	}
+
+	g.report.Inserts++
+	p := strings.Index(w.TrackCollection, ".")
+	id := bson.NewObjectId()
+	document := bson.M{"_id": id}
+	setField(document, w.TriggerReference, mgo.DBRef{Database: w.TrackCollection[:p], Collection: w.TrackCollection[p+1:], Id: tracked})
+	if err := g.collection(w.TargetCollection).Insert(document); err != nil {
+		return err
+	}
+
+	g.targets[i] = append(g.targets[i], id)
+	return nil
+}
+
+func (g *loadGenerator) collection(ns string) *mgo.Collection {
+	p := strings.Index(ns, ".")
+	return g.session.DB(ns[:p]).C(ns[p+1:])
+}
+
+func (g *loadGenerator) value() string {
+	return fmt.Sprintf("value-%d", g.random.Int63())
+}
+
+//loadReference picks one of count tracked documents, skewed
+//picks go to the first 20% of them 80% of the time
+func loadReference(count int, skewed bool, random *rand.Rand) int {
+	hot := count / 5
+	if !skewed || hot == 0 {
+		return random.Intn(count)
+	}
+
+	if random.Intn(100) < 80 {
+		return random.Intn(hot)
+	}
+
+	return hot + random.Intn(count-hot)
+}
+
+//setField sets the dotted field of document, the
+//documents on the way are created
+func setField(document bson.M, field string, value interface{}) {
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		inner, ok := document[part].(bson.M)
+		if !ok {
+			inner = bson.M{}
+			document[part] = inner
+		}
+		document = inner
+	}
+
+	document[parts[len(parts)-1]] = value
+}
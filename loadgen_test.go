@@ -0,0 +1,90 @@
+package redkeep_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load generator", func() {
+	settings := LoadSettings{
+		ScratchPrefix: "redkeep_load_",
+		Rate:          100,
+		Duration:      time.Minute,
+		Documents:     10,
+		Inserts:       20,
+		Updates:       70,
+		Deletes:       10,
+	}
+
+	It("will reject invalid settings before writing", func() {
+		invalid := settings
+		invalid.ScratchPrefix = ""
+		_, err := GenerateLoad(context.Background(), nil, nil, invalid)
+		Expect(err).To(MatchError("A scratch prefix is needed, generating load without would change the live databases"))
+
+		invalid = settings
+		invalid.Deletes = 20
+		_, err = GenerateLoad(context.Background(), nil, nil, invalid)
+		Expect(err).To(MatchError("Inserts, updates and deletes must add up to 100 percent, not 110"))
+
+		invalid = settings
+		invalid.Rate = 1000000
+		_, err = GenerateLoad(context.Background(), nil, nil, invalid)
+		Expect(err).To(MatchError("Rate can be at most 100000 operations per second"))
+
+		invalid = settings
+		invalid.Duration = 0
+		_, err = GenerateLoad(context.Background(), nil, nil, invalid)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("will configure the agent for the scratch databases with mock targets", func() {
+		config := Configuration{Watches: []Watch{{
+			TrackCollection:       "live.user",
+			TargetCollection:      "live.comment",
+			TargetNormalizedField: "meta",
+			BehaviourSettings:     BehaviourSettings{StopOnDrop: true},
+		}}}
+
+		scratch := ScratchConfiguration(config, "redkeep_load_")
+		Expect(scratch.MockTargets).To(BeTrue())
+		Expect(scratch.Watches[0].TrackCollection).To(Equal("redkeep_load_live.user"))
+		Expect(scratch.Watches[0].TargetCollection).To(Equal("redkeep_load_live.comment"))
+		Expect(scratch.Watches[0].Key()).To(Equal(config.Watches[0].Key()))
+		Expect(config.Watches[0].TrackCollection).To(Equal("live.user"))
+		Expect(config.Watches[0].BehaviourSettings.StopOnDrop).To(BeTrue())
+		Expect(scratch.Watches[0].BehaviourSettings.StopOnDrop).To(BeFalse())
+	})
+
+	It("will reference hot documents more often when skewed", func() {
+		hot := 0
+		for _, pick := range LoadReferences(1000, 100, true) {
+			Expect(pick).To(BeNumerically("<", 100))
+			if pick < 20 {
+				hot++
+			}
+		}
+		Expect(hot).To(BeNumerically(">", 700))
+
+		hot = 0
+		for _, pick := range LoadReferences(1000, 100, false) {
+			if pick < 20 {
+				hot++
+			}
+		}
+		Expect(hot).To(BeNumerically("<", 300))
+	})
+
+	It("will create nested fields", func() {
+		document := bson.M{"_id": 1}
+		SetField(document, "name.firstName", "nino")
+		SetField(document, "name.lastName", "walker")
+		Expect(document).To(Equal(bson.M{"_id": 1, "name": bson.M{"firstName": "nino", "lastName": "walker"}}))
+	})
+})
@@ -0,0 +1,156 @@
+package redkeep
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//log levels of LogSettings
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+var logLevels = map[string]int{LogDebug: 0, LogInfo: 1, "": 1, LogWarn: 2, LogError: 3}
+
+//Fields describe a log event, like the watch and namespace it belongs to
+type Fields map[string]interface{}
+
+//withError adds err to the fields
+func (f Fields) withError(err error) Fields {
+	if f == nil {
+		f = Fields{}
+	}
+
+	f["error"] = err.Error()
+	return f
+}
+
+//watchFields are the fields of log events of w
+func watchFields(w Watch) Fields {
+	return Fields{"watch": w.Key(), "ns": w.TrackCollection, "target": w.TargetCollection}
+}
+
+//errorFields are the fields of log events that only have an error
+func errorFields(err error) Fields {
+	return Fields{}.withError(err)
+}
+
+//Logger receives the log events of redkeep, adapters for zap, zerolog or
+//slog implement it. Fields may be nil and must not be changed.
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+//LogSettings configure the default logger of the process, Level is
+//debug, info (default), warn or error
+type LogSettings struct {
+	Level string `json:"level"`
+}
+
+func checkLogSettings(settings LogSettings) error {
+	if _, ok := logLevels[settings.Level]; !ok {
+		return fmt.Errorf("Unknown log level %s, use debug, info, warn or error", settings.Level)
+	}
+
+	return nil
+}
+
+//stdLogger writes the events of level and above with the log package
+//like "WARN Sink could not be closed error=... watch=userComments"
+type stdLogger struct {
+	level int
+}
+
+func (l stdLogger) write(level int, name, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	line := []string{name, msg}
+	for _, key := range keys {
+		line = append(line, fmt.Sprintf("%s=%v", key, fields[key]))
+	}
+
+	log.Println(strings.Join(line, " "))
+}
+
+func (l stdLogger) Debug(msg string, fields Fields) {
+	l.write(0, "DEBUG", msg, fields)
+}
+
+func (l stdLogger) Info(msg string, fields Fields) {
+	l.write(1, "INFO", msg, fields)
+}
+
+func (l stdLogger) Warn(msg string, fields Fields) {
+	l.write(2, "WARN", msg, fields)
+}
+
+func (l stdLogger) Error(msg string, fields Fields) {
+	l.write(3, "ERROR", msg, fields)
+}
+
+var logger = struct {
+	sync.RWMutex
+	Logger
+}{Logger: stdLogger{level: logLevels[LogInfo]}}
+
+//SetLogger replaces the logger of the process, nil restores the default
+//logger that writes the events of level info and above with the log package
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{level: logLevels[LogInfo]}
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+	logger.Logger = l
+}
+
+//ApplyLogSettings sets the default logger of the process with the
+//level of settings, it replaces a logger set with SetLogger
+func ApplyLogSettings(settings LogSettings) error {
+	if err := checkLogSettings(settings); err != nil {
+		return err
+	}
+
+	SetLogger(stdLogger{level: logLevels[settings.Level]})
+	return nil
+}
+
+func currentLogger() Logger {
+	logger.RLock()
+	defer logger.RUnlock()
+	return logger.Logger
+}
+
+func logDebug(msg string, fields Fields) {
+	currentLogger().Debug(msg, fields)
+}
+
+func logInfo(msg string, fields Fields) {
+	currentLogger().Info(msg, fields)
+}
+
+func logWarn(msg string, fields Fields) {
+	currentLogger().Warn(msg, fields)
+}
+
+func logError(msg string, fields Fields) {
+	currentLogger().Error(msg, fields)
+}
@@ -0,0 +1,92 @@
+package redkeep_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type loggedEvent struct {
+	level  string
+	msg    string
+	fields Fields
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	events []loggedEvent
+}
+
+func (l *recordingLogger) record(level, msg string, fields Fields) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, loggedEvent{level: level, msg: msg, fields: fields})
+}
+
+func (l *recordingLogger) Debug(msg string, fields Fields) { l.record("debug", msg, fields) }
+func (l *recordingLogger) Info(msg string, fields Fields)  { l.record("info", msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields Fields)  { l.record("warn", msg, fields) }
+func (l *recordingLogger) Error(msg string, fields Fields) { l.record("error", msg, fields) }
+
+var _ = Describe("Logging", func() {
+	watches := []Watch{{
+		Name:                  "comments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username"},
+		TargetCollection:      "app.comments",
+		TargetNormalizedField: "meta.user",
+		TriggerReference:      "user",
+	}}
+	orders := append([]Watch{}, watches[0])
+	orders[0].Name, orders[0].TargetCollection = "orders", "app.orders"
+
+	AfterEach(func() {
+		SetLogger(nil)
+		log.SetOutput(os.Stderr)
+	})
+
+	It("will log events with their fields to the logger that is set", func() {
+		logger := &recordingLogger{}
+		SetLogger(logger)
+
+		//the agent does not tail, the backfill of the new watch fails
+		agent, _, _, _ := ControlledAgent(watches)
+		_, err := agent.ReloadWatches(orders, true)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(logger.events).To(HaveLen(1))
+		Expect(logger.events[0].level).To(Equal("warn"))
+		Expect(logger.events[0].msg).To(Equal("Reloaded watch not backfilled"))
+		Expect(logger.events[0].fields).To(Equal(Fields{"watch": "orders", "error": "The agent is not tailing"}))
+	})
+
+	It("will only log events of the configured level and above", func() {
+		output := &bytes.Buffer{}
+		log.SetOutput(output)
+
+		Expect(ApplyLogSettings(LogSettings{Level: LogError})).To(Succeed())
+		agent, _, _, _ := ControlledAgent(watches)
+		agent.ReloadWatches(orders, true)
+		Expect(output.String()).To(BeEmpty())
+
+		Expect(ApplyLogSettings(LogSettings{Level: LogWarn})).To(Succeed())
+		agent, _, _, _ = ControlledAgent(watches)
+		agent.ReloadWatches(orders, true)
+		Expect(strings.TrimSpace(output.String())).To(HaveSuffix("WARN Reloaded watch not backfilled error=The agent is not tailing watch=orders"))
+	})
+
+	It("will not accept an unknown level", func() {
+		Expect(ApplyLogSettings(LogSettings{Level: "verbose"})).To(MatchError("Unknown log level verbose, use debug, info, warn or error"))
+
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"log": { "level": "trace" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Unknown log level trace, use debug, info, warn or error"))
+	})
+})
@@ -0,0 +1,74 @@
+package redkeep
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+)
+
+//MetadataSettings place the internal collections of redkeep: checkpoints,
+//dead letters, diagnostics, orphans, reference frequencies and dry run
+//reports. Their collections may be configured without database, like
+//"dead_letters", they are then created in Database with Prefix in front
+//of their name. ConnectionURI connects to another cluster for all internal
+//collections, by default they are kept on the tracked cluster.
+type MetadataSettings struct {
+	ConnectionURI string `json:"connectionURI"`
+	Database      string `json:"database"`
+	Prefix        string `json:"prefix"`
+}
+
+func checkMetadataSettings(settings MetadataSettings) error {
+	if strings.Contains(settings.Database, ".") {
+		return errors.New("Metadata database must not contain a dot")
+	}
+
+	if settings.Prefix != "" && settings.Database == "" {
+		return errors.New("Metadata prefix needs a metadata database")
+	}
+
+	return nil
+}
+
+//metadataCollection places the collection name c in the metadata
+//database, collections with a database are kept
+func metadataCollection(settings MetadataSettings, c string) string {
+	if c == "" || settings.Database == "" || strings.Contains(c, ".") {
+		return c
+	}
+
+	return settings.Database + "." + settings.Prefix + c
+}
+
+//metadataCollections places the internal collections of config
+//in the metadata database
+func metadataCollections(config *Configuration) {
+	settings := config.Metadata
+	config.Checkpoint.Collection = metadataCollection(settings, config.Checkpoint.Collection)
+	config.DeadLetters.Collection = metadataCollection(settings, config.DeadLetters.Collection)
+	config.Diagnostics.Collection = metadataCollection(settings, config.Diagnostics.Collection)
+	config.Orphans.Collection = metadataCollection(settings, config.Orphans.Collection)
+	config.ReferenceCache.Frequencies = metadataCollection(settings, config.ReferenceCache.Frequencies)
+	if config.DryRun != nil {
+		dryRun := *config.DryRun
+		dryRun.Collection = metadataCollection(settings, dryRun.Collection)
+		config.DryRun = &dryRun
+	}
+}
+
+//DialMetadata connects to the cluster of the internal collections of c,
+//without metadata connection it returns session
+func DialMetadata(c Configuration, session *mgo.Session) (*mgo.Session, error) {
+	if c.Metadata.ConnectionURI == "" {
+		return session, nil
+	}
+
+	metadata, err := mgo.Dial(c.Metadata.ConnectionURI)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.SetMode(mgo.Strong, true)
+	return metadata, nil
+}
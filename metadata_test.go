@@ -0,0 +1,63 @@
+package redkeep_test
+
+import (
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metadata", func() {
+	It("places internal collections without database in the metadata database", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `
+			"metadata": { "database": "redkeep", "prefix": "eu_" },
+			"checkpoint": { "collection": "checkpoints" },
+			"deadLetters": { "collection": "dead_letters" },
+			"diagnostics": { "collection": "ops.unknown_operations" },
+			"orphans": { "collection": "orphans" },
+			"referenceCache": { "size": 10, "frequencies": "frequencies" },
+			"dryRun": { "collection": "intended_writes" },
+			"watches"`, 1)
+		loaded, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.Checkpoint.Collection).To(Equal("redkeep.eu_checkpoints"))
+		Expect(loaded.DeadLetters.Collection).To(Equal("redkeep.eu_dead_letters"))
+		Expect(loaded.Diagnostics.Collection).To(Equal("ops.unknown_operations"))
+		Expect(loaded.Orphans.Collection).To(Equal("redkeep.eu_orphans"))
+		Expect(loaded.ReferenceCache.Frequencies).To(Equal("redkeep.eu_frequencies"))
+		Expect(loaded.DryRun.Collection).To(Equal("redkeep.eu_intended_writes"))
+	})
+
+	It("places collections of built configurations", func() {
+		config, err := NewConfig().
+			Mongo("localhost:30000").
+			AddWatch(Watch{
+				TrackCollection:       "live.user",
+				TrackFields:           []string{"username"},
+				TargetCollection:      "live.comment",
+				TargetNormalizedField: "meta",
+				TriggerReference:      "user",
+			}).
+			Checkpoint(CheckpointSettings{Collection: "checkpoints"}).
+			Metadata(MetadataSettings{Database: "redkeep"}).
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Checkpoint.Collection).To(Equal("redkeep.checkpoints"))
+	})
+
+	It("checks the metadata settings", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"metadata": { "prefix": "eu_" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Metadata prefix needs a metadata database"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"metadata": { "database": "redkeep.meta" }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Metadata database must not contain a dot"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"deadLetters": { "collection": "dead_letters" }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Dead letter collection dead_letters must be database.collection"))
+	})
+})
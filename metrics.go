@@ -0,0 +1,200 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//names of the internal metrics of an agent
+const (
+	//MetricLagSeconds is the age of the last handled oplog entry,
+	//it is zero while the agent is caught up
+	MetricLagSeconds = "lag_seconds"
+	//MetricMigrationEntries counts skipped oplog entries of chunk migrations
+	MetricMigrationEntries = "migration_entries_total"
+	//MetricUnknownOperations counts oplog entries with unknown operation types
+	MetricUnknownOperations = "unknown_operations_total"
+	//MetricWrites counts successful writes to target collections
+	MetricWrites = "writes_total"
+	//MetricWriteFailures counts failed writes to target collections
+	MetricWriteFailures = "write_failures_total"
+	//MetricWriteLatency is the time from an oplog entry until the writes
+	//of a watch were done, per watch as quantiles 0.5, 0.95 and 0.99
+	MetricWriteLatency = "write_latency_seconds"
+	//MetricThrottledSeconds is the time writes waited for the rate limit of their tenant
+	MetricThrottledSeconds = "throttled_seconds_total"
+	//MetricVerifiedWrites counts writes whose target documents were read back
+	MetricVerifiedWrites = "verified_writes_total"
+	//MetricWriteDivergences counts target documents that did not have
+	//the written values when they were read back
+	MetricWriteDivergences = "write_divergences_total"
+	//MetricWriteConflicts counts application writes to the normalized
+	//field of targets that do not match the tracked document
+	MetricWriteConflicts = "write_conflicts_total"
+	//MetricPendingTargetUpdates is the number of queued updates waiting
+	//for their target document to be inserted
+	MetricPendingTargetUpdates = "pending_target_updates"
+	//MetricWriteRetries counts writes to targets that were sent again
+	//after a transient error, see Mongo.RetryWrites
+	MetricWriteRetries = "write_retries_total"
+	//MetricWorkerQueue is the number of oplog entries waiting for a worker
+	MetricWorkerQueue = "worker_queue_entries"
+	//MetricWorkers is the number of workers handling oplog entries
+	MetricWorkers = "workers"
+	//MetricDroppedEvents counts change events a sink had no room for
+	MetricDroppedEvents = "dropped_events_total"
+	//MetricBackfillDocuments counts the documents that were backfilled
+	MetricBackfillDocuments = "backfill_documents_total"
+	//MetricBackfillTotal is the estimated number of documents of all
+	//backfills, with MetricBackfillDocuments it shows their progress
+	MetricBackfillTotal = "backfill_estimated_documents_total"
+	//MetricOperations counts the oplog entries that were read by
+	//operation type, like operations_total{op="u"}
+	MetricOperations = "operations_total"
+	//MetricHandlerDuration is a histogram of the time it took
+	//to handle an oplog entry with all watches
+	MetricHandlerDuration = "handler_duration_seconds"
+	//MetricBatchFailures counts batches of batch sinks that failed
+	//and are written again with the next watermark
+	MetricBatchFailures = "batch_failures_total"
+	//MetricDeadLetters counts the failed changes that were stored as dead letters
+	MetricDeadLetters = "dead_letters_total"
+	//MetricBatchFactor scales the watermark interval and batch
+	//sizes while the agent lags, see AdaptiveBatchSettings
+	MetricBatchFactor = "batch_scale_factor"
+	//MetricReferenceCacheHits counts lookups of tracked documents
+	//answered by the reference cache
+	MetricReferenceCacheHits = "reference_cache_hits_total"
+	//MetricReferenceCacheMisses counts lookups of tracked documents
+	//that were read from the database
+	MetricReferenceCacheMisses = "reference_cache_misses_total"
+	//MetricPaused is 1 while reading the oplog is paused
+	MetricPaused = "paused"
+	//MetricHotKeySkipped counts the updates of hot keys that were skipped
+	MetricHotKeySkipped = "hot_key_skipped_updates_total"
+	//MetricHotKeyDebounced counts the updates of hot keys that were coalesced
+	MetricHotKeyDebounced = "hot_key_debounced_updates_total"
+	//MetricHotKeyQueue is the number of updates of hot keys waiting for the background fan-out
+	MetricHotKeyQueue = "hot_key_queue_updates"
+	//MetricDryRunWrites counts the intended writes a dry run reported
+	MetricDryRunWrites = "dry_run_writes_total"
+	//MetricOplogGapSeconds is the time between the start of the agent and
+	//the oldest oplog entry if the oplog rotated past the start, else zero
+	MetricOplogGapSeconds = "oplog_gap_seconds"
+	//MetricCacheInvalidations counts the writes to targets that invalidated caches
+	MetricCacheInvalidations = "cache_invalidations_total"
+	//MetricCacheInvalidationFailures counts the caches that could not be invalidated
+	MetricCacheInvalidationFailures = "cache_invalidation_failures_total"
+	//MetricLineageEvents counts the OpenLineage events that were sent
+	MetricLineageEvents = "lineage_events_total"
+	//MetricLineageFailures counts the OpenLineage events that could not be sent
+	MetricLineageFailures = "lineage_failures_total"
+	//MetricOrphanedReferences counts the lookups of referenced tracked
+	//documents that do not exist, also per watch
+	MetricOrphanedReferences = "orphaned_references_total"
+)
+
+//metricRegistry keeps counters and gauges of one agent,
+//all methods can be called on nil
+type metricRegistry struct {
+	sync.RWMutex
+	values map[string]float64
+}
+
+func newMetricRegistry() *metricRegistry {
+	return &metricRegistry{values: map[string]float64{}}
+}
+
+func (m *metricRegistry) add(name string, delta float64) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.values[name] += delta
+}
+
+func (m *metricRegistry) set(name string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.values[name] = value
+}
+
+func (m *metricRegistry) get(name string) float64 {
+	if m == nil {
+		return 0
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	return m.values[name]
+}
+
+//snapshot returns a copy of all values
+func (m *metricRegistry) snapshot() map[string]float64 {
+	result := map[string]float64{}
+	if m == nil {
+		return result
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	for name, value := range m.values {
+		result[name] = value
+	}
+
+	return result
+}
+
+//histogramBuckets are the upper bounds of the buckets of histograms in seconds
+var histogramBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//labeled is the name of metric with one label, like writes_total{watch="userComments"}
+func labeled(name, label, value string) string {
+	return fmt.Sprintf("%s{%s=%q}", name, label, value)
+}
+
+//observe adds value to the histogram name, its buckets count the
+//values up to their bound like prometheus histograms
+func (m *metricRegistry) observe(name string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	for _, bound := range histogramBuckets {
+		if value <= bound {
+			m.values[fmt.Sprintf("%s_bucket{le=\"%g\"}", name, bound)]++
+		}
+	}
+	m.values[name+`_bucket{le="+Inf"}`]++
+	m.values[name+"_sum"] += value
+	m.values[name+"_count"]++
+}
+
+//metricFamily is the name of metric without labels and histogram suffixes
+func metricFamily(metric string) (string, string) {
+	family := metric
+	if p := strings.Index(family, "{"); p >= 0 {
+		family = family[:p]
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(family, suffix) {
+			return strings.TrimSuffix(family, suffix), "histogram"
+		}
+	}
+
+	if strings.HasSuffix(family, "_total") {
+		return family, "counter"
+	}
+
+	return family, "gauge"
+}
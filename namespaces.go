@@ -0,0 +1,114 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//namespaceChange is a drop or rename of a collection or database,
+//from is only a database name for dropped databases
+type namespaceChange struct {
+	from string
+	to   string
+}
+
+//parseNamespaceChange reads drop, dropDatabase and renameCollection
+//commands of database db
+func parseNamespaceChange(db string, command map[string]interface{}) (namespaceChange, bool) {
+	if collection, ok := command["drop"].(string); ok {
+		return namespaceChange{from: db + "." + collection}, true
+	}
+
+	if _, ok := command["dropDatabase"]; ok {
+		return namespaceChange{from: db}, true
+	}
+
+	from, okFrom := command["renameCollection"].(string)
+	to, okTo := command["to"].(string)
+	if okFrom && okTo {
+		return namespaceChange{from: from, to: to}, true
+	}
+
+	return namespaceChange{}, false
+}
+
+func (c namespaceChange) affects(ns string) bool {
+	return ns == c.from || (!strings.Contains(c.from, ".") && strings.HasPrefix(ns, c.from+"."))
+}
+
+func (c namespaceChange) String() string {
+	if c.to != "" {
+		return fmt.Sprintf("%s was renamed to %s", c.from, c.to)
+	}
+
+	return c.from + " was dropped"
+}
+
+//applyNamespaceChange re-points the watches that follow renames and
+//removes the watches that stop on drops. It returns a message for
+//every affected watch by watch key.
+func (s *watchSet) applyNamespaceChange(c namespaceChange) map[string]string {
+	s.Lock()
+	defer s.Unlock()
+
+	messages := map[string]string{}
+	list := make([]Watch, 0, len(s.watches))
+	for _, w := range s.watches {
+		if !c.affects(w.TrackCollection) && !c.affects(w.TargetCollection) {
+			list = append(list, w)
+			continue
+		}
+
+		key := w.Key()
+		switch {
+		case c.to != "" && w.BehaviourSettings.FollowRenames:
+			//keep the key, hooks and transforms are registered by it
+			w.Name = key
+			if w.TrackCollection == c.from {
+				w.TrackCollection = c.to
+			}
+			if w.TargetCollection == c.from {
+				w.TargetCollection = c.to
+			}
+			messages[key] = c.String() + ", the watch follows"
+		case c.to == "" && w.BehaviourSettings.StopOnDrop:
+			messages[key] = c.String() + ", the watch was stopped"
+			continue
+		default:
+			messages[key] = c.String() + ", the watch was not changed"
+		}
+
+		list = append(list, w)
+	}
+	s.watches = list
+
+	return messages
+}
+
+//handleCommand handles command entries that change watched collections.
+//Drops and renames change the watches, entries before them are handled
+//with the old ones. An alert is recorded for every affected watch.
+func (t TailAgent) handleCommand(entry map[string]interface{}, workers *sync.WaitGroup) {
+	query, err := NewOplogQuery(entry)
+	if err != nil || query.OP() != "c" {
+		return
+	}
+
+	command, ok := entry["o"].(map[string]interface{})
+	if !ok || t.handleCollectionCommand(query.DB(), command) {
+		return
+	}
+
+	change, ok := parseNamespaceChange(query.DB(), command)
+	if !ok {
+		return
+	}
+
+	workers.Wait()
+	for key, message := range t.watches.applyNamespaceChange(change) {
+		t.events.record(EventAlert, key, message)
+	}
+	t.updateSchemaManifest()
+	t.lineage.reloadRuns(t.watches.list())
+}
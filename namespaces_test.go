@@ -0,0 +1,48 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespace changes", func() {
+	following := Watch{
+		TrackCollection:       "live.user",
+		TargetCollection:      "live.comment",
+		TargetNormalizedField: "user",
+		BehaviourSettings:     BehaviourSettings{FollowRenames: true, StopOnDrop: true},
+	}
+	static := Watch{Name: "invoices", TrackCollection: "billing.customer", TargetCollection: "billing.invoice"}
+
+	It("re-points watches that follow renames and keeps their key", func() {
+		watches, messages := ApplyNamespaceChange([]Watch{following, static}, "admin", map[string]interface{}{
+			"renameCollection": "live.user", "to": "live.member",
+		})
+
+		Expect(watches).To(HaveLen(2))
+		Expect(watches[0].TrackCollection).To(Equal("live.member"))
+		Expect(watches[0].Key()).To(Equal("live.user->live.comment.user"))
+		Expect(messages).To(Equal(map[string]string{
+			"live.user->live.comment.user": "live.user was renamed to live.member, the watch follows",
+		}))
+	})
+
+	It("stops watches on drops if configured", func() {
+		watches, messages := ApplyNamespaceChange([]Watch{following, static}, "live", map[string]interface{}{"drop": "comment"})
+		Expect(watches).To(Equal([]Watch{static}))
+		Expect(messages).To(HaveKeyWithValue("live.user->live.comment.user", "live.comment was dropped, the watch was stopped"))
+	})
+
+	It("reports dropped databases", func() {
+		watches, messages := ApplyNamespaceChange([]Watch{following, static}, "billing", map[string]interface{}{"dropDatabase": 1})
+		Expect(watches).To(HaveLen(2))
+		Expect(messages).To(Equal(map[string]string{"invoices": "billing was dropped, the watch was not changed"}))
+	})
+
+	It("ignores other commands", func() {
+		_, messages := ApplyNamespaceChange([]Watch{following}, "live", map[string]interface{}{"create": "user"})
+		Expect(messages).To(BeNil())
+	})
+})
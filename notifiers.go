@@ -0,0 +1,189 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	notifierTimeout        = 10 * time.Second
+	defaultPagerDutyURL    = "https://events.pagerduty.com/v2/enqueue"
+	pagerDutyDedupKeyStart = "redkeep-"
+)
+
+//WebhookNotifierSettings posts every notification as json to URL
+type WebhookNotifierSettings struct {
+	URL     string            `json:"url" validate:"required,url"`
+	Headers map[string]string `json:"headers"`
+}
+
+//SlackNotifierSettings posts to an incoming webhook of slack
+type SlackNotifierSettings struct {
+	WebhookURL string `json:"webhookURL" validate:"required,url"`
+}
+
+//PagerDutyNotifierSettings triggers and resolves incidents
+//with the events api v2, URL is optional. Severity overrides
+//the severity of the notifications.
+type PagerDutyNotifierSettings struct {
+	RoutingKey string `json:"routingKey" validate:"required,min=1"`
+	URL        string `json:"url"`
+	Severity   string `json:"severity"`
+}
+
+//SMTPNotifierSettings sends mails, Address is host:port.
+//Username and Password are optional and used with plain auth.
+type SMTPNotifierSettings struct {
+	Address  string   `json:"address" validate:"required,min=1"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from" validate:"required,min=1"`
+	To       []string `json:"to" validate:"required,min=1,dive,min=1"`
+}
+
+type webhookNotifier struct {
+	settings WebhookNotifierSettings
+	client   *http.Client
+}
+
+type slackNotifier struct {
+	settings SlackNotifierSettings
+	client   *http.Client
+}
+
+type pagerDutyNotifier struct {
+	settings PagerDutyNotifierSettings
+	client   *http.Client
+	source   string
+}
+
+type smtpNotifier struct {
+	settings SMTPNotifierSettings
+}
+
+//registerNotifier registers a notifier type whose options are
+//decoded into settings and validated before create is called
+func registerNotifier(name string, settings func() interface{}, create func(s interface{}) Notifier) {
+	RegisterNotifierType(name, func(options json.RawMessage) (Notifier, error) {
+		s := settings()
+		if err := json.Unmarshal(options, s); err != nil {
+			return nil, err
+		}
+
+		validate := validator.New(&validator.Config{TagName: "validate"})
+		if err := validate.Struct(s); err != nil {
+			return nil, err
+		}
+
+		return create(s), nil
+	})
+}
+
+func init() {
+	registerNotifier("webhook", func() interface{} { return &WebhookNotifierSettings{} }, func(s interface{}) Notifier {
+		return &webhookNotifier{*s.(*WebhookNotifierSettings), &http.Client{Timeout: notifierTimeout}}
+	})
+	registerNotifier("slack", func() interface{} { return &SlackNotifierSettings{} }, func(s interface{}) Notifier {
+		return &slackNotifier{*s.(*SlackNotifierSettings), &http.Client{Timeout: notifierTimeout}}
+	})
+	registerNotifier("pagerduty", func() interface{} { return &PagerDutyNotifierSettings{} }, func(s interface{}) Notifier {
+		settings := *s.(*PagerDutyNotifierSettings)
+		if settings.URL == "" {
+			settings.URL = defaultPagerDutyURL
+		}
+
+		source, _ := os.Hostname()
+		return &pagerDutyNotifier{settings, &http.Client{Timeout: notifierTimeout}, source}
+	})
+	registerNotifier("smtp", func() interface{} { return &SMTPNotifierSettings{} }, func(s interface{}) Notifier {
+		return &smtpNotifier{*s.(*SMTPNotifierSettings)}
+	})
+}
+
+func (w *webhookNotifier) Notify(n Notification) error {
+	return postJSON(w.client, w.settings.URL, w.settings.Headers, n)
+}
+
+func (s *slackNotifier) Notify(n Notification) error {
+	icon := ":rotating_light:"
+	switch {
+	case n.Resolved:
+		icon = ":white_check_mark:"
+	case n.Severity == "info":
+		icon = ":information_source:"
+	case n.Severity == "warning":
+		icon = ":warning:"
+	}
+
+	return postJSON(s.client, s.settings.WebhookURL, nil, map[string]string{
+		"text": fmt.Sprintf("%s redkeep %s: %s", icon, n.Condition, n.Message),
+	})
+}
+
+func (p *pagerDutyNotifier) Notify(n Notification) error {
+	action := "trigger"
+	if n.Resolved {
+		action = "resolve"
+	}
+
+	severity := p.settings.Severity
+	if severity == "" {
+		severity = n.Severity
+	}
+
+	if severity == "" {
+		severity = "error"
+	}
+
+	return postJSON(p.client, p.settings.URL, nil, map[string]interface{}{
+		"routing_key":  p.settings.RoutingKey,
+		"event_action": action,
+		"dedup_key":    pagerDutyDedupKeyStart + n.Condition,
+		"payload": map[string]interface{}{
+			"summary":        n.Message,
+			"source":         p.source,
+			"severity":       severity,
+			"timestamp":      n.Time.UTC().Format(time.RFC3339),
+			"component":      "redkeep",
+			"custom_details": n,
+		},
+	})
+}
+
+func (s *smtpNotifier) Notify(n Notification) error {
+	subject := fmt.Sprintf("[redkeep] %s %s", n.Severity, n.Condition)
+	if n.Resolved {
+		subject += " resolved"
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.settings.From,
+		"To: " + strings.Join(s.settings.To, ", "),
+		"Subject: " + subject,
+		"Date: " + n.Time.Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		n.Message,
+		"",
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if s.settings.Username != "" {
+		host, _, err := net.SplitHostPort(s.settings.Address)
+		if err != nil {
+			return err
+		}
+
+		auth = smtp.PlainAuth("", s.settings.Username, s.settings.Password, host)
+	}
+
+	return smtp.SendMail(s.settings.Address, auth, s.settings.From, s.settings.To, []byte(message))
+}
@@ -0,0 +1,296 @@
+package redkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultNotificationInterval = 30 * time.Second
+
+//Notification tells about an operational condition of the agent,
+//Condition is the name of the condition or alert rule.
+//Resolved is true once the condition is not met anymore.
+type Notification struct {
+	Condition string    `json:"condition"`
+	Severity  string    `json:"severity"`
+	Resolved  bool      `json:"resolved"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+//Notifier delivers notifications to humans
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+//NotifierFactory creates a notifier from the options of its configuration
+type NotifierFactory func(options json.RawMessage) (Notifier, error)
+
+//NotifierConfig configures one notifier, conditions refer to it by name
+type NotifierConfig struct {
+	Name    string          `json:"name" validate:"required,min=1"`
+	Type    string          `json:"type" validate:"required,min=1"`
+	Options json.RawMessage `json:"options"`
+}
+
+//ConditionSettings configures one condition, it is met
+//if the observed value is greater than Threshold
+type ConditionSettings struct {
+	Threshold float64  `json:"threshold"`
+	Notify    []string `json:"notify" validate:"required,min=1"`
+}
+
+//NotificationSettings configures notifiers and the conditions
+//that trigger them. Known conditions are:
+//lag (seconds behind the oplog), deadLetterGrowth and writeFailures
+//(growth per interval). Rules are evaluated on any metric.
+type NotificationSettings struct {
+	Interval   Duration                     `json:"interval"`
+	Notifiers  []NotifierConfig             `json:"notifiers" validate:"dive"`
+	Conditions map[string]ConditionSettings `json:"conditions" validate:"dive"`
+	Rules      []AlertRule                  `json:"rules" validate:"dive"`
+}
+
+//conditionDefinition maps a condition to a metric, growth conditions
+//observe the increase of the metric since the last evaluation
+type conditionDefinition struct {
+	metric  string
+	growth  bool
+	message string
+}
+
+var conditionDefinitions = map[string]conditionDefinition{
+	"lag":              {MetricLagSeconds, false, "Agent is %.0f seconds behind the oplog (threshold %.0f)"},
+	"deadLetterGrowth": {MetricDeadLetters, true, "%.0f dead letters were stored (threshold %.0f)"},
+	"writeFailures":    {MetricWriteFailures, true, "%.0f writes failed (threshold %.0f)"},
+}
+
+var (
+	notifierTypesMutex sync.RWMutex
+	notifierTypes      = map[string]NotifierFactory{}
+)
+
+//RegisterNotifierType makes a notifier available under name
+//for the configuration
+func RegisterNotifierType(name string, factory NotifierFactory) {
+	notifierTypesMutex.Lock()
+	defer notifierTypesMutex.Unlock()
+	notifierTypes[name] = factory
+}
+
+func getNotifierFactory(name string) (NotifierFactory, bool) {
+	notifierTypesMutex.RLock()
+	defer notifierTypesMutex.RUnlock()
+	factory, ok := notifierTypes[name]
+	return factory, ok
+}
+
+//NewNotifier creates a notifier from the given configuration
+func NewNotifier(c NotifierConfig) (Notifier, error) {
+	factory, ok := getNotifierFactory(c.Type)
+	if !ok {
+		return nil, fmt.Errorf("Unknown notifier type %s", c.Type)
+	}
+
+	return factory(c.Options)
+}
+
+//checkNotificationSettings verifies all names used in the settings
+func checkNotificationSettings(s NotificationSettings) error {
+	names := map[string]bool{}
+	for _, n := range s.Notifiers {
+		if _, ok := getNotifierFactory(n.Type); !ok {
+			return fmt.Errorf("Unknown notifier type %s", n.Type)
+		}
+
+		names[n.Name] = true
+	}
+
+	for condition, settings := range s.Conditions {
+		if _, ok := conditionDefinitions[condition]; !ok {
+			return fmt.Errorf("Unknown condition %s", condition)
+		}
+
+		for _, name := range settings.Notify {
+			if !names[name] {
+				return fmt.Errorf("Condition %s uses unknown notifier %s", condition, name)
+			}
+		}
+	}
+
+	rules := map[string]bool{}
+	for _, r := range s.Rules {
+		if _, ok := s.Conditions[r.Name]; ok || rules[r.Name] {
+			return fmt.Errorf("Rule %s is defined twice", r.Name)
+		}
+		rules[r.Name] = true
+
+		if err := checkAlertRule(r); err != nil {
+			return err
+		}
+
+		for _, name := range r.Notify {
+			if !names[name] {
+				return fmt.Errorf("Rule %s uses unknown notifier %s", r.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+//notificationCenter evaluates conditions and rules against the metrics
+//and notifies once a rule fires and once it is resolved
+type notificationCenter struct {
+	settings  NotificationSettings
+	metrics   *metricRegistry
+	notifiers map[string]Notifier
+	previous  map[string]float64
+	alerts    []*alertState
+	events    *eventLog
+	quit      chan bool
+	done      chan bool
+}
+
+func newNotificationCenter(settings NotificationSettings, metrics *metricRegistry, events *eventLog) (*notificationCenter, error) {
+	if err := checkNotificationSettings(settings); err != nil {
+		return nil, err
+	}
+
+	if settings.Interval.Duration <= 0 {
+		settings.Interval.Duration = defaultNotificationInterval
+	}
+
+	center := &notificationCenter{
+		settings:  settings,
+		metrics:   metrics,
+		events:    events,
+		notifiers: map[string]Notifier{},
+		previous:  metrics.snapshot(),
+	}
+
+	for name, condition := range settings.Conditions {
+		center.alerts = append(center.alerts, &alertState{rule: conditionRule(name, condition).withDefaults()})
+	}
+
+	for _, r := range settings.Rules {
+		center.alerts = append(center.alerts, &alertState{rule: r.withDefaults()})
+	}
+
+	for _, c := range settings.Notifiers {
+		notifier, err := NewNotifier(c)
+		if err != nil {
+			return nil, err
+		}
+
+		center.notifiers[c.Name] = notifier
+	}
+
+	return center, nil
+}
+
+//evaluate checks all rules once
+func (c *notificationCenter) evaluate(now time.Time) {
+	current := c.metrics.snapshot()
+
+	for _, a := range c.alerts {
+		value := current[a.rule.Metric]
+		if a.rule.Rate {
+			value -= c.previous[a.rule.Metric]
+		}
+
+		if !a.update(value, now) {
+			continue
+		}
+
+		n := Notification{
+			Condition: a.rule.Name,
+			Severity:  a.rule.Severity,
+			Resolved:  !a.firing,
+			Value:     value,
+			Threshold: a.rule.Threshold,
+			Message:   fmt.Sprintf(a.rule.Message, value, a.rule.Threshold),
+			Time:      now,
+		}
+
+		if n.Resolved {
+			n.Message = "Resolved: " + n.Message
+		}
+
+		c.events.record(EventAlert, "", n.Message)
+		c.notify(a.rule.Notify, n)
+	}
+
+	c.previous = current
+}
+
+func (c *notificationCenter) notify(names []string, n Notification) {
+	for _, name := range names {
+		if err := c.notifiers[name].Notify(n); err != nil {
+			logError("Notifier failed", Fields{"notifier": name, "condition": n.Condition}.withError(err))
+		}
+	}
+}
+
+func (c *notificationCenter) start() {
+	c.quit = make(chan bool)
+	c.done = make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(c.settings.Interval.Duration)
+		defer ticker.Stop()
+		defer close(c.done)
+
+		for {
+			select {
+			case <-c.quit:
+				return
+			case now := <-ticker.C:
+				c.evaluate(now)
+			}
+		}
+	}()
+}
+
+func (c *notificationCenter) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+//postJSON sends value as json body and fails on non 2xx answers
+func postJSON(client *http.Client, url string, headers map[string]string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s answered with %s: %s", url, response.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
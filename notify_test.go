@@ -0,0 +1,119 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var notificationConfig = `
+{
+  "mongo": { "connectionURI": "localhost:30000" },
+  "watches": [
+    {
+      "trackCollection": "live.user",
+      "trackFields": ["username"],
+      "targetCollection": "live.comment",
+      "targetNormalizedField": "meta",
+      "triggerReference": "user"
+    }
+  ],
+  "notifications": {
+    "notifiers": [ { "name": "ops", "type": "slack", "options": { "webhookURL": "http://localhost/hook" } } ],
+    %s
+  }
+}`
+
+var _ = Describe("Notifications", func() {
+	var (
+		server *httptest.Server
+		bodies chan []byte
+	)
+
+	BeforeEach(func() {
+		bodies = make(chan []byte, 1)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			bodies <- data
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("configuration", func() {
+		It("accepts known conditions", func() {
+			_, err := NewConfiguration([]byte(fmt.Sprintf(notificationConfig, `"conditions": { "lag": { "threshold": 60, "notify": ["ops"] } }`)))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects unknown conditions", func() {
+			_, err := NewConfiguration([]byte(fmt.Sprintf(notificationConfig, `"conditions": { "sunshine": { "notify": ["ops"] } }`)))
+			Expect(err).To(MatchError("Unknown condition sunshine"))
+		})
+
+		It("accepts rules on any metric", func() {
+			_, err := NewConfiguration([]byte(fmt.Sprintf(notificationConfig,
+				`"rules": [{ "name": "stalled", "metric": "lag_seconds", "operator": ">=", "threshold": 600, "for": "5m", "severity": "critical", "notify": ["ops"] }]`)))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects rules with unknown operators", func() {
+			_, err := NewConfiguration([]byte(fmt.Sprintf(notificationConfig,
+				`"rules": [{ "name": "odd", "metric": "lag_seconds", "operator": "~", "notify": ["ops"] }]`)))
+			Expect(err).To(MatchError("Unknown operator ~ in rule odd"))
+		})
+
+		It("rejects unknown notifiers", func() {
+			_, err := NewConfiguration([]byte(fmt.Sprintf(notificationConfig, `"conditions": { "writeFailures": { "notify": ["devs"] } }`)))
+			Expect(err).To(MatchError("Condition writeFailures uses unknown notifier devs"))
+		})
+	})
+
+	It("posts notifications to webhooks", func() {
+		notifier, err := NewNotifier(NotifierConfig{
+			Name:    "hook",
+			Type:    "webhook",
+			Options: json.RawMessage(`{"url": "` + server.URL + `"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(notifier.Notify(Notification{Condition: "lag", Value: 90, Threshold: 60, Time: time.Now()})).To(Succeed())
+
+		var n Notification
+		Expect(json.Unmarshal(<-bodies, &n)).To(Succeed())
+		Expect(n.Condition).To(Equal("lag"))
+		Expect(n.Value).To(Equal(90.0))
+	})
+
+	It("triggers and resolves pagerduty incidents per condition", func() {
+		notifier, err := NewNotifier(NotifierConfig{
+			Name:    "pager",
+			Type:    "pagerduty",
+			Options: json.RawMessage(`{"routingKey": "key", "url": "` + server.URL + `"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(notifier.Notify(Notification{Condition: "writeFailures", Resolved: true, Message: "ok"})).To(Succeed())
+
+		var event map[string]interface{}
+		Expect(json.Unmarshal(<-bodies, &event)).To(Succeed())
+		Expect(event["routing_key"]).To(Equal("key"))
+		Expect(event["event_action"]).To(Equal("resolve"))
+		Expect(event["dedup_key"]).To(Equal("redkeep-writeFailures"))
+	})
+
+	It("validates notifier options", func() {
+		_, err := NewNotifier(NotifierConfig{Name: "mail", Type: "smtp", Options: json.RawMessage(`{"address": "localhost:25"}`)})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,138 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//delete policies of a watch, see BehaviourSettings
+const (
+	//OnDeleteIgnore keeps the targets unchanged, it is the default
+	OnDeleteIgnore = "ignore"
+	//OnDeleteUnset removes the normalized field from the targets
+	OnDeleteUnset = "unset"
+	//OnDeleteNullify sets the tracked fields of the targets to null
+	OnDeleteNullify = "nullify"
+	//OnDeleteDelete removes the targets
+	OnDeleteDelete = "delete"
+)
+
+var onDeletePolicies = map[string]bool{
+	"":              true,
+	OnDeleteIgnore:  true,
+	OnDeleteUnset:   true,
+	OnDeleteNullify: true,
+	OnDeleteDelete:  true,
+}
+
+func checkOnDelete(w Watch) error {
+	policy := w.BehaviourSettings.OnDelete
+	if !onDeletePolicies[policy] {
+		return fmt.Errorf("Unknown delete policy %s of watch %s, use %s, %s, %s or %s", policy, w.Key(),
+			OnDeleteIgnore, OnDeleteUnset, OnDeleteNullify, OnDeleteDelete)
+	}
+
+	if w.BehaviourSettings.ReferenceArray && onDeletePolicy(w) == OnDeleteDelete {
+		return errors.New("Reference arrays can not delete their targets")
+	}
+
+	return nil
+}
+
+//onDeletePolicy is the delete policy of w, cascadeDelete without a
+//policy deletes the targets
+func onDeletePolicy(w Watch) string {
+	if w.BehaviourSettings.OnDelete != "" {
+		return w.BehaviourSettings.OnDelete
+	}
+
+	if w.BehaviourSettings.CascadeDelete {
+		return OnDeleteDelete
+	}
+
+	return OnDeleteIgnore
+}
+
+//deleteQuery is the update of the targets of w for the unset and nullify
+//policies, it is nil for the other policies
+func deleteQuery(w Watch) bson.M {
+	var query bson.M
+	switch onDeletePolicy(w) {
+	case OnDeleteUnset:
+		query = bson.M{"$unset": bson.M{w.TargetNormalizedField: ""}}
+	case OnDeleteNullify:
+		nulls := bson.M{}
+		for _, field := range w.TrackFields {
+			nulls[w.TargetNormalizedField+"."+w.targetField(field)] = nil
+		}
+		query = bson.M{"$set": nulls}
+	default:
+		return nil
+	}
+
+	if w.BehaviourSettings.ReferenceArray {
+		return elementQuery(w, query, "$")
+	}
+
+	return query
+}
+
+func (c changeTracker) HandleRemove(w Watch, command map[string]interface{}, selector map[string]interface{}) {
+	if err := c.remove(w, command, selector); err != nil {
+		c.deadLetters.add(w, "d", w.TrackCollection, command, selector, 0, err)
+	}
+}
+
+//remove applies the delete policy of w to the targets of the removed
+//tracked document selector, it returns the error of the write
+func (c changeTracker) remove(w Watch, command map[string]interface{}, selector map[string]interface{}) error {
+	policy := onDeletePolicy(w)
+	refID, ok := selector["_id"]
+	if policy == OnDeleteIgnore || !ok {
+		return nil
+	}
+
+	session, done := c.useSession()
+	defer done()
+	p := strings.Index(w.TargetCollection, ".")
+	collection := session.DB(w.TargetCollection[:p]).C(w.TargetCollection[p+1:])
+	selectQuery := bson.M{w.TriggerReference + ".$id": refID}
+	query := deleteQuery(w)
+	if err := c.hooks.beforeWrite(w, command, query); err != nil {
+		logInfo("Write skipped by hook", watchFields(w).withError(err))
+		return nil
+	}
+
+	c.builds.wait(w)
+	c.tenants.wait(w.Tenant)
+	err := errInjectedFault
+	if !c.chaos.dropWrite() {
+		intended := IntendedWrite{Namespace: collection.FullName, Selector: selectQuery, Update: query, Multi: true, Remove: policy == OnDeleteDelete}
+		err = c.write(w, session, intended, func() (err error) {
+			if policy == OnDeleteDelete {
+				_, err = collection.RemoveAll(selectQuery)
+				return err
+			}
+
+			_, err = collection.UpdateAll(selectQuery, query)
+			return err
+		})
+	}
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	c.hooks.afterWrite(w, command, query, err)
+	c.countWrite(w, err)
+	if err != nil {
+		c.events.record(EventError, w.Key(), "Delete policy "+policy+" of "+w.TargetCollection+" failed: "+err.Error())
+		logError("Query could not be executed successfully", watchFields(w).withError(err))
+		return err
+	}
+
+	c.invalidateCaches(w, refID, nil, query)
+	return nil
+}
@@ -0,0 +1,94 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Delete policies", func() {
+	w := Watch{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"name", "address.city"},
+		TargetCollection:      "app.comments",
+		TargetNormalizedField: "meta",
+		TriggerReference:      "user",
+	}
+
+	withPolicy := func(w Watch, policy string) Watch {
+		w.BehaviourSettings.OnDelete = policy
+		return w
+	}
+
+	intended := func(w Watch) map[string]interface{} {
+		output, err := DryRunRemove(w, "1")
+		Expect(err).ToNot(HaveOccurred())
+		if output == "" {
+			return nil
+		}
+
+		write := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(output), &write)).To(Succeed())
+		return write
+	}
+
+	It("will unset the normalized field", func() {
+		Expect(DeleteQuery(withPolicy(w, OnDeleteUnset))).To(Equal(bson.M{"$unset": bson.M{"meta": ""}}))
+
+		write := intended(withPolicy(w, OnDeleteUnset))
+		Expect(write["selector"]).To(Equal(map[string]interface{}{"user.$id": "1"}))
+		Expect(write["update"]).To(Equal(map[string]interface{}{"$unset": map[string]interface{}{"meta": ""}}))
+		Expect(write["multi"]).To(BeTrue())
+		Expect(write).ToNot(HaveKey("remove"))
+	})
+
+	It("will set the tracked fields to null", func() {
+		Expect(DeleteQuery(withPolicy(w, OnDeleteNullify))).To(Equal(bson.M{"$set": bson.M{"meta.name": nil, "meta.address.city": nil}}))
+
+		reference := withPolicy(w, OnDeleteNullify)
+		reference.BehaviourSettings.ReferenceArray = true
+		Expect(DeleteQuery(reference)).To(Equal(bson.M{"$set": bson.M{"user.$.meta.name": nil, "user.$.meta.address.city": nil}}))
+	})
+
+	It("will delete the targets", func() {
+		Expect(DeleteQuery(withPolicy(w, OnDeleteDelete))).To(BeNil())
+
+		write := intended(withPolicy(w, OnDeleteDelete))
+		Expect(write["selector"]).To(Equal(map[string]interface{}{"user.$id": "1"}))
+		Expect(write["remove"]).To(BeTrue())
+
+		cascade := w
+		cascade.BehaviourSettings.CascadeDelete = true
+		Expect(intended(cascade)["remove"]).To(BeTrue())
+	})
+
+	It("will keep the targets by default", func() {
+		Expect(intended(w)).To(BeNil())
+		Expect(intended(withPolicy(w, OnDeleteIgnore))).To(BeNil())
+
+		cascade := withPolicy(w, OnDeleteIgnore)
+		cascade.BehaviourSettings.CascadeDelete = true
+		Expect(intended(cascade)).To(BeNil())
+	})
+
+	It("will validate the policy", func() {
+		config := strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "behaviourSettings": { "onDelete": "drop" }`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError(ContainSubstring("Unknown delete policy drop of watch")))
+
+		config = strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "behaviourSettings": { "onDelete": "delete", "referenceArray": true }`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Reference arrays can not delete their targets"))
+
+		config = strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "behaviourSettings": { "onDelete": "nullify" }`, 1)
+		parsed, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Watches[0].BehaviourSettings.OnDelete).To(Equal(OnDeleteNullify))
+	})
+})
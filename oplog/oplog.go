@@ -0,0 +1,17 @@
+//Package oplog has the oplog entries and change events of redkeep
+//agents. Its types are aliases of the ones in package redkeep, so both
+//can be mixed.
+package oplog
+
+import "github.com/manyminds/redkeep"
+
+//ChangeEvent describes one change of a tracked document that matched a watch
+type ChangeEvent = redkeep.ChangeEvent
+
+//Query represents an oplog entry
+type Query = redkeep.Query
+
+//NewQuery generates a query from an oplog entry
+func NewQuery(dataset map[string]interface{}) (Query, error) {
+	return redkeep.NewOplogQuery(dataset)
+}
@@ -0,0 +1,147 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//orderings of the handling of oplog entries, see Configuration.Ordering
+const (
+	//OrderingPartitioned handles the entries of different partitions
+	//concurrently, see PartitionSettings. It is the default.
+	OrderingPartitioned = "partitioned"
+	//OrderingStrict handles one entry after another in the tailing
+	//goroutine, sinks acknowledge every entry before the next is read
+	OrderingStrict = "strict"
+	//strictMaxRetryPause bounds the pause before a sink gets an entry again
+	strictMaxRetryPause = 10 * time.Second
+)
+
+//strictRetryPause is the first pause before a sink gets an entry again
+var strictRetryPause = 100 * time.Millisecond
+
+func checkOrdering(config Configuration) error {
+	switch config.Ordering {
+	case "", OrderingPartitioned:
+		return nil
+	case OrderingStrict:
+	default:
+		return fmt.Errorf("Unknown ordering %s, use %s or %s", config.Ordering, OrderingPartitioned, OrderingStrict)
+	}
+
+	if config.Workers.Count > 0 {
+		return errors.New("Strict ordering handles the oplog without workers")
+	}
+
+	if config.CatchUp != "" {
+		return errors.New("Strict ordering can not catch up newest first")
+	}
+
+	if config.Source == SourceChangeStream {
+		return errors.New("Strict ordering needs the oplog source")
+	}
+
+	if config.Mongo.Sharded {
+		return errors.New("Strict ordering can not tail shards concurrently")
+	}
+
+	if config.HotKeys.Size > 0 || len(config.HotKeys.Strategies) > 0 {
+		return errors.New("Strict ordering can not defer the updates of hot keys")
+	}
+
+	for _, s := range config.Sinks {
+		if s.Spool != nil {
+			return fmt.Errorf("Sink %s spools its events, strict ordering needs sinks that acknowledge them", s.Type)
+		}
+	}
+
+	return nil
+}
+
+//eventCollector keeps the events of one entry until the sinks
+//acknowledged them
+type eventCollector struct {
+	events []ChangeEvent
+}
+
+func (c *eventCollector) Send(e ChangeEvent) error {
+	c.events = append(c.events, e)
+	return nil
+}
+
+func (c *eventCollector) Close() error {
+	return nil
+}
+
+//handleStrict handles entry in the tailing goroutine. Every sink has to
+//take the events of entry and batch sinks have to commit them before the
+//next entry is read, failures are retried until ctx is done. The
+//watermark only passes entry once it is acknowledged.
+func (t TailAgent) handleStrict(ctx context.Context, entry map[string]interface{}, ts bson.MongoTimestamp) error {
+	started := time.Now()
+	collector := &eventCollector{}
+	events := &sinkDispatcher{sinks: []Sink{collector}, filters: []*EventFilter{nil}}
+	analyzeResult(entry, t.watches.active(), t.tracker, events, t.unknown, t.latencies)
+	t.metrics.observe(MetricHandlerDuration, time.Since(started).Seconds())
+
+	if err := t.sinks.acknowledge(ctx, collector.events); err != nil {
+		return err
+	}
+
+	t.watermarks.end(ts)
+	return nil
+}
+
+//acknowledge sends events to every sink that wants them and commits the
+//batches. A sink that fails gets the same event again after a pause, the
+//sinks that took it already do not, until it succeeds or ctx is done.
+func (d *sinkDispatcher) acknowledge(ctx context.Context, events []ChangeEvent) error {
+	d.RLock()
+	defer d.RUnlock()
+	for i, s := range d.sinks {
+		for _, e := range events {
+			if filter := d.filters[i]; filter != nil && !filter.matches(e) {
+				continue
+			}
+
+			if err := untilAcknowledged(ctx, func() error { return s.Send(e) }); err != nil {
+				return err
+			}
+		}
+
+		if batch, ok := s.(*batchSink); ok {
+			if err := untilAcknowledged(ctx, batch.flush); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//untilAcknowledged calls send until it succeeds, the pause between the
+//tries doubles from strictRetryPause up to strictMaxRetryPause
+func untilAcknowledged(ctx context.Context, send func() error) error {
+	pause := strictRetryPause
+	for {
+		err := send()
+		if err == nil {
+			return nil
+		}
+
+		logWarn("Sink did not acknowledge the entry, retrying", Fields{"pause": pause}.withError(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+
+		if pause *= 2; pause > strictMaxRetryPause {
+			pause = strictMaxRetryPause
+		}
+	}
+}
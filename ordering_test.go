@@ -0,0 +1,132 @@
+package redkeep_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//flakySink fails the first failures sends
+type flakySink struct {
+	failures int
+	events   []ChangeEvent
+}
+
+func (s *flakySink) Send(e ChangeEvent) error {
+	if s.failures != 0 {
+		s.failures--
+		return errors.New("not now")
+	}
+
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+//flakyBatchSink fails the first failures commits
+type flakyBatchSink struct {
+	flakySink
+	pending []ChangeEvent
+}
+
+func (s *flakyBatchSink) Begin() error {
+	s.pending = nil
+	return nil
+}
+
+func (s *flakyBatchSink) Add(e ChangeEvent) error {
+	s.pending = append(s.pending, e)
+	return nil
+}
+
+func (s *flakyBatchSink) Commit() error {
+	for _, e := range s.pending {
+		if err := s.flakySink.Send(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *flakyBatchSink) Rollback() error {
+	s.pending = nil
+	return nil
+}
+
+var _ = Describe("Ordering", func() {
+	configure := func(settings string) error {
+		_, err := NewConfiguration([]byte(strings.Replace(templateForTestsConfig, `"watches"`, settings+`, "watches"`, 1)))
+		return err
+	}
+
+	It("accepts strict ordering", func() {
+		Expect(configure(`"ordering": "strict"`)).To(Succeed())
+		Expect(configure(`"ordering": "partitioned", "workers": { "count": 4 }`)).To(Succeed())
+	})
+
+	It("rejects unknown orderings", func() {
+		Expect(configure(`"ordering": "random"`)).To(MatchError("Unknown ordering random, use partitioned or strict"))
+	})
+
+	Context("with strict ordering", func() {
+		watches := []Watch{{
+			TrackCollection:       "app.user",
+			TrackFields:           []string{"name"},
+			TargetCollection:      "app.comment",
+			TargetNormalizedField: "meta",
+			TriggerReference:      "user",
+		}}
+		entry := map[string]interface{}{
+			"ts": bson.MongoTimestamp(time.Now().Unix() << 32),
+			"op": "i",
+			"ns": "app.user",
+			"o":  map[string]interface{}{"_id": bson.NewObjectId(), "name": "alice"},
+		}
+
+		It("retries failed sinks until every sink acknowledged the entry", func() {
+			healthy, flaky, batch := &flakySink{}, &flakySink{failures: 2}, &flakyBatchSink{flakySink: flakySink{failures: 1}}
+			err := DispatchStrictly(context.Background(), watches, []Sink{healthy, flaky, batch}, entry, time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(healthy.events).To(HaveLen(1))
+			Expect(flaky.events).To(HaveLen(1))
+			Expect(flaky.failures).To(BeZero())
+			Expect(batch.events).To(HaveLen(1))
+			Expect(batch.events[0].Fields).To(Equal(map[string]interface{}{"name": "alice"}))
+		})
+
+		It("gives up on an unacknowledged entry once the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			broken := &flakySink{failures: -1}
+			err := DispatchStrictly(ctx, watches, []Sink{broken}, entry, time.Millisecond)
+			Expect(err).To(Equal(context.DeadlineExceeded))
+			Expect(broken.events).To(BeEmpty())
+		})
+	})
+
+	It("rejects settings that handle changes in the background", func() {
+		Expect(configure(`"ordering": "strict", "workers": { "count": 4 }`)).
+			To(MatchError("Strict ordering handles the oplog without workers"))
+		Expect(configure(`"ordering": "strict", "catchUp": "newestFirst"`)).
+			To(MatchError("Strict ordering can not catch up newest first"))
+		Expect(configure(`"ordering": "strict", "source": "changestream"`)).
+			To(MatchError("Strict ordering needs the oplog source"))
+		Expect(configure(`"ordering": "strict", "hotKeys": { "size": 10 }`)).
+			To(MatchError("Strict ordering can not defer the updates of hot keys"))
+		Expect(configure(`"ordering": "strict", "sinks": [{ "type": "sql", "spool": { "directory": "/var/spool/redkeep" } }]`)).
+			To(MatchError("Sink sql spools its events, strict ordering needs sinks that acknowledge them"))
+	})
+})
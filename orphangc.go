@@ -0,0 +1,168 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//orphanCollectBatch is the number of referenced ids looked up at once
+const orphanCollectBatch = 500
+
+//OrphanCollection is the result of CollectOrphanedTargets for a watch.
+//Missing are the referenced tracked documents that do not exist, Targets
+//the targets the delete policy was applied to.
+type OrphanCollection struct {
+	Watch   string `json:"watch"`
+	Policy  string `json:"policy"`
+	Checked int    `json:"checked"`
+	Missing int    `json:"missing"`
+	Targets int    `json:"targets"`
+	DryRun  bool   `json:"dryRun"`
+}
+
+//targetReferences returns the ids of the tracked documents of w a target
+//document references, reference arrays can reference several. Like on
+//inserts, references without a database are to the database of the target.
+func targetReferences(w Watch, document map[string]interface{}) []interface{} {
+	p := strings.Index(w.TargetCollection, ".")
+	references := []interface{}{GetValue(w.TriggerReference, document)}
+	if array, ok := references[0].([]interface{}); ok {
+		references = array
+	}
+
+	ids := []interface{}{}
+	for _, reference := range references {
+		ref, ok := getReference(reference, w.TargetCollection[:p])
+		if ok && ref.Database+"."+ref.Collection == w.TrackCollection {
+			ids = append(ids, ref.Id)
+		}
+	}
+
+	return ids
+}
+
+//CollectOrphanedTargets applies the delete policy of w to the targets
+//whose tracked document was deleted, like the agent would have if it had
+//seen the delete. It cleans up targets from before redkeep tailed the
+//oplog. With dryRun the targets are only counted. It stops when ctx is done.
+func CollectOrphanedTargets(ctx context.Context, session *mgo.Session, w Watch, dryRun bool) (OrphanCollection, error) {
+	tracker := &changeTracker{session: session, hooks: newHookRegistry()}
+	return collectOrphans(ctx, session, tracker, w, dryRun)
+}
+
+//CollectOrphanedTargets works like the function of the same name for the
+//watch with key and writes with the tracker of the agent: its hooks,
+//retries, dry run, cache invalidation and metrics are included
+func (t *TailAgent) CollectOrphanedTargets(ctx context.Context, key string, dryRun bool) (OrphanCollection, error) {
+	tracker, ok := t.tracker.(*changeTracker)
+	if !ok || t.session == nil {
+		return OrphanCollection{}, errors.New("The agent is not connected")
+	}
+
+	w, err := t.watch(key)
+	if err != nil {
+		return OrphanCollection{}, err
+	}
+
+	session := t.session.Copy()
+	defer session.Close()
+	return collectOrphans(ctx, session, tracker, w, dryRun)
+}
+
+//collectOrphans reads the targets and tracked documents of w with session,
+//the delete policy is applied by tracker like for a tailed delete
+func collectOrphans(ctx context.Context, session *mgo.Session, tracker *changeTracker, w Watch, dryRun bool) (OrphanCollection, error) {
+	result := OrphanCollection{Watch: w.Key(), Policy: onDeletePolicy(w), DryRun: dryRun}
+	if result.Policy == OnDeleteIgnore {
+		return result, fmt.Errorf("Watch %s ignores deletes, orphaned targets are kept", w.Key())
+	}
+
+	p := strings.Index(w.TargetCollection, ".")
+	targets := session.DB(w.TargetCollection[:p]).C(w.TargetCollection[p+1:])
+	p = strings.Index(w.TrackCollection, ".")
+	tracked := session.DB(w.TrackCollection[:p]).C(w.TrackCollection[p+1:])
+
+	seen := map[interface{}]bool{}
+	batch := []interface{}{}
+	collect := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		existing := map[interface{}]bool{}
+		iter := tracked.Find(bson.M{"_id": bson.M{"$in": batch}}).Select(bson.M{"_id": 1}).Iter()
+		document := map[string]interface{}{}
+		for iter.Next(&document) {
+			existing[document["_id"]] = true
+			document = map[string]interface{}{}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+
+		result.Checked += len(batch)
+		for _, id := range batch {
+			if existing[id] {
+				continue
+			}
+
+			result.Missing++
+			changed, err := collectOrphanedTargets(targets, tracker, w, id, dryRun)
+			result.Targets += changed
+			if err != nil {
+				return err
+			}
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := targets.Find(nil).Select(bson.M{w.TriggerReference: 1}).Iter()
+	document := map[string]interface{}{}
+	for iter.Next(&document) {
+		if err := ctx.Err(); err != nil {
+			iter.Close()
+			return result, err
+		}
+
+		for _, id := range targetReferences(w, document) {
+			if !seen[id] {
+				seen[id] = true
+				batch = append(batch, id)
+			}
+		}
+
+		if len(batch) >= orphanCollectBatch {
+			if err := collect(); err != nil {
+				iter.Close()
+				return result, err
+			}
+		}
+		document = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return result, err
+	}
+
+	return result, collect()
+}
+
+//collectOrphanedTargets lets tracker apply the delete policy of w to the
+//targets of the deleted tracked document with id, it returns the number
+//of targets that were found
+func collectOrphanedTargets(targets *mgo.Collection, tracker *changeTracker, w Watch, id interface{}, dryRun bool) (int, error) {
+	count, err := targets.Find(bson.M{w.TriggerReference + ".$id": id}).Count()
+	if err != nil || dryRun || count == 0 {
+		return count, err
+	}
+
+	deleted := map[string]interface{}{"_id": id}
+	return count, tracker.remove(w, deleted, deleted)
+}
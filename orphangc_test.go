@@ -0,0 +1,60 @@
+package redkeep_test
+
+import (
+	"context"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Orphaned target collection", func() {
+	w := Watch{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"name"},
+		TargetCollection:      "app.comments",
+		TargetNormalizedField: "meta",
+		TriggerReference:      "user",
+	}
+
+	It("reads the references to the tracked collection", func() {
+		alice, bob := bson.NewObjectId(), bson.NewObjectId()
+		document := map[string]interface{}{"user": map[string]interface{}{"$ref": "user", "$id": alice}}
+		Expect(TargetReferences(w, document)).To(Equal([]interface{}{alice}))
+
+		document = map[string]interface{}{"user": map[string]interface{}{"$ref": "user", "$id": alice, "$db": "other"}}
+		Expect(TargetReferences(w, document)).To(BeEmpty())
+
+		elsewhere := w
+		elsewhere.TargetCollection = "blog.comments"
+		document = map[string]interface{}{"user": map[string]interface{}{"$ref": "user", "$id": alice}}
+		Expect(TargetReferences(elsewhere, document)).To(BeEmpty())
+
+		document = map[string]interface{}{"user": map[string]interface{}{"$ref": "user", "$id": alice, "$db": "app"}}
+		Expect(TargetReferences(elsewhere, document)).To(Equal([]interface{}{alice}))
+
+		array := w
+		array.BehaviourSettings.ReferenceArray = true
+		document = map[string]interface{}{"user": []interface{}{
+			map[string]interface{}{"$ref": "user", "$id": alice},
+			map[string]interface{}{"$ref": "group", "$id": bson.NewObjectId()},
+			map[string]interface{}{"$ref": "user", "$id": bob},
+		}}
+		Expect(TargetReferences(array, document)).To(Equal([]interface{}{alice, bob}))
+	})
+
+	It("refuses watches that ignore deletes", func() {
+		result, err := CollectOrphanedTargets(context.Background(), nil, w, true)
+		Expect(err).To(MatchError("Watch userComments ignores deletes, orphaned targets are kept"))
+		Expect(result.Policy).To(Equal(OnDeleteIgnore))
+	})
+
+	It("writes with the tracker of a connected agent", func() {
+		agent := &TailAgent{}
+		_, err := agent.CollectOrphanedTargets(context.Background(), "userComments", false)
+		Expect(err).To(MatchError("The agent is not connected"))
+	})
+})
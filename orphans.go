@@ -0,0 +1,148 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//OrphanSettings record the targets whose references point to tracked
+//documents that do not exist, to clean them up later. Orphaned references
+//are always counted, with Collection (database.collection) every target
+//is stored there once with the time it was seen first and last.
+type OrphanSettings struct {
+	Collection string            `json:"collection"`
+	Retention  RetentionSettings `json:"retention"`
+}
+
+func checkOrphanSettings(settings OrphanSettings) error {
+	if c := settings.Collection; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Orphan collection %s must be database.collection", c)
+	}
+
+	return nil
+}
+
+//OrphanedReference is a target of a watch that references a tracked
+//document that did not exist when it was looked up. Count is how often
+//the reference was looked up in vain.
+type OrphanedReference struct {
+	ID        string      `bson:"_id" json:"-"`
+	Watch     string      `bson:"watch" json:"watch"`
+	Namespace string      `bson:"ns" json:"ns"`
+	Target    interface{} `bson:"target" json:"target"`
+	Reference mgo.DBRef   `bson:"ref" json:"ref"`
+	Count     int         `bson:"count" json:"count"`
+	FirstSeen time.Time   `bson:"firstSeen" json:"firstSeen"`
+	LastSeen  time.Time   `bson:"lastSeen" json:"lastSeen"`
+}
+
+//orphans counts and stores orphaned references, all methods can be
+//called on nil. store is nil without a collection.
+type orphans struct {
+	metrics *metricRegistry
+	store   func(orphan OrphanedReference) error
+}
+
+func newOrphans(settings OrphanSettings, metrics *metricRegistry, session *mgo.Session) *orphans {
+	o := &orphans{metrics: metrics}
+	if settings.Collection == "" || session == nil {
+		return o
+	}
+
+	o.store = func(orphan OrphanedReference) error {
+		s := session.Copy()
+		defer s.Close()
+		collection, err := orphanCollection(s, settings)
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.UpsertId(orphan.ID, bson.M{
+			"$set": bson.M{
+				"watch":    orphan.Watch,
+				"ns":       orphan.Namespace,
+				"target":   orphan.Target,
+				"ref":      orphan.Reference,
+				"lastSeen": orphan.LastSeen,
+			},
+			"$setOnInsert": bson.M{"firstSeen": orphan.FirstSeen},
+			"$inc":         bson.M{"count": 1},
+		})
+		if err != nil {
+			return err
+		}
+
+		return pruneCollection(collection, "lastSeen", settings.Retention, orphan.LastSeen)
+	}
+
+	return o
+}
+
+//record counts that the target originRef of w references ref,
+//which does not exist
+func (o *orphans) record(w Watch, originRef, ref mgo.DBRef) {
+	if o == nil {
+		return
+	}
+
+	o.metrics.add(MetricOrphanedReferences, 1)
+	o.metrics.add(labeled(MetricOrphanedReferences, "watch", w.Key()), 1)
+	if o.store == nil {
+		return
+	}
+
+	ns := originRef.Database + "." + originRef.Collection
+	now := time.Now()
+	orphan := OrphanedReference{
+		ID:        w.Key() + "/" + ns + "/" + idString(originRef.Id),
+		Watch:     w.Key(),
+		Namespace: ns,
+		Target:    originRef.Id,
+		Reference: ref,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+	if err := o.store(orphan); err != nil {
+		logError("Orphaned reference could not be stored", watchFields(w).withError(err))
+	}
+}
+
+//lookupFailed records ref as orphaned if err tells that it does not exist
+func (c changeTracker) lookupFailed(w Watch, originRef, ref mgo.DBRef, err error) {
+	if err == mgo.ErrNotFound {
+		c.orphans.record(w, originRef, ref)
+	}
+}
+
+func orphanCollection(session *mgo.Session, settings OrphanSettings) (*mgo.Collection, error) {
+	p := strings.Index(settings.Collection, ".")
+	if p < 1 {
+		return nil, errors.New("No orphan collection configured")
+	}
+
+	return session.DB(settings.Collection[:p]).C(settings.Collection[p+1:]), nil
+}
+
+//ListOrphanedReferences returns the stored orphaned references of the
+//watch with key, of all watches if it is empty, most recently seen first.
+//limit zero returns all of them.
+func ListOrphanedReferences(session *mgo.Session, settings OrphanSettings, watch string, limit int) ([]OrphanedReference, error) {
+	collection, err := orphanCollection(session, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := bson.M{}
+	if watch != "" {
+		selector["watch"] = watch
+	}
+
+	orphaned := []OrphanedReference{}
+	err = collection.Find(selector).Sort("-lastSeen").Limit(limit).All(&orphaned)
+	return orphaned, err
+}
@@ -0,0 +1,49 @@
+package redkeep_test
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2"
+)
+
+var _ = Describe("Orphaned references", func() {
+	w := Watch{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username"},
+		TargetCollection:      "app.comment",
+		TargetNormalizedField: "meta.user",
+		TriggerReference:      "user",
+	}
+	target := mgo.DBRef{Database: "app", Collection: "comment", Id: "c1"}
+	missing := mgo.DBRef{Database: "app", Collection: "user", Id: "u1"}
+
+	It("will count and store references to documents that do not exist", func() {
+		stored, metrics := FailedLookups(w, target, []mgo.DBRef{missing, missing}, mgo.ErrNotFound)
+		Expect(metrics[MetricOrphanedReferences]).To(Equal(2.0))
+		Expect(metrics[`orphaned_references_total{watch="userComments"}`]).To(Equal(2.0))
+
+		Expect(stored).To(HaveLen(2))
+		Expect(stored[0].ID).To(Equal("userComments/app.comment/c1"))
+		Expect(stored[0].Namespace).To(Equal("app.comment"))
+		Expect(stored[0].Target).To(Equal("c1"))
+		Expect(stored[0].Reference).To(Equal(missing))
+	})
+
+	It("will not count failed lookups", func() {
+		stored, metrics := FailedLookups(w, target, []mgo.DBRef{missing}, errors.New("connection reset"))
+		Expect(stored).To(BeEmpty())
+		Expect(metrics[MetricOrphanedReferences]).To(BeZero())
+	})
+
+	It("will only accept orphan collections with database", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"orphans": { "collection": "orphans" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Orphan collection orphans must be database.collection"))
+	})
+})
@@ -0,0 +1,322 @@
+package redkeep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+)
+
+//parquet constants, see parquet-format/src/main/thrift/parquet.thrift
+const (
+	parquetMagic = "PAR1"
+
+	parquetTypeByteArray      = 6
+	parquetRepetitionOptional = 1
+	parquetConvertedUTF8      = 0
+	parquetEncodingPlain      = 0
+	parquetEncodingRLE        = 3
+	parquetCodecGzip          = 2
+	parquetPageData           = 0
+
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+//parquetWriter writes a parquet file with optional UTF8 columns.
+//It supports exactly what the exporter needs: plain encoding,
+//gzip compressed pages and one page per column chunk.
+type parquetWriter struct {
+	out       io.Writer
+	offset    int64
+	columns   []string
+	rows      [][]*string
+	rowGroups []parquetRowGroup
+	metadata  map[string]string
+	numRows   int64
+}
+
+type parquetColumnChunk struct {
+	offset           int64
+	values           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type parquetRowGroup struct {
+	chunks    []parquetColumnChunk
+	byteSize  int64
+	rowNumber int64
+}
+
+func newParquetWriter(out io.Writer, columns []string) (*parquetWriter, error) {
+	w := &parquetWriter{out: out, columns: columns, metadata: map[string]string{}}
+	return w, w.write([]byte(parquetMagic))
+}
+
+func (w *parquetWriter) write(data []byte) error {
+	n, err := w.out.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+//add buffers one row, nil values are stored as null
+func (w *parquetWriter) add(row []*string) {
+	w.rows = append(w.rows, row)
+}
+
+//flushRowGroup writes all buffered rows as a row group
+func (w *parquetWriter) flushRowGroup() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{rowNumber: int64(len(w.rows))}
+	for c := range w.columns {
+		var page bytes.Buffer
+		levels := make([]bool, len(w.rows))
+		var values bytes.Buffer
+		for r, row := range w.rows {
+			if row[c] == nil {
+				continue
+			}
+
+			levels[r] = true
+			binary.Write(&values, binary.LittleEndian, uint32(len(*row[c])))
+			values.WriteString(*row[c])
+		}
+
+		encodedLevels := parquetDefinitionLevels(levels)
+		binary.Write(&page, binary.LittleEndian, uint32(len(encodedLevels)))
+		page.Write(encodedLevels)
+		page.Write(values.Bytes())
+
+		var compressed bytes.Buffer
+		zipper := gzip.NewWriter(&compressed)
+		zipper.Write(page.Bytes())
+		if err := zipper.Close(); err != nil {
+			return err
+		}
+
+		header := &thriftWriter{}
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(len(w.rows)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunk := parquetColumnChunk{
+			offset:           w.offset,
+			values:           int64(len(w.rows)),
+			uncompressedSize: int64(header.buffer.Len() + page.Len()),
+			compressedSize:   int64(header.buffer.Len() + compressed.Len()),
+		}
+
+		if err := w.write(header.buffer.Bytes()); err != nil {
+			return err
+		}
+
+		if err := w.write(compressed.Bytes()); err != nil {
+			return err
+		}
+
+		group.byteSize += chunk.uncompressedSize
+		group.chunks = append(group.chunks, chunk)
+	}
+
+	w.numRows += group.rowNumber
+	w.rowGroups = append(w.rowGroups, group)
+	w.rows = nil
+	return nil
+}
+
+//close writes the remaining rows and the footer
+func (w *parquetWriter) close() error {
+	if err := w.flushRowGroup(); err != nil {
+		return err
+	}
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+
+	meta.beginList(2, thriftStruct, len(w.columns)+1)
+	meta.beginListStruct()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(w.columns)))
+	meta.endStruct()
+	for _, c := range w.columns {
+		meta.beginListStruct()
+		meta.i32(1, parquetTypeByteArray)
+		meta.i32(3, parquetRepetitionOptional)
+		meta.binary(4, c)
+		meta.i32(6, parquetConvertedUTF8)
+		meta.endStruct()
+	}
+
+	meta.i64(3, w.numRows)
+
+	meta.beginList(4, thriftStruct, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		meta.beginListStruct()
+		meta.beginList(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			meta.beginListStruct()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, parquetTypeByteArray)
+			meta.beginList(2, thriftI32, 2)
+			meta.listI32(parquetEncodingPlain)
+			meta.listI32(parquetEncodingRLE)
+			meta.beginList(3, thriftBinary, 1)
+			meta.listBinary(w.columns[i])
+			meta.i32(4, parquetCodecGzip)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.uncompressedSize)
+			meta.i64(7, chunk.compressedSize)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, group.byteSize)
+		meta.i64(3, group.rowNumber)
+		meta.endStruct()
+	}
+
+	meta.beginList(5, thriftStruct, len(w.metadata))
+	for _, key := range sortedKeys(w.metadata) {
+		meta.beginListStruct()
+		meta.binary(1, key)
+		meta.binary(2, w.metadata[key])
+		meta.endStruct()
+	}
+
+	meta.binary(6, "redkeep")
+	meta.stop()
+
+	if err := w.write(meta.buffer.Bytes()); err != nil {
+		return err
+	}
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.buffer.Len()))
+	if err := w.write(length[:]); err != nil {
+		return err
+	}
+
+	return w.write([]byte(parquetMagic))
+}
+
+//parquetDefinitionLevels encodes levels of bit width one
+//with the RLE part of the RLE/bit-packing hybrid
+func parquetDefinitionLevels(levels []bool) []byte {
+	var buffer bytes.Buffer
+	for i := 0; i < len(levels); {
+		run := 1
+		for i+run < len(levels) && levels[i+run] == levels[i] {
+			run++
+		}
+
+		writeUvarint(&buffer, uint64(run)<<1)
+		if levels[i] {
+			buffer.WriteByte(1)
+		} else {
+			buffer.WriteByte(0)
+		}
+
+		i += run
+	}
+
+	return buffer.Bytes()
+}
+
+func writeUvarint(buffer *bytes.Buffer, value uint64) {
+	var data [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(data[:], value)
+	buffer.Write(data[:n])
+}
+
+//thriftWriter writes the thrift compact protocol
+type thriftWriter struct {
+	buffer    bytes.Buffer
+	lastField []int16
+	current   int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	delta := id - t.current
+	if delta > 0 && delta <= 15 {
+		t.buffer.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.buffer.WriteByte(fieldType)
+		writeUvarint(&t.buffer, uint64((uint16(id)<<1)^uint16(id>>15)))
+	}
+	t.current = id
+}
+
+func (t *thriftWriter) zigzag(value int64) {
+	writeUvarint(&t.buffer, uint64((value<<1)^(value>>63)))
+}
+
+func (t *thriftWriter) i32(id int16, value int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(value))
+}
+
+func (t *thriftWriter) i64(id int16, value int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(value)
+}
+
+func (t *thriftWriter) binary(id int16, value string) {
+	t.fieldHeader(id, thriftBinary)
+	t.listBinary(value)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginListStruct()
+}
+
+//beginListStruct starts a struct that is an element of a list
+func (t *thriftWriter) beginListStruct() {
+	t.lastField = append(t.lastField, t.current)
+	t.current = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.current = t.lastField[len(t.lastField)-1]
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buffer.WriteByte(0)
+}
+
+func (t *thriftWriter) beginList(id int16, elementType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buffer.WriteByte(byte(size)<<4 | elementType)
+		return
+	}
+
+	t.buffer.WriteByte(0xf0 | elementType)
+	writeUvarint(&t.buffer, uint64(size))
+}
+
+func (t *thriftWriter) listI32(value int32) {
+	t.zigzag(int64(value))
+}
+
+func (t *thriftWriter) listBinary(value string) {
+	writeUvarint(&t.buffer, uint64(len(value)))
+	t.buffer.WriteString(value)
+}
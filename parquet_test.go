@@ -0,0 +1,222 @@
+package redkeep_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+//thriftReader decodes the thrift compact protocol into maps
+//of field id to value, lists become slices
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (t *thriftReader) uvarint() uint64 {
+	value, n := binary.Uvarint(t.data[t.pos:])
+	Expect(n).To(BeNumerically(">", 0))
+	t.pos += n
+	return value
+}
+
+func (t *thriftReader) zigzag() int64 {
+	value := t.uvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (t *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 5, 6:
+		return t.zigzag()
+	case 8:
+		size := int(t.uvarint())
+		value := string(t.data[t.pos : t.pos+size])
+		t.pos += size
+		return value
+	case 9:
+		header := t.data[t.pos]
+		t.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(t.uvarint())
+		}
+
+		list := []interface{}{}
+		for i := 0; i < size; i++ {
+			list = append(list, t.value(header&0x0f))
+		}
+		return list
+	case 12:
+		return t.object()
+	}
+
+	Fail(fmt.Sprintf("Unexpected thrift type %d", kind))
+	return nil
+}
+
+func (t *thriftReader) object() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		header := t.data[t.pos]
+		t.pos++
+		if header == 0 {
+			return fields
+		}
+
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(t.zigzag())
+		}
+
+		fields[id] = t.value(header & 0x0f)
+		last = id
+	}
+}
+
+//readColumnChunk decodes the page of a column chunk into its values
+func readColumnChunk(file []byte, offset int64) []*string {
+	reader := &thriftReader{data: file, pos: int(offset)}
+	header := reader.object()
+	compressed := file[reader.pos : reader.pos+int(header[3].(int64))]
+
+	unzipper, err := gzip.NewReader(bytes.NewReader(compressed))
+	Expect(err).ToNot(HaveOccurred())
+	page, err := ioutil.ReadAll(unzipper)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(page).To(HaveLen(int(header[2].(int64))))
+
+	rows := int(header[5].(map[int16]interface{})[1].(int64))
+	levelsSize := int(binary.LittleEndian.Uint32(page))
+	levels := &thriftReader{data: page[4 : 4+levelsSize]}
+	defined := []bool{}
+	for levels.pos < len(levels.data) {
+		run := levels.uvarint()
+		Expect(run&1).To(BeZero(), "only RLE runs are written")
+		value := levels.data[levels.pos]
+		levels.pos++
+		for i := uint64(0); i < run>>1; i++ {
+			defined = append(defined, value == 1)
+		}
+	}
+	Expect(defined).To(HaveLen(rows))
+
+	values := page[4+levelsSize:]
+	result := []*string{}
+	for _, d := range defined {
+		if !d {
+			result = append(result, nil)
+			continue
+		}
+
+		size := int(binary.LittleEndian.Uint32(values))
+		value := string(values[4 : 4+size])
+		values = values[4+size:]
+		result = append(result, &value)
+	}
+	Expect(values).To(BeEmpty())
+
+	return result
+}
+
+var _ = Describe("Parquet export", func() {
+	value := func(s string) *string {
+		return &s
+	}
+
+	watch := Watch{
+		TrackCollection:       "live.user",
+		TrackFields:           []string{"username", "name.first"},
+		TargetCollection:      "live.comment",
+		TargetNormalizedField: "meta",
+		TriggerReference:      "user",
+	}
+
+	rows := [][]*string{
+		{value("a"), value("alice"), nil},
+		{value("b"), nil, nil},
+		{value("c"), nil, value("Carl")},
+		{value("d"), value("dora"), value("Dora")},
+		{value("e"), value("eve"), nil},
+	}
+
+	var (
+		file     []byte
+		metadata map[int16]interface{}
+	)
+
+	BeforeEach(func() {
+		var buffer bytes.Buffer
+		Expect(WriteParquet(&buffer, watch, bson.MongoTimestamp(6254758392345657345), rows, 2)).To(Succeed())
+		file = buffer.Bytes()
+
+		Expect(string(file[:4])).To(Equal("PAR1"))
+		Expect(string(file[len(file)-4:])).To(Equal("PAR1"))
+		size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+		footer := &thriftReader{data: file[len(file)-8-size : len(file)-8]}
+		metadata = footer.object()
+		Expect(footer.pos).To(Equal(size))
+	})
+
+	It("writes the schema and the number of rows", func() {
+		Expect(metadata[3]).To(Equal(int64(5)))
+		schema := metadata[2].([]interface{})
+		Expect(schema).To(HaveLen(4))
+		Expect(schema[0].(map[int16]interface{})[5]).To(Equal(int64(3)))
+
+		names := []interface{}{}
+		for _, element := range schema[1:] {
+			names = append(names, element.(map[int16]interface{})[4])
+		}
+		Expect(names).To(Equal([]interface{}{"_id", "username", "name_first"}))
+	})
+
+	It("stores the oplog position in the metadata", func() {
+		pairs := map[interface{}]interface{}{}
+		for _, pair := range metadata[5].([]interface{}) {
+			pairs[pair.(map[int16]interface{})[1]] = pair.(map[int16]interface{})[2]
+		}
+
+		Expect(pairs).To(HaveKeyWithValue("redkeep.oplogTimestamp", "6254758392345657345"))
+		Expect(pairs).To(HaveKeyWithValue("redkeep.namespace", "live.user"))
+		Expect(pairs).To(HaveKeyWithValue("redkeep.watch", watch.Key()))
+	})
+
+	It("writes row groups with null runs that decode to the rows", func() {
+		groups := metadata[4].([]interface{})
+		Expect(groups).To(HaveLen(3))
+
+		decoded := make([][]*string, len(rows))
+		row := 0
+		for _, g := range groups {
+			group := g.(map[int16]interface{})
+			chunks := group[1].([]interface{})
+			Expect(chunks).To(HaveLen(3))
+
+			count := int(group[3].(int64))
+			for c, chunk := range chunks {
+				offset := chunk.(map[int16]interface{})[2].(int64)
+				Expect(chunk.(map[int16]interface{})[3].(map[int16]interface{})[9]).To(Equal(offset))
+
+				for i, v := range readColumnChunk(file, offset) {
+					decoded[row+i] = append(decoded[row+i], v)
+				}
+				Expect(decoded[row+count-1]).To(HaveLen(c + 1))
+			}
+			row += count
+		}
+
+		Expect(row).To(Equal(len(rows)))
+		Expect(decoded).To(Equal(rows))
+	})
+})
@@ -0,0 +1,135 @@
+package redkeep
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//strategies that partition the oplog entries between the workers
+const (
+	//PartitionByDocument orders the entries of every document, it is the default
+	PartitionByDocument = "document"
+	//PartitionByNamespace orders the entries of every collection
+	PartitionByNamespace = "namespace"
+	//PartitionByTenant orders the entries of the collections of every tenant
+	PartitionByTenant = "tenant"
+	//PartitionByField orders the entries with the same value of a field
+	PartitionByField = "field"
+)
+
+//PartitionFunc returns the ordering domain of an oplog entry. The entries
+//of one domain are handled one after another in oplog order, different
+//domains in parallel. Entries without domain are ordered by document,
+//unless earlier entries of their document are still in another domain.
+type PartitionFunc func(entry map[string]interface{}) string
+
+//PartitionSettings decide which oplog entries the workers handle in order.
+//By is document (default), namespace, tenant, field or a type registered
+//with RegisterPartitionType. Field is the field of the changed documents
+//for field, updates only carry it if it is changed or a shard key.
+type PartitionSettings struct {
+	By    string `json:"by"`
+	Field string `json:"field"`
+}
+
+var (
+	partitionTypesMutex sync.RWMutex
+	partitionTypes      = map[string]PartitionFunc{}
+)
+
+//RegisterPartitionType makes a partitioning available under name
+//for the configuration
+func RegisterPartitionType(name string, partition PartitionFunc) {
+	partitionTypesMutex.Lock()
+	defer partitionTypesMutex.Unlock()
+	partitionTypes[name] = partition
+}
+
+func getPartitionType(name string) (PartitionFunc, bool) {
+	partitionTypesMutex.RLock()
+	defer partitionTypesMutex.RUnlock()
+	partition, ok := partitionTypes[name]
+	return partition, ok
+}
+
+func checkPartitionSettings(settings PartitionSettings) error {
+	switch settings.By {
+	case "", PartitionByDocument, PartitionByNamespace, PartitionByTenant:
+		return nil
+	case PartitionByField:
+		if settings.Field == "" {
+			return errors.New("Partitioning by field needs a field")
+		}
+
+		return nil
+	}
+
+	if _, ok := getPartitionType(settings.By); !ok {
+		return fmt.Errorf("Unknown partitioning %s, use %s, %s, %s, %s or a registered type", settings.By,
+			PartitionByDocument, PartitionByNamespace, PartitionByTenant, PartitionByField)
+	}
+
+	return nil
+}
+
+//partitionKey returns the ordering domain of entry by settings, entries
+//without document like commands have none. The workers keep entries in
+//the domain of the pending entries of their document.
+func partitionKey(settings PartitionSettings, watches *watchSet, entry map[string]interface{}) string {
+	document := documentKey(entry)
+	if document == "" {
+		return ""
+	}
+
+	key := ""
+	switch settings.By {
+	case "", PartitionByDocument:
+	case PartitionByNamespace:
+		key = fmt.Sprintf("ns/%v", entry["ns"])
+	case PartitionByTenant:
+		if tenant := entryTenant(watches.active(), entry); tenant != "" {
+			key = "tenant/" + tenant
+		}
+	case PartitionByField:
+		if value := entryField(settings.Field, entry); value != nil {
+			key = "field/" + idString(value)
+		}
+	default:
+		if partition, ok := getPartitionType(settings.By); ok {
+			key = partition(entry)
+		}
+	}
+
+	if key == "" {
+		return document
+	}
+
+	return key
+}
+
+//entryTenant returns the tenant of the first watch of a tenant that
+//tracks or targets the namespace of entry
+func entryTenant(watches []Watch, entry map[string]interface{}) string {
+	for _, w := range watches {
+		if w.Tenant != "" && (entry["ns"] == w.TrackCollection || entry["ns"] == w.TargetCollection) {
+			return w.Tenant
+		}
+	}
+
+	return ""
+}
+
+//entryField returns the value of field of the document entry changes,
+//from the selector, the inserted document or the $set of an update
+func entryField(field string, entry map[string]interface{}) interface{} {
+	if value := GetValue(field, entry["o2"]); value != nil {
+		return value
+	}
+
+	if value := GetValue(field, entry["o"]); value != nil {
+		return value
+	}
+
+	return GetValue("$set."+field, entry["o"])
+}
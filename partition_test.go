@@ -0,0 +1,88 @@
+package redkeep_test
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Worker partitions", func() {
+	watches := []Watch{
+		{TrackCollection: "shop.user", TargetCollection: "shop.order", Tenant: "shop"},
+		{TrackCollection: "blog.user", TargetCollection: "blog.comment"},
+	}
+	insert := map[string]interface{}{"op": "i", "ns": "shop.user", "o": map[string]interface{}{"_id": "1", "account": "a"}}
+	update := map[string]interface{}{"op": "u", "ns": "blog.user", "o2": map[string]interface{}{"_id": "2"}, "o": map[string]interface{}{"$set": map[string]interface{}{"account": "b"}}}
+	sharded := map[string]interface{}{"op": "u", "ns": "shop.order", "o2": map[string]interface{}{"_id": "3", "account": "a"}, "o": map[string]interface{}{"$set": map[string]interface{}{"total": 3}}}
+	command := map[string]interface{}{"op": "c", "ns": "shop.$cmd", "o": map[string]interface{}{"drop": "user"}}
+
+	It("will order the entries of every document by default", func() {
+		Expect(PartitionKey(PartitionSettings{}, watches, insert)).To(Equal("shop.user/1"))
+		Expect(PartitionKey(PartitionSettings{By: PartitionByDocument}, watches, update)).To(Equal("blog.user/2"))
+		Expect(PartitionKey(PartitionSettings{By: PartitionByNamespace}, watches, command)).To(BeEmpty())
+	})
+
+	It("will order the entries of namespaces and tenants", func() {
+		settings := PartitionSettings{By: PartitionByNamespace}
+		Expect(PartitionKey(settings, watches, insert)).To(Equal("ns/shop.user"))
+		Expect(PartitionKey(settings, watches, sharded)).To(Equal("ns/shop.order"))
+
+		settings = PartitionSettings{By: PartitionByTenant}
+		Expect(PartitionKey(settings, watches, insert)).To(Equal("tenant/shop"))
+		Expect(PartitionKey(settings, watches, sharded)).To(Equal("tenant/shop"))
+		Expect(PartitionKey(settings, watches, update)).To(Equal("blog.user/2"))
+	})
+
+	It("will order the entries with the same value of a field", func() {
+		settings := PartitionSettings{By: PartitionByField, Field: "account"}
+		Expect(PartitionKey(settings, watches, insert)).To(Equal("field/a"))
+		Expect(PartitionKey(settings, watches, sharded)).To(Equal("field/a"))
+		Expect(PartitionKey(settings, watches, update)).To(Equal("field/b"))
+
+		settings.Field = "region"
+		Expect(PartitionKey(settings, watches, insert)).To(Equal("shop.user/1"))
+	})
+
+	It("will keep the entries of a document in the domain of its pending entries", func() {
+		insertA := map[string]interface{}{"op": "i", "ns": "shop.user", "o": map[string]interface{}{"_id": "4", "account": "a"}}
+		rename := map[string]interface{}{"op": "u", "ns": "shop.user", "o2": map[string]interface{}{"_id": "4"}, "o": map[string]interface{}{"$set": map[string]interface{}{"name": "x"}}}
+		moveB := map[string]interface{}{"op": "u", "ns": "shop.user", "o2": map[string]interface{}{"_id": "4"}, "o": map[string]interface{}{"$set": map[string]interface{}{"account": "b"}}}
+		settings := PartitionSettings{By: PartitionByField, Field: "account"}
+		Expect(PartitionKey(settings, watches, rename)).To(Equal("shop.user/4"))
+
+		entries := []map[string]interface{}{insertA, rename, moveB, insert, rename}
+		for _, workers := range []WorkerSettings{{Partition: settings}, {Count: 4, Partition: settings}} {
+			order := RunPartitioned(workers, watches, entries)
+			Expect(order["shop.user/4"]).To(Equal([]int{0, 1, 2, 4}))
+			Expect(order["shop.user/1"]).To(Equal([]int{3}))
+		}
+	})
+
+	It("will use registered partitions", func() {
+		RegisterPartitionType("database", func(entry map[string]interface{}) string {
+			ns := fmt.Sprint(entry["ns"])
+			return ns[:strings.Index(ns, ".")]
+		})
+
+		Expect(PartitionKey(PartitionSettings{By: "database"}, watches, sharded)).To(Equal("shop"))
+	})
+
+	It("will validate the partitioning", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"workers": { "count": 4, "partition": { "by": "shard" } }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Unknown partitioning shard, use document, namespace, tenant, field or a registered type"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"workers": { "count": 4, "partition": { "by": "field" } }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Partitioning by field needs a field"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"workers": { "count": 4, "partition": { "by": "tenant" } }, "watches"`, 1)
+		parsed, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Workers.Partition.By).To(Equal(PartitionByTenant))
+	})
+})
@@ -0,0 +1,93 @@
+package redkeep
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//defaultMaxPendingUpdates limits the queued updates of missing targets,
+	//the oldest one is dropped when the limit is reached
+	defaultMaxPendingUpdates = 10000
+	//defaultPendingUpdateMaxAge is how long an update waits for its target
+	defaultPendingUpdateMaxAge = time.Hour
+)
+
+type pendingUpdate struct {
+	key        string
+	update     bson.M
+	generation bson.MongoTimestamp
+	queued     time.Time
+}
+
+//pendingTargets queues updates that matched no target document by watch
+//and reference, all methods can be called on nil
+type pendingTargets struct {
+	sync.Mutex
+	updates   []pendingUpdate
+	retention RetentionSettings
+	metrics   *metricRegistry
+}
+
+func newPendingTargets(retention RetentionSettings, metrics *metricRegistry) *pendingTargets {
+	retention = retention.withDefaults(defaultPendingUpdateMaxAge, defaultMaxPendingUpdates)
+	return &pendingTargets{retention: retention, metrics: metrics}
+}
+
+func pendingKey(w Watch, reference interface{}) string {
+	return fmt.Sprintf("%s/%v", w.Key(), reference)
+}
+
+//add queues update for the targets of w that reference the tracked document
+func (p *pendingTargets) add(w Watch, reference interface{}, update bson.M, generation bson.MongoTimestamp, now time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.expire(now)
+	if len(p.updates) >= p.retention.MaxSize {
+		p.updates = p.updates[1:]
+	}
+
+	p.updates = append(p.updates, pendingUpdate{key: pendingKey(w, reference), update: update, generation: generation, queued: now})
+	p.metrics.set(MetricPendingTargetUpdates, float64(len(p.updates)))
+}
+
+//take removes and returns the queued updates for the targets of w that
+//reference the tracked document, oldest first
+func (p *pendingTargets) take(w Watch, reference interface{}, now time.Time) []pendingUpdate {
+	if p == nil {
+		return nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.expire(now)
+
+	key := pendingKey(w, reference)
+	taken := []pendingUpdate{}
+	kept := p.updates[:0]
+	for _, update := range p.updates {
+		if update.key == key {
+			taken = append(taken, update)
+		} else {
+			kept = append(kept, update)
+		}
+	}
+	p.updates = kept
+	p.metrics.set(MetricPendingTargetUpdates, float64(len(p.updates)))
+
+	return taken
+}
+
+//expire drops the updates older than the retention allows
+func (p *pendingTargets) expire(now time.Time) {
+	for len(p.updates) > 0 && p.retention.expired(p.updates[0].queued, now) {
+		p.updates = p.updates[1:]
+	}
+}
@@ -0,0 +1,52 @@
+package redkeep_test
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Updates of missing targets", func() {
+	w := Watch{Name: "userComments"}
+	first := bson.M{"$set": bson.M{"meta.name": "Hans"}}
+	second := bson.M{"$set": bson.M{"meta.name": "Peter"}}
+
+	It("returns the queued updates of a reference oldest first", func() {
+		pending := NewPendingTargets(RetentionSettings{})
+		now := time.Now()
+		pending.Add(w, "1", first, now)
+		pending.Add(w, "2", first, now)
+		pending.Add(w, "1", second, now)
+		Expect(pending.Metrics()[MetricPendingTargetUpdates]).To(Equal(3.0))
+
+		Expect(pending.Take(w, "1", now)).To(Equal([]bson.M{first, second}))
+		Expect(pending.Take(w, "1", now)).To(BeEmpty())
+		Expect(pending.Take(Watch{Name: "other"}, "2", now)).To(BeEmpty())
+		Expect(pending.Metrics()[MetricPendingTargetUpdates]).To(Equal(1.0))
+	})
+
+	It("drops updates that waited longer than an hour", func() {
+		pending := NewPendingTargets(RetentionSettings{})
+		now := time.Now()
+		pending.Add(w, "1", first, now.Add(-2*time.Hour))
+		pending.Add(w, "1", second, now)
+
+		Expect(pending.Take(w, "1", now)).To(Equal([]bson.M{second}))
+	})
+
+	It("keeps the configured number of updates", func() {
+		pending := NewPendingTargets(RetentionSettings{MaxAge: Duration{Duration: time.Minute}, MaxSize: 1})
+		now := time.Now()
+		pending.Add(w, "1", first, now.Add(-30*time.Second))
+		pending.Add(w, "1", second, now.Add(-30*time.Second))
+		Expect(pending.Take(w, "1", now)).To(Equal([]bson.M{second}))
+
+		pending.Add(w, "1", first, now.Add(-2*time.Minute))
+		Expect(pending.Take(w, "1", now)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,48 @@
+package redkeep
+
+import (
+	"fmt"
+	"plugin"
+	"sync"
+)
+
+//pluginRegister is the name of the optional function a plugin exports
+const pluginRegister = "Register"
+
+var (
+	loadedPluginsMutex sync.Mutex
+	loadedPlugins      = map[string]bool{}
+)
+
+//LoadPlugin opens a Go plugin (go build -buildmode=plugin) that was built
+//against the same version of redkeep. Plugins register their sinks,
+//transforms and notifiers in init or in an exported func Register() error
+//that is called once after the plugin was opened. Go plugins are
+//only supported on linux, freebsd and macOS.
+func LoadPlugin(path string) error {
+	loadedPluginsMutex.Lock()
+	defer loadedPluginsMutex.Unlock()
+
+	if loadedPlugins[path] {
+		return nil
+	}
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("Plugin %s could not be loaded: %s", path, err.Error())
+	}
+
+	if symbol, err := p.Lookup(pluginRegister); err == nil {
+		register, ok := symbol.(func() error)
+		if !ok {
+			return fmt.Errorf("Plugin %s exports %s but it is not a func() error", path, pluginRegister)
+		}
+
+		if err := register(); err != nil {
+			return fmt.Errorf("Plugin %s could not be registered: %s", path, err.Error())
+		}
+	}
+
+	loadedPlugins[path] = true
+	return nil
+}
@@ -0,0 +1,302 @@
+package redkeep
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	defaultWorkerQueue = 1000
+	scaleInterval      = 10 * time.Second
+)
+
+//WorkerSettings bound the goroutines that handle oplog entries. Count
+//workers take the entries from a queue of Queue entries (default 1000),
+//while it is full the oplog is read no further. Without Count every entry
+//is handled on its own goroutine. Each worker reuses one mongo session.
+//With Max the pool grows up to Max workers while entries queue up or take
+//longer than TargetLatency from the queue to the written targets, and
+//shrinks back to Count when the queue is empty and entries are handled
+//in less than half of it. Partition decides which entries are handled in
+//order, those of every document by default.
+type WorkerSettings struct {
+	Count         int               `json:"count" validate:"min=0"`
+	Queue         int               `json:"queue" validate:"min=0"`
+	Max           int               `json:"max" validate:"min=0"`
+	TargetLatency Duration          `json:"targetLatency"`
+	Partition     PartitionSettings `json:"partition"`
+}
+
+func checkWorkerSettings(settings WorkerSettings) error {
+	if settings.Max > 0 && (settings.Count == 0 || settings.Max < settings.Count) {
+		return errors.New("Max workers need a count of workers below them")
+	}
+
+	return checkPartitionSettings(settings.Partition)
+}
+
+//scaleWorkers returns the number of workers for the next interval, queued
+//are the waiting entries and latency the mean seconds of the last entries
+func scaleWorkers(settings WorkerSettings, workers, queue, queued int, latency float64) int {
+	target := settings.TargetLatency.Seconds()
+	backlog := queued > 0 && (queued >= queue/10 || (target > 0 && latency > target))
+	idle := queued == 0 && (target == 0 || latency < target/2)
+
+	switch {
+	case backlog && workers < settings.Max:
+		workers += workers/2 + 1
+		if workers > settings.Max {
+			workers = settings.Max
+		}
+	case idle && workers > settings.Count:
+		workers--
+	}
+
+	return workers
+}
+
+//placement is the partition of the queued and running jobs of a document
+type placement struct {
+	partition string
+	pending   int
+}
+
+//workerPool hands jobs to a fixed number of workers, without workers
+//every job runs on its own goroutine. Queued and running jobs are
+//counted in workers. documents has the jobs waiting behind the running
+//job of a document, placements the partitions of documents with jobs.
+type workerPool struct {
+	sync.Mutex
+	jobs       chan func(Tracker)
+	documents  map[string][]func(Tracker)
+	placements map[string]*placement
+	tracker    Tracker
+	workers    *sync.WaitGroup
+	metrics    *metricRegistry
+	settings   WorkerSettings
+	running    int
+	retire     chan bool
+	done       chan bool
+	//latency and handled are the seconds and number
+	//of entries handled since the last scaling
+	latency float64
+	handled int
+}
+
+func newWorkerPool(settings WorkerSettings, tracker Tracker, workers *sync.WaitGroup, metrics *metricRegistry) *workerPool {
+	p := &workerPool{tracker: tracker, workers: workers, metrics: metrics, documents: map[string][]func(Tracker){}, placements: map[string]*placement{}}
+	if settings.Count <= 0 {
+		return p
+	}
+
+	queue := settings.Queue
+	if queue <= 0 {
+		queue = defaultWorkerQueue
+	}
+
+	p.jobs = make(chan func(Tracker), queue)
+	p.settings = settings
+	p.retire = make(chan bool)
+	p.done = make(chan bool)
+	p.resize(settings.Count)
+	if settings.Max > settings.Count {
+		go p.scale()
+	}
+
+	return p
+}
+
+//resize starts or retires workers until count are running
+func (p *workerPool) resize(count int) {
+	p.Lock()
+	change := count - p.running
+	p.running = count
+	p.Unlock()
+	p.metrics.set(MetricWorkers, float64(count))
+
+	for ; change > 0; change-- {
+		go p.work()
+	}
+
+	for ; change < 0; change++ {
+		select {
+		case p.retire <- true:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+//scale adjusts the number of workers every scaleInterval
+func (p *workerPool) scale() {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.Lock()
+			latency := 0.0
+			if p.handled > 0 {
+				latency = p.latency / float64(p.handled)
+			}
+			p.latency, p.handled = 0, 0
+			workers := scaleWorkers(p.settings, p.running, cap(p.jobs), len(p.jobs), latency)
+			p.Unlock()
+
+			p.resize(workers)
+		}
+	}
+}
+
+//work runs jobs until the pool is closed, with a copy of the
+//change tracker that keeps its session
+func (p *workerPool) work() {
+	tracker := p.tracker
+	if c, ok := tracker.(*changeTracker); ok {
+		worker := *c
+		worker.session = c.session.Copy()
+		worker.reuseSession = true
+		defer worker.session.Close()
+		tracker = &worker
+	}
+
+	for {
+		select {
+		case <-p.retire:
+			return
+		case job, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+
+			p.metrics.set(MetricWorkerQueue, float64(len(p.jobs)))
+			job(tracker)
+			p.workers.Done()
+		}
+	}
+}
+
+//submit queues job, it blocks while the queue is full
+func (p *workerPool) submit(job func(Tracker)) {
+	p.workers.Add(1)
+	if p.jobs == nil {
+		go func() {
+			defer p.workers.Done()
+			job(p.tracker)
+		}()
+		return
+	}
+
+	queued := time.Now()
+	p.jobs <- func(tracker Tracker) {
+		job(tracker)
+		p.Lock()
+		p.latency += time.Since(queued).Seconds()
+		p.handled++
+		p.Unlock()
+	}
+	p.metrics.set(MetricWorkerQueue, float64(len(p.jobs)))
+}
+
+//submitOrdered queues job behind the jobs of the same partition, they
+//run one after another in the order they were submitted while jobs of
+//other partitions run in parallel. Jobs without partition run as submitted.
+func (p *workerPool) submitOrdered(document string, job func(Tracker)) {
+	if document == "" {
+		p.submit(job)
+		return
+	}
+
+	p.Lock()
+	if waiting, ok := p.documents[document]; ok {
+		p.workers.Add(1)
+		p.documents[document] = append(waiting, job)
+		p.Unlock()
+		return
+	}
+	p.documents[document] = nil
+	p.Unlock()
+
+	p.submit(func(tracker Tracker) {
+		job(tracker)
+		for {
+			next, ok := p.next(document)
+			if !ok {
+				return
+			}
+
+			next(tracker)
+			p.workers.Done()
+		}
+	})
+}
+
+//submitPlaced is submitOrdered for a job of document. While jobs of
+//document are queued or running, its jobs stay in the partition of the
+//first of them, even if partition differs. Otherwise an update without
+//the partition field would be ordered by document and could overtake
+//the insert before it.
+func (p *workerPool) submitPlaced(document, partition string, job func(Tracker)) {
+	if document == "" {
+		p.submitOrdered(partition, job)
+		return
+	}
+
+	p.Lock()
+	placed, ok := p.placements[document]
+	if !ok {
+		placed = &placement{partition: partition}
+		p.placements[document] = placed
+	}
+	placed.pending++
+	p.Unlock()
+
+	p.submitOrdered(placed.partition, func(tracker Tracker) {
+		job(tracker)
+		p.Lock()
+		if placed.pending--; placed.pending == 0 {
+			delete(p.placements, document)
+		}
+		p.Unlock()
+	})
+}
+
+//next returns the next waiting job of document, false once
+//there is none and jobs of document are submitted again
+func (p *workerPool) next(document string) (func(Tracker), bool) {
+	p.Lock()
+	defer p.Unlock()
+	waiting := p.documents[document]
+	if len(waiting) == 0 {
+		delete(p.documents, document)
+		return nil, false
+	}
+
+	p.documents[document] = waiting[1:]
+	return waiting[0], true
+}
+
+//close lets the workers finish the queued jobs and stop
+func (p *workerPool) close() {
+	if p.jobs != nil {
+		close(p.done)
+		close(p.jobs)
+	}
+}
+
+//useSession returns the session for the writes of one oplog entry,
+//workers reuse their own session
+func (c changeTracker) useSession() (*mgo.Session, func()) {
+	if c.reuseSession {
+		return c.session, func() {}
+	}
+
+	session := c.session.Copy()
+	return session, session.Close
+}
@@ -0,0 +1,77 @@
+package redkeep_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Worker pool", func() {
+	It("handles entries on at most count workers", func() {
+		most, trackers := RunWorkerPool(WorkerSettings{Count: 3, Queue: 2}, &updateRecorder{}, 30)
+		Expect(trackers).To(HaveLen(30))
+		Expect(most).To(BeNumerically("<=", 3))
+	})
+
+	It("hands custom trackers to the workers as they are", func() {
+		tracker := &updateRecorder{}
+		_, trackers := RunWorkerPool(WorkerSettings{Count: 2}, tracker, 5)
+		for _, t := range trackers {
+			Expect(t).To(BeIdenticalTo(tracker))
+		}
+	})
+
+	It("handles every entry on its own goroutine without workers", func() {
+		_, trackers := RunWorkerPool(WorkerSettings{}, &updateRecorder{}, 10)
+		Expect(trackers).To(HaveLen(10))
+	})
+
+	It("grows the pool while entries queue up or are slow", func() {
+		settings := WorkerSettings{Count: 2, Max: 8, TargetLatency: Duration{time.Second}}
+		Expect(ScaleWorkers(settings, 2, 1000, 100, 0.1)).To(Equal(4))
+		Expect(ScaleWorkers(settings, 4, 1000, 5, 2)).To(Equal(7))
+		Expect(ScaleWorkers(settings, 7, 1000, 100, 2)).To(Equal(8))
+		Expect(ScaleWorkers(settings, 8, 1000, 100, 2)).To(Equal(8))
+		Expect(ScaleWorkers(settings, 4, 1000, 5, 0.8)).To(Equal(4))
+	})
+
+	It("shrinks the pool when it is idle", func() {
+		settings := WorkerSettings{Count: 2, Max: 8, TargetLatency: Duration{time.Second}}
+		Expect(ScaleWorkers(settings, 4, 1000, 0, 0.2)).To(Equal(3))
+		Expect(ScaleWorkers(settings, 4, 1000, 0, 0.7)).To(Equal(4))
+		Expect(ScaleWorkers(settings, 2, 1000, 0, 0)).To(Equal(2))
+		Expect(ScaleWorkers(WorkerSettings{Count: 2, Max: 8}, 4, 1000, 0, 5)).To(Equal(3))
+	})
+
+	It("handles the entries of one document in order", func() {
+		documents := []string{"a", "b", "a", "c", "a", "b", "", "a", "", "c"}
+		for _, settings := range []WorkerSettings{{}, {Count: 3, Queue: 2}} {
+			order := RunOrdered(settings, documents)
+			Expect(order["a"]).To(Equal([]int{0, 2, 4, 7}))
+			Expect(order["b"]).To(Equal([]int{1, 5}))
+			Expect(order["c"]).To(Equal([]int{3, 9}))
+			Expect(order[""]).To(ConsistOf(6, 8))
+		}
+	})
+
+	It("identifies the document of an oplog entry", func() {
+		id := bson.ObjectIdHex("56a65494b204ccd1edc0b055")
+		Expect(DocumentKey(map[string]interface{}{"op": "i", "ns": "live.user", "o": map[string]interface{}{"_id": id}})).
+			To(Equal("live.user/56a65494b204ccd1edc0b055"))
+		Expect(DocumentKey(map[string]interface{}{"op": "u", "ns": "live.user", "o2": map[string]interface{}{"_id": 7}, "o": map[string]interface{}{}})).
+			To(Equal("live.user/7"))
+		Expect(DocumentKey(map[string]interface{}{"op": "c", "ns": "live.$cmd", "o": map[string]interface{}{"drop": "user"}})).
+			To(BeEmpty())
+	})
+
+	It("needs a count below max workers", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"workers": { "count": 10, "max": 5 }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Max workers need a count of workers below them"))
+	})
+})
@@ -0,0 +1,102 @@
+package redkeep
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+)
+
+//metricsNamespace prefixes the metrics of an agent for prometheus
+const metricsNamespace = "redkeep_"
+
+//MetricsSettings configures the prometheus endpoint /metrics,
+//it listens on Listen as long as it is not empty
+type MetricsSettings struct {
+	Listen string `json:"listen"`
+}
+
+//writePrometheus writes values in the prometheus text format, the
+//metrics of a family are written together below their type
+func writePrometheus(w io.Writer, values map[string]float64) {
+	families := map[string][]string{}
+	types := map[string]string{}
+	for metric := range values {
+		family, kind := metricFamily(metric)
+		families[family] = append(families[family], metric)
+		types[family] = kind
+	}
+
+	names := make([]string, 0, len(families))
+	for family := range families {
+		names = append(names, family)
+	}
+	sort.Strings(names)
+
+	for _, family := range names {
+		fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsNamespace, family, types[family])
+		metrics := families[family]
+		if types[family] == "histogram" {
+			metrics = histogramMetrics(family)
+		} else {
+			sort.Strings(metrics)
+		}
+
+		for _, metric := range metrics {
+			fmt.Fprintf(w, "%s%s %g\n", metricsNamespace, metric, values[metric])
+		}
+	}
+}
+
+//histogramMetrics are the metrics of the histogram family
+//with the buckets in increasing order
+func histogramMetrics(family string) []string {
+	metrics := []string{}
+	for _, bound := range histogramBuckets {
+		metrics = append(metrics, fmt.Sprintf("%s_bucket{le=\"%g\"}", family, bound))
+	}
+
+	return append(metrics, family+`_bucket{le="+Inf"}`, family+"_sum", family+"_count")
+}
+
+//serveMetrics serves the metrics of the agent for prometheus
+func (t *TailAgent) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheus(w, t.metrics.snapshot())
+}
+
+//metricsServer serves /metrics on its own listener, it
+//can be scraped without the access of the admin server
+type metricsServer struct {
+	settings MetricsSettings
+	handler  http.Handler
+	server   *http.Server
+}
+
+func (m *metricsServer) start() error {
+	listener, err := net.Listen("tcp", m.settings.Listen)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.handler)
+	logInfo("Metrics listening", Fields{"address": listener.Addr().String()})
+	server := &http.Server{Handler: mux}
+	m.server = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logError("Metrics server stopped", errorFields(err))
+		}
+	}()
+
+	return nil
+}
+
+func (m *metricsServer) stop() {
+	if m.server != nil {
+		m.server.Close()
+		m.server = nil
+	}
+}
@@ -0,0 +1,48 @@
+package redkeep_test
+
+import (
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prometheus metrics", func() {
+	It("writes counters and gauges with their type", func() {
+		text := PrometheusText(map[string]float64{
+			MetricLagSeconds:                     2.5,
+			`writes_total{watch="userComments"}`: 3,
+			MetricWrites:                         4,
+			`operations_total{op="u"}`:           7,
+			`write_latency_seconds{watch="userComments",quantile="0.5"}`: 0.25,
+		})
+
+		Expect(text).To(Equal(strings.Join([]string{
+			"# TYPE redkeep_lag_seconds gauge",
+			"redkeep_lag_seconds 2.5",
+			"# TYPE redkeep_operations_total counter",
+			`redkeep_operations_total{op="u"} 7`,
+			"# TYPE redkeep_write_latency_seconds gauge",
+			`redkeep_write_latency_seconds{watch="userComments",quantile="0.5"} 0.25`,
+			"# TYPE redkeep_writes_total counter",
+			"redkeep_writes_total 4",
+			`redkeep_writes_total{watch="userComments"} 3`,
+			"",
+		}, "\n")))
+	})
+
+	It("writes histograms with increasing buckets", func() {
+		text := PrometheusText(nil, 0.003, 0.2, 20)
+
+		Expect(text).To(HavePrefix("# TYPE redkeep_handler_duration_seconds histogram\n" +
+			"redkeep_handler_duration_seconds_bucket{le=\"0.001\"} 0\n" +
+			"redkeep_handler_duration_seconds_bucket{le=\"0.005\"} 1\n"))
+		Expect(text).To(ContainSubstring("redkeep_handler_duration_seconds_bucket{le=\"0.25\"} 2\n"))
+		Expect(text).To(HaveSuffix("redkeep_handler_duration_seconds_bucket{le=\"10\"} 2\n" +
+			"redkeep_handler_duration_seconds_bucket{le=\"+Inf\"} 3\n" +
+			"redkeep_handler_duration_seconds_sum 20.203\n" +
+			"redkeep_handler_duration_seconds_count 3\n"))
+	})
+})
@@ -1,6 +1,7 @@
 package redkeep
 
 import (
+	"sort"
 	"strings"
 
 	"gopkg.in/mgo.v2/bson"
@@ -20,12 +21,38 @@ func checkKey(hackstack []string, field string) bool {
 	return false
 }
 
+//trackedValues returns the tracked fields that the change of key to value
+//by operator changes. If key is a parent of tracked fields, their values are
+//looked up in value, fields it does not contain are nil. Removing a parent
+//removes all tracked fields below it.
+func trackedValues(tracked []string, operator, key string, value interface{}) map[string]interface{} {
+	if checkKey(tracked, key) {
+		return map[string]interface{}{key: value}
+	}
+
+	fields := map[string]interface{}{}
+	for _, field := range tracked {
+		if !strings.HasPrefix(field, key+".") {
+			continue
+		}
+
+		if operator == "$unset" {
+			fields[field] = value
+			continue
+		}
+
+		fields[field], _ = lookupValue(field[len(key)+1:], value)
+	}
+
+	return fields
+}
+
 //BuildInsertQuery generates the query
 func BuildInsertQuery(w Watch, command map[string]interface{}) bson.M {
 	normalizingFields := bson.M{}
-	for key, value := range command {
-		if checkKey(w.TrackFields, key) {
-			normalizingFields[w.TargetNormalizedField+"."+key] = value
+	for _, field := range w.TrackFields {
+		if value, ok := lookupValue(field, command); ok {
+			normalizingFields[w.TargetNormalizedField+"."+w.targetField(field)] = value
 		}
 	}
 
@@ -42,8 +69,8 @@ func BuildUpdateQuery(w Watch, command map[string]interface{}) bson.M {
 	for queryType, query := range command {
 		if mappedQuery, ok := query.(map[string]interface{}); ok {
 			for key, value := range mappedQuery {
-				if checkKey(w.TrackFields, key) {
-					normalizingFields[w.TargetNormalizedField+"."+key] = value
+				for field, fieldValue := range trackedValues(w.TrackFields, queryType, key, value) {
+					normalizingFields[w.TargetNormalizedField+"."+w.targetField(field)] = fieldValue
 				}
 			}
 		}
@@ -57,3 +84,71 @@ func BuildUpdateQuery(w Watch, command map[string]interface{}) bson.M {
 
 	return nil
 }
+
+//changedFields returns all tracked fields of an insert or update command,
+//fields that were removed by $unset are returned as nil
+func changedFields(w Watch, command map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for key, value := range command {
+		if !strings.HasPrefix(key, "$") {
+			if checkKey(w.TrackFields, key) {
+				fields[key] = value
+			}
+
+			for _, field := range w.TrackFields {
+				if !strings.HasPrefix(field, key+".") {
+					continue
+				}
+
+				if fieldValue, ok := lookupValue(field[len(key)+1:], value); ok {
+					fields[field] = fieldValue
+				}
+			}
+
+			continue
+		}
+
+		mappedQuery, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for changed, changedValue := range mappedQuery {
+			for field, fieldValue := range trackedValues(w.TrackFields, key, changed, changedValue) {
+				if key == "$unset" {
+					fieldValue = nil
+				}
+
+				fields[field] = fieldValue
+			}
+		}
+	}
+
+	return fields
+}
+
+//intermediatePaths returns the parents of the fields that update sets,
+//parents come before their children
+func intermediatePaths(update bson.M) []string {
+	set, _ := update["$set"].(bson.M)
+	seen := map[string]bool{}
+	paths := []string{}
+	for field := range set {
+		for p := strings.LastIndex(field, "."); p != -1; p = strings.LastIndex(field[:p], ".") {
+			if !seen[field[:p]] {
+				seen[field[:p]] = true
+				paths = append(paths, field[:p])
+			}
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if a, b := strings.Count(paths[i], "."), strings.Count(paths[j], "."); a != b {
+			return a < b
+		}
+
+		return paths[i] < paths[j]
+	})
+
+	return paths
+}
@@ -93,5 +93,74 @@ var _ = Describe("Querybuilder tests", func() {
 			actual := BuildUpdateQuery(w, command)
 			Expect(actual).To(Equal(expected))
 		})
+
+		It("will generate updates of deeply nested fields", func() {
+			w.TrackFields = []string{"meta.owner.ref", "comments.0.user"}
+			command := map[string]interface{}{
+				"$set": map[string]interface{}{
+					"meta":     map[string]interface{}{"owner": map[string]interface{}{"ref": "nino", "other": "A"}},
+					"comments": []interface{}{map[string]interface{}{"user": "anna"}},
+				},
+			}
+
+			expected := bson.M{"$set": bson.M{"norm.meta.owner.ref": "nino", "norm.comments.0.user": "anna"}}
+			Expect(BuildUpdateQuery(w, command)).To(Equal(expected))
+
+			command = map[string]interface{}{"$set": map[string]interface{}{"meta.owner": map[string]interface{}{"other": "A"}}}
+			expected = bson.M{"$set": bson.M{"norm.meta.owner.ref": nil}}
+			Expect(BuildUpdateQuery(w, command)).To(Equal(expected))
+
+			command = map[string]interface{}{"$unset": map[string]interface{}{"meta": ""}}
+			expected = bson.M{"$unset": bson.M{"norm.meta.owner.ref": ""}}
+			Expect(BuildUpdateQuery(w, command)).To(Equal(expected))
+			Expect(ChangedFields(w, command)).To(Equal(map[string]interface{}{"meta.owner.ref": nil}))
+		})
+
+		It("will generate inserts of deeply nested fields", func() {
+			w.TrackFields = []string{"meta.owner.ref", "comments.1.user", "username"}
+			document := map[string]interface{}{
+				"meta":     bson.M{"owner": bson.M{"ref": "nino"}},
+				"comments": []interface{}{"first"},
+			}
+
+			expected := bson.M{"$set": bson.M{"norm.meta.owner.ref": "nino"}}
+			Expect(BuildInsertQuery(w, document)).To(Equal(expected))
+			Expect(ChangedFields(w, document)).To(Equal(map[string]interface{}{"meta.owner.ref": "nino"}))
+		})
+
+		It("will find the parents of nested fields", func() {
+			update := bson.M{"$set": bson.M{"norm.meta.owner.ref": "nino", "norm.name": "nino", "norm.meta.editor": nil}}
+			Expect(IntermediatePaths(update)).To(Equal([]string{"norm", "norm.meta", "norm.meta.owner"}))
+			Expect(IntermediatePaths(bson.M{"$unset": bson.M{"norm.meta.owner": ""}})).To(BeEmpty())
+		})
+
+		It("will move updates into the elements of reference arrays", func() {
+			w.TriggerReference = "authors"
+			command := map[string]interface{}{
+				"$set":   map[string]interface{}{"username": "nino"},
+				"$unset": map[string]interface{}{"name": ""},
+			}
+
+			update := BuildUpdateQuery(w, map[string]interface{}{"$set": command["$set"]})
+			Expect(ElementQuery(w, update, "$")).To(Equal(bson.M{"$set": bson.M{"authors.$.norm.username": "nino"}}))
+
+			update = BuildInsertQuery(w, map[string]interface{}{"username": "nino", "gender": "male"})
+			Expect(ElementQuery(w, update, "2")).To(Equal(bson.M{"$set": bson.M{"authors.2.norm.username": "nino"}}))
+		})
+
+		It("will rename fields of the field map on the targets", func() {
+			w.TrackFields = []string{"name", "avatar.small"}
+			w.FieldMap = map[string]string{"name": "userName", "avatar.small": "userAvatar"}
+
+			document := map[string]interface{}{"name": "nino", "avatar": map[string]interface{}{"small": "s.png", "large": "l.png"}}
+			Expect(BuildInsertQuery(w, document)).To(Equal(bson.M{"$set": bson.M{"norm.userName": "nino", "norm.userAvatar": "s.png"}}))
+
+			update := map[string]interface{}{"$set": map[string]interface{}{"avatar": map[string]interface{}{"small": "t.png"}}}
+			Expect(BuildUpdateQuery(w, update)).To(Equal(bson.M{"$set": bson.M{"norm.userAvatar": "t.png"}}))
+
+			unset := map[string]interface{}{"$unset": map[string]interface{}{"avatar": ""}}
+			Expect(BuildUpdateQuery(w, unset)).To(Equal(bson.M{"$unset": bson.M{"norm.userAvatar": ""}}))
+			Expect(ChangedFields(w, update)).To(Equal(map[string]interface{}{"avatar.small": "t.png"}))
+		})
 	})
 })
@@ -0,0 +1,313 @@
+package redkeep
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//recordingIndexInterval is the number of entries between index entries
+	recordingIndexInterval = 1000
+	recordingIndexSuffix   = ".index"
+)
+
+var recordingExtensions = map[string]string{"": "", "gzip": ".gz", "zstd": ".zst"}
+
+//RecordingSettings configure the files of a RecordingWriter.
+//Compression is empty, gzip or zstd. A new segment is started after
+//MaxSize uncompressed bytes, zero writes one segment.
+type RecordingSettings struct {
+	Compression string
+	MaxSize     int64
+}
+
+type recordingIndexEntry struct {
+	Timestamp bson.MongoTimestamp `json:"ts"`
+	Segment   string              `json:"segment"`
+	Offset    int64               `json:"offset"`
+}
+
+//RecordingWriter writes an oplog recording in segments path.000001,
+//path.000002, ... with the extension of the compression. The index
+//path.index maps timestamps to uncompressed offsets in the segments,
+//see ReadOplogRecordingFiles. Every Write has to be one bson document,
+//like RecordOplog writes them.
+type RecordingWriter struct {
+	path       string
+	settings   RecordingSettings
+	index      *os.File
+	file       *os.File
+	compressor io.WriteCloser
+	segment    int
+	size       int64
+	entries    int
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+//NewRecordingWriter creates the index of the recording at path
+func NewRecordingWriter(path string, settings RecordingSettings) (*RecordingWriter, error) {
+	if _, ok := recordingExtensions[settings.Compression]; !ok {
+		return nil, fmt.Errorf("Unknown compression %s, use gzip or zstd", settings.Compression)
+	}
+
+	index, err := os.Create(path + recordingIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordingWriter{path: path, settings: settings, index: index}, nil
+}
+
+func (r *RecordingWriter) Write(data []byte) (int, error) {
+	var entry struct {
+		Ts bson.MongoTimestamp `bson:"ts"`
+	}
+	if err := bson.Unmarshal(data, &entry); err != nil {
+		return 0, err
+	}
+
+	if r.file == nil || (r.settings.MaxSize > 0 && r.size >= r.settings.MaxSize) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.entries%recordingIndexInterval == 0 {
+		line, err := json.Marshal(recordingIndexEntry{
+			Timestamp: entry.Ts,
+			Segment:   filepath.Base(r.file.Name()),
+			Offset:    r.size,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := r.index.Write(append(line, '\n')); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.compressor.Write(data)
+	r.size += int64(n)
+	r.entries++
+
+	return n, err
+}
+
+//rotate closes the current segment and starts the next one
+func (r *RecordingWriter) rotate() error {
+	if err := r.closeSegment(); err != nil {
+		return err
+	}
+
+	r.segment++
+	name := fmt.Sprintf("%s.%06d%s", r.path, r.segment, recordingExtensions[r.settings.Compression])
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	r.file, r.size, r.entries = file, 0, 0
+	switch r.settings.Compression {
+	case "gzip":
+		r.compressor = gzip.NewWriter(file)
+	case "zstd":
+		if r.compressor, err = zstd.NewWriter(file); err != nil {
+			return err
+		}
+	default:
+		r.compressor = nopWriteCloser{file}
+	}
+
+	return nil
+}
+
+func (r *RecordingWriter) closeSegment() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.compressor.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file = nil
+
+	return err
+}
+
+//Close flushes the current segment and closes all files
+func (r *RecordingWriter) Close() error {
+	err := r.closeSegment()
+	if closeErr := r.index.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func readRecordingIndex(path string) ([]recordingIndexEntry, error) {
+	file, err := os.Open(path + recordingIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	index := []recordingIndexEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry recordingIndexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Invalid recording index %s: %s", path+recordingIndexSuffix, err.Error())
+		}
+
+		index = append(index, entry)
+	}
+
+	return index, scanner.Err()
+}
+
+//openRecordingSegment returns the uncompressed content of segment
+//starting at offset
+func openRecordingSegment(name string, offset int64) (io.Reader, func(), error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reader io.Reader = file
+	closer := func() { file.Close() }
+	switch filepath.Ext(name) {
+	case ".gz":
+		if reader, err = gzip.NewReader(file); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	case ".zst":
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		reader = decoder
+		closer = func() {
+			decoder.Close()
+			file.Close()
+		}
+	default:
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		return file, closer, nil
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, reader, offset); err != nil {
+		closer()
+		return nil, nil, err
+	}
+
+	return reader, closer, nil
+}
+
+//RecordingFilter selects the entries of a recording after From and up
+//to To, zero reads to the end. Namespaces are databases or collections
+//like shop.user, without any all entries are read.
+type RecordingFilter struct {
+	From       bson.MongoTimestamp
+	To         bson.MongoTimestamp
+	Namespaces []string
+}
+
+func (f RecordingFilter) matchesNamespace(entry map[string]interface{}) bool {
+	if len(f.Namespaces) == 0 {
+		return true
+	}
+
+	ns, _ := entry["ns"].(string)
+	for _, namespace := range f.Namespaces {
+		if ns == namespace || strings.HasPrefix(ns, namespace+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ReadOplogRecordingFiles calls handle with the entries selected by filter
+//of a recording written by a RecordingWriter, one at a time until handle
+//returns false. Segments and entries before From are skipped with the
+//index, compressed segments are decompressed up to the offset. Reading
+//stops at the first entry after To.
+func ReadOplogRecordingFiles(path string, filter RecordingFilter, handle func(entry map[string]interface{}) bool) error {
+	index, err := readRecordingIndex(path)
+	if err != nil {
+		return err
+	}
+
+	start := 0
+	for i, entry := range index {
+		if entry.Timestamp > filter.From {
+			break
+		}
+		start = i
+	}
+
+	done := false
+	dir := filepath.Dir(path)
+	for i := start; i < len(index) && !done; i++ {
+		if i > start && index[i].Segment == index[i-1].Segment {
+			continue
+		}
+
+		offset := int64(0)
+		if i == start {
+			offset = index[i].Offset
+		}
+
+		reader, closer, err := openRecordingSegment(filepath.Join(dir, index[i].Segment), offset)
+		if err != nil {
+			return err
+		}
+
+		err = readOplogEntries(reader, func(entry map[string]interface{}) bool {
+			ts, ok := entry["ts"].(bson.MongoTimestamp)
+			if ok && filter.To > 0 && ts > filter.To {
+				done = true
+				return false
+			}
+
+			if (!ok || ts > filter.From) && filter.matchesNamespace(entry) && !handle(entry) {
+				done = true
+				return false
+			}
+
+			return true
+		})
+		closer()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package redkeep_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recording files", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redkeep-recording")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	record := func(settings RecordingSettings, count int) string {
+		path := filepath.Join(dir, "oplog.bson")
+		writer, err := NewRecordingWriter(path, settings)
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= count; i++ {
+			ns := "shop.user"
+			if i%2 == 0 {
+				ns = "shop.order"
+			}
+
+			data, err := bson.Marshal(bson.M{"ts": bson.MongoTimestamp(i), "op": "i", "ns": ns})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = writer.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(writer.Close()).To(Succeed())
+		return path
+	}
+
+	read := func(path string, filter RecordingFilter) ([]map[string]interface{}, error) {
+		entries := []map[string]interface{}{}
+		err := ReadOplogRecordingFiles(path, filter, func(entry map[string]interface{}) bool {
+			entries = append(entries, entry)
+			return true
+		})
+		return entries, err
+	}
+
+	timestamps := func(entries []map[string]interface{}) []bson.MongoTimestamp {
+		result := []bson.MongoTimestamp{}
+		for _, entry := range entries {
+			result = append(result, entry["ts"].(bson.MongoTimestamp))
+		}
+		return result
+	}
+
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		compression := compression
+
+		It("reads all entries of rotated segments compressed with "+compression, func() {
+			path := record(RecordingSettings{Compression: compression, MaxSize: 20000}, 2500)
+
+			segments, err := filepath.Glob(path + ".0*")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(segments)).To(BeNumerically(">", 1))
+
+			entries, err := read(path, RecordingFilter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2500))
+			Expect(timestamps(entries)[2499]).To(Equal(bson.MongoTimestamp(2500)))
+		})
+
+		It("skips entries before the start with the index using "+compression, func() {
+			path := record(RecordingSettings{Compression: compression}, 2500)
+
+			entries, err := read(path, RecordingFilter{From: 2200})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(300))
+			Expect(timestamps(entries)[0]).To(Equal(bson.MongoTimestamp(2201)))
+		})
+	}
+
+	It("stops after the end", func() {
+		path := record(RecordingSettings{Compression: "gzip", MaxSize: 20000}, 2500)
+
+		entries, err := read(path, RecordingFilter{From: 1000, To: 1010})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(timestamps(entries)).To(Equal([]bson.MongoTimestamp{1001, 1002, 1003, 1004, 1005, 1006, 1007, 1008, 1009, 1010}))
+	})
+
+	It("streams the entries until they are no longer needed", func() {
+		path := record(RecordingSettings{Compression: "zstd", MaxSize: 20000}, 2500)
+
+		entries := []bson.MongoTimestamp{}
+		err := ReadOplogRecordingFiles(path, RecordingFilter{From: 100}, func(entry map[string]interface{}) bool {
+			entries = append(entries, entry["ts"].(bson.MongoTimestamp))
+			return len(entries) < 3
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(Equal([]bson.MongoTimestamp{101, 102, 103}))
+	})
+
+	It("filters namespaces", func() {
+		path := record(RecordingSettings{}, 2500)
+
+		entries, err := read(path, RecordingFilter{To: 6, Namespaces: []string{"shop.order"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(timestamps(entries)).To(Equal([]bson.MongoTimestamp{2, 4, 6}))
+
+		entries, err = read(path, RecordingFilter{To: 3, Namespaces: []string{"shop"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(timestamps(entries)).To(Equal([]bson.MongoTimestamp{1, 2, 3}))
+	})
+
+	It("rejects unknown compressions", func() {
+		_, err := NewRecordingWriter(filepath.Join(dir, "oplog.bson"), RecordingSettings{Compression: "lz4"})
+		Expect(err).To(MatchError("Unknown compression lz4, use gzip or zstd"))
+	})
+})
@@ -0,0 +1,166 @@
+package redkeep
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const redisTimeout = 5 * time.Second
+
+//RedisSettings configures the connection to a redis server
+type RedisSettings struct {
+	Address  string `json:"address" validate:"required,min=1"`
+	Password string `json:"password"`
+	Database int    `json:"database"`
+}
+
+//redisClient is a minimal redis client that speaks
+//enough RESP for publishing and deleting keys.
+//It reconnects lazily after errors.
+type redisClient struct {
+	sync.Mutex
+	settings RedisSettings
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func newRedisClient(settings RedisSettings) *redisClient {
+	return &redisClient{settings: settings}
+}
+
+func (c *redisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.settings.Address, redisTimeout)
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if c.settings.Password != "" {
+		if _, err := c.roundtrip("AUTH", c.settings.Password); err != nil {
+			c.reset()
+			return err
+		}
+	}
+
+	if c.settings.Database != 0 {
+		if _, err := c.roundtrip("SELECT", strconv.Itoa(c.settings.Database)); err != nil {
+			c.reset()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *redisClient) reset() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	c.conn = nil
+	c.reader = nil
+}
+
+//Do executes one command and returns the reply
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundtrip(args...)
+	if _, isRedisError := err.(redisError); err != nil && !isRedisError {
+		c.reset()
+	}
+
+	return reply, err
+}
+
+func (c *redisClient) roundtrip(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	buffer := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		buffer += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := io.WriteString(c.conn, buffer); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(c.reader)
+}
+
+func (c *redisClient) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.reset()
+	return nil
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 {
+		return nil, errors.New("Invalid redis reply")
+	}
+
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		elements := make([]interface{}, size)
+		for i := range elements {
+			if elements[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return elements, nil
+	}
+
+	return nil, errors.New("Invalid redis reply")
+}
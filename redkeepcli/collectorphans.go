@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//collectOrphans applies the delete policies to targets whose tracked
+//document was deleted before redkeep saw it and prints a json line per watch
+func collectOrphans(arguments []string) {
+	flags := flag.NewFlagSet("collect-orphans", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	watch := flags.String("watch", "", "only targets of the watch with this key")
+	dryRun := flags.Bool("dry-run", false, "count the orphaned targets without changing them")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, w := range config.Watches {
+		if *watch != "" && w.Key() != *watch {
+			continue
+		}
+
+		result, err := redkeep.CollectOrphanedTargets(context.Background(), session, w, *dryRun)
+		//without -watch the watches that ignore deletes are skipped
+		if err != nil && *watch == "" && result.Policy == redkeep.OnDeleteIgnore {
+			continue
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := encoder.Encode(result); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//coverage reports which oplog traffic the watches miss
+func coverage(arguments []string) {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	window := flags.Duration("window", 5*time.Minute, "how long the oplog is sampled")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	log.Printf("Sampling the oplog for %s\n", *window)
+	report, err := redkeep.ReportWatchCoverage(session, config.Watches, *window)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Sampled %d oplog entries\n", report.Entries)
+	namespaces := []string{}
+	for ns := range report.Unwatched {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return report.Unwatched[namespaces[i]] > report.Unwatched[namespaces[j]]
+	})
+
+	for _, ns := range namespaces {
+		log.Printf("Not watched: %s (%d entries)\n", ns, report.Unwatched[ns])
+	}
+
+	for _, key := range report.Unused {
+		log.Println("No traffic:", key)
+	}
+}
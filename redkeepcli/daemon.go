@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/manyminds/redkeep"
+)
+
+//writePIDFile writes the pid of the process to path, it fails if the
+//file belongs to a process that is still running. The returned function
+//removes the file.
+func writePIDFile(path string) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+
+	if content, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err == nil && pid != os.Getpid() && processRunning(pid) {
+			return nil, fmt.Errorf("Pid file %s belongs to running process %d", path, pid)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return nil, err
+	}
+
+	return func() { os.Remove(path) }, nil
+}
+
+//sdNotify sends state to systemd, it does nothing
+//if the process was not started by systemd
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Println("Notify systemd failed:", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Println("Notify systemd failed:", err)
+	}
+}
+
+//watchdogInterval is half the watchdog timeout of systemd,
+//zero if the watchdog is not enabled for this process
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+//agent is a tail agent of a replica set or of all shards of a cluster
+type agent interface {
+	TailContext(ctx context.Context, opts redkeep.TailOptions) error
+	Status() redkeep.AgentStatus
+	ReloadWatches(watches []redkeep.Watch, backfill bool) (redkeep.WatchChanges, error)
+	Close()
+}
+
+//onlyWatchesChanged is true if reloaded differs from config in its watches only
+func onlyWatchesChanged(config, reloaded redkeep.Configuration) bool {
+	config.Watches, reloaded.Watches = nil, nil
+	return reflect.DeepEqual(config, reloaded)
+}
+
+//withDryRun reports the writes of config on stdout if dryRun is set
+//and the configuration does not report them elsewhere
+func withDryRun(config *redkeep.Configuration, dryRun bool) {
+	if dryRun && config.DryRun == nil {
+		config.DryRun = &redkeep.DryRunSettings{}
+	}
+}
+
+//agentStart is where an agent starts, after the checkpoint
+//of its configuration or at time
+type agentStart struct {
+	checkpoint bool
+	time       time.Time
+}
+
+func hasCheckpoint(config redkeep.Configuration) bool {
+	return config.Checkpoint.File != "" || config.Checkpoint.Collection != ""
+}
+
+//firstStart resumes from the checkpoint if one is configured and options
+//ask for neither a rescan nor a backfill, otherwise the agent starts now
+func firstStart(config redkeep.Configuration, options redkeep.TailOptions, now time.Time) agentStart {
+	return agentStart{checkpoint: hasCheckpoint(config) && !options.ForceRescan && !options.Backfill, time: now}
+}
+
+//reloadStart is where the agent of the reloaded configuration continues
+//after the agent of config stopped with lag seconds. If both store the
+//same checkpoint it resumes after the one the stopped agent stored last.
+//Otherwise it starts a second before the entries it had not handled yet,
+//those entries are handled again: their writes set the same values, only
+//histories on the targets can get them twice.
+func reloadStart(config, reloaded redkeep.Configuration, lag float64, now time.Time) agentStart {
+	if hasCheckpoint(reloaded) && reflect.DeepEqual(config.Checkpoint, reloaded.Checkpoint) {
+		return agentStart{checkpoint: true}
+	}
+
+	return agentStart{time: now.Add(-time.Duration(lag*float64(time.Second)) - time.Second)}
+}
+
+//newAgent creates the agent of config that starts at start
+func newAgent(config redkeep.Configuration, start agentStart) (agent, error) {
+	switch {
+	case config.Mongo.Sharded && start.checkpoint:
+		return redkeep.NewShardedTailAgentFromCheckpoint(config)
+	case config.Mongo.Sharded:
+		return redkeep.NewShardedTailAgentWithStartDate(config, start.time)
+	case start.checkpoint:
+		return redkeep.NewTailAgentFromCheckpoint(config)
+	}
+
+	return redkeep.NewTailAgentWithStartDate(config, start.time)
+}
+
+//runAgent tails the oplog until signals gets SIGTERM or SIGINT, it resumes from the
+//checkpoint if one is configured and options ask for neither a rescan nor
+//a backfill. Options only apply to the first start. On SIGTERM or SIGINT the agent
+//handles the entries that were already read before it stops. SIGHUP
+//reads the configuration again. If only the watches changed they are
+//reloaded without interrupting the agent, with -backfill the new and
+//changed ones are backfilled. Otherwise the agent is stopped, its sessions
+//are closed and a new one continues where it stopped, see reloadStart.
+//An invalid configuration is logged and ignored.
+//With dryRun the agent reports its writes, see Configuration.DryRun.
+func runAgent(configurationFilepath string, options redkeep.TailOptions, pidFile string, dryRun bool, signals <-chan os.Signal) {
+	removePIDFile, err := writePIDFile(pidFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer removePIDFile()
+
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	config := readConfiguration(configurationFilepath)
+	withDryRun(config, dryRun)
+	for _, change := range redkeep.ApplyResourceSettings(config.Resources) {
+		log.Println("Resources limited:", change)
+	}
+
+	backfill := options.Backfill
+	start := firstStart(*config, options, time.Now())
+	for {
+		//the level can change with a reload
+		if err := redkeep.ApplyLogSettings(config.Log); err != nil {
+			log.Fatal(err)
+		}
+
+		agent, err := newAgent(*config, start)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func(options redkeep.TailOptions) {
+			err := agent.TailContext(ctx, options)
+			if err == context.Canceled {
+				err = nil
+			}
+			done <- err
+		}(options)
+		options = redkeep.TailOptions{}
+
+		log.Println("Agent started.")
+		sdNotify("READY=1\nSTATUS=Tailing the oplog")
+
+		reload := false
+		for !reload {
+			select {
+			case err := <-done:
+				stop()
+				agent.Close()
+				if err != nil {
+					log.Fatal(err)
+				}
+				return
+			case <-watchdog:
+				sdNotify("WATCHDOG=1")
+			case s := <-signals:
+				if s != syscall.SIGHUP {
+					sdNotify("STOPPING=1")
+					stop()
+					if err := <-done; err != nil {
+						log.Println(err)
+					}
+					agent.Close()
+					return
+				}
+
+				reloaded, err := loadConfiguration(configurationFilepath)
+				if err != nil {
+					log.Println("Configuration not reloaded:", err)
+					continue
+				}
+				withDryRun(reloaded, dryRun)
+
+				sdNotify("RELOADING=1")
+				if onlyWatchesChanged(*config, *reloaded) {
+					changes, err := agent.ReloadWatches(reloaded.Watches, backfill)
+					if err != nil {
+						log.Println("Watches not reloaded:", err)
+					} else {
+						config = reloaded
+						log.Println("Watches reloaded:", changes)
+					}
+					sdNotify("READY=1")
+					continue
+				}
+
+				lag := agent.Status().Metrics[redkeep.MetricLagSeconds]
+				stop()
+				if err := <-done; err != nil {
+					log.Println(err)
+				}
+				agent.Close()
+
+				start = reloadStart(*config, *reloaded, lag, time.Now())
+				config, reload = reloaded, true
+				log.Println("Configuration reloaded.")
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloads", func() {
+	now := time.Date(2016, 2, 1, 13, 0, 0, 0, time.UTC)
+	checkpointed := redkeep.Configuration{Checkpoint: redkeep.CheckpointSettings{Collection: "redkeep.checkpoints"}}
+
+	It("resume from the checkpoint the stopped agent stored", func() {
+		reloaded := checkpointed
+		reloaded.Watches = []redkeep.Watch{{TrackCollection: "live.user"}}
+		Expect(reloadStart(checkpointed, reloaded, 30, now)).To(Equal(agentStart{checkpoint: true}))
+	})
+
+	It("start before the entries the stopped agent had not handled without a checkpoint", func() {
+		Expect(reloadStart(redkeep.Configuration{}, redkeep.Configuration{}, 30, now)).To(Equal(agentStart{time: now.Add(-31 * time.Second)}))
+
+		moved := redkeep.Configuration{Checkpoint: redkeep.CheckpointSettings{File: "/var/lib/redkeep/checkpoint.json"}}
+		Expect(reloadStart(checkpointed, moved, 0, now)).To(Equal(agentStart{time: now.Add(-time.Second)}))
+	})
+
+	It("resume the first agent unless a rescan or backfill was asked for", func() {
+		Expect(firstStart(checkpointed, redkeep.TailOptions{}, now)).To(Equal(agentStart{checkpoint: true, time: now}))
+		Expect(firstStart(checkpointed, redkeep.TailOptions{Backfill: true}, now)).To(Equal(agentStart{time: now}))
+		Expect(firstStart(redkeep.Configuration{}, redkeep.TailOptions{}, now)).To(Equal(agentStart{time: now}))
+	})
+})
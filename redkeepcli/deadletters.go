@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//deadLetters lists, replays and purges the changes stored
+//in the dead letter collection
+func deadLetters(arguments []string) {
+	if len(arguments) == 0 {
+		log.Fatal("Usage: redkeepcli dead-letters list|replay|purge [flags]")
+	}
+
+	action := arguments[0]
+	flags := flag.NewFlagSet("dead-letters "+action, flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	watch := flags.String("watch", "", "only dead letters of the watch with this key")
+	since := flags.Duration("since", 0, "only dead letters that failed within this duration, like 24h")
+	limit := flags.Int("limit", 20, "maximum number of listed or replayed dead letters, 0 for all")
+	flags.Parse(arguments[1:])
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	session.SetMode(mgo.Strong, true)
+	metadata, err := redkeep.DialMetadata(*config, session)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer metadata.Close()
+
+	filter := redkeep.DeadLetterFilter{Watch: *watch}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	switch action {
+	case "list":
+		letters, err := redkeep.ListDeadLetters(metadata, config.DeadLetters, filter, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, letter := range letters {
+			fmt.Printf("%s %s %s %s %s attempts=%d %s\n", letter.ID.Hex(), letter.Failed.Format(time.RFC3339), letter.Watch, letter.Operation, letter.Namespace, letter.Attempts, letter.Error)
+		}
+	case "replay":
+		report, err := redkeep.ReplayDeadLetters(session, metadata, config.DeadLetters, config.Watches, filter, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Replayed %d dead letters, %d failed again, %d skipped\n", report.Replayed, report.Failed, report.Skipped)
+	case "purge":
+		removed, err := redkeep.PurgeDeadLetters(metadata, config.DeadLetters, filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Purged %d dead letters\n", removed)
+	default:
+		log.Fatalf("Unknown action %s, use list, replay or purge", action)
+	}
+}
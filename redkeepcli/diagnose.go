@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//diagnose writes a support bundle to attach to bug reports
+func diagnose(arguments []string) {
+	flags := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	output := flags.String("output", "redkeep-support.tar.gz", "path of the support bundle")
+	agentURL := flags.String("agent", "", "url of the admin server of the running agent, defaults to admin.listen of the configuration")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	if *agentURL == "" && config.Admin.Listen != "" {
+		*agentURL = "http://" + config.Admin.Listen
+	}
+
+	session, err := mgo.DialWithTimeout(config.Mongo.ConnectionURI, 10*time.Second)
+	if err != nil {
+		log.Println("MongoDB is not reachable, the bundle will not contain its build info:", err)
+		session = nil
+	} else {
+		defer session.Close()
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := redkeep.WriteSupportBundle(file, *config, session, *agentURL); err != nil {
+		file.Close()
+		log.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Support bundle written to", *output)
+}
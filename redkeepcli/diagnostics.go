@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//diagnostics lists, inspects, purges and exports the unknown operations
+//stored in the diagnostics collection
+func diagnostics(arguments []string) {
+	if len(arguments) == 0 {
+		log.Fatal("Usage: redkeepcli diagnostics list|inspect|purge|export [flags]")
+	}
+
+	action := arguments[0]
+	flags := flag.NewFlagSet("diagnostics "+action, flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	namespace := flags.String("ns", "", "only entries of this namespace, like shop.user")
+	operation := flags.String("op", "", "only entries of this operation type")
+	since := flags.Duration("since", 0, "only entries captured within this duration, like 24h")
+	limit := flags.Int("limit", 20, "maximum number of listed entries, 0 lists all")
+	id := flags.String("id", "", "id of the entry to inspect")
+	output := flags.String("output", "unknown-operations.json", "path of the export, one json document per line")
+	flags.Parse(arguments[1:])
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	metadata, err := redkeep.DialMetadata(*config, session)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer metadata.Close()
+
+	filter := redkeep.DiagnosticsFilter{Namespace: *namespace, Operation: *operation}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	switch action {
+	case "list":
+		examples, err := redkeep.ListUnknownOperations(metadata, config.Diagnostics, filter, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, example := range examples {
+			id, _ := example["_id"].(bson.ObjectId)
+			fmt.Printf("%s %v %v %v\n", id.Hex(), example["captured"], example["ns"], example["op"])
+		}
+	case "inspect":
+		if !bson.IsObjectIdHex(*id) {
+			log.Fatalf("Invalid id %q", *id)
+		}
+
+		example, err := redkeep.InspectUnknownOperation(metadata, config.Diagnostics, bson.ObjectIdHex(*id))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		data, err := json.MarshalIndent(example, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+	case "purge":
+		removed, err := redkeep.PurgeUnknownOperations(metadata, config.Diagnostics, filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Purged %d entries\n", removed)
+	case "export":
+		examples, err := redkeep.ListUnknownOperations(metadata, config.Diagnostics, filter, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		encoder := json.NewEncoder(file)
+		for _, example := range examples {
+			if err := encoder.Encode(example); err != nil {
+				file.Close()
+				log.Fatal(err)
+			}
+		}
+
+		if err := file.Close(); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Exported %d entries to %s\n", len(examples), *output)
+	default:
+		log.Fatalf("Unknown action %s, use list, inspect, purge or export", action)
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+//database drivers that can be used by the sql sink
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
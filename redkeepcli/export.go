@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//exportParquet writes a parquet snapshot of the tracked collection of a watch
+func exportParquet(arguments []string) {
+	flags := flag.NewFlagSet("export-parquet", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	watchKey := flags.String("watch", "", "name of the watch or track->target.field")
+	output := flags.String("output", "snapshot.parquet", "path of the parquet file")
+	rowsPerGroup := flags.Int("rows-per-group", 100000, "rows per parquet row group")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+
+	var watch *redkeep.Watch
+	for i, w := range config.Watches {
+		if w.Key() == *watchKey {
+			watch = &config.Watches[i]
+		}
+	}
+
+	if watch == nil {
+		log.Fatalf("No watch %s configured\n", *watchKey)
+	}
+
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts, count, err := redkeep.ExportParquet(session, *watch, file, *rowsPerGroup)
+	if err != nil {
+		file.Close()
+		os.Remove(*output)
+		log.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Exported %d documents of %s at oplog timestamp %d to %s\n", count, watch.TrackCollection, ts, *output)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//loadgen writes synthetic traffic for the watches into scratch databases
+func loadgen(arguments []string) {
+	flags := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	target := flags.String("target", "", "mongodb for the scratch databases, defaults to the configured one")
+	prefix := flags.String("scratch-prefix", "redkeep_load_", "prefix of the scratch databases, they are dropped")
+	rate := flags.Int("rate", 100, "operations per second")
+	duration := flags.Duration("duration", time.Minute, "how long the load is written, like 10m")
+	documents := flags.Int("documents", 1000, "tracked documents per watch")
+	mix := flags.String("mix", "20,70,10", "percent of inserts, updates and deletes")
+	skewed := flags.Bool("skewed", false, "80% of the references go to 20% of the tracked documents")
+	agentConfig := flags.String("agent-config", "", "only write the configuration of the agent with mock targets to this path")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	if *target == "" {
+		*target = config.Mongo.ConnectionURI
+	}
+
+	settings := redkeep.LoadSettings{
+		ScratchPrefix: *prefix,
+		Rate:          *rate,
+		Duration:      *duration,
+		Documents:     *documents,
+		Skewed:        *skewed,
+	}
+	shares := strings.Split(*mix, ",")
+	if len(shares) != 3 {
+		log.Fatalf("Invalid mix %q, use inserts,updates,deletes", *mix)
+	}
+	for i, share := range []*int{&settings.Inserts, &settings.Updates, &settings.Deletes} {
+		value, err := strconv.Atoi(strings.TrimSpace(shares[i]))
+		if err != nil {
+			log.Fatalf("Invalid mix %q, use inserts,updates,deletes", *mix)
+		}
+		*share = value
+	}
+
+	if *agentConfig != "" {
+		scratch := redkeep.ScratchConfiguration(*config, *prefix)
+		scratch.Mongo.ConnectionURI = *target
+		data, err := json.MarshalIndent(scratch, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(*agentConfig, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("Start the agent with", *agentConfig, "before the load is written")
+		return
+	}
+
+	session, err := mgo.Dial(*target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	session.SetMode(mgo.Strong, true)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop()
+	}()
+
+	report, err := redkeep.GenerateLoad(ctx, session, config.Watches, settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Wrote %d inserts, %d updates and %d deletes, %d failed\n", report.Inserts, report.Updates, report.Deletes, report.Failures)
+}
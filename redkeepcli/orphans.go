@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//orphans prints the stored targets with orphaned references as json
+//lines, one per target, for cleanups
+func orphans(arguments []string) {
+	flags := flag.NewFlagSet("orphans", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	watch := flags.String("watch", "", "only targets of the watch with this key")
+	limit := flags.Int("limit", 0, "maximum number of listed targets, 0 for all")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	metadata, err := redkeep.DialMetadata(*config, session)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer metadata.Close()
+
+	orphaned, err := redkeep.ListOrphanedReferences(metadata, config.Orphans, *watch, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, orphan := range orphaned {
+		if err := encoder.Encode(orphan); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
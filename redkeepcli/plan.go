@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//planRescan estimates the impact of -rescan without writing anything
+func planRescan(arguments []string) {
+	flags := flag.NewFlagSet("plan-rescan", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	plan, err := redkeep.PlanRescan(session, config.Watches)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("A rescan reads %d oplog entries from %s to %s\n", plan.Entries, plan.From, plan.To)
+	keys := []string{}
+	for key := range plan.Watches {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		w := plan.Watches[key]
+		log.Printf("%s: %d updates with a fan-out of %.1f, %d inserted targets, %d writes changing about %d targets\n",
+			key, w.Updates, w.FanOut, w.Inserts, w.Writes, w.Documents)
+	}
+
+	log.Printf("In total %d writes changing about %d targets\n", plan.Writes, plan.Documents)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//recordOplog writes oplog entries to a file for replay-check
+func recordOplog(arguments []string) {
+	flags := flag.NewFlagSet("record-oplog", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	output := flags.String("output", "oplog.bson", "path of the recording")
+	since := flags.Duration("since", time.Hour, "record the entries of this period")
+	limit := flags.Int("limit", 0, "maximum number of entries, 0 records all")
+	compression := flags.String("compression", "", "gzip or zstd, writes indexed segments")
+	maxSize := flags.Int64("max-size", 0, "bytes per segment, writes indexed segments")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	var file io.WriteCloser
+	if *compression != "" || *maxSize > 0 {
+		file, err = redkeep.NewRecordingWriter(*output, redkeep.RecordingSettings{Compression: *compression, MaxSize: *maxSize})
+	} else {
+		file, err = os.Create(*output)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	from := bson.MongoTimestamp(time.Now().Add(-*since).Unix() << 32)
+	count, err := redkeep.RecordOplog(session, file, from, *limit)
+	if err != nil {
+		file.Close()
+		log.Fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Recorded %d oplog entries to %s\n", count, *output)
+}
+
+//replayCheck replays a recording twice and compares the results
+func replayCheck(arguments []string) {
+	flags := flag.NewFlagSet("replay-check", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	input := flags.String("input", "oplog.bson", "path of the recording")
+	target := flags.String("target", "", "mongodb for the scratch databases, defaults to the configured one")
+	prefix := flags.String("scratch-prefix", "redkeep_replay_", "prefix of the scratch databases, they are dropped")
+	from := flags.String("from", "", "RFC3339 time, replays indexed recordings from then")
+	to := flags.String("to", "", "RFC3339 time, replays indexed recordings up to then")
+	namespaces := flags.String("namespaces", "", "comma separated databases or collections to replay from indexed recordings")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	if *target == "" {
+		*target = config.Mongo.ConnectionURI
+	}
+
+	entries, err := readRecording(*input, *from, *to, *namespaces)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	session, err := mgo.Dial(*target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	session.SetMode(mgo.Strong, true)
+
+	report, err := redkeep.CheckReplayDeterminism(session, entries, config.Watches, *prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Replayed %d entries twice\n", report.Entries)
+	for ns, count := range report.Documents {
+		log.Printf("%s: %d documents\n", ns, count)
+	}
+
+	if !report.Deterministic() {
+		for _, difference := range report.Differences {
+			log.Println("Differs:", difference)
+		}
+
+		log.Fatalf("Replays differ in %d documents\n", len(report.Differences))
+	}
+
+	log.Println("Replays are identical.")
+}
+
+//readRecording streams the selected entries of indexed recordings,
+//others completely. The files are opened again for every replay.
+func readRecording(input, from, to, namespaces string) (redkeep.OplogEntries, error) {
+	if _, err := os.Stat(input + ".index"); err != nil {
+		if from != "" || to != "" || namespaces != "" {
+			return nil, errors.New("-from, -to and -namespaces need a recording with an index")
+		}
+
+		return func(handle func(entry map[string]interface{}) bool) error {
+			file, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			return redkeep.StreamOplogRecording(file, handle)
+		}, nil
+	}
+
+	filter := redkeep.RecordingFilter{}
+	if namespaces != "" {
+		filter.Namespaces = strings.Split(namespaces, ",")
+	}
+
+	for _, bound := range []struct {
+		value string
+		ts    *bson.MongoTimestamp
+	}{{from, &filter.From}, {to, &filter.To}} {
+		if bound.value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return nil, err
+		}
+		*bound.ts = bson.MongoTimestamp(t.Unix() << 32)
+	}
+
+	return func(handle func(entry map[string]interface{}) bool) error {
+		return redkeep.ReadOplogRecordingFiles(input, filter, handle)
+	}, nil
+}
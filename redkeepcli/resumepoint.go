@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//resumePoint prints where a change stream continues after the checkpoint
+func resumePoint(arguments []string) {
+	flags := flag.NewFlagSet("resume-point", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	session, err := mgo.Dial(config.Mongo.ConnectionURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	point, err := redkeep.ChangeStreamResumePoint(session, *config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	start := point.StartAtOperationTime
+	log.Printf("Checkpoint %d, change streams continue with\n", point.Checkpoint)
+	fmt.Printf("{\"$changeStream\": {\"startAtOperationTime\": Timestamp(%d, %d)}}\n", start>>32, start&0xffffffff)
+}
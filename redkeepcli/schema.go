@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+)
+
+//schema prints the JSON schema of the fields the watches write
+func schema(arguments []string) {
+	flags := flag.NewFlagSet("schema", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	sample := flags.Int("sample", 0, "documents of every tracked collection the types are read from")
+	flags.Parse(arguments)
+
+	config := readConfiguration(*configurationFilepath)
+	var types map[string][]string
+	if *sample > 0 {
+		session, err := mgo.Dial(config.Mongo.ConnectionURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer session.Close()
+
+		if types, err = redkeep.SampleSchemaTypes(session, config.Watches, *sample); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(redkeep.NewSchemaManifest(config.Watches, types)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=redkeep agent {{.Name}}
+Wants=network-online.target
+After=network-online.target
+StartLimitIntervalSec=300
+StartLimitBurst=10
+
+[Service]
+Type=notify
+ExecStart={{.Executable}} -config "{{.Config}}" -pidfile /run/{{.Name}}/{{.Name}}.pid
+ExecReload=/bin/kill -HUP $MAINPID
+PIDFile=/run/{{.Name}}/{{.Name}}.pid
+RuntimeDirectory={{.Name}}
+{{- if .User}}
+User={{.User}}
+{{- end}}
+Restart=on-failure
+RestartSec=5
+WatchdogSec=60
+TimeoutStopSec=30
+{{- if .LogFile}}
+StandardOutput=append:{{.LogFile}}
+StandardError=append:{{.LogFile}}
+{{- else}}
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier={{.Name}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+type serviceDefinition struct {
+	Name       string
+	Executable string
+	Config     string
+	User       string
+	LogFile    string
+}
+
+//systemdUnitFile is the systemd unit of definition
+func systemdUnitFile(definition serviceDefinition) (string, error) {
+	var unit strings.Builder
+	if err := systemdUnit.Execute(&unit, definition); err != nil {
+		return "", err
+	}
+
+	return unit.String(), nil
+}
+
+//writeSystemdUnit writes the unit of definition to directory, with print
+//it is printed instead
+func writeSystemdUnit(definition serviceDefinition, directory string, print bool) {
+	unit, err := systemdUnitFile(definition)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if print {
+		fmt.Print(unit)
+		return
+	}
+
+	path := filepath.Join(directory, definition.Name+".service")
+	if err := ioutil.WriteFile(path, []byte(unit), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Unit written to", path)
+	log.Printf("Start it with: systemctl daemon-reload && systemctl enable --now %s\n", definition.Name)
+}
+
+//installService registers redkeepcli as systemd unit or as Windows service
+func installService(arguments []string) {
+	flags := flag.NewFlagSet("install-service", flag.ExitOnError)
+	configurationFilepath := flags.String("config", "configuration.json", "path to the configuration file")
+	name := flags.String("name", "redkeep", "name of the service")
+	user := flags.String("user", "", "user the service runs as, root or LocalSystem if empty")
+	logFile := flags.String("log", "", "file the log is appended to, the journal if empty")
+	unitDirectory := flags.String("unit-dir", "/etc/systemd/system", "directory the unit is written to")
+	printUnit := flags.Bool("print", false, "print the unit or service instead of installing it")
+	flags.Parse(arguments)
+
+	readConfiguration(*configurationFilepath)
+	definition := serviceDefinition{Name: *name, User: *user, LogFile: *logFile}
+
+	var err error
+	if definition.Executable, err = os.Executable(); err != nil {
+		log.Fatal(err)
+	}
+	if definition.Config, err = filepath.Abs(*configurationFilepath); err != nil {
+		log.Fatal(err)
+	}
+	if definition.LogFile != "" {
+		if definition.LogFile, err = filepath.Abs(definition.LogFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	registerService(definition, *unitDirectory, *printUnit)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+//registerService writes the systemd unit of definition
+func registerService(definition serviceDefinition, unitDirectory string, print bool) {
+	if runtime.GOOS != "linux" {
+		log.Fatalf("Services on %s are not supported, use systemd on linux or Windows services\n", runtime.GOOS)
+	}
+
+	writeSystemdUnit(definition, unitDirectory, print)
+}
+
+//runAsService is false, only Windows starts redkeepcli as service
+func runAsService(signals chan os.Signal, run func()) bool {
+	return false
+}
+
+//processRunning is true if a process with pid exists
+func processRunning(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
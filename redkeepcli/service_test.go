@@ -0,0 +1,31 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Systemd units", func() {
+	definition := serviceDefinition{Name: "redkeep-eu", Executable: "/usr/local/bin/redkeepcli", Config: "/etc/redkeep/configuration.json"}
+
+	It("run the agent with its configuration and log to the journal", func() {
+		unit, err := systemdUnitFile(definition)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unit).To(ContainSubstring("Description=redkeep agent redkeep-eu\n"))
+		Expect(unit).To(ContainSubstring(`ExecStart=/usr/local/bin/redkeepcli -config "/etc/redkeep/configuration.json" -pidfile /run/redkeep-eu/redkeep-eu.pid` + "\n"))
+		Expect(unit).To(ContainSubstring("RuntimeDirectory=redkeep-eu\n"))
+		Expect(unit).To(ContainSubstring("StandardOutput=journal\nStandardError=journal\nSyslogIdentifier=redkeep-eu\n"))
+		Expect(unit).ToNot(ContainSubstring("User="))
+	})
+
+	It("run the agent as user and append the log to a file", func() {
+		logging := definition
+		logging.User = "redkeep"
+		logging.LogFile = "/var/log/redkeep.log"
+		unit, err := systemdUnitFile(logging)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unit).To(ContainSubstring("RuntimeDirectory=redkeep-eu\nUser=redkeep\nRestart=on-failure\n"))
+		Expect(unit).To(ContainSubstring("StandardOutput=append:/var/log/redkeep.log\nStandardError=append:/var/log/redkeep.log\n\n"))
+		Expect(unit).ToNot(ContainSubstring("journal"))
+	})
+})
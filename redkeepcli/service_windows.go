@@ -0,0 +1,135 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+//registerService creates the Windows service of definition, it starts
+//with Windows and is restarted on failures. With print the service is
+//printed instead.
+func registerService(definition serviceDefinition, unitDirectory string, print bool) {
+	arguments := serviceArguments(definition)
+	if print {
+		fmt.Printf("%s: \"%s\" %s\n", definition.Name, definition.Executable, strings.Join(arguments, " "))
+		return
+	}
+
+	manager, err := mgr.Connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer manager.Disconnect()
+
+	if service, err := manager.OpenService(definition.Name); err == nil {
+		service.Close()
+		log.Fatalf("Service %s exists already\n", definition.Name)
+	}
+
+	config := mgr.Config{
+		DisplayName:      "redkeep agent " + definition.Name,
+		Description:      "Keeps denormalized fields of MongoDB documents up to date",
+		StartType:        mgr.StartAutomatic,
+		ServiceStartName: definition.User,
+	}
+	service, err := manager.CreateService(definition.Name, definition.Executable, config, arguments...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer service.Close()
+
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}
+	if err := service.SetRecoveryActions(restart, 300); err != nil {
+		log.Println("Service is not restarted on failures:", err)
+	}
+
+	log.Println("Service", definition.Name, "created")
+	log.Printf("Start it with: sc start %s\n", definition.Name)
+}
+
+//serviceArguments are the arguments the service of definition is started with
+func serviceArguments(definition serviceDefinition) []string {
+	arguments := []string{"-config", definition.Config}
+	if definition.LogFile != "" {
+		arguments = append(arguments, "-logfile", definition.LogFile)
+	}
+
+	return arguments
+}
+
+//windowsService passes the requests of the service control manager
+//to the agent as signals: stop and shutdown as SIGTERM, a parameter
+//change as SIGHUP to reload the configuration
+type windowsService struct {
+	signals chan os.Signal
+	run     func()
+}
+
+func (s windowsService) Execute(arguments []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		s.run()
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				s.signals <- syscall.SIGTERM
+				<-done
+				return false, 0
+			case svc.ParamChange:
+				s.signals <- syscall.SIGHUP
+			}
+		}
+	}
+}
+
+//runAsService runs run as Windows service if the service control manager
+//started redkeepcli, it returns false otherwise
+func runAsService(signals chan os.Signal, run func()) bool {
+	service, err := svc.IsWindowsService()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !service {
+		return false
+	}
+
+	if err := svc.Run("redkeep", windowsService{signals: signals, run: run}); err != nil {
+		log.Fatal(err)
+	}
+
+	return true
+}
+
+//processRunning is true if a process with pid exists
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	process.Release()
+	return true
+}
@@ -4,34 +4,89 @@ import (
 	"flag"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/manyminds/redkeep"
 )
 
+//commands are started with redkeepcli <command> [flags],
+//without a command the agent is started
+var commands = map[string]func(arguments []string){
+	"export-parquet":  exportParquet,
+	"collect-orphans": collectOrphans,
+	"coverage":        coverage,
+	"dead-letters":    deadLetters,
+	"diagnose":        diagnose,
+	"diagnostics":     diagnostics,
+	"install-service": installService,
+	"loadgen":         loadgen,
+	"orphans":         orphans,
+	"plan-rescan":     planRescan,
+	"record-oplog":    recordOplog,
+	"resume-point":    resumePoint,
+	"schema":          schema,
+	"replay-check":    replayCheck,
+}
+
+//loadConfiguration loads and validates the configuration file
+func loadConfiguration(path string) (*redkeep.Configuration, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return redkeep.NewConfiguration(file)
+}
+
+//readConfiguration loads and validates the configuration file,
+//the process exits if it is invalid
+func readConfiguration(path string) *redkeep.Configuration {
+	config, err := loadConfiguration(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return config
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	configurationFilepath := flag.String("config", "configuration.json", "path to the configuration file")
 	rescan := flag.Bool("rescan", false, "shall we start from the oplog beginnging?")
+	backfill := flag.Bool("backfill", false, "write the tracked fields of all documents before tailing and of watches added by a reload")
+	pidFile := flag.String("pidfile", "", "path of the pid file, none if empty")
+	logFile := flag.String("logfile", "", "file the log is appended to, stderr if empty")
+	dryRun := flag.Bool("dry-run", false, "report the writes to targets on stdout instead of sending them, unless dryRun is configured")
 	flag.Parse()
 
 	if configurationFilepath == nil {
 		return
 	}
 
-	file, err := ioutil.ReadFile(*configurationFilepath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	config, err := redkeep.NewConfiguration(file)
-	if err != nil {
-		log.Fatal(err)
+	if *logFile != "" {
+		file, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		log.SetOutput(file)
 	}
-	running := make(chan bool)
-	agent, err := redkeep.NewTailAgent(*config)
-	if err != nil {
-		log.Fatal(err)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	run := func() {
+		runAgent(*configurationFilepath, redkeep.TailOptions{ForceRescan: *rescan, Backfill: *backfill}, *pidFile, *dryRun, signals)
 	}
 
-	log.Println("Agent started.")
-	agent.Tail(running, *rescan)
-	running <- false
+	if !runAsService(signals, run) {
+		run()
+	}
 }
@@ -0,0 +1,310 @@
+package redkeep
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//frequencyFlushInterval is how often the lookup counts are stored
+const frequencyFlushInterval = time.Minute
+
+//ReferenceCacheSettings keep up to Size looked up tracked documents in
+//memory, zero disables the cache. Changes of a tracked document remove it
+//from the cache. With Frequencies (database.collection) the lookups of
+//every tracked document are counted there, with Prime the Size most
+//referenced documents are loaded into the cache before the oplog is read.
+type ReferenceCacheSettings struct {
+	Size        int    `json:"size" validate:"min=0"`
+	Frequencies string `json:"frequencies"`
+	Prime       bool   `json:"prime"`
+}
+
+func checkReferenceCacheSettings(settings ReferenceCacheSettings) error {
+	if c := settings.Frequencies; c != "" && strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Reference frequencies collection %s must be database.collection", c)
+	}
+
+	if settings.Prime && (settings.Size == 0 || settings.Frequencies == "") {
+		return errors.New("Priming the reference cache needs a size and a frequencies collection")
+	}
+
+	return nil
+}
+
+type cachedReference struct {
+	key      string
+	document map[string]interface{}
+}
+
+type referenceFrequency struct {
+	ref   mgo.DBRef
+	count int
+}
+
+//referenceCache keeps the most recently looked up tracked documents by
+//documentKey, all methods can be called on nil
+type referenceCache struct {
+	sync.Mutex
+	settings ReferenceCacheSettings
+	metrics  *metricRegistry
+	session  *mgo.Session
+	metadata *mgo.Session
+	entries  map[string]*list.Element
+	order    *list.List
+	//loading are the keys that are looked up right now, false
+	//if they changed meanwhile and must not be cached
+	loading map[string]bool
+	counts  map[string]*referenceFrequency
+	quit    chan bool
+	done    chan bool
+}
+
+//newReferenceCache looks up tracked documents with session, the
+//frequencies are stored with metadata
+func newReferenceCache(settings ReferenceCacheSettings, metrics *metricRegistry, session, metadata *mgo.Session) *referenceCache {
+	if settings.Size == 0 {
+		return nil
+	}
+
+	return &referenceCache{
+		settings: settings,
+		metrics:  metrics,
+		session:  session,
+		metadata: metadata,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		loading:  map[string]bool{},
+		counts:   map[string]*referenceFrequency{},
+	}
+}
+
+func referenceKey(ref mgo.DBRef) string {
+	return fmt.Sprintf("%s.%s/%s", ref.Database, ref.Collection, idString(ref.Id))
+}
+
+//get returns the cached document of ref, a miss marks it as loading
+//until put is called
+func (c *referenceCache) get(ref mgo.DBRef) (map[string]interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	key := referenceKey(ref)
+	c.Lock()
+	defer c.Unlock()
+	if c.settings.Frequencies != "" {
+		frequency, ok := c.counts[key]
+		if !ok {
+			frequency = &referenceFrequency{ref: ref}
+			c.counts[key] = frequency
+		}
+		frequency.count++
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.metrics.add(MetricReferenceCacheHits, 1)
+		return element.Value.(*cachedReference).document, true
+	}
+
+	c.metrics.add(MetricReferenceCacheMisses, 1)
+	c.loading[key] = true
+	return nil, false
+}
+
+//put caches the looked up document of ref unless it changed during
+//the lookup, nil ends a lookup that found nothing
+func (c *referenceCache) put(ref mgo.DBRef, document map[string]interface{}) {
+	if c == nil {
+		return
+	}
+
+	key := referenceKey(ref)
+	c.Lock()
+	defer c.Unlock()
+	current := c.loading[key]
+	delete(c.loading, key)
+	if current && document != nil {
+		c.store(key, document)
+	}
+}
+
+//store adds document as most recently used, c is locked
+func (c *referenceCache) store(key string, document map[string]interface{}) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cachedReference).document = document
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedReference{key: key, document: document})
+	for c.order.Len() > c.settings.Size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedReference).key)
+	}
+}
+
+//invalidate removes the document with key, it is called
+//in oplog order before the change is handled
+func (c *referenceCache) invalidate(key string) {
+	if c == nil || key == "" {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+
+	if _, ok := c.loading[key]; ok {
+		c.loading[key] = false
+	}
+}
+
+//clear removes all documents, commands like drops can change any of them
+func (c *referenceCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+	for key := range c.loading {
+		c.loading[key] = false
+	}
+}
+
+func (c *referenceCache) frequencies(session *mgo.Session) *mgo.Collection {
+	p := strings.Index(c.settings.Frequencies, ".")
+	return session.DB(c.settings.Frequencies[:p]).C(c.settings.Frequencies[p+1:])
+}
+
+//flush adds the lookups counted since the last flush to the frequencies
+func (c *referenceCache) flush() {
+	c.Lock()
+	counts := c.counts
+	c.counts = map[string]*referenceFrequency{}
+	c.Unlock()
+
+	session := c.metadata.Copy()
+	defer session.Close()
+	collection := c.frequencies(session)
+	for key, frequency := range counts {
+		update := bson.M{
+			"$inc": bson.M{"count": frequency.count},
+			"$set": bson.M{"ref": frequency.ref, "updated": time.Now()},
+		}
+		if _, err := collection.UpsertId(key, update); err != nil {
+			logWarn("Reference frequencies could not be stored", errorFields(err))
+			return
+		}
+	}
+}
+
+//prime loads the most referenced documents into the cache
+func (c *referenceCache) prime() error {
+	session := c.session.Copy()
+	defer session.Close()
+	metadata := c.metadata.Copy()
+	defer metadata.Close()
+
+	frequencies := []struct {
+		Ref mgo.DBRef `bson:"ref"`
+	}{}
+	if err := c.frequencies(metadata).Find(nil).Sort("-count").Limit(c.settings.Size).All(&frequencies); err != nil {
+		return err
+	}
+
+	ids := map[string][]interface{}{}
+	for _, frequency := range frequencies {
+		ns := frequency.Ref.Database + "." + frequency.Ref.Collection
+		ids[ns] = append(ids[ns], frequency.Ref.Id)
+	}
+
+	primed := 0
+	c.Lock()
+	defer c.Unlock()
+	for ns, nsIDs := range ids {
+		p := strings.Index(ns, ".")
+		documents := []map[string]interface{}{}
+		if err := session.DB(ns[:p]).C(ns[p+1:]).Find(bson.M{"_id": bson.M{"$in": nsIDs}}).All(&documents); err != nil {
+			return err
+		}
+
+		for _, document := range documents {
+			ref := mgo.DBRef{Database: ns[:p], Collection: ns[p+1:], Id: document["_id"]}
+			c.store(referenceKey(ref), document)
+			primed++
+		}
+	}
+
+	logInfo("Reference cache primed", Fields{"documents": primed})
+	return nil
+}
+
+//start primes the cache and stores the lookup counts every minute
+func (c *referenceCache) start() error {
+	if c.settings.Prime {
+		if err := c.prime(); err != nil {
+			logWarn("Reference cache could not be primed", errorFields(err))
+		}
+	}
+
+	c.quit = make(chan bool)
+	c.done = make(chan bool)
+	go func() {
+		defer close(c.done)
+		if c.settings.Frequencies == "" {
+			<-c.quit
+			return
+		}
+
+		ticker := time.NewTicker(frequencyFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.quit:
+				c.flush()
+				return
+			case <-ticker.C:
+				c.flush()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *referenceCache) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+//lookup reads the tracked document of ref, from the cache if possible
+func (c changeTracker) lookup(session *mgo.Session, ref mgo.DBRef) (map[string]interface{}, error) {
+	if document, ok := c.references.get(ref); ok {
+		return document, nil
+	}
+
+	document := map[string]interface{}{}
+	c.chaos.delayLookup()
+	if err := session.DB(ref.Database).C(ref.Collection).FindId(ref.Id).One(&document); err != nil {
+		c.references.put(ref, nil)
+		return nil, err
+	}
+
+	c.references.put(ref, document)
+	return document, nil
+}
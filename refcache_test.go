@@ -0,0 +1,95 @@
+package redkeep_test
+
+import (
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reference cache", func() {
+	user := func(id string) mgo.DBRef {
+		return mgo.DBRef{Database: "live", Collection: "user", Id: bson.ObjectIdHex(id)}
+	}
+	nino := user("5735f4e4c6b7c3fd1b2a4e11")
+	rick := user("5735f4e4c6b7c3fd1b2a4e12")
+	tom := user("5735f4e4c6b7c3fd1b2a4e13")
+
+	It("will keep looked up documents", func() {
+		cache := NewReferenceCache(10)
+		_, ok := cache.Get(nino)
+		Expect(ok).To(BeFalse())
+
+		cache.Put(nino, map[string]interface{}{"username": "nino"})
+		document, ok := cache.Get(nino)
+		Expect(ok).To(BeTrue())
+		Expect(document["username"]).To(Equal("nino"))
+	})
+
+	It("will drop the least recently used documents", func() {
+		cache := NewReferenceCache(2)
+		for _, ref := range []mgo.DBRef{nino, rick} {
+			cache.Get(ref)
+			cache.Put(ref, map[string]interface{}{})
+		}
+
+		cache.Get(nino)
+		cache.Get(tom)
+		cache.Put(tom, map[string]interface{}{})
+
+		_, ok := cache.Get(rick)
+		Expect(ok).To(BeFalse())
+		_, ok = cache.Get(nino)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("will forget documents that changed", func() {
+		cache := NewReferenceCache(10)
+		cache.Get(nino)
+		cache.Put(nino, map[string]interface{}{"username": "nino"})
+
+		entry := map[string]interface{}{
+			"op": "u",
+			"ns": "live.user",
+			"o":  map[string]interface{}{"$set": map[string]interface{}{"username": "nina"}},
+			"o2": map[string]interface{}{"_id": nino.Id},
+		}
+		cache.Invalidate(DocumentKey(entry))
+		_, ok := cache.Get(nino)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("will not cache documents that changed during their lookup", func() {
+		cache := NewReferenceCache(10)
+		cache.Get(nino)
+		cache.Invalidate(DocumentKey(map[string]interface{}{"op": "d", "ns": "live.user", "o": map[string]interface{}{"_id": nino.Id}}))
+		cache.Put(nino, map[string]interface{}{"username": "nino"})
+		_, ok := cache.Get(nino)
+		Expect(ok).To(BeFalse())
+
+		cache.Get(rick)
+		cache.Clear()
+		cache.Put(rick, map[string]interface{}{"username": "rick"})
+		_, ok = cache.Get(rick)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("will only prime with frequencies", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"referenceCache": { "size": 1000, "prime": true }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Priming the reference cache needs a size and a frequencies collection"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"referenceCache": { "size": 1000, "frequencies": "redkeep", "prime": true }, "watches"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Reference frequencies collection redkeep must be database.collection"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"referenceCache": { "size": 1000, "frequencies": "redkeep.frequencies", "prime": true }, "watches"`, 1)
+		loaded, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.ReferenceCache.Prime).To(BeTrue())
+	})
+})
@@ -0,0 +1,102 @@
+package redkeep
+
+import (
+	"errors"
+	"strconv"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func checkReferenceArray(w Watch) error {
+	settings := w.BehaviourSettings
+	if settings.ReferenceArray && (settings.Generations || settings.ConflictPolicy != "" || settings.QueueMissingTargets) {
+		return errors.New("Reference arrays can not be combined with generations, conflict policies or queued targets")
+	}
+
+	return nil
+}
+
+//elementQuery moves the fields of query into the element at position of
+//the reference array of w, like authors.$.authorInfo.username
+func elementQuery(w Watch, query bson.M, position string) bson.M {
+	moved := bson.M{}
+	for operator, fields := range query {
+		elementFields := bson.M{}
+		if fields, ok := fields.(bson.M); ok {
+			for field, value := range fields {
+				elementFields[w.TriggerReference+"."+position+"."+field] = value
+			}
+		}
+		moved[operator] = elementFields
+	}
+
+	return moved
+}
+
+//insertReferenceArray writes the tracked fields of every document the
+//array references into its element of the target originRef, it returns
+//the error of the write
+func (c changeTracker) insertReferenceArray(w Watch, command map[string]interface{}, originRef mgo.DBRef, references []interface{}) error {
+	session, done := c.useSession()
+	defer done()
+
+	set := bson.M{}
+	written := []interface{}{}
+	for i, reference := range references {
+		ref, ok := getReference(reference, originRef.Database)
+		if !ok {
+			continue
+		}
+
+		document, err := c.lookup(session, ref)
+		if err != nil {
+			c.lookupFailed(w, originRef, ref, err)
+			logWarn("Referenced document not found for update", watchFields(w).withError(err))
+			continue
+		}
+
+		query := BuildInsertQuery(w, document)
+		if query == nil || !c.transform(w, ref.Id, query) {
+			continue
+		}
+
+		for field, value := range elementQuery(w, query, strconv.Itoa(i))["$set"].(bson.M) {
+			set[field] = value
+		}
+		written = append(written, ref.Id)
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+
+	query := bson.M{"$set": set}
+	if err := c.hooks.beforeWrite(w, command, query); err != nil {
+		logInfo("Write skipped by hook", watchFields(w).withError(err))
+		return nil
+	}
+
+	c.builds.wait(w)
+	c.tenants.wait(w.Tenant)
+	collection := session.DB(originRef.Database).C(originRef.Collection)
+	err := errInjectedFault
+	if !c.chaos.dropWrite() {
+		intended := IntendedWrite{Namespace: collection.FullName, Selector: bson.M{"_id": originRef.Id}, Update: query}
+		err = c.write(w, session, intended, func() error {
+			return collection.UpdateId(originRef.Id, query)
+		})
+	}
+	c.hooks.afterWrite(w, command, query, err)
+	c.countWrite(w, err)
+	if err != nil {
+		c.events.record(EventError, w.Key(), "Update of "+originRef.Database+"."+originRef.Collection+" failed: "+err.Error())
+		return err
+	}
+
+	for _, id := range written {
+		c.invalidateCaches(w, id, originRef.Id, query)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package redkeep
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//WatchChanges are the keys of the watches a reload added, changed or removed
+type WatchChanges struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+//Empty is true if the reload did not change any watch
+func (c WatchChanges) Empty() bool {
+	return len(c.Added)+len(c.Changed)+len(c.Removed) == 0
+}
+
+func (c WatchChanges) String() string {
+	return fmt.Sprintf("added [%s], changed [%s], removed [%s]",
+		strings.Join(c.Added, ", "), strings.Join(c.Changed, ", "), strings.Join(c.Removed, ", "))
+}
+
+//diffWatches compares the watches before and after a reload by key
+func diffWatches(before, after []Watch) WatchChanges {
+	changes := WatchChanges{Added: []string{}, Changed: []string{}, Removed: []string{}}
+	previous := map[string]Watch{}
+	for _, w := range before {
+		previous[w.Key()] = w
+	}
+
+	for _, w := range after {
+		old, ok := previous[w.Key()]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, w.Key())
+		case !reflect.DeepEqual(old, w):
+			changes.Changed = append(changes.Changed, w.Key())
+		}
+		delete(previous, w.Key())
+	}
+
+	for _, w := range before {
+		if _, ok := previous[w.Key()]; ok {
+			changes.Removed = append(changes.Removed, w.Key())
+		}
+	}
+
+	return changes
+}
+
+//ReloadWatches replaces the configured watches while the agent tails,
+//the watches of tenants that were added at runtime are kept. New and
+//changed watches are tracked from the next oplog entry on, removed ones
+//are no longer tracked. With backfill the new and changed watches are
+//rescanned in the background, see RescanWatch.
+func (t *TailAgent) ReloadWatches(watches []Watch, backfill bool) (WatchChanges, error) {
+	config := t.config
+	config.Watches = watches
+	if err := validateConfiguration(config); err != nil {
+		return WatchChanges{}, err
+	}
+
+	//transforms have to exist before the watches are tracked
+	if err := t.transforms.add(watches); err != nil {
+		return WatchChanges{}, err
+	}
+
+	before, err := t.watches.reload(watches)
+	if err != nil {
+		return WatchChanges{}, err
+	}
+
+	changes := diffWatches(before, watches)
+	//the chains of removed watches and of watches without transforms are dropped
+	untransformed := changes.Removed
+	for _, w := range watches {
+		if len(w.Transforms) == 0 {
+			untransformed = append(untransformed, w.Key())
+		}
+	}
+	t.transforms.remove(untransformed)
+	if changes.Empty() {
+		return changes, nil
+	}
+
+	t.events.record(EventLifecycle, "", "Watches reloaded: "+changes.String())
+	t.updateSchemaManifest()
+	t.graphql.reload(t.watches.list())
+	t.lineage.reloadRuns(t.watches.list())
+	if !backfill {
+		return changes, nil
+	}
+
+	for _, key := range append(append([]string{}, changes.Added...), changes.Changed...) {
+		if err := t.RescanWatch(key); err != nil {
+			logWarn("Reloaded watch not backfilled", Fields{"watch": key}.withError(err))
+		}
+	}
+
+	return changes, nil
+}
+
+//ReloadWatches reloads the watches of the agents of all shards,
+//see TailAgent.ReloadWatches. Every shard backfills its own documents.
+func (s *ShardedTailAgent) ReloadWatches(watches []Watch, backfill bool) (WatchChanges, error) {
+	var changes WatchChanges
+	for _, agent := range s.agents {
+		var err error
+		if changes, err = agent.ReloadWatches(watches, backfill); err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, nil
+}
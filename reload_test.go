@@ -0,0 +1,73 @@
+package redkeep_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloading watches", func() {
+	watch := func(name, field string) Watch {
+		return Watch{
+			Name:                  name,
+			TrackCollection:       "app.user",
+			TrackFields:           []string{field},
+			TargetCollection:      "app." + name,
+			TargetNormalizedField: "meta.user",
+			TriggerReference:      "user",
+		}
+	}
+
+	It("will tell added, changed and removed watches apart", func() {
+		before := []Watch{watch("comments", "username"), watch("reviews", "username")}
+		after := []Watch{watch("comments", "email"), watch("orders", "username")}
+		Expect(DiffWatches(before, after)).To(Equal(WatchChanges{
+			Added:   []string{"orders"},
+			Changed: []string{"comments"},
+			Removed: []string{"reviews"},
+		}))
+		Expect(DiffWatches(before, before).Empty()).To(BeTrue())
+	})
+
+	It("will replace the watches of a running agent", func() {
+		agent, _, _, _ := ControlledAgent([]Watch{watch("comments", "username"), watch("reviews", "username")})
+		changes, err := agent.ReloadWatches([]Watch{watch("comments", "username"), watch("orders", "username")}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes.Added).To(Equal([]string{"orders"}))
+		Expect(changes.Removed).To(Equal([]string{"reviews"}))
+
+		Expect(agent.Status().Watches).To(Equal([]string{"comments", "orders"}))
+	})
+
+	It("will expose the reloaded watches as GraphQL subscriptions", func() {
+		agent, handler, _, _ := ControlledAgent([]Watch{watch("comments", "username"), watch("reviews", "username")})
+		_, err := agent.ReloadWatches([]Watch{watch("comments", "username"), watch("orders", "username")}, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/graphql/schema", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(ContainSubstring("orders: OrdersChange!"))
+		Expect(recorder.Body.String()).ToNot(ContainSubstring("reviews"))
+	})
+
+	It("will keep the watches when the reloaded ones are invalid", func() {
+		agent, _, _, _ := ControlledAgent([]Watch{watch("comments", "username")})
+		invalid := watch("orders", "username")
+		invalid.TargetCollection = ""
+		_, err := agent.ReloadWatches([]Watch{invalid}, false)
+		Expect(err).To(MatchError("TargetCollection must not be empty"))
+		Expect(agent.Status().Watches).To(Equal([]string{"comments"}))
+	})
+
+	It("will not reload watches with the same key twice", func() {
+		agent, _, _, _ := ControlledAgent([]Watch{watch("comments", "username")})
+		_, err := agent.ReloadWatches([]Watch{watch("comments", "username"), watch("comments", "email")}, false)
+		Expect(err).To(MatchError("Watch comments is already configured"))
+		Expect(agent.Status().Watches).To(Equal([]string{"comments"}))
+	})
+})
@@ -0,0 +1,428 @@
+package redkeep
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//maxRecordedEntrySize is the maximum bson document size of mongodb
+const maxRecordedEntrySize = 16 * 1024 * 1024
+
+//RecordOplog writes the oplog entries after from as concatenated bson
+//documents, like mongodump does. At most limit entries are written if
+//limit is greater than zero. It returns the number of written entries.
+func RecordOplog(session *mgo.Session, out io.Writer, from bson.MongoTimestamp, limit int) (int, error) {
+	query := session.DB("local").C("oplog.rs").Find(bson.M{"ts": bson.M{"$gt": from}}).Sort("$natural")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	iter := query.Iter()
+	count := 0
+	var entry bson.Raw
+	for iter.Next(&entry) {
+		data, err := bson.Marshal(entry)
+		if err != nil {
+			iter.Close()
+			return count, err
+		}
+
+		if _, err := out.Write(data); err != nil {
+			iter.Close()
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, iter.Close()
+}
+
+//OplogEntries streams the entries of a recording, it calls handle for
+//every entry until handle returns false. Every call reads them again.
+type OplogEntries func(handle func(entry map[string]interface{}) bool) error
+
+//RecordedEntries streams entries that were already read
+func RecordedEntries(entries []map[string]interface{}) OplogEntries {
+	return func(handle func(entry map[string]interface{}) bool) error {
+		for _, entry := range entries {
+			if !handle(entry) {
+				return nil
+			}
+		}
+
+		return nil
+	}
+}
+
+//StreamOplogRecording calls handle for every entry written by
+//RecordOplog until it returns false
+func StreamOplogRecording(in io.Reader, handle func(entry map[string]interface{}) bool) error {
+	return readOplogEntries(in, handle)
+}
+
+//ReadOplogRecording reads all entries written by RecordOplog
+func ReadOplogRecording(in io.Reader) ([]map[string]interface{}, error) {
+	entries := []map[string]interface{}{}
+	err := readOplogEntries(in, func(entry map[string]interface{}) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+//readOplogEntries calls handle for every entry of a recording
+//until it returns false
+func readOplogEntries(in io.Reader, handle func(entry map[string]interface{}) bool) error {
+	for count := 0; ; count++ {
+		var length [4]byte
+		if _, err := io.ReadFull(in, length[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		size := binary.LittleEndian.Uint32(length[:])
+		if size < 5 || size > maxRecordedEntrySize {
+			return fmt.Errorf("Invalid oplog recording, entry %d has a size of %d bytes", count, size)
+		}
+
+		data := make([]byte, size)
+		copy(data, length[:])
+		if _, err := io.ReadFull(in, data[4:]); err != nil {
+			return err
+		}
+
+		entry := map[string]interface{}{}
+		if err := bson.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+
+		if !handle(entry) {
+			return nil
+		}
+	}
+}
+
+//DeterminismReport is the result of replaying a recording twice
+type DeterminismReport struct {
+	Entries int `json:"entries"`
+	//Documents is the number of documents per collection after the first replay
+	Documents map[string]int `json:"documents"`
+	//Differences lists namespace and id of documents that differ
+	Differences []string `json:"differences"`
+}
+
+//Deterministic is true if both replays produced the same state
+func (r DeterminismReport) Deterministic() bool {
+	return len(r.Differences) == 0
+}
+
+//CheckReplayDeterminism replays entries twice against scratch databases and
+//compares the state of all tracked and target collections afterwards.
+//The entries are streamed, a recording is read once per replay.
+//Every database name is prefixed with scratchPrefix, the scratch databases
+//are dropped before each replay. Entries are handled concurrently like the
+//agent does, so results that depend on the order of handling show up as
+//differences.
+func CheckReplayDeterminism(session *mgo.Session, entries OplogEntries, watches []Watch, scratchPrefix string) (DeterminismReport, error) {
+	transforms, err := newWatchTransforms(watches, nil)
+	if err != nil {
+		return DeterminismReport{}, err
+	}
+
+	return checkReplayDeterminism(session, entries, watches, newHookRegistry(), transforms, scratchPrefix)
+}
+
+//CheckReplayDeterminism works like the function of the same name
+//and runs the hooks registered at the agent
+func (t *TailAgent) CheckReplayDeterminism(entries OplogEntries, scratchPrefix string) (DeterminismReport, error) {
+	return checkReplayDeterminism(t.session, entries, t.watches.list(), t.hooks, t.transforms, scratchPrefix)
+}
+
+func checkReplayDeterminism(session *mgo.Session, entries OplogEntries, watches []Watch, hooks *hookRegistry, transforms *watchTransforms, scratchPrefix string) (DeterminismReport, error) {
+	report := DeterminismReport{}
+	if scratchPrefix == "" {
+		return report, errors.New("A scratch prefix is needed, replaying without would change the live databases")
+	}
+
+	scratch := scratchWatches(watches, scratchPrefix)
+	var states [2]map[string]string
+	for run := range states {
+		if err := dropScratchDatabases(session, scratch); err != nil {
+			return report, err
+		}
+
+		count, err := replayEntries(session, entries, scratch, hooks, transforms, scratchPrefix)
+		if err != nil {
+			return report, err
+		}
+		report.Entries = count
+
+		state, err := collectionState(session, scratch)
+		if err != nil {
+			return report, err
+		}
+
+		states[run] = state
+	}
+
+	compareStates(&report, states[0], states[1])
+	return report, nil
+}
+
+//compareStates counts the documents of first and lists the keys
+//that are missing in one of the states or differ
+func compareStates(report *DeterminismReport, first, second map[string]string) {
+	report.Documents = map[string]int{}
+	for key := range first {
+		report.Documents[strings.SplitN(key, " ", 2)[0]]++
+	}
+
+	for key, hash := range first {
+		if second[key] != hash {
+			report.Differences = append(report.Differences, key)
+		}
+	}
+
+	for key := range second {
+		if _, ok := first[key]; !ok {
+			report.Differences = append(report.Differences, key)
+		}
+	}
+	sort.Strings(report.Differences)
+}
+
+//scratchWatches returns the watches with the databases of
+//their collections prefixed by scratchPrefix
+func scratchWatches(watches []Watch, scratchPrefix string) []Watch {
+	scratch := []Watch{}
+	for _, w := range watches {
+		//keep the key, hooks and transforms are registered by it
+		w.Name = w.Key()
+		w.TrackCollection = scratchPrefix + w.TrackCollection
+		w.TargetCollection = scratchPrefix + w.TargetCollection
+		scratch = append(scratch, w)
+	}
+
+	return scratch
+}
+
+//scratchNamespaces returns all namespaces of the watches
+func scratchNamespaces(watches []Watch) []string {
+	known := map[string]bool{}
+	namespaces := []string{}
+	for _, w := range watches {
+		for _, ns := range []string{w.TrackCollection, w.TargetCollection} {
+			if !known[ns] {
+				known[ns] = true
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+func dropScratchDatabases(session *mgo.Session, watches []Watch) error {
+	dropped := map[string]bool{}
+	for _, ns := range scratchNamespaces(watches) {
+		db := ns[:strings.Index(ns, ".")]
+		if dropped[db] {
+			continue
+		}
+
+		if err := session.DB(db).DropDatabase(); err != nil {
+			return err
+		}
+		dropped[db] = true
+	}
+
+	return nil
+}
+
+//replayEntries applies every entry of a watched namespace to the scratch
+//databases and lets the tracker handle it, like the agent would have done.
+//Updates the agent made to target collections are part of the recording,
+//they are skipped because the tracker makes them again. It returns the
+//number of entries that were read.
+func replayEntries(session *mgo.Session, entries OplogEntries, watches []Watch, hooks *hookRegistry, transforms *watchTransforms, scratchPrefix string) (int, error) {
+	tracker := &changeTracker{session: session, hooks: hooks, transforms: transforms}
+	sinks := &sinkDispatcher{}
+
+	watched := map[string]bool{}
+	for _, ns := range scratchNamespaces(watches) {
+		watched[ns] = true
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	count := 0
+	var failed error
+	err := entries(func(original map[string]interface{}) bool {
+		count++
+		entry := remapDatabases(original, scratchPrefix).(map[string]interface{})
+		ns, _ := entry["ns"].(string)
+		p := strings.Index(ns, ".")
+		op, _ := entry["op"].(string)
+		if p == -1 || !watched[ns] || op == "c" || op == "n" || agentWrite(entry, watches) {
+			return true
+		}
+
+		collection := session.DB(ns[:p]).C(ns[p+1:])
+		var err error
+		switch op {
+		case "i":
+			err = collection.Insert(entry["o"])
+		case "u":
+			err = collection.Update(entry["o2"], entry["o"])
+		case "d":
+			err = collection.Remove(entry["o"])
+		}
+
+		if err != nil && err != mgo.ErrNotFound {
+			failed = fmt.Errorf("Entry %v could not be applied: %s", entry["ts"], err.Error())
+			return false
+		}
+
+		//migrated documents exist on the recorded shard but did not change
+		if fromMigration(entry) {
+			return true
+		}
+
+		wg.Add(1)
+		go func(entry map[string]interface{}) {
+			defer wg.Done()
+			analyzeResult(entry, watches, tracker, sinks, nil, nil)
+		}(entry)
+		return true
+	})
+	if failed != nil {
+		return count, failed
+	}
+
+	return count, err
+}
+
+//agentWrite is true for updates of a target collection that
+//only change the normalized field of a watch
+func agentWrite(entry map[string]interface{}, watches []Watch) bool {
+	update, ok := entry["o"].(map[string]interface{})
+	if entry["op"] != "u" || !ok {
+		return false
+	}
+
+	for _, w := range watches {
+		if w.TargetCollection == entry["ns"] && onlyChanges(update, w.TargetNormalizedField) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//onlyChanges is true if the update operators only change field
+//or fields below it, replacements are never only changing a field
+func onlyChanges(update map[string]interface{}, field string) bool {
+	changed := false
+	for operator, value := range update {
+		if operator == "$v" {
+			continue
+		}
+
+		fields, ok := value.(map[string]interface{})
+		if !ok || !strings.HasPrefix(operator, "$") {
+			return false
+		}
+
+		for name := range fields {
+			if name != field && !strings.HasPrefix(name, field+".") {
+				return false
+			}
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+//remapDatabases prefixes the database of the namespace and
+//of all database references, the original is not modified
+func remapDatabases(value interface{}, prefix string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for k, inner := range v {
+			if name, ok := inner.(string); ok && (k == "ns" || k == "$db") {
+				result[k] = prefix + name
+				continue
+			}
+
+			result[k] = remapDatabases(inner, prefix)
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, inner := range v {
+			result[i] = remapDatabases(inner, prefix)
+		}
+
+		return result
+	}
+
+	return value
+}
+
+//collectionState hashes every document of the namespaces,
+//keys are namespace and id separated by a space
+func collectionState(session *mgo.Session, watches []Watch) (map[string]string, error) {
+	state := map[string]string{}
+	for _, ns := range scratchNamespaces(watches) {
+		p := strings.Index(ns, ".")
+		iter := session.DB(ns[:p]).C(ns[p+1:]).Find(nil).Sort("_id").Iter()
+		var document bson.D
+		for iter.Next(&document) {
+			data, err := bson.Marshal(document)
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+
+			hash := sha1.Sum(data)
+			id := ""
+			for _, element := range document {
+				if element.Name == "_id" {
+					id = idString(element.Value)
+				}
+			}
+
+			state[ns+" "+id] = hex.EncodeToString(hash[:])
+			document = nil
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
@@ -0,0 +1,114 @@
+package redkeep_test
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	Context("reading recordings", func() {
+		entryOfSize := func(size uint32, body int) []byte {
+			data := make([]byte, 4+body)
+			binary.LittleEndian.PutUint32(data, size)
+			return data
+		}
+
+		It("reads nothing from an empty recording", func() {
+			entries, err := ReadOplogRecording(bytes.NewReader(nil))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("reads all recorded entries", func() {
+			var recording bytes.Buffer
+			for _, op := range []string{"i", "u"} {
+				data, err := bson.Marshal(bson.M{"op": op, "ns": "live.user"})
+				Expect(err).ToNot(HaveOccurred())
+				recording.Write(data)
+			}
+
+			entries, err := ReadOplogRecording(&recording)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[1]["op"]).To(Equal("u"))
+		})
+
+		It("rejects entries that are too small or too large", func() {
+			_, err := ReadOplogRecording(bytes.NewReader(entryOfSize(4, 0)))
+			Expect(err).To(MatchError("Invalid oplog recording, entry 0 has a size of 4 bytes"))
+
+			_, err = ReadOplogRecording(bytes.NewReader(entryOfSize(16*1024*1024+1, 0)))
+			Expect(err).To(MatchError(ContainSubstring("has a size of 16777217 bytes")))
+		})
+
+		It("fails on truncated recordings", func() {
+			_, err := ReadOplogRecording(bytes.NewReader(entryOfSize(100, 10)))
+			Expect(err).To(HaveOccurred())
+
+			_, err = ReadOplogRecording(bytes.NewReader([]byte{1, 0}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("remaps namespaces and database references without changing the original", func() {
+		original := map[string]interface{}{
+			"ns": "live.comment",
+			"o": map[string]interface{}{
+				"user": map[string]interface{}{"$ref": "user", "$id": "a", "$db": "live"},
+				"tags": []interface{}{map[string]interface{}{"$db": "other"}, "ns"},
+			},
+		}
+
+		remapped := RemapDatabases(original, "scratch_").(map[string]interface{})
+		Expect(remapped["ns"]).To(Equal("scratch_live.comment"))
+		o := remapped["o"].(map[string]interface{})
+		Expect(o["user"]).To(Equal(map[string]interface{}{"$ref": "user", "$id": "a", "$db": "scratch_live"}))
+		Expect(o["tags"]).To(Equal([]interface{}{map[string]interface{}{"$db": "scratch_other"}, "ns"}))
+
+		Expect(original["ns"]).To(Equal("live.comment"))
+		Expect(original["o"].(map[string]interface{})["user"].(map[string]interface{})["$db"]).To(Equal("live"))
+	})
+
+	It("recognizes the updates of the agent", func() {
+		watches := []Watch{{TrackCollection: "live.user", TargetCollection: "live.comment", TargetNormalizedField: "meta"}}
+		entry := func(op, ns string, o map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"op": op, "ns": ns, "o": o}
+		}
+
+		Expect(AgentWrite(entry("u", "live.comment", map[string]interface{}{
+			"$v":   1,
+			"$set": map[string]interface{}{"meta.username": "a", "meta.name": "b"},
+		}), watches)).To(BeTrue())
+		Expect(AgentWrite(entry("u", "live.comment", map[string]interface{}{
+			"$set": map[string]interface{}{"meta.username": "a", "text": "b"},
+		}), watches)).To(BeFalse())
+		Expect(AgentWrite(entry("u", "live.comment", map[string]interface{}{"meta": "replaced"}), watches)).To(BeFalse())
+		Expect(AgentWrite(entry("u", "live.comment", map[string]interface{}{
+			"$set": map[string]interface{}{"metadata": "a"},
+		}), watches)).To(BeFalse())
+		Expect(AgentWrite(entry("u", "live.user", map[string]interface{}{
+			"$set": map[string]interface{}{"meta.username": "a"},
+		}), watches)).To(BeFalse())
+		Expect(AgentWrite(entry("i", "live.comment", map[string]interface{}{"meta": "a"}), watches)).To(BeFalse())
+	})
+
+	It("reports documents that differ or exist in one replay only", func() {
+		report := CompareStates(
+			map[string]string{"s_live.user a": "1", "s_live.user b": "2", "s_live.comment c": "3"},
+			map[string]string{"s_live.user a": "1", "s_live.user b": "9", "s_live.comment d": "4"},
+		)
+
+		Expect(report.Deterministic()).To(BeFalse())
+		Expect(report.Documents).To(Equal(map[string]int{"s_live.user": 2, "s_live.comment": 1}))
+		Expect(report.Differences).To(Equal([]string{"s_live.comment c", "s_live.comment d", "s_live.user b"}))
+
+		Expect(CompareStates(map[string]string{"x a": "1"}, map[string]string{"x a": "1"}).Deterministic()).To(BeTrue())
+	})
+})
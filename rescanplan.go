@@ -0,0 +1,134 @@
+package redkeep
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//RescanPlan estimates what a rescan (TailOptions.ForceRescan) of the
+//oplog between From and To would do. Writes are the writes the agent
+//sends, Documents the target documents they are estimated to change.
+type RescanPlan struct {
+	Entries   int                        `json:"entries"`
+	From      time.Time                  `json:"from"`
+	To        time.Time                  `json:"to"`
+	Writes    int                        `json:"writes"`
+	Documents int                        `json:"documents"`
+	Watches   map[string]WatchRescanPlan `json:"watches"`
+}
+
+//WatchRescanPlan estimates the rescan of one watch. Every update of a
+//tracked document in the oplog writes all its targets, FanOut is the
+//average number of targets per tracked document. Every inserted target
+//is looked up and written once.
+type WatchRescanPlan struct {
+	Updates   int     `json:"updates"`
+	Inserts   int     `json:"inserts"`
+	FanOut    float64 `json:"fanOut"`
+	Writes    int     `json:"writes"`
+	Documents int     `json:"documents"`
+}
+
+//oplogOperations counts the entries of the oplog by namespace and operation
+type oplogOperations map[string]map[string]int
+
+//planWatchRescan estimates the rescan of w from the counted operations and
+//the number of tracked and target documents
+func planWatchRescan(w Watch, operations oplogOperations, tracked, targets int) WatchRescanPlan {
+	plan := WatchRescanPlan{Updates: operations[w.TrackCollection]["u"], Inserts: operations[w.TargetCollection]["i"]}
+	if tracked > 0 {
+		plan.FanOut = float64(targets) / float64(tracked)
+	}
+
+	plan.Writes = plan.Updates + plan.Inserts
+	plan.Documents = int(math.Ceil(float64(plan.Updates)*plan.FanOut)) + plan.Inserts
+	return plan
+}
+
+//planRescan sums the plans of watches, entries is the size of the oplog
+func planRescan(watches map[string]WatchRescanPlan, entries int, from, to bson.MongoTimestamp) RescanPlan {
+	plan := RescanPlan{
+		Entries: entries,
+		From:    time.Unix(int64(from>>32), 0),
+		To:      time.Unix(int64(to>>32), 0),
+		Watches: watches,
+	}
+
+	for _, w := range watches {
+		plan.Writes += w.Writes
+		plan.Documents += w.Documents
+	}
+
+	return plan
+}
+
+//countDocuments counts the documents of the collection ns
+func countDocuments(session *mgo.Session, ns string) (int, error) {
+	p := strings.Index(ns, ".")
+	return session.DB(ns[:p]).C(ns[p+1:]).Count()
+}
+
+//PlanRescan estimates the impact of a rescan of watches without writing
+//anything. It reads the oplog once to count its inserts and updates per
+//namespace and counts the tracked and target documents of every watch.
+func PlanRescan(session *mgo.Session, watches []Watch) (RescanPlan, error) {
+	oplog := session.DB("local").C("oplog.rs")
+	from, err := oldestOplogEntry(oplog)
+	if err != nil {
+		return RescanPlan{}, err
+	}
+
+	to, err := newestOplogEntry(oplog)
+	if err != nil {
+		return RescanPlan{}, err
+	}
+
+	entries, err := oplog.Count()
+	if err != nil {
+		return RescanPlan{}, err
+	}
+
+	counted := []struct {
+		ID struct {
+			Namespace string `bson:"ns"`
+			Operation string `bson:"op"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}{}
+	pipeline := []bson.M{
+		{"$match": bson.M{"op": bson.M{"$in": []string{"i", "u"}}}},
+		{"$group": bson.M{"_id": bson.M{"ns": "$ns", "op": "$op"}, "count": bson.M{"$sum": 1}}},
+	}
+	if err := oplog.Pipe(pipeline).AllowDiskUse().All(&counted); err != nil {
+		return RescanPlan{}, err
+	}
+
+	operations := oplogOperations{}
+	for _, c := range counted {
+		if operations[c.ID.Namespace] == nil {
+			operations[c.ID.Namespace] = map[string]int{}
+		}
+		operations[c.ID.Namespace][c.ID.Operation] = c.Count
+	}
+
+	plans := map[string]WatchRescanPlan{}
+	for _, w := range watches {
+		tracked, err := countDocuments(session, w.TrackCollection)
+		if err != nil {
+			return RescanPlan{}, err
+		}
+
+		targets, err := countDocuments(session, w.TargetCollection)
+		if err != nil {
+			return RescanPlan{}, err
+		}
+
+		plans[w.Key()] = planWatchRescan(w, operations, tracked, targets)
+	}
+
+	return planRescan(plans, entries, from, to), nil
+}
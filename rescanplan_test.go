@@ -0,0 +1,44 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Rescan plans", func() {
+	comments := Watch{Name: "comments", TrackCollection: "app.user", TargetCollection: "app.comment"}
+	invoices := Watch{Name: "invoices", TrackCollection: "billing.customer", TargetCollection: "billing.invoice"}
+	operations := map[string]map[string]int{
+		"app.user":         {"u": 40, "i": 5},
+		"app.comment":      {"i": 300, "u": 12},
+		"billing.customer": {"i": 2},
+	}
+
+	It("estimates the writes of a watch with its fan-out", func() {
+		Expect(PlanWatchRescan(comments, operations, 100, 250)).To(Equal(WatchRescanPlan{
+			Updates:   40,
+			Inserts:   300,
+			FanOut:    2.5,
+			Writes:    340,
+			Documents: 400,
+		}))
+		Expect(PlanWatchRescan(invoices, operations, 0, 0)).To(Equal(WatchRescanPlan{}))
+	})
+
+	It("sums the plans of all watches", func() {
+		from := bson.MongoTimestamp(int64(1714644000) << 32)
+		to := bson.MongoTimestamp(int64(1714647600)<<32 | 3)
+		plan := SumRescanPlans(map[string]WatchRescanPlan{
+			"comments": PlanWatchRescan(comments, operations, 100, 250),
+			"invoices": PlanWatchRescan(invoices, operations, 10, 30),
+		}, 1000, from, to)
+
+		Expect(plan.Entries).To(Equal(1000))
+		Expect(plan.Writes).To(Equal(340))
+		Expect(plan.Documents).To(Equal(400))
+		Expect(plan.To.Sub(plan.From).Hours()).To(Equal(1.0))
+	})
+})
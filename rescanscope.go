@@ -0,0 +1,212 @@
+package redkeep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//RescanScope limits a rescan to the documents that changed in the oplog
+//from From to To (default now) in Namespaces, for Watches. Empty
+//Namespaces are the tracked and target collections of the watches,
+//empty Watches are all watches.
+type RescanScope struct {
+	Watches    []string  `json:"watches"`
+	Namespaces []string  `json:"namespaces"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+}
+
+//rescanFilter selects the oplog entries of a scoped rescan. Entries up to
+//to mark their document, later entries are only handled for marked
+//documents, so every rescanned document ends with its current values.
+type rescanFilter struct {
+	to         bson.MongoTimestamp
+	namespaces map[string]bool
+	documents  map[string]bool
+}
+
+func newRescanFilter(scope RescanScope, watches []Watch) (*rescanFilter, error) {
+	if scope.From.IsZero() {
+		return nil, errors.New("A scoped rescan needs a start")
+	}
+
+	if !scope.To.IsZero() && scope.To.Before(scope.From) {
+		return nil, errors.New("The end of the rescan is before its start")
+	}
+
+	known := map[string]bool{}
+	for _, w := range watches {
+		known[w.TrackCollection] = true
+		known[w.TargetCollection] = true
+	}
+
+	namespaces := known
+	if len(scope.Namespaces) > 0 {
+		namespaces = map[string]bool{}
+		for _, ns := range scope.Namespaces {
+			if !known[ns] {
+				return nil, fmt.Errorf("Namespace %s is neither tracked nor written by the rescanned watches", ns)
+			}
+			namespaces[ns] = true
+		}
+	}
+
+	//the oplog counts seconds, the whole second of the end is included
+	to := bson.MongoTimestamp(1<<63 - 1)
+	if !scope.To.IsZero() {
+		to = bson.MongoTimestamp((scope.To.Unix() + 1) << 32)
+	}
+
+	return &rescanFilter{to: to, namespaces: namespaces, documents: map[string]bool{}}, nil
+}
+
+//matches is true if entry has to be handled again
+func (f *rescanFilter) matches(entry map[string]interface{}) bool {
+	ns, _ := entry["ns"].(string)
+	key := documentKey(entry)
+	if !f.namespaces[ns] || key == "" || fromMigration(entry) {
+		return false
+	}
+
+	if ts, _ := entry["ts"].(bson.MongoTimestamp); ts < f.to {
+		f.documents[key] = true
+	}
+
+	return f.documents[key]
+}
+
+//RescanScoped handles the oplog entries of scope again in the background,
+//for the documents that changed in its time range and up to now. Entries
+//of documents that were changed live meanwhile are skipped like in a catch
+//up. Other than the checkpoint of a watch it does not change anything.
+func (t *TailAgent) RescanScoped(scope RescanScope) error {
+	if t.session == nil {
+		return errors.New("Agent is not connected")
+	}
+
+	watches := t.watches.list()
+	if len(scope.Watches) > 0 {
+		watches = []Watch{}
+		for _, key := range scope.Watches {
+			w, err := t.watch(key)
+			if err != nil {
+				return err
+			}
+			watches = append(watches, w)
+		}
+	}
+
+	filter, err := newRescanFilter(scope, watches)
+	if err != nil {
+		return err
+	}
+
+	session := t.session.Copy()
+	oplog := session.DB("local").C("oplog.rs")
+	from := mongoTimestamp{scope.From}.MongoTimestamp()
+	oldest, err := oldestOplogEntry(oplog)
+	if err == nil && oldest > from {
+		err = fmt.Errorf("The oplog rotated past %s, rescan the watches instead", scope.From.Format(time.RFC3339))
+	}
+
+	var to bson.MongoTimestamp
+	if err == nil {
+		to, err = newestOplogEntry(oplog)
+	}
+
+	if err != nil {
+		session.Close()
+		return err
+	}
+
+	keys := []string{}
+	for _, w := range watches {
+		keys = append(keys, w.Key())
+	}
+
+	backlog := newCatchUp()
+	t.catchUps.add(backlog)
+	err = t.rescans.run("scoped:"+strings.Join(keys, ","), func(ctx context.Context) error {
+		defer session.Close()
+		defer t.catchUps.remove(backlog)
+		defer backlog.finish()
+		return t.rescanScoped(ctx, session, watches, filter, from, to, backlog)
+	})
+	if err != nil {
+		t.catchUps.remove(backlog)
+		session.Close()
+	}
+
+	return err
+}
+
+//rescanScoped handles the entries from from up to to that filter
+//matches for watches, sinks do not get their events again
+func (t *TailAgent) rescanScoped(ctx context.Context, session *mgo.Session, watches []Watch, filter *rescanFilter, from, to bson.MongoTimestamp, backlog *catchUp) error {
+	t.events.record(EventLifecycle, "", fmt.Sprintf("Scoped rescan from %d to %d", from, to))
+	namespaces := []string{}
+	for ns := range filter.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+
+	query := session.DB("local").C("oplog.rs").Find(bson.M{"ts": bson.M{"$gte": from, "$lte": to}, "ns": bson.M{"$in": namespaces}})
+	iter := query.LogReplay().Sort("$natural").Iter()
+
+	handled, skipped := 0, 0
+	entry := map[string]interface{}{}
+	for iter.Next(&entry) {
+		if ctx.Err() != nil {
+			iter.Close()
+			return ctx.Err()
+		}
+
+		if filter.matches(entry) {
+			if backlog.handleBacklog(entry, func(current map[string]interface{}) {
+				analyzeResult(current, watches, t.tracker, &sinkDispatcher{}, t.unknown, nil)
+			}) {
+				handled++
+			} else {
+				skipped++
+			}
+		}
+
+		entry = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		t.events.record(EventError, "", "Scoped rescan failed: "+err.Error())
+		return err
+	}
+
+	t.events.record(EventLifecycle, "", fmt.Sprintf("Scoped rescan done, %d oplog entries of %d documents handled, %d skipped", handled, len(filter.documents), skipped))
+	return nil
+}
+
+//serveRescan starts a scoped rescan with a POST of its json scope
+func (t *TailAgent) serveRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Start a scoped rescan with POST"})
+		return
+	}
+
+	var scope RescanScope
+	if err := json.NewDecoder(r.Body).Decode(&scope); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid json: " + err.Error()})
+		return
+	}
+
+	if err := t.RescanScoped(scope); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, scope)
+}
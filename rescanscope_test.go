@@ -0,0 +1,61 @@
+package redkeep_test
+
+import (
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Scoped rescans", func() {
+	watches := []Watch{{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"name"},
+		TargetCollection:      "app.comment",
+		TargetNormalizedField: "meta",
+		TriggerReference:      "user",
+	}}
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+
+	entry := func(ns string, id bson.ObjectId, at time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"ts": bson.MongoTimestamp(at.Unix()<<32 | 1),
+			"op": "i",
+			"ns": ns,
+			"o":  map[string]interface{}{"_id": id},
+		}
+	}
+
+	It("handles the later entries of the documents changed in the range", func() {
+		matches, err := RescanFilter(RescanScope{Namespaces: []string{"app.user"}, From: start, To: start.Add(time.Hour)}, watches)
+		Expect(err).ToNot(HaveOccurred())
+
+		alice, bob := bson.NewObjectId(), bson.NewObjectId()
+		Expect(matches(entry("app.user", alice, start.Add(time.Hour)))).To(BeTrue())
+		Expect(matches(entry("app.comment", bson.NewObjectId(), start))).To(BeFalse())
+		Expect(matches(entry("app.user", bob, start.Add(2*time.Hour)))).To(BeFalse())
+		Expect(matches(entry("app.user", alice, start.Add(3*time.Hour)))).To(BeTrue())
+	})
+
+	It("includes all namespaces of the watches and everything up to now by default", func() {
+		matches, err := RescanFilter(RescanScope{From: start}, watches)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(matches(entry("app.comment", bson.NewObjectId(), start.Add(24*time.Hour)))).To(BeTrue())
+		Expect(matches(entry("app.other", bson.NewObjectId(), start))).To(BeFalse())
+	})
+
+	It("rejects invalid scopes", func() {
+		_, err := RescanFilter(RescanScope{}, watches)
+		Expect(err).To(MatchError("A scoped rescan needs a start"))
+
+		_, err = RescanFilter(RescanScope{From: start, To: start.Add(-time.Hour)}, watches)
+		Expect(err).To(MatchError("The end of the rescan is before its start"))
+
+		_, err = RescanFilter(RescanScope{From: start, Namespaces: []string{"app.other"}}, watches)
+		Expect(err).To(MatchError("Namespace app.other is neither tracked nor written by the rescanned watches"))
+	})
+})
@@ -0,0 +1,138 @@
+package redkeep
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+//memoryHeadroom is the share of the cgroup memory limit used as soft
+//limit, the rest is left for memory the go runtime does not manage
+const memoryHeadroom = 0.9
+
+//ResourceSettings limit the cpu and memory the process uses. MaxProcs
+//sets GOMAXPROCS, MemoryLimitMB the soft memory limit of the garbage
+//collector and GCPercent its target (zero keeps the defaults). With
+//Cgroup the unset limits are derived from the cgroup of the process:
+//GOMAXPROCS from the cpu quota rounded up and the memory limit as 90%
+//of the cgroup memory limit.
+type ResourceSettings struct {
+	MaxProcs      int  `json:"maxProcs" validate:"min=0"`
+	MemoryLimitMB int  `json:"memoryLimitMB" validate:"min=0"`
+	GCPercent     int  `json:"gcPercent" validate:"min=0"`
+	Cgroup        bool `json:"cgroup"`
+}
+
+//cgroup files of the limits, they are read relative to a root
+const (
+	cgroupCPUMax      = "/sys/fs/cgroup/cpu.max"
+	cgroupCPUQuota    = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cgroupCPUPeriod   = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+	cgroupMemoryMax   = "/sys/fs/cgroup/memory.max"
+	cgroupMemoryLimit = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+)
+
+func readCgroupValue(root, file string) (float64, bool) {
+	data, err := ioutil.ReadFile(root + file)
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	return value, err == nil && value > 0
+}
+
+//cgroupCPUs is the cpu quota of the cgroup below root in cpus,
+//false without quota
+func cgroupCPUs(root string) (float64, bool) {
+	if data, err := ioutil.ReadFile(root + cgroupCPUMax); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return 0, false
+		}
+
+		quota, errQuota := strconv.ParseFloat(fields[0], 64)
+		period, errPeriod := strconv.ParseFloat(fields[1], 64)
+		if errQuota != nil || errPeriod != nil || quota <= 0 || period <= 0 {
+			return 0, false
+		}
+
+		return quota / period, true
+	}
+
+	quota, okQuota := readCgroupValue(root, cgroupCPUQuota)
+	period, okPeriod := readCgroupValue(root, cgroupCPUPeriod)
+	if !okQuota || !okPeriod {
+		return 0, false
+	}
+
+	return quota / period, true
+}
+
+//cgroupMemory is the memory limit of the cgroup below root in bytes,
+//false without limit. cgroup v1 reports no limit as a huge number.
+func cgroupMemory(root string) (int64, bool) {
+	limit, ok := readCgroupValue(root, cgroupMemoryMax)
+	if !ok {
+		limit, ok = readCgroupValue(root, cgroupMemoryLimit)
+	}
+
+	if !ok || limit >= math.MaxInt64/2 {
+		return 0, false
+	}
+
+	return int64(limit), true
+}
+
+//resourceLimits returns GOMAXPROCS and the memory limit in bytes for
+//settings and the cgroup below root, zero keeps the current value
+func resourceLimits(settings ResourceSettings, root string) (int, int64) {
+	procs := settings.MaxProcs
+	memory := int64(settings.MemoryLimitMB) << 20
+	if !settings.Cgroup {
+		return procs, memory
+	}
+
+	if cpus, ok := cgroupCPUs(root); ok && procs == 0 {
+		procs = int(math.Ceil(cpus))
+	}
+
+	if limit, ok := cgroupMemory(root); ok && memory == 0 {
+		memory = int64(float64(limit) * memoryHeadroom)
+	}
+
+	return procs, memory
+}
+
+//ApplyResourceSettings sets GOMAXPROCS and the garbage collector of the
+//process, it returns what was changed. The settings are global, embedding
+//applications call it once for the whole process.
+func ApplyResourceSettings(settings ResourceSettings) []string {
+	changes := []string{}
+	procs, memory := resourceLimits(settings, "")
+	if procs > 0 {
+		runtime.GOMAXPROCS(procs)
+		changes = append(changes, fmt.Sprintf("GOMAXPROCS %d", procs))
+	}
+
+	if memory > 0 {
+		debug.SetMemoryLimit(memory)
+		changes = append(changes, fmt.Sprintf("memory limit %d MB", memory>>20))
+	}
+
+	if settings.GCPercent > 0 {
+		debug.SetGCPercent(settings.GCPercent)
+		changes = append(changes, fmt.Sprintf("GC percent %d", settings.GCPercent))
+	}
+
+	return changes
+}
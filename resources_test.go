@@ -0,0 +1,69 @@
+package redkeep_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resources", func() {
+	var root string
+
+	write := func(file, content string) {
+		path := filepath.Join(root, file)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "redkeep-cgroup")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	It("uses the configured limits", func() {
+		procs, memory := ResourceLimits(ResourceSettings{MaxProcs: 2, MemoryLimitMB: 512}, root)
+		Expect(procs).To(Equal(2))
+		Expect(memory).To(Equal(int64(512 << 20)))
+	})
+
+	It("derives limits from cgroup v2", func() {
+		write("sys/fs/cgroup/cpu.max", "150000 100000\n")
+		write("sys/fs/cgroup/memory.max", "1073741824\n")
+
+		procs, memory := ResourceLimits(ResourceSettings{Cgroup: true}, root)
+		Expect(procs).To(Equal(2))
+		Expect(memory).To(Equal(int64(966367641)))
+
+		procs, _ = ResourceLimits(ResourceSettings{Cgroup: true, MaxProcs: 4}, root)
+		Expect(procs).To(Equal(4))
+	})
+
+	It("derives limits from cgroup v1", func() {
+		write("sys/fs/cgroup/cpu/cpu.cfs_quota_us", "400000\n")
+		write("sys/fs/cgroup/cpu/cpu.cfs_period_us", "100000\n")
+		write("sys/fs/cgroup/memory/memory.limit_in_bytes", "9223372036854771712\n")
+
+		procs, memory := ResourceLimits(ResourceSettings{Cgroup: true}, root)
+		Expect(procs).To(Equal(4))
+		Expect(memory).To(BeZero())
+	})
+
+	It("keeps the defaults without cgroup limits", func() {
+		write("sys/fs/cgroup/cpu.max", "max 100000\n")
+		write("sys/fs/cgroup/memory.max", "max\n")
+
+		procs, memory := ResourceLimits(ResourceSettings{Cgroup: true}, root)
+		Expect(procs).To(BeZero())
+		Expect(memory).To(BeZero())
+	})
+})
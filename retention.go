@@ -0,0 +1,71 @@
+package redkeep
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//RetentionSettings limit what redkeep keeps about itself. Entries older
+//than MaxAge are pruned and at most MaxSize entries are kept, the oldest
+//are pruned first. Zero keeps entries without limit or uses the default
+//of the store.
+type RetentionSettings struct {
+	MaxAge  Duration `json:"maxAge"`
+	MaxSize int      `json:"maxSize" validate:"min=0"`
+}
+
+//withDefaults returns the settings with maxAge and maxSize
+//for the limits that are not configured
+func (r RetentionSettings) withDefaults(maxAge time.Duration, maxSize int) RetentionSettings {
+	if r.MaxAge.Duration == 0 {
+		r.MaxAge.Duration = maxAge
+	}
+
+	if r.MaxSize == 0 {
+		r.MaxSize = maxSize
+	}
+
+	return r
+}
+
+//expired is true for entries created at created
+func (r RetentionSettings) expired(created, now time.Time) bool {
+	return r.MaxAge.Duration > 0 && now.Sub(created) > r.MaxAge.Duration
+}
+
+//pruneCollection removes the entries of collection that are older than
+//MaxAge or exceed MaxSize, field is the creation time of the entries
+func pruneCollection(collection *mgo.Collection, field string, retention RetentionSettings, now time.Time) error {
+	if retention.MaxAge.Duration > 0 {
+		selector := bson.M{field: bson.M{"$lt": now.Add(-retention.MaxAge.Duration)}}
+		if _, err := collection.RemoveAll(selector); err != nil {
+			return err
+		}
+	}
+
+	if retention.MaxSize == 0 {
+		return nil
+	}
+
+	count, err := collection.Count()
+	if err != nil || count <= retention.MaxSize {
+		return err
+	}
+
+	oldest := []struct {
+		ID interface{} `bson:"_id"`
+	}{}
+	if err := collection.Find(nil).Sort(field).Limit(count - retention.MaxSize).Select(bson.M{"_id": 1}).All(&oldest); err != nil {
+		return err
+	}
+
+	ids := []interface{}{}
+	for _, entry := range oldest {
+		ids = append(ids, entry.ID)
+	}
+
+	_, err = collection.RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
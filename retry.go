@@ -0,0 +1,142 @@
+package redkeep
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	//defaultRetryAttempts is the number of attempts of policies without MaxAttempts
+	defaultRetryAttempts = 3
+	//defaultRetryMultiplier grows the backoff of policies without Multiplier
+	defaultRetryMultiplier = 2
+)
+
+//RetryPolicy decides which failed writes to targets are sent again and
+//when. A write is attempted at most MaxAttempts times (default 3). Before
+//the first retry the tracker waits Backoff, every further retry waits
+//Multiplier (default 2) times longer, at most MaxBackoff. Jitter varies
+//each wait by up to that fraction, so agents do not retry in lockstep.
+//Transient errors are retried (see Mongo.RetryWrites), RetryCodes adds
+//server error codes. Applications embedding redkeep can classify errors
+//themselves with Retryable.
+type RetryPolicy struct {
+	MaxAttempts int                  `json:"maxAttempts" validate:"min=0"`
+	Backoff     Duration             `json:"backoff"`
+	MaxBackoff  Duration             `json:"maxBackoff"`
+	Multiplier  float64              `json:"multiplier"`
+	Jitter      float64              `json:"jitter" validate:"min=0,max=1"`
+	RetryCodes  []int                `json:"retryCodes"`
+	Retryable   func(err error) bool `json:"-"`
+}
+
+//retryWritesPolicy is the policy of Mongo.RetryWrites,
+//it sends a write once more right away
+var retryWritesPolicy = RetryPolicy{MaxAttempts: 2}
+
+func checkRetryPolicy(p *RetryPolicy) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return errors.New("Retry multiplier must be at least 1")
+	}
+
+	if p.MaxBackoff.Duration > 0 && p.MaxBackoff.Duration < p.Backoff.Duration {
+		return errors.New("Retry maxBackoff must not be shorter than backoff")
+	}
+
+	return nil
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts == 0 {
+		return defaultRetryAttempts
+	}
+
+	return p.MaxAttempts
+}
+
+//retryable is true if a write that failed with err is sent again
+func (p RetryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	if retryableWrite(err) {
+		return true
+	}
+
+	code := 0
+	switch e := err.(type) {
+	case *mgo.LastError:
+		code = e.Code
+	case *mgo.QueryError:
+		code = e.Code
+	}
+
+	for _, retried := range p.RetryCodes {
+		if code != 0 && code == retried {
+			return true
+		}
+	}
+
+	return false
+}
+
+//backoff is the wait before attempt, the first retry is attempt 2.
+//random returns values in [0, 1) to apply the jitter.
+func (p RetryPolicy) backoff(attempt int, random func() float64) time.Duration {
+	if p.Backoff.Duration <= 0 {
+		return 0
+	}
+
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	wait := float64(p.Backoff.Duration) * math.Pow(multiplier, float64(attempt-2))
+	if max := float64(p.MaxBackoff.Duration); max > 0 && wait > max {
+		wait = max
+	}
+
+	if p.Jitter > 0 {
+		wait *= 1 - p.Jitter + 2*p.Jitter*random()
+	}
+
+	return time.Duration(wait)
+}
+
+//retryPolicy is the policy of w, watches without
+//policy use the one of the tracker
+func (c changeTracker) retryPolicy(w Watch) *RetryPolicy {
+	if w.Retry != nil {
+		return w.Retry
+	}
+
+	return c.retry
+}
+
+//trackerRetryPolicy is the configured policy of all watches,
+//RetryWrites without policy retries once
+func trackerRetryPolicy(c Configuration) *RetryPolicy {
+	if c.Retry != nil {
+		return c.Retry
+	}
+
+	if c.Mongo.RetryWrites {
+		policy := retryWritesPolicy
+		return &policy
+	}
+
+	return nil
+}
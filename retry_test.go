@@ -0,0 +1,93 @@
+package redkeep_test
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2"
+)
+
+var _ = Describe("Retry policy", func() {
+	stale := &mgo.LastError{Code: 63, Err: "stale shard version"}
+	invalid := &mgo.LastError{Code: 121, Err: "Document failed validation"}
+
+	It("will wait longer for every retry", func() {
+		policy := RetryPolicy{Backoff: Duration{100 * time.Millisecond}, MaxBackoff: Duration{time.Second}}
+		Expect(RetryBackoff(policy, 2, 0)).To(Equal(100 * time.Millisecond))
+		Expect(RetryBackoff(policy, 3, 0)).To(Equal(200 * time.Millisecond))
+		Expect(RetryBackoff(policy, 4, 0)).To(Equal(400 * time.Millisecond))
+		Expect(RetryBackoff(policy, 8, 0)).To(Equal(time.Second))
+
+		policy.Multiplier = 1
+		Expect(RetryBackoff(policy, 4, 0)).To(Equal(100 * time.Millisecond))
+
+		Expect(RetryBackoff(RetryPolicy{}, 3, 0)).To(BeZero())
+	})
+
+	It("will vary the backoff by the jitter", func() {
+		policy := RetryPolicy{Backoff: Duration{100 * time.Millisecond}, Jitter: 0.5}
+		Expect(RetryBackoff(policy, 2, 0)).To(Equal(50 * time.Millisecond))
+		Expect(RetryBackoff(policy, 2, 0.5)).To(Equal(100 * time.Millisecond))
+		Expect(RetryBackoff(policy, 2, 0.75)).To(Equal(125 * time.Millisecond))
+	})
+
+	It("will classify errors by code or with the classifier", func() {
+		Expect(RetryableError(RetryPolicy{}, stale)).To(BeTrue())
+		Expect(RetryableError(RetryPolicy{}, invalid)).To(BeFalse())
+		Expect(RetryableError(RetryPolicy{RetryCodes: []int{121}}, invalid)).To(BeTrue())
+		Expect(RetryableError(RetryPolicy{RetryCodes: []int{121}}, nil)).To(BeFalse())
+
+		classified := RetryPolicy{Retryable: func(err error) bool { return err.Error() == "busy" }}
+		Expect(RetryableError(classified, errors.New("busy"))).To(BeTrue())
+		Expect(RetryableError(classified, stale)).To(BeFalse())
+	})
+
+	It("will attempt writes up to the attempts of the policy", func() {
+		config := Configuration{Retry: &RetryPolicy{}}
+		attempts, retries, err := RetryWritePolicy(config, Watch{}, []error{stale, stale, stale, nil})
+		Expect(err).To(Equal(stale))
+		Expect(attempts).To(Equal(3))
+		Expect(retries).To(Equal(2.0))
+
+		config.Retry.MaxAttempts = 4
+		attempts, _, err = RetryWritePolicy(config, Watch{}, []error{stale, stale, stale, nil})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(4))
+
+		attempts, _, err = RetryWritePolicy(config, Watch{}, []error{invalid, nil})
+		Expect(err).To(Equal(invalid))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("will use the policy of the watch", func() {
+		config := Configuration{Mongo: Mongo{RetryWrites: true}}
+		w := Watch{Retry: &RetryPolicy{MaxAttempts: 1}}
+		attempts, _, err := RetryWritePolicy(config, w, []error{stale, nil})
+		Expect(err).To(Equal(stale))
+		Expect(attempts).To(Equal(1))
+
+		attempts, _, err = RetryWritePolicy(config, Watch{}, []error{stale, nil})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("will reject invalid policies", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"retry": { "backoff": "1s", "maxBackoff": "100ms" }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Retry maxBackoff must not be shorter than backoff"))
+
+		config = strings.Replace(templateForTestsConfig, `"triggerReference": "xEx"`, `"triggerReference": "xEx", "retry": { "multiplier": 0.5 }`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Retry multiplier must be at least 1"))
+
+		config = strings.Replace(templateForTestsConfig, `"watches"`, `"retry": { "maxAttempts": 5, "backoff": "50ms", "maxBackoff": "2s", "jitter": 0.2, "retryCodes": [112] }, "watches"`, 1)
+		loaded, err := NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.Retry.RetryCodes).To(Equal([]int{112}))
+	})
+})
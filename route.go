@@ -0,0 +1,67 @@
+package redkeep
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+//RouteData are the fields of a change event a route template can use:
+//{{.DB}}, {{.Collection}}, {{.Namespace}}, {{.Op}} (i, u or d) and {{.Watch}}
+type RouteData struct {
+	DB         string
+	Collection string
+	Namespace  string
+	Op         string
+	Watch      string
+}
+
+//Route computes the destination of a change event, like a topic, an
+//index or a table, from a template such as "cdc.{{.DB}}.{{.Collection}}".
+//Routes without template always return their text.
+type Route struct {
+	text     string
+	template *template.Template
+}
+
+//NewRoute parses the route template text
+func NewRoute(text string) (*Route, error) {
+	route := &Route{text: text}
+	if !strings.Contains(text, "{{") {
+		return route, nil
+	}
+
+	parsed, err := template.New("route").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid route %q: %s", text, err.Error())
+	}
+	route.template = parsed
+
+	//unknown fields only fail when the template is executed
+	if _, err := route.Resolve(ChangeEvent{Namespace: "db.collection"}); err != nil {
+		return nil, err
+	}
+
+	return route, nil
+}
+
+//Resolve returns the destination of e
+func (r *Route) Resolve(e ChangeEvent) (string, error) {
+	if r.template == nil {
+		return r.text, nil
+	}
+
+	data := RouteData{Namespace: e.Namespace, Op: e.Operation, Watch: e.Watch}
+	data.DB = e.Namespace
+	if p := strings.Index(e.Namespace, "."); p >= 0 {
+		data.DB, data.Collection = e.Namespace[:p], e.Namespace[p+1:]
+	}
+
+	var destination bytes.Buffer
+	if err := r.template.Execute(&destination, data); err != nil {
+		return "", fmt.Errorf("Invalid route %q: %s", r.text, err.Error())
+	}
+
+	return destination.String(), nil
+}
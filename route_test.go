@@ -0,0 +1,44 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routes", func() {
+	event := ChangeEvent{Watch: "userComments", Operation: "d", Namespace: "live.user.archive", ID: "1"}
+
+	It("computes the destination of an event", func() {
+		route, err := NewRoute("cdc.{{.DB}}.{{.Collection}}.{{.Op}}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(route.Resolve(event)).To(Equal("cdc.live.user.archive.d"))
+
+		route, err = NewRoute("{{.Watch}}-{{.Namespace}}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(route.Resolve(event)).To(Equal("userComments-live.user.archive"))
+	})
+
+	It("returns routes without template as they are", func() {
+		route, err := NewRoute("cache.invalidation")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(route.Resolve(event)).To(Equal("cache.invalidation"))
+	})
+
+	It("does not accept invalid templates", func() {
+		_, err := NewRoute("cdc.{{.DB")
+		Expect(err).To(MatchError(HavePrefix(`Invalid route "cdc.{{.DB"`)))
+
+		_, err = NewRoute("cdc.{{.Database}}")
+		Expect(err).To(MatchError(HavePrefix(`Invalid route "cdc.{{.Database}}"`)))
+	})
+
+	It("routes invalidation messages", func() {
+		_, err := NewInvalidationSink(InvalidationSettings{Redis: &InvalidationRedis{
+			RedisSettings: RedisSettings{Address: "localhost:6379"},
+			Channel:       "invalidation.{{.Table}}",
+		}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,103 @@
+package redkeep
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+//retryableCodes are server errors of writes that succeed when they are
+//sent again, mongos reports them while a primary steps down or chunks move
+var retryableCodes = map[int]bool{
+	6:     true, //HostUnreachable
+	7:     true, //HostNotFound
+	63:    true, //StaleShardVersion
+	89:    true, //NetworkTimeout
+	91:    true, //ShutdownInProgress
+	150:   true, //StaleEpoch
+	189:   true, //PrimarySteppedDown
+	9001:  true, //SocketException
+	10107: true, //NotMaster
+	11600: true, //InterruptedAtShutdown
+	11602: true, //InterruptedDueToReplStateChange
+	13388: true, //StaleConfig
+	13435: true, //NotMasterNoSlaveOk
+	13436: true, //NotMasterOrSecondary
+}
+
+//retryableWrite is true if a write failed with err can be sent again.
+//Duplicate keys are permanent, the writes to targets never upsert, so
+//they violate a unique index of the target that a retry violates again.
+func retryableWrite(err error) bool {
+	if err == nil || err == mgo.ErrNotFound || err == errInjectedFault || mgo.IsDup(err) {
+		return false
+	}
+
+	if err == io.EOF {
+		return true
+	}
+
+	switch e := err.(type) {
+	case *mgo.LastError:
+		return retryableCodes[e.Code]
+	case *mgo.QueryError:
+		return retryableCodes[e.Code]
+	case net.Error:
+		return true
+	}
+
+	return false
+}
+
+//write runs a write of w to a target collection of session. A write that
+//failed with a retryable error is sent again after the backoff of the retry
+//policy of w, with a refreshed session. The writes of the agent set values,
+//sending them twice has the same result, history entries are only added
+//once, see historySelector. With mockTargets the write is not
+//sent, a dry run reports intended instead.
+func (c changeTracker) write(w Watch, session *mgo.Session, intended IntendedWrite, write func() error) error {
+	if c.dryRun != nil {
+		c.dryRun.report(session, w, intended)
+		return nil
+	}
+
+	if c.mockTargets {
+		return nil
+	}
+
+	err := write()
+	if policy := c.retryPolicy(w); policy != nil {
+		for attempt := 2; attempt <= policy.attempts() && policy.retryable(err); attempt++ {
+			c.metrics.add(MetricWriteRetries, 1)
+			time.Sleep(policy.backoff(attempt, rand.Float64))
+			session.Refresh()
+			err = write()
+		}
+	}
+
+	if err != nil && c.reuseSession {
+		//the session of a worker is used for the next entries as well
+		session.Refresh()
+	}
+
+	return err
+}
+
+//dialRouter connects to the mongos router for writes to targets,
+//without router the writes use session
+func dialRouter(settings Mongo, session *mgo.Session) (*mgo.Session, error) {
+	if settings.Router == "" {
+		return session, nil
+	}
+
+	router, err := mgo.Dial(settings.Router)
+	if err != nil {
+		return nil, err
+	}
+
+	router.SetMode(mgo.Strong, true)
+	return router, nil
+}
@@ -0,0 +1,49 @@
+package redkeep_test
+
+import (
+	"errors"
+	"io"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2"
+)
+
+var _ = Describe("Router", func() {
+	It("retries writes that failed while the cluster changed", func() {
+		Expect(RetryableWrite(nil)).To(BeFalse())
+		Expect(RetryableWrite(mgo.ErrNotFound)).To(BeFalse())
+		Expect(RetryableWrite(errors.New("Document failed validation"))).To(BeFalse())
+		Expect(RetryableWrite(&mgo.LastError{Code: 121})).To(BeFalse())
+		Expect(RetryableWrite(&mgo.LastError{Code: 11000})).To(BeFalse())
+		Expect(RetryableWrite(io.EOF)).To(BeTrue())
+		Expect(RetryableWrite(&mgo.LastError{Code: 10107})).To(BeTrue())
+		Expect(RetryableWrite(&mgo.QueryError{Code: 13388})).To(BeTrue())
+	})
+
+	It("sends a write once more with retryWrites", func() {
+		stale := &mgo.LastError{Code: 63, Err: "stale shard version"}
+
+		attempts, retries, err := RetryWrite(true, []error{stale, nil})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(2))
+		Expect(retries).To(Equal(1.0))
+
+		attempts, _, err = RetryWrite(true, []error{stale, stale})
+		Expect(err).To(Equal(stale))
+		Expect(attempts).To(Equal(2))
+
+		attempts, retries, err = RetryWrite(false, []error{stale})
+		Expect(err).To(Equal(stale))
+		Expect(attempts).To(Equal(1))
+		Expect(retries).To(BeZero())
+	})
+
+	It("discards writes with mock targets", func() {
+		attempts, err := MockWrite()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(BeZero())
+	})
+})
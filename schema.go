@@ -0,0 +1,316 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//schemaDraft is the JSON schema version of the manifest
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+//SchemaNode is a field in the JSON schema of the fields redkeep writes.
+//BSONType lists the types the field can have like in $jsonSchema of
+//MongoDB, it is empty if they are not known. Watches are the keys of the
+//watches that write it, Sources the tracked fields (database.collection.field)
+//its values come from.
+type SchemaNode struct {
+	Type       string                 `json:"type,omitempty"`
+	BSONType   []string               `json:"bsonType,omitempty"`
+	Properties map[string]*SchemaNode `json:"properties,omitempty"`
+	Items      *SchemaNode            `json:"items,omitempty"`
+	Watches    []string               `json:"x-redkeep-watches,omitempty"`
+	Sources    []string               `json:"x-redkeep-sources,omitempty"`
+}
+
+//SchemaManifest describes what redkeep writes where as a JSON schema, its
+//properties are the written namespaces (database.collection). Targets have
+//the fields of their normalized fields, history and temporal collections
+//their documents.
+type SchemaManifest struct {
+	Schema     string                 `json:"$schema"`
+	Title      string                 `json:"title"`
+	Type       string                 `json:"type"`
+	Properties map[string]*SchemaNode `json:"properties"`
+}
+
+//NewSchemaManifest describes the writes of watches. types are the bson
+//types of tracked fields by source, see SampleSchemaTypes, the types of
+//watches with transforms are unknown.
+func NewSchemaManifest(watches []Watch, types map[string][]string) SchemaManifest {
+	m := SchemaManifest{Schema: schemaDraft, Title: "redkeep", Type: "object", Properties: map[string]*SchemaNode{}}
+	for _, w := range watches {
+		normalized := m.namespace(w.TargetCollection)
+		if w.BehaviourSettings.ReferenceArray {
+			array := normalized.path(w.TriggerReference, w.Key())
+			array.addTypes("array")
+			if array.Items == nil {
+				array.Items = &SchemaNode{Type: "object", BSONType: []string{"object"}}
+			}
+			array.Items.addWatch(w.Key())
+			normalized = array.Items
+		}
+		normalized = normalized.path(w.TargetNormalizedField, w.Key())
+		normalized.addTypes("object")
+
+		for _, field := range w.TrackFields {
+			source := w.TrackCollection + "." + field
+			node := normalized.path(w.targetField(field), w.Key())
+			node.Sources = appendUnique(node.Sources, source)
+			if len(w.Transforms) == 0 {
+				node.addTypes(types[source]...)
+			}
+		}
+
+		if w.BehaviourSettings.Generations {
+			normalized.path(generationField, w.Key()).addTypes("timestamp")
+		}
+
+		if w.History != nil && w.History.Collection == "" {
+			history := normalized.path(historyField, w.Key())
+			history.addTypes("array")
+			history.Items = historyEntrySchema(w.Key(), false)
+		}
+
+		if w.History != nil && w.History.Collection != "" {
+			m.namespace(w.History.Collection).merge(historyEntrySchema(w.Key(), true))
+		}
+
+		if w.Temporal != nil {
+			m.namespace(w.Temporal.Collection).merge(temporalValueSchema(w.Key()))
+		}
+	}
+
+	return m
+}
+
+//namespace returns the node of the documents of ns
+func (m SchemaManifest) namespace(ns string) *SchemaNode {
+	node, ok := m.Properties[ns]
+	if !ok {
+		node = &SchemaNode{Type: "object", BSONType: []string{"object"}}
+		m.Properties[ns] = node
+	}
+
+	return node
+}
+
+//path returns the node of the dotted path below n, the nodes on
+//the way are objects written by watch
+func (n *SchemaNode) path(dotted, watch string) *SchemaNode {
+	node := n
+	node.addWatch(watch)
+	for _, name := range strings.Split(dotted, ".") {
+		if node.Properties == nil {
+			node.Properties = map[string]*SchemaNode{}
+		}
+
+		child, ok := node.Properties[name]
+		if !ok {
+			child = &SchemaNode{}
+			node.Properties[name] = child
+		}
+
+		if node != n {
+			node.addTypes("object")
+		}
+		node = child
+		node.addWatch(watch)
+	}
+
+	return node
+}
+
+func (n *SchemaNode) addWatch(watch string) {
+	n.Watches = appendUnique(n.Watches, watch)
+}
+
+//addTypes adds bson types, type is object if all of them are objects
+func (n *SchemaNode) addTypes(types ...string) {
+	for _, t := range types {
+		n.BSONType = appendUnique(n.BSONType, t)
+	}
+
+	n.Type = ""
+	if len(n.BSONType) == 1 && n.BSONType[0] == "object" {
+		n.Type = "object"
+	}
+}
+
+//merge adds the fields and watches of other to n
+func (n *SchemaNode) merge(other *SchemaNode) {
+	n.addTypes(other.BSONType...)
+	for _, watch := range other.Watches {
+		n.addWatch(watch)
+	}
+	for _, source := range other.Sources {
+		n.Sources = appendUnique(n.Sources, source)
+	}
+
+	for name, child := range other.Properties {
+		if n.Properties == nil {
+			n.Properties = map[string]*SchemaNode{}
+		}
+
+		if existing, ok := n.Properties[name]; ok {
+			existing.merge(child)
+			continue
+		}
+		n.Properties[name] = child
+	}
+}
+
+//historyEntrySchema is a HistoryEntry of watch, target entries have the id
+//of their write, collection entries the watch and the id of the tracked document
+func historyEntrySchema(watch string, collection bool) *SchemaNode {
+	entry := &SchemaNode{Type: "object", BSONType: []string{"object"}, Watches: []string{watch}, Properties: map[string]*SchemaNode{
+		"fields":  {Type: "object", BSONType: []string{"object"}, Watches: []string{watch}},
+		"changed": {BSONType: []string{"date"}, Watches: []string{watch}},
+	}}
+	if !collection {
+		entry.Properties["write"] = &SchemaNode{BSONType: []string{"objectId"}, Watches: []string{watch}}
+	}
+	if collection {
+		entry.Properties["watch"] = &SchemaNode{BSONType: []string{"string"}, Watches: []string{watch}}
+		entry.Properties["id"] = &SchemaNode{Watches: []string{watch}}
+	}
+
+	return entry
+}
+
+//temporalValueSchema is a TemporalValue of watch
+func temporalValueSchema(watch string) *SchemaNode {
+	field := func(types ...string) *SchemaNode {
+		return &SchemaNode{BSONType: types, Watches: []string{watch}}
+	}
+
+	return &SchemaNode{Type: "object", BSONType: []string{"object"}, Watches: []string{watch}, Properties: map[string]*SchemaNode{
+		"watch":     field("string"),
+		"ref":       field(),
+		"field":     field("string"),
+		"value":     field(),
+		"validFrom": field("date"),
+		"validTo":   field("date", "null"),
+		"recorded":  field("date"),
+	}}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+
+	values = append(values, value)
+	sort.Strings(values)
+	return values
+}
+
+//bsonTypeName is the $jsonSchema name of the type of value
+func bsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	case time.Time:
+		return "date"
+	case bson.ObjectId:
+		return "objectId"
+	case bson.MongoTimestamp:
+		return "timestamp"
+	case []byte:
+		return "binData"
+	case map[string]interface{}, bson.M, bson.D:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	return ""
+}
+
+//SampleSchemaTypes reads up to size documents of every tracked collection
+//and returns the bson types of the tracked fields by source
+//(database.collection.field) for NewSchemaManifest
+func SampleSchemaTypes(session *mgo.Session, watches []Watch, size int) (map[string][]string, error) {
+	fields := map[string][]string{}
+	for _, w := range watches {
+		fields[w.TrackCollection] = append(fields[w.TrackCollection], w.TrackFields...)
+	}
+
+	types := map[string][]string{}
+	for ns, tracked := range fields {
+		p := strings.Index(ns, ".")
+		iter := session.DB(ns[:p]).C(ns[p+1:]).Find(nil).Limit(size).Iter()
+		document := map[string]interface{}{}
+		for iter.Next(&document) {
+			for _, field := range tracked {
+				value, ok := lookupValue(field, document)
+				if !ok {
+					continue
+				}
+
+				if name := bsonTypeName(value); name != "" {
+					types[ns+"."+field] = appendUnique(types[ns+"."+field], name)
+				}
+			}
+			document = map[string]interface{}{}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return types, nil
+}
+
+//writeSchemaManifest writes the manifest of watches to path, readers
+//never see a partly written file
+func writeSchemaManifest(path string, watches []Watch) error {
+	data, err := json.MarshalIndent(NewSchemaManifest(watches, nil), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary := path + ".tmp"
+	if err := ioutil.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
+
+//updateSchemaManifest writes the manifest of the current watches if the
+//configuration has a file for it, failures are logged
+func (t *TailAgent) updateSchemaManifest() {
+	if t.config.SchemaManifest == "" {
+		return
+	}
+
+	if err := writeSchemaManifest(t.config.SchemaManifest, t.watches.list()); err != nil {
+		logWarn("Schema manifest could not be written", Fields{"file": t.config.SchemaManifest}.withError(err))
+	}
+}
+
+//serveSchema returns the manifest of the current watches
+func (t *TailAgent) serveSchema(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, NewSchemaManifest(t.watches.list(), nil))
+}
@@ -0,0 +1,95 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema manifests", func() {
+	comments := Watch{
+		Name:                  "userComments",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"username", "address.city"},
+		TargetCollection:      "app.comment",
+		TargetNormalizedField: "meta.user",
+		TriggerReference:      "user",
+		BehaviourSettings:     BehaviourSettings{Generations: true},
+	}
+
+	It("will describe the fields of the targets", func() {
+		manifest := NewSchemaManifest([]Watch{comments}, map[string][]string{"app.user.username": {"string"}})
+		Expect(manifest.Schema).To(Equal("http://json-schema.org/draft-07/schema#"))
+		Expect(manifest.Properties).To(HaveLen(1))
+
+		user := manifest.Properties["app.comment"].Properties["meta"].Properties["user"]
+		Expect(user.Type).To(Equal("object"))
+		Expect(user.Watches).To(Equal([]string{"userComments"}))
+		Expect(user.Properties["username"].BSONType).To(Equal([]string{"string"}))
+		Expect(user.Properties["username"].Sources).To(Equal([]string{"app.user.username"}))
+		Expect(user.Properties["address"].Type).To(Equal("object"))
+		Expect(user.Properties["address"].Properties["city"].BSONType).To(BeEmpty())
+		Expect(user.Properties["address"].Properties["city"].Sources).To(Equal([]string{"app.user.address.city"}))
+		Expect(user.Properties["_generation"].BSONType).To(Equal([]string{"timestamp"}))
+	})
+
+	It("will describe reference arrays, histories and temporal collections", func() {
+		tags := Watch{
+			Name:                  "postTags",
+			TrackCollection:       "app.tag",
+			TrackFields:           []string{"label"},
+			TargetCollection:      "app.post",
+			TargetNormalizedField: "tag",
+			TriggerReference:      "tags",
+			BehaviourSettings:     BehaviourSettings{ReferenceArray: true},
+			Temporal:              &TemporalSettings{Collection: "app.tagHistory"},
+		}
+		withHistory := comments
+		withHistory.History = &HistorySettings{}
+
+		manifest := NewSchemaManifest([]Watch{tags, withHistory}, map[string][]string{"app.tag.label": {"string"}})
+		array := manifest.Properties["app.post"].Properties["tags"]
+		Expect(array.BSONType).To(Equal([]string{"array"}))
+		Expect(array.Items.Properties["tag"].Properties["label"].BSONType).To(Equal([]string{"string"}))
+
+		history := manifest.Properties["app.comment"].Properties["meta"].Properties["user"].Properties["_history"]
+		Expect(history.Items.Properties).To(HaveKey("changed"))
+		Expect(manifest.Properties["app.tagHistory"].Properties["validTo"].BSONType).To(Equal([]string{"date", "null"}))
+		Expect(manifest.Properties["app.tagHistory"].Watches).To(Equal([]string{"postTags"}))
+	})
+
+	It("will not guess the types of transformed fields", func() {
+		transformed := comments
+		transformed.Transforms = []TransformConfig{{Type: "upper"}}
+		manifest := NewSchemaManifest([]Watch{transformed}, map[string][]string{"app.user.username": {"string"}})
+		Expect(manifest.Properties["app.comment"].Properties["meta"].Properties["user"].Properties["username"].BSONType).To(BeEmpty())
+	})
+
+	It("will write the manifest again when the watches are reloaded", func() {
+		directory, err := ioutil.TempDir("", "schema")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(directory)
+
+		file := filepath.Join(directory, "schema.json")
+		agent, _, _, _ := ControlledAgent([]Watch{comments})
+		agent.SetSchemaManifest(file)
+		reviews := comments
+		reviews.Name = "userReviews"
+		reviews.TargetCollection = "app.review"
+		_, err = agent.ReloadWatches([]Watch{comments, reviews}, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		data, err := ioutil.ReadFile(file)
+		Expect(err).ToNot(HaveOccurred())
+		var manifest SchemaManifest
+		Expect(json.Unmarshal(data, &manifest)).To(Succeed())
+		Expect(manifest.Properties).To(HaveKey("app.review"))
+		Expect(manifest.Properties).To(HaveKey("app.comment"))
+	})
+})
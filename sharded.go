@@ -0,0 +1,299 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//Shard is a shard of a sharded cluster as listed in config.shards,
+//Host is the replica set and its members like rs0/shard-01:27018
+type Shard struct {
+	ID   string `bson:"_id" json:"id"`
+	Host string `bson:"host" json:"host"`
+}
+
+//DiscoverShards lists the shards of the cluster session is connected to through mongos
+func DiscoverShards(session *mgo.Session) ([]Shard, error) {
+	shards := []Shard{}
+	if err := session.DB("config").C("shards").Find(nil).Sort("_id").All(&shards); err != nil {
+		return nil, err
+	}
+
+	if len(shards) == 0 {
+		return nil, errors.New("No shards found in config.shards, is the connection uri a mongos router?")
+	}
+
+	return shards, nil
+}
+
+//shardURI is the connection uri of the members of the shard host,
+//with the credentials, database and options of the router uri
+func shardURI(router, host string) string {
+	if p := strings.Index(host, "/"); p >= 0 {
+		host = host[p+1:]
+	}
+
+	scheme, rest := "", router
+	if strings.HasPrefix(rest, "mongodb://") {
+		scheme, rest = "mongodb://", strings.TrimPrefix(rest, "mongodb://")
+	}
+
+	options := ""
+	if p := strings.IndexAny(rest, "/?"); p >= 0 {
+		rest, options = rest[:p], rest[p:]
+	}
+
+	credentials := ""
+	if p := strings.LastIndex(rest, "@"); p >= 0 {
+		credentials = rest[:p+1]
+	}
+
+	return scheme + credentials + host + options
+}
+
+//shardConfiguration is the configuration of the agent of shard. It
+//reads the oplog of the shard and writes through the routers, its
+//checkpoint is stored for the shard. Sinks are shared by all shards,
+//admin and metrics are not served for single shards.
+func shardConfiguration(c Configuration, shard Shard) Configuration {
+	if c.Mongo.Router == "" {
+		c.Mongo.Router = c.Mongo.ConnectionURI
+	}
+	c.Mongo.ConnectionURI = shardURI(c.Mongo.ConnectionURI, shard.Host)
+	c.Mongo.Sharded = false
+	c.Sinks = nil
+	c.Admin.Listen = ""
+	c.Metrics.Listen = ""
+
+	if c.Checkpoint.File != "" {
+		c.Checkpoint.File += "." + shard.ID
+	}
+	if c.Checkpoint.Collection != "" {
+		if c.Checkpoint.Name == "" {
+			c.Checkpoint.Name = defaultCheckpointName
+		}
+		c.Checkpoint.Name += "-" + shard.ID
+	}
+	if c.DryRun != nil && c.DryRun.File != "" {
+		dryRun := *c.DryRun
+		dryRun.File += "." + shard.ID
+		c.DryRun = &dryRun
+	}
+
+	return c
+}
+
+//mergedSink passes the change events of all shards to one sink. Its
+//watermark is the lowest watermark of all shards and it is closed
+//when the agents of all shards closed it.
+type mergedSink struct {
+	sync.Mutex
+	sink       Sink
+	watermarks []bson.MongoTimestamp
+	emitted    bson.MongoTimestamp
+	open       int
+}
+
+func newMergedSink(sink Sink, shards int) *mergedSink {
+	return &mergedSink{sink: sink, watermarks: make([]bson.MongoTimestamp, shards), open: shards}
+}
+
+//shardSink is the sink of the merged sink for the agent of one shard
+type shardSink struct {
+	merged *mergedSink
+	shard  int
+}
+
+//Send passes e to the sink, one event at a time
+func (s shardSink) Send(e ChangeEvent) error {
+	s.merged.Lock()
+	defer s.merged.Unlock()
+	return s.merged.sink.Send(e)
+}
+
+//Watermark passes the lowest watermark of all shards to the sink once it advanced
+func (s shardSink) Watermark(ts bson.MongoTimestamp) error {
+	m := s.merged
+	m.Lock()
+	defer m.Unlock()
+	m.watermarks[s.shard] = ts
+	low := ts
+	for _, watermark := range m.watermarks {
+		if watermark < low {
+			low = watermark
+		}
+	}
+
+	sink, ok := m.sink.(WatermarkSink)
+	if !ok || low <= m.emitted {
+		return nil
+	}
+
+	if err := sink.Watermark(low); err != nil {
+		return err
+	}
+
+	m.emitted = low
+	return nil
+}
+
+//Close closes the sink after the last shard closed it
+func (s shardSink) Close() error {
+	m := s.merged
+	m.Lock()
+	defer m.Unlock()
+	m.open--
+	if m.open > 0 {
+		return nil
+	}
+
+	return m.sink.Close()
+}
+
+//ShardedTailAgent tails the oplogs of all shards of a sharded cluster
+//with one TailAgent per shard. The change events of all shards are
+//merged into the same sinks.
+type ShardedTailAgent struct {
+	shards []Shard
+	agents []*TailAgent
+}
+
+//NewShardedTailAgentWithStartDate discovers the shards through the router
+//Mongo.ConnectionURI and starts the agent of every shard at startTime
+func NewShardedTailAgentWithStartDate(c Configuration, startTime time.Time) (*ShardedTailAgent, error) {
+	return newShardedTailAgent(c, func(shard Configuration) (*TailAgent, error) {
+		return NewTailAgentWithStartDate(shard, startTime)
+	})
+}
+
+//NewShardedTailAgentFromCheckpoint works like NewTailAgentFromCheckpoint,
+//every shard resumes from its own checkpoint
+func NewShardedTailAgentFromCheckpoint(c Configuration) (*ShardedTailAgent, error) {
+	return newShardedTailAgent(c, NewTailAgentFromCheckpoint)
+}
+
+func newShardedTailAgent(c Configuration, newAgent func(c Configuration) (*TailAgent, error)) (*ShardedTailAgent, error) {
+	session, err := mgo.Dial(c.Mongo.ConnectionURI)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := DiscoverShards(session)
+	session.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sharded := &ShardedTailAgent{shards: shards}
+	for _, shard := range shards {
+		agent, err := newAgent(shardConfiguration(c, shard))
+		if err != nil {
+			sharded.close()
+			return nil, err
+		}
+
+		sharded.agents = append(sharded.agents, agent)
+	}
+
+	for _, sc := range c.Sinks {
+		sink, err := NewSink(sc)
+		if err != nil {
+			sharded.close()
+			return nil, err
+		}
+
+		sharded.addSink(sink, sc.Filter)
+	}
+
+	return sharded, nil
+}
+
+//close closes the sinks and sessions of agents that never tailed
+func (s *ShardedTailAgent) close() {
+	for _, agent := range s.agents {
+		agent.sinks.close()
+	}
+	s.Close()
+}
+
+//Close closes the sessions of the agents of all shards
+func (s *ShardedTailAgent) Close() {
+	for _, agent := range s.agents {
+		agent.Close()
+	}
+}
+
+//AddSink adds a sink that will receive the change events of all shards.
+//Batch sinks get every event on its own.
+func (s *ShardedTailAgent) AddSink(sink Sink) {
+	s.addSink(sink, nil)
+}
+
+func (s *ShardedTailAgent) addSink(sink Sink, filter *EventFilter) {
+	merged := newMergedSink(sink, len(s.agents))
+	for i, agent := range s.agents {
+		agent.addSink(shardSink{merged: merged, shard: i}, filter)
+	}
+}
+
+//Shards are the shards that are tailed
+func (s *ShardedTailAgent) Shards() []Shard {
+	return s.shards
+}
+
+//TailContext tails the oplogs of all shards until ctx is done or
+//one of them fails, then all of them are stopped
+func (s *ShardedTailAgent) TailContext(ctx context.Context, opts TailOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(s.agents))
+	for _, agent := range s.agents {
+		go func(agent *TailAgent) {
+			err := agent.TailContext(ctx, opts)
+			if err != nil && err != context.Canceled {
+				cancel()
+			}
+			errs <- err
+		}(agent)
+	}
+
+	var first error
+	for range s.agents {
+		if err := <-errs; err != nil && err != context.Canceled && first == nil {
+			first = err
+		}
+	}
+
+	if first != nil {
+		return first
+	}
+
+	return ctx.Err()
+}
+
+//Status returns the status of all shards, counters are summed
+//up and the lag is the one of the shard that lags most
+func (s *ShardedTailAgent) Status() AgentStatus {
+	status := s.agents[0].Status()
+	status.Metrics = map[string]float64{}
+	for _, agent := range s.agents {
+		for name, value := range agent.Status().Metrics {
+			if name == MetricLagSeconds {
+				if value > status.Metrics[name] {
+					status.Metrics[name] = value
+				}
+				continue
+			}
+
+			status.Metrics[name] += value
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,233 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//ChangeEvent describes one change of a tracked document
+//that matched a watch
+type ChangeEvent struct {
+	Watch     string                 `json:"watch"`
+	Operation string                 `json:"op"`
+	Namespace string                 `json:"ns"`
+	ID        interface{}            `json:"id"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Timestamp bson.MongoTimestamp    `json:"ts"`
+}
+
+//Sink receives every change event redkeep handled
+type Sink interface {
+	Send(e ChangeEvent) error
+	Close() error
+}
+
+//SinkFactory creates a sink from the options of its configuration
+type SinkFactory func(options json.RawMessage) (Sink, error)
+
+//SinkConfig configures one sink, options depend on the type.
+//Spool keeps the events the sink fails to take on disk,
+//Filter selects the events the sink gets.
+type SinkConfig struct {
+	Type    string          `json:"type" validate:"required,min=1"`
+	Options json.RawMessage `json:"options"`
+	Spool   *SpoolSettings  `json:"spool"`
+	Filter  *EventFilter    `json:"filter"`
+}
+
+var (
+	sinkTypesMutex sync.RWMutex
+	sinkTypes      = map[string]SinkFactory{}
+)
+
+//RegisterSinkType makes a sink available under name
+//for the configuration
+func RegisterSinkType(name string, factory SinkFactory) {
+	sinkTypesMutex.Lock()
+	defer sinkTypesMutex.Unlock()
+	sinkTypes[name] = factory
+}
+
+func getSinkFactory(name string) (SinkFactory, bool) {
+	sinkTypesMutex.RLock()
+	defer sinkTypesMutex.RUnlock()
+	factory, ok := sinkTypes[name]
+	return factory, ok
+}
+
+//NewSink creates a sink from the given configuration
+func NewSink(c SinkConfig) (Sink, error) {
+	factory, ok := getSinkFactory(c.Type)
+	if !ok {
+		return nil, fmt.Errorf("Unknown sink type %s", c.Type)
+	}
+
+	sink, err := factory(c.Options)
+	if err != nil || c.Spool == nil {
+		return sink, err
+	}
+
+	spooled, err := newSpoolSink(sink, *c.Spool)
+	if err != nil {
+		sink.Close()
+		return nil, err
+	}
+
+	return spooled, nil
+}
+
+//errSinkFull is returned by sinks that buffer events and had to drop one
+var errSinkFull = errors.New("Sink buffer is full, event dropped")
+
+//sinkDispatcher forwards events to all sinks,
+//filters has the filter of every sink or nil
+type sinkDispatcher struct {
+	sync.RWMutex
+	sinks   []Sink
+	filters []*EventFilter
+	metrics *metricRegistry
+	//dropped is the timestamp of the first event a sink had no room
+	//for, watermarks stay before it until the agent is started again
+	dropped   bson.MongoTimestamp
+	dropMutex sync.Mutex
+}
+
+//add forwards events to s, batch sinks get them in batches
+func (d *sinkDispatcher) add(s Sink) {
+	d.addFiltered(s, nil)
+}
+
+//addFiltered forwards the events that match filter to s,
+//all events if filter is nil
+func (d *sinkDispatcher) addFiltered(s Sink, filter *EventFilter) {
+	if sink, ok := s.(BatchSink); ok {
+		s = &batchSink{sink: sink, metrics: d.metrics}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.sinks = append(d.sinks, s)
+	d.filters = append(d.filters, filter)
+}
+
+//batching is true if one of the sinks is a batch sink
+func (d *sinkDispatcher) batching() bool {
+	d.RLock()
+	defer d.RUnlock()
+	for _, s := range d.sinks {
+		if _, ok := s.(*batchSink); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *sinkDispatcher) send(e ChangeEvent) {
+	d.RLock()
+	defer d.RUnlock()
+	for i, s := range d.sinks {
+		if filter := d.filters[i]; filter != nil && !filter.matches(e) {
+			continue
+		}
+
+		if err := s.Send(e); err != nil {
+			if err == errSinkFull {
+				d.drop(s, e)
+			}
+			logError("Sink could not handle event", Fields{"watch": e.Watch, "ns": e.Namespace}.withError(err))
+		}
+	}
+}
+
+//drop counts e as dropped by s. Unless s is a subscription, watermarks
+//and with them checkpoints are held before e, so the agent sends it
+//again once it is started from the checkpoint.
+func (d *sinkDispatcher) drop(s Sink, e ChangeEvent) {
+	d.metrics.add(MetricDroppedEvents, 1)
+	if _, ok := s.(*subscriptions); ok || e.Timestamp == 0 {
+		return
+	}
+
+	d.dropMutex.Lock()
+	defer d.dropMutex.Unlock()
+	if d.dropped == 0 || e.Timestamp < d.dropped {
+		d.dropped = e.Timestamp
+	}
+}
+
+//held returns ts or, after events were dropped, the last
+//timestamp before the first dropped event
+func (d *sinkDispatcher) held(ts bson.MongoTimestamp) bson.MongoTimestamp {
+	d.dropMutex.Lock()
+	defer d.dropMutex.Unlock()
+	if d.dropped == 0 || ts < d.dropped {
+		return ts
+	}
+
+	logWarn("Events were dropped, watermark held back", Fields{"dropped": int64(d.dropped)})
+	return d.dropped - 1
+}
+
+func (d *sinkDispatcher) close() {
+	d.Lock()
+	defer d.Unlock()
+	for _, s := range d.sinks {
+		if err := s.Close(); err != nil {
+			logError("Sink could not be closed", errorFields(err))
+		}
+	}
+	d.sinks = nil
+	d.filters = nil
+}
+
+//newChangeEvent creates the event for an oplog entry of the
+//tracked collection of w, returns false if there is nothing to tell
+func newChangeEvent(w Watch, operationType string, dataset map[string]interface{}) (ChangeEvent, bool) {
+	command, ok := dataset["o"].(map[string]interface{})
+	if !ok {
+		return ChangeEvent{}, false
+	}
+
+	event := ChangeEvent{
+		Watch:     w.Key(),
+		Operation: operationType,
+		Namespace: w.TrackCollection,
+	}
+	event.Timestamp, _ = dataset["ts"].(bson.MongoTimestamp)
+
+	switch operationType {
+	case "i":
+		event.ID = command["_id"]
+		event.Fields = changedFields(w, command)
+	case "u":
+		selector, ok := dataset["o2"].(map[string]interface{})
+		if !ok {
+			return ChangeEvent{}, false
+		}
+		event.ID = selector["_id"]
+		event.Fields = changedFields(w, command)
+		if len(event.Fields) == 0 {
+			return ChangeEvent{}, false
+		}
+	case "d":
+		event.ID = command["_id"]
+	default:
+		return ChangeEvent{}, false
+	}
+
+	return event, true
+}
+
+//idString formats a document id, object ids are written as hex
+func idString(id interface{}) string {
+	if objectID, ok := id.(bson.ObjectId); ok {
+		return objectID.Hex()
+	}
+
+	return fmt.Sprint(id)
+}
@@ -0,0 +1,37 @@
+//Package sink has the extension points for sinks of redkeep agents. Its
+//types are aliases of the ones in package redkeep, so sinks written
+//against either package can be added to an agent.
+package sink
+
+import (
+	"github.com/manyminds/redkeep"
+	"github.com/manyminds/redkeep/oplog"
+)
+
+//Sink receives every change event an agent handled
+type Sink = redkeep.Sink
+
+//WatermarkSink is told up to which oplog time all changes were sent
+type WatermarkSink = redkeep.WatermarkSink
+
+//BatchSink receives the change events in batches
+type BatchSink = redkeep.BatchSink
+
+//Factory creates a sink from the options of its configuration
+type Factory = redkeep.SinkFactory
+
+//Config configures one sink
+type Config = redkeep.SinkConfig
+
+//Event is a change event sinks get
+type Event = oplog.ChangeEvent
+
+//Register makes a sink available under name for the configuration
+func Register(name string, factory Factory) {
+	redkeep.RegisterSinkType(name, factory)
+}
+
+//New creates a sink from the given configuration
+func New(c Config) (Sink, error) {
+	return redkeep.NewSink(c)
+}
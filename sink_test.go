@@ -0,0 +1,72 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type closingSink struct {
+	closed chan bool
+}
+
+func (s closingSink) Send(e ChangeEvent) error {
+	return nil
+}
+
+func (s closingSink) Close() error {
+	s.closed <- true
+	return nil
+}
+
+var _ = Describe("Sinks of the agent", func() {
+	It("will close created sinks if the agent cannot be created", func() {
+		closed := make(chan bool, 1)
+		RegisterSinkType("closing", func(options json.RawMessage) (Sink, error) {
+			return closingSink{closed}, nil
+		})
+		RegisterSinkType("failing", func(options json.RawMessage) (Sink, error) {
+			return nil, errors.New("not today")
+		})
+
+		_, err := NewTailAgent(Configuration{
+			Mongo: Mongo{ConnectionURI: "localhost:30000"},
+			Sinks: []SinkConfig{{Type: "closing"}, {Type: "failing"}},
+		})
+		Expect(err).To(MatchError("not today"))
+		Expect(closed).To(Receive())
+	})
+
+	It("will only send the events that match the filter of a sink", func() {
+		events := []ChangeEvent{
+			{Watch: "userComments", Operation: "u", Namespace: "app.user", ID: "1"},
+			{Watch: "userComments", Operation: "d", Namespace: "app.user", ID: "2"},
+			{Watch: "itemReviews", Operation: "d", Namespace: "shop.item", ID: "3"},
+		}
+
+		all := &outageSink{}
+		SendFiltered(all, nil, events...)
+		Expect(all.ids).To(Equal([]interface{}{"1", "2", "3"}))
+
+		deletes := &outageSink{}
+		SendFiltered(deletes, &EventFilter{Operations: []string{"d"}, Databases: []string{"app"}}, events...)
+		Expect(deletes.ids).To(Equal([]interface{}{"2"}))
+
+		reviews := &outageSink{}
+		SendFiltered(reviews, &EventFilter{Watches: []string{"itemReviews"}}, events...)
+		Expect(reviews.ids).To(Equal([]interface{}{"3"}))
+	})
+
+	It("will not accept unknown operations in sink filters", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"sinks": [
+			{ "type": "sql", "filter": { "operations": ["delete"] } }
+		], "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Unknown operation delete in event filter, use i, u or d"))
+	})
+})
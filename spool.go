@@ -0,0 +1,380 @@
+package redkeep
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultSpoolMaxSizeMB     = 100
+	defaultSpoolRetryInterval = 10 * time.Second
+	//spoolDrainEvents are sent at once while the spool is drained
+	spoolDrainEvents = 100
+)
+
+//SpoolSettings keep the events a sink failed to take in Directory, every
+//sink needs its own. They are sent again every RetryInterval (default
+//10s) in order, new events queue up behind them. At most MaxSizeMB
+//(default 100) are kept, further events are dropped and checkpoints stay
+//before the first dropped one. The spool survives restarts, its events are
+//sent after the agent started again.
+type SpoolSettings struct {
+	Directory     string   `json:"directory" validate:"required,min=1"`
+	MaxSizeMB     int      `json:"maxSizeMB" validate:"min=0"`
+	RetryInterval Duration `json:"retryInterval"`
+}
+
+func checkSpoolSettings(sinks []SinkConfig) error {
+	directories := map[string]bool{}
+	for _, s := range sinks {
+		if s.Spool == nil {
+			continue
+		}
+
+		directory := filepath.Clean(s.Spool.Directory)
+		if directories[directory] {
+			return fmt.Errorf("Sinks need their own spool directory, %s is used twice", directory)
+		}
+		directories[directory] = true
+	}
+
+	return nil
+}
+
+//spool is an append only file of bson documents, offset stores how
+//many bytes of it were sent. It is truncated once everything was sent.
+type spool struct {
+	events  *os.File
+	offset  int64
+	size    int64
+	maxSize int64
+	path    string
+}
+
+func openSpool(settings SpoolSettings) (*spool, error) {
+	if err := os.MkdirAll(settings.Directory, 0755); err != nil {
+		return nil, err
+	}
+
+	maxSize := settings.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = defaultSpoolMaxSizeMB
+	}
+
+	s := &spool{path: settings.Directory, maxSize: int64(maxSize) << 20}
+	events, err := os.OpenFile(filepath.Join(s.path, "events"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.events = events
+
+	if data, err := ioutil.ReadFile(filepath.Join(s.path, "offset")); err == nil {
+		s.offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	//a crash can leave a partly written event at the end
+	s.size, err = s.complete()
+	if err != nil {
+		events.Close()
+		return nil, err
+	}
+
+	if err := events.Truncate(s.size); err != nil {
+		events.Close()
+		return nil, err
+	}
+
+	if s.offset > s.size {
+		s.offset = s.size
+	}
+
+	return s, nil
+}
+
+//complete returns the size of the complete events of the file
+func (s *spool) complete() (int64, error) {
+	if _, err := s.events.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(s.events)
+	var size int64
+	for {
+		length, err := readSpoolLength(reader)
+		if err != nil {
+			return size, nil
+		}
+
+		if _, err := reader.Discard(int(length) - 4); err != nil {
+			return size, nil
+		}
+		size += length
+	}
+}
+
+func readSpoolLength(reader io.Reader) (int64, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, err
+	}
+
+	length := int64(binary.LittleEndian.Uint32(header[:]))
+	if length < 5 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return length, nil
+}
+
+func (s *spool) empty() bool {
+	return s.offset >= s.size
+}
+
+//append writes e behind the spooled events, false if the spool is full
+func (s *spool) append(e ChangeEvent) (bool, error) {
+	data, err := bson.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+
+	if s.size-s.offset+int64(len(data)) > s.maxSize {
+		return false, nil
+	}
+
+	if _, err := s.events.WriteAt(data, s.size); err != nil {
+		return false, err
+	}
+
+	if err := s.events.Sync(); err != nil {
+		return false, err
+	}
+
+	s.size += int64(len(data))
+	return true, nil
+}
+
+//read returns up to max spooled events between offset and size with
+//the bytes each takes. It only reads complete events with ReadAt, so
+//events can be appended while it reads.
+func (s *spool) read(offset, size int64, max int) ([]ChangeEvent, []int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(s.events, offset, size-offset))
+	var events []ChangeEvent
+	var lengths []int64
+	for offset < size && len(events) < max {
+		length, err := readSpoolLength(reader)
+		if err != nil {
+			return events, lengths, err
+		}
+
+		data := make([]byte, length)
+		binary.LittleEndian.PutUint32(data, uint32(length))
+		if _, err := io.ReadFull(reader, data[4:]); err != nil {
+			return events, lengths, err
+		}
+
+		var e ChangeEvent
+		if err := bson.Unmarshal(data, &e); err != nil {
+			return events, lengths, err
+		}
+
+		events = append(events, e)
+		lengths = append(lengths, length)
+		offset += length
+	}
+
+	return events, lengths, nil
+}
+
+//sent marks length more bytes as sent
+func (s *spool) sent(length int64) error {
+	if length == 0 {
+		return nil
+	}
+
+	s.offset += length
+	return s.saveOffset()
+}
+
+//reset truncates the spool once everything was sent
+func (s *spool) reset() error {
+	s.offset, s.size = 0, 0
+	if err := s.events.Truncate(0); err != nil {
+		return err
+	}
+
+	return s.saveOffset()
+}
+
+func (s *spool) saveOffset() error {
+	path := filepath.Join(s.path, "offset")
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.FormatInt(s.offset, 10)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+//spoolSink sends events to a sink and keeps them in a spool while the
+//sink fails. Watermarks are held back until the spool is drained.
+type spoolSink struct {
+	sync.Mutex
+	sink      Sink
+	spool     *spool
+	watermark bson.MongoTimestamp
+	quit      chan bool
+	done      chan bool
+}
+
+func newSpoolSink(sink Sink, settings SpoolSettings) (*spoolSink, error) {
+	spool, err := openSpool(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := settings.RetryInterval.Duration
+	if interval <= 0 {
+		interval = defaultSpoolRetryInterval
+	}
+
+	s := &spoolSink{sink: sink, spool: spool, quit: make(chan bool), done: make(chan bool)}
+	go s.retry(interval)
+	return s, nil
+}
+
+func (s *spoolSink) retry(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.drain()
+		}
+	}
+}
+
+//drain sends the spooled events in chunks of spoolDrainEvents, the
+//lock is only held between them so Send keeps spooling new events
+//while a slow sink gets the old ones. Once the spool is empty it is
+//truncated and the held watermark is sent.
+func (s *spoolSink) drain() {
+	for {
+		s.Lock()
+		if s.spool.empty() {
+			s.drained()
+			s.Unlock()
+			return
+		}
+		offset, size := s.spool.offset, s.spool.size
+		s.Unlock()
+
+		events, lengths, err := s.spool.read(offset, size, spoolDrainEvents)
+		var sent int64
+		for i := 0; err == nil && i < len(events); i++ {
+			if err = s.sink.Send(events[i]); err == nil {
+				sent += lengths[i]
+			}
+		}
+
+		s.Lock()
+		if saveErr := s.spool.sent(sent); err == nil {
+			err = saveErr
+		}
+		s.Unlock()
+
+		if err != nil {
+			logWarn("Spooled events not sent yet", errorFields(err))
+			return
+		}
+	}
+}
+
+//drained truncates the sent spool and sends the held watermark, s is locked
+func (s *spoolSink) drained() {
+	if s.spool.size == 0 {
+		return
+	}
+
+	if err := s.spool.reset(); err != nil {
+		logWarn("Spool could not be truncated", errorFields(err))
+		return
+	}
+
+	logInfo("Spooled events sent", nil)
+	if sink, ok := s.sink.(WatermarkSink); ok && s.watermark > 0 {
+		if err := sink.Watermark(s.watermark); err != nil {
+			logError("Sink could not handle watermark", errorFields(err))
+		}
+		s.watermark = 0
+	}
+}
+
+//Send passes e to the sink, if the sink fails or events are spooled
+//already, e is spooled. It fails only if e could not be spooled.
+func (s *spoolSink) Send(e ChangeEvent) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.spool.empty() {
+		err := s.sink.Send(e)
+		if err == nil || err == errSinkFull {
+			return err
+		}
+
+		logWarn("Sink failed, spooling events", errorFields(err))
+	}
+
+	ok, err := s.spool.append(e)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errSinkFull
+	}
+
+	return nil
+}
+
+//Watermark passes ts to the sink once all events before it were sent
+func (s *spoolSink) Watermark(ts bson.MongoTimestamp) error {
+	sink, ok := s.sink.(WatermarkSink)
+	if !ok {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if !s.spool.empty() {
+		s.watermark = ts
+		return nil
+	}
+
+	return sink.Watermark(ts)
+}
+
+//Close stops the retries and closes the sink, spooled
+//events are sent after the next start
+func (s *spoolSink) Close() error {
+	close(s.quit)
+	<-s.done
+
+	s.Lock()
+	defer s.Unlock()
+	s.spool.events.Close()
+	return s.sink.Close()
+}
@@ -0,0 +1,165 @@
+package redkeep_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//outageSink fails while down
+type outageSink struct {
+	sync.Mutex
+	down       bool
+	ids        []interface{}
+	watermarks []bson.MongoTimestamp
+}
+
+func (s *outageSink) Send(e ChangeEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.down {
+		return errors.New("Connection refused")
+	}
+
+	s.ids = append(s.ids, e.ID)
+	return nil
+}
+
+func (s *outageSink) Watermark(ts bson.MongoTimestamp) error {
+	s.watermarks = append(s.watermarks, ts)
+	return nil
+}
+
+func (s *outageSink) Close() error {
+	return nil
+}
+
+//gatedSink waits for its gate before it takes an event
+type gatedSink struct {
+	outageSink
+	gate chan bool
+}
+
+func (s *gatedSink) Send(e ChangeEvent) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+
+	return s.outageSink.Send(e)
+}
+
+var _ = Describe("Spool", func() {
+	var directory string
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "redkeep-spool")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	It("keeps events while the sink is down and sends them in order", func() {
+		sink := &outageSink{}
+		spooled, err := NewSpoolSink(sink, SpoolSettings{Directory: directory})
+		Expect(err).ToNot(HaveOccurred())
+		defer spooled.Close()
+
+		Expect(spooled.Send(ChangeEvent{ID: "1"})).To(Succeed())
+		sink.down = true
+		Expect(spooled.Send(ChangeEvent{ID: "2"})).To(Succeed())
+		sink.down = false
+		Expect(spooled.Send(ChangeEvent{ID: "3"})).To(Succeed())
+		Expect(spooled.Watermark(3)).To(Succeed())
+		Expect(sink.ids).To(Equal([]interface{}{"1"}))
+		Expect(sink.watermarks).To(BeEmpty())
+
+		spooled.Drain()
+		Expect(sink.ids).To(Equal([]interface{}{"1", "2", "3"}))
+		Expect(sink.watermarks).To(Equal([]bson.MongoTimestamp{3}))
+
+		Expect(spooled.Send(ChangeEvent{ID: "4"})).To(Succeed())
+		Expect(sink.ids).To(HaveLen(4))
+	})
+
+	It("keeps spooling events while a slow sink is drained", func() {
+		sink := &gatedSink{}
+		sink.down = true
+		spooled, err := NewSpoolSink(sink, SpoolSettings{Directory: directory})
+		Expect(err).ToNot(HaveOccurred())
+		defer spooled.Close()
+		Expect(spooled.Send(ChangeEvent{ID: "1"})).To(Succeed())
+
+		sink.down = false
+		sink.gate = make(chan bool)
+		drained := make(chan bool)
+		go func() {
+			spooled.Drain()
+			close(drained)
+		}()
+
+		sent := make(chan error)
+		go func() {
+			sent <- spooled.Send(ChangeEvent{ID: "2"})
+		}()
+		Eventually(sent).Should(Receive(BeNil()))
+
+		close(sink.gate)
+		Eventually(drained).Should(BeClosed())
+		Expect(sink.ids).To(Equal([]interface{}{"1", "2"}))
+	})
+
+	It("sends spooled events after a restart", func() {
+		sink := &outageSink{down: true}
+		spooled, err := NewSpoolSink(sink, SpoolSettings{Directory: directory})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spooled.Send(ChangeEvent{ID: "1"})).To(Succeed())
+		Expect(spooled.Send(ChangeEvent{ID: "2"})).To(Succeed())
+		Expect(spooled.Close()).To(Succeed())
+
+		//a crash while an event was written
+		events, err := os.OpenFile(filepath.Join(directory, "events"), os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		events.Write([]byte{200, 0, 0, 0, 1})
+		events.Close()
+
+		sink.down = false
+		spooled, err = NewSpoolSink(sink, SpoolSettings{Directory: directory})
+		Expect(err).ToNot(HaveOccurred())
+		defer spooled.Close()
+
+		spooled.Drain()
+		Expect(sink.ids).To(Equal([]interface{}{"1", "2"}))
+	})
+
+	It("drops events when the spool is full", func() {
+		sink := &outageSink{down: true}
+		spooled, err := NewSpoolSink(sink, SpoolSettings{Directory: directory, MaxSizeMB: 1})
+		Expect(err).ToNot(HaveOccurred())
+		defer spooled.Close()
+
+		large := ChangeEvent{ID: "1", Fields: map[string]interface{}{"bio": strings.Repeat("x", 600<<10)}}
+		Expect(spooled.Send(large)).To(Succeed())
+		Expect(spooled.Send(large)).To(MatchError("Sink buffer is full, event dropped"))
+	})
+
+	It("needs a spool directory per sink", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"sinks": [
+			{ "type": "sql", "spool": { "directory": "/var/spool/redkeep" } },
+			{ "type": "clickhouse", "spool": { "directory": "/var/spool/redkeep/" } }
+		], "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Sinks need their own spool directory, /var/spool/redkeep is used twice"))
+	})
+})
@@ -0,0 +1,265 @@
+package redkeep
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//SQLSinkSettings configures a sink that mirrors tracked fields into
+//relational tables, the changes between two watermarks are written in
+//one transaction. Driver must be registered with database/sql,
+//Dialect is either postgres or mysql and defaults to the driver name.
+type SQLSinkSettings struct {
+	Driver  string     `json:"driver" validate:"required,min=1"`
+	DSN     string     `json:"dsn" validate:"required,min=1"`
+	Dialect string     `json:"dialect"`
+	Tables  []SQLTable `json:"tables" validate:"required,gt=0,dive"`
+}
+
+//SQLTable maps one tracked collection to a table. Documents are
+//upserted by their _id into IDColumn (default id).
+//Columns maps tracked fields to column names, if it is empty all
+//tracked fields are mirrored with dots replaced by underscores.
+type SQLTable struct {
+	Collection string            `json:"collection" validate:"required,min=1"`
+	Table      string            `json:"table" validate:"required,min=1"`
+	IDColumn   string            `json:"idColumn"`
+	Columns    map[string]string `json:"columns"`
+}
+
+//sqlSink writes every batch in one transaction
+type sqlSink struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect string
+	tables  map[string]SQLTable
+}
+
+//sqlExecer is a database or a transaction
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func init() {
+	RegisterSinkType("sql", func(options json.RawMessage) (Sink, error) {
+		var settings SQLSinkSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewSQLSink(settings)
+	})
+}
+
+//NewSQLSink opens the database and creates the mirror sink
+func NewSQLSink(settings SQLSinkSettings) (Sink, error) {
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, err
+	}
+
+	dialect := settings.Dialect
+	if dialect == "" {
+		dialect = settings.Driver
+	}
+
+	if dialect != "postgres" && dialect != "mysql" {
+		return nil, fmt.Errorf("Unsupported sql dialect %s, use postgres or mysql", dialect)
+	}
+
+	db, err := sql.Open(settings.Driver, settings.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &sqlSink{db: db, dialect: dialect, tables: map[string]SQLTable{}}
+	for _, t := range settings.Tables {
+		if t.IDColumn == "" {
+			t.IDColumn = "id"
+		}
+		sink.tables[t.Collection] = t
+	}
+
+	return sink, nil
+}
+
+func (s *sqlSink) Send(e ChangeEvent) error {
+	return s.write(s.db, e)
+}
+
+func (s *sqlSink) Begin() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	s.tx = tx
+	return nil
+}
+
+func (s *sqlSink) Add(e ChangeEvent) error {
+	if s.tx == nil {
+		return errors.New("Batch was not begun")
+	}
+
+	return s.write(s.tx, e)
+}
+
+func (s *sqlSink) Commit() error {
+	if s.tx == nil {
+		return errors.New("Batch was not begun")
+	}
+
+	tx := s.tx
+	s.tx = nil
+	return tx.Commit()
+}
+
+func (s *sqlSink) Rollback() error {
+	if s.tx == nil {
+		return nil
+	}
+
+	tx := s.tx
+	s.tx = nil
+	return tx.Rollback()
+}
+
+//write mirrors e with db
+func (s *sqlSink) write(db sqlExecer, e ChangeEvent) error {
+	table, ok := s.tables[e.Namespace]
+	if !ok {
+		return nil
+	}
+
+	id := sqlValue(e.ID)
+	if id == nil {
+		return errors.New("Change without _id can not be mirrored")
+	}
+
+	if e.Operation == "d" {
+		_, err := db.Exec(s.deleteStatement(table), id)
+		return err
+	}
+
+	columns, values := s.columns(table, e.Fields)
+	_, err := db.Exec(s.upsertStatement(table, columns), append([]interface{}{id}, values...)...)
+	return err
+}
+
+//columns returns the sorted columns and their values for fields
+func (s *sqlSink) columns(table SQLTable, fields map[string]interface{}) ([]string, []interface{}) {
+	byColumn := map[string]interface{}{}
+	for field, value := range fields {
+		column := strings.Replace(field, ".", "_", -1)
+		if len(table.Columns) > 0 {
+			mapped, ok := table.Columns[field]
+			if !ok {
+				continue
+			}
+			column = mapped
+		}
+
+		byColumn[column] = sqlValue(value)
+	}
+
+	columns := []string{}
+	for c := range byColumn {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+
+	values := []interface{}{}
+	for _, c := range columns {
+		values = append(values, byColumn[c])
+	}
+
+	return columns, values
+}
+
+func (s *sqlSink) quote(identifier string) string {
+	if s.dialect == "mysql" {
+		return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+	}
+
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func (s *sqlSink) placeholder(i int) string {
+	if s.dialect == "mysql" {
+		return "?"
+	}
+
+	return fmt.Sprintf("$%d", i)
+}
+
+func (s *sqlSink) upsertStatement(table SQLTable, columns []string) string {
+	names := []string{s.quote(table.IDColumn)}
+	placeholders := []string{s.placeholder(1)}
+	updates := []string{}
+	for i, c := range columns {
+		names = append(names, s.quote(c))
+		placeholders = append(placeholders, s.placeholder(i+2))
+		if s.dialect == "mysql" {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", s.quote(c), s.quote(c)))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", s.quote(c), s.quote(c)))
+		}
+	}
+
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		s.quote(table.Table),
+		strings.Join(names, ", "),
+		strings.Join(placeholders, ", "),
+	)
+
+	switch {
+	case s.dialect == "mysql" && len(updates) == 0:
+		return strings.Replace(insert, "INSERT", "INSERT IGNORE", 1)
+	case s.dialect == "mysql":
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	case len(updates) == 0:
+		return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", insert, s.quote(table.IDColumn))
+	}
+
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", insert, s.quote(table.IDColumn), strings.Join(updates, ", "))
+}
+
+func (s *sqlSink) deleteStatement(table SQLTable) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s = %s", s.quote(table.Table), s.quote(table.IDColumn), s.placeholder(1))
+}
+
+func (s *sqlSink) Close() error {
+	return s.db.Close()
+}
+
+//sqlValue converts mongo values into something database/sql can store,
+//documents and arrays are stored as json
+func sqlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bson.ObjectId:
+		return v.Hex()
+	case string, bool, int, int32, int64, float64, []byte, time.Time:
+		return v
+	case bson.MongoTimestamp:
+		return int64(v)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
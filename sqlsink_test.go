@@ -0,0 +1,192 @@
+package redkeep_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+//recordingDriver remembers all executed statements
+type recordingDriver struct {
+	sync.Mutex
+	statements []string
+	arguments  [][]driver.Value
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return recordingConn{d}, nil
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return recordingStmt{c.driver, query}, nil
+}
+
+func (c recordingConn) Close() error {
+	return nil
+}
+
+func (c recordingConn) Begin() (driver.Tx, error) {
+	return recordingTx{c.driver}, nil
+}
+
+type recordingTx struct {
+	driver *recordingDriver
+}
+
+func (t recordingTx) Commit() error {
+	t.driver.record("COMMIT")
+	return nil
+}
+
+func (t recordingTx) Rollback() error {
+	t.driver.record("ROLLBACK")
+	return nil
+}
+
+type recordingStmt struct {
+	driver *recordingDriver
+	query  string
+}
+
+func (s recordingStmt) Close() error {
+	return nil
+}
+
+func (s recordingStmt) NumInput() int {
+	return -1
+}
+
+func (d *recordingDriver) record(statement string) {
+	d.Lock()
+	defer d.Unlock()
+	d.statements = append(d.statements, statement)
+}
+
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.Lock()
+	defer s.driver.Unlock()
+	s.driver.statements = append(s.driver.statements, s.query)
+	s.driver.arguments = append(s.driver.arguments, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+var recorder = &recordingDriver{}
+
+func init() {
+	sql.Register("redkeep-recorder", recorder)
+}
+
+var _ = Describe("SQL sink", func() {
+	id := bson.ObjectIdHex("56a65494b204ccd1edc0b055")
+
+	BeforeEach(func() {
+		recorder.statements = nil
+		recorder.arguments = nil
+	})
+
+	It("needs a known dialect", func() {
+		_, err := NewSQLSink(SQLSinkSettings{
+			Driver: "redkeep-recorder",
+			DSN:    "memory",
+			Tables: []SQLTable{{Collection: "live.user", Table: "users"}},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("upserts tracked fields for postgres", func() {
+		sink, err := NewSQLSink(SQLSinkSettings{
+			Driver:  "redkeep-recorder",
+			DSN:     "memory",
+			Dialect: "postgres",
+			Tables: []SQLTable{{
+				Collection: "live.user",
+				Table:      "users",
+				Columns:    map[string]string{"username": "user_name", "name.first": "first_name"},
+			}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sink.Close()
+
+		err = sink.Send(ChangeEvent{
+			Operation: "u",
+			Namespace: "live.user",
+			ID:        id,
+			Fields: map[string]interface{}{
+				"username":   "naan",
+				"name.first": "Naan",
+				"gender":     "male",
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(recorder.statements).To(Equal([]string{
+			`INSERT INTO "users" ("id", "first_name", "user_name") VALUES ($1, $2, $3) ` +
+				`ON CONFLICT ("id") DO UPDATE SET "first_name" = EXCLUDED."first_name", "user_name" = EXCLUDED."user_name"`,
+		}))
+		Expect(recorder.arguments[0]).To(Equal([]driver.Value{id.Hex(), "Naan", "naan"}))
+	})
+
+	It("deletes and ignores unknown collections for mysql", func() {
+		sink, err := NewSQLSink(SQLSinkSettings{
+			Driver:  "redkeep-recorder",
+			DSN:     "memory",
+			Dialect: "mysql",
+			Tables:  []SQLTable{{Collection: "live.user", Table: "users", IDColumn: "mongo_id"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sink.Close()
+
+		Expect(sink.Send(ChangeEvent{Operation: "i", Namespace: "live.item", ID: id})).To(Succeed())
+		Expect(sink.Send(ChangeEvent{
+			Operation: "i",
+			Namespace: "live.user",
+			ID:        id,
+			Fields:    map[string]interface{}{"name.first": "Naan"},
+		})).To(Succeed())
+		Expect(sink.Send(ChangeEvent{Operation: "d", Namespace: "live.user", ID: id})).To(Succeed())
+
+		Expect(recorder.statements).To(Equal([]string{
+			"INSERT INTO `users` (`mongo_id`, `name_first`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `name_first` = VALUES(`name_first`)",
+			"DELETE FROM `users` WHERE `mongo_id` = ?",
+		}))
+	})
+
+	It("writes batches in a transaction", func() {
+		sink, err := NewSQLSink(SQLSinkSettings{
+			Driver:  "redkeep-recorder",
+			DSN:     "memory",
+			Dialect: "postgres",
+			Tables:  []SQLTable{{Collection: "live.user", Table: "users"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sink.Close()
+
+		batch, ok := sink.(BatchSink)
+		Expect(ok).To(BeTrue())
+		Expect(batch.Add(ChangeEvent{Operation: "d", Namespace: "live.user", ID: id})).To(MatchError("Batch was not begun"))
+
+		Expect(batch.Begin()).To(Succeed())
+		Expect(batch.Add(ChangeEvent{Operation: "d", Namespace: "live.user", ID: id})).To(Succeed())
+		Expect(batch.Commit()).To(Succeed())
+		Expect(batch.Begin()).To(Succeed())
+		Expect(batch.Rollback()).To(Succeed())
+
+		Expect(recorder.statements).To(Equal([]string{`DELETE FROM "users" WHERE "id" = $1`, "COMMIT", "ROLLBACK"}))
+	})
+})
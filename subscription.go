@@ -0,0 +1,132 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const subscriptionBuffer = 64
+
+//EventFilter selects the change events of a subscription or a sink by
+//watch key, operation type (i, u, d), namespace and database, empty
+//lists select all
+type EventFilter struct {
+	Watches    []string `json:"watches"`
+	Operations []string `json:"operations"`
+	Namespaces []string `json:"namespaces"`
+	Databases  []string `json:"databases"`
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f EventFilter) matches(e ChangeEvent) bool {
+	database := strings.SplitN(e.Namespace, ".", 2)[0]
+	return matchesAny(f.Watches, e.Watch) && matchesAny(f.Operations, e.Operation) &&
+		matchesAny(f.Namespaces, e.Namespace) && matchesAny(f.Databases, database)
+}
+
+func checkEventFilter(f EventFilter) error {
+	for _, operation := range f.Operations {
+		if operation != "i" && operation != "u" && operation != "d" {
+			return fmt.Errorf("Unknown operation %s in event filter, use i, u or d", operation)
+		}
+	}
+
+	return nil
+}
+
+type subscription struct {
+	filter EventFilter
+	events chan ChangeEvent
+}
+
+//subscriptions is a sink that hands change events to subscribers
+//in the same process. Subscribers that are too slow miss events.
+type subscriptions struct {
+	sync.RWMutex
+	subscribers map[*subscription]bool
+	closed      bool
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{subscribers: map[*subscription]bool{}}
+}
+
+//Send hands the event to all subscribers whose filter matches it
+func (s *subscriptions) Send(e ChangeEvent) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	var err error
+	for subscriber := range s.subscribers {
+		if !subscriber.filter.matches(e) {
+			continue
+		}
+
+		select {
+		case subscriber.events <- e:
+		default:
+			err = errSinkFull
+		}
+	}
+
+	return err
+}
+
+//Close ends all subscriptions
+func (s *subscriptions) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	for subscriber := range s.subscribers {
+		close(subscriber.events)
+		delete(s.subscribers, subscriber)
+	}
+
+	return nil
+}
+
+func (s *subscriptions) subscribe(filter EventFilter) (<-chan ChangeEvent, func()) {
+	subscriber := &subscription{filter: filter, events: make(chan ChangeEvent, subscriptionBuffer)}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		close(subscriber.events)
+		return subscriber.events, func() {}
+	}
+	s.subscribers[subscriber] = true
+
+	cancel := func() {
+		s.Lock()
+		defer s.Unlock()
+		if s.subscribers[subscriber] {
+			delete(s.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+
+	return subscriber.events, cancel
+}
+
+//Subscribe returns the change events selected by filter as they are
+//handled, until cancel is called or the agent stopped; then the channel
+//is closed. The channel buffers 64 events, if the subscriber does not
+//keep up events are dropped and counted in dropped_events_total.
+func (t *TailAgent) Subscribe(filter EventFilter) (<-chan ChangeEvent, func()) {
+	return t.subscriptions.subscribe(filter)
+}
@@ -0,0 +1,56 @@
+package redkeep_test
+
+import (
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Subscriptions", func() {
+	insert := ChangeEvent{Watch: "userComments", Operation: "i", Namespace: "app.user", ID: "1"}
+	update := ChangeEvent{Watch: "userComments", Operation: "u", Namespace: "app.user", ID: "1"}
+
+	It("hands matching events to subscribers", func() {
+		sink, subscribe := NewSubscriptions()
+		updates, cancel := subscribe(EventFilter{Operations: []string{"u"}})
+		defer cancel()
+		all, cancelAll := subscribe(EventFilter{})
+		defer cancelAll()
+
+		Expect(sink.Send(insert)).To(Succeed())
+		Expect(sink.Send(update)).To(Succeed())
+
+		Expect(<-updates).To(Equal(update))
+		Expect(updates).ToNot(Receive())
+		Expect(<-all).To(Equal(insert))
+		Expect(<-all).To(Equal(update))
+	})
+
+	It("closes the channel on cancel and on close", func() {
+		sink, subscribe := NewSubscriptions()
+		events, cancel := subscribe(EventFilter{})
+		cancel()
+		cancel()
+		Expect(events).To(BeClosed())
+
+		events, _ = subscribe(EventFilter{})
+		Expect(sink.Close()).To(Succeed())
+		Expect(events).To(BeClosed())
+
+		events, _ = subscribe(EventFilter{})
+		Expect(events).To(BeClosed())
+	})
+
+	It("drops events of slow subscribers", func() {
+		sink, subscribe := NewSubscriptions()
+		_, cancel := subscribe(EventFilter{})
+		defer cancel()
+
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = sink.Send(update)
+		}
+		Expect(err).To(HaveOccurred())
+	})
+})
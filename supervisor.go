@@ -0,0 +1,164 @@
+package redkeep
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Supervisor runs several agents, for example for different clusters,
+//in one process. It serves one admin server where the endpoints of every
+//agent are available below /agents/<name>/ and /status aggregates all.
+//If one agent stops, all others are stopped as well.
+type Supervisor struct {
+	sync.RWMutex
+	agents map[string]supervisedAgent
+	admin  *adminServer
+}
+
+//supervisedAgent is implemented by TailAgent
+type supervisedAgent interface {
+	Tail(quit chan bool, forceRescan bool) error
+	Status() AgentStatus
+	handlers() map[string]http.Handler
+}
+
+//SupervisorStatus is the status of all agents of a supervisor.
+//Metrics are summed up, except for lag and latencies where the maximum is used.
+type SupervisorStatus struct {
+	Agents  map[string]AgentStatus `json:"agents"`
+	Metrics map[string]float64     `json:"metrics"`
+}
+
+//NewSupervisor creates a supervisor, the admin server is
+//disabled if admin.Listen is empty
+func NewSupervisor(admin AdminSettings) *Supervisor {
+	s := &Supervisor{agents: map[string]supervisedAgent{}}
+	if admin.Listen != "" {
+		s.admin = newAdminServer(admin)
+		s.admin.handle("/status", http.HandlerFunc(s.serveStatus))
+		s.admin.handle("/debug/goroutines", http.HandlerFunc(serveGoroutines))
+	}
+
+	return s
+}
+
+//Add adds an agent under name, agents must be added before Run.
+//The agents should not start their own admin server.
+func (s *Supervisor) Add(name string, agent *TailAgent) error {
+	return s.add(name, agent)
+}
+
+func (s *Supervisor) add(name string, agent supervisedAgent) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid agent name %q", name)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.agents[name]; ok {
+		return fmt.Errorf("Agent %s was already added", name)
+	}
+
+	s.agents[name] = agent
+	if s.admin != nil {
+		prefix := "/agents/" + name
+		for pattern, handler := range agent.handlers() {
+			s.admin.handle(prefix+pattern, http.StripPrefix(prefix, handler))
+		}
+	}
+
+	return nil
+}
+
+//Status returns the status of all agents
+func (s *Supervisor) Status() SupervisorStatus {
+	s.RLock()
+	defer s.RUnlock()
+
+	status := SupervisorStatus{Agents: map[string]AgentStatus{}, Metrics: map[string]float64{}}
+	for name, agent := range s.agents {
+		agentStatus := agent.Status()
+		status.Agents[name] = agentStatus
+		for metric, value := range agentStatus.Metrics {
+			if metric == MetricLagSeconds || strings.HasPrefix(metric, MetricWriteLatency+"{") {
+				if value > status.Metrics[metric] {
+					status.Metrics[metric] = value
+				}
+				continue
+			}
+
+			status.Metrics[metric] += value
+		}
+	}
+
+	return status
+}
+
+func (s *Supervisor) serveStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+//Run tails with all agents until quit receives a value or one agent
+//stops, then all agents are stopped. The first error of an agent
+//is returned.
+func (s *Supervisor) Run(quit chan bool, forceRescan bool) error {
+	s.RLock()
+	names := []string{}
+	for name := range s.agents {
+		names = append(names, name)
+	}
+	s.RUnlock()
+	sort.Strings(names)
+
+	if s.admin != nil {
+		if err := s.admin.start(); err != nil {
+			return err
+		}
+		defer s.admin.stop()
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+
+	results := make(chan result, len(names))
+	stops := map[string]chan bool{}
+	for _, name := range names {
+		stop := make(chan bool)
+		stops[name] = stop
+		go func(name string, agent supervisedAgent) {
+			results <- result{name, agent.Tail(stop, forceRescan)}
+		}(name, s.agents[name])
+	}
+
+	var firstErr error
+	running := len(names)
+	select {
+	case <-quit:
+	case r := <-results:
+		running--
+		logWarn("Agent stopped, stopping all agents", Fields{"agent": r.name})
+		firstErr = r.err
+		delete(stops, r.name)
+	}
+
+	for _, stop := range stops {
+		close(stop)
+	}
+
+	for ; running > 0; running-- {
+		r := <-results
+		if r.err != nil {
+			logError("Agent failed", Fields{"agent": r.name}.withError(r.err))
+			if firstErr == nil {
+				firstErr = r.err
+			}
+		}
+	}
+
+	return firstErr
+}
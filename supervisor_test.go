@@ -0,0 +1,77 @@
+package redkeep_test
+
+import (
+	"errors"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Supervisor", func() {
+	It("needs unique agent names", func() {
+		supervisor := NewSupervisor(AdminSettings{})
+		Expect(supervisor.Add("eu", &TailAgent{})).To(Succeed())
+		Expect(supervisor.Add("eu", &TailAgent{})).ToNot(Succeed())
+		Expect(supervisor.Add("eu/west", &TailAgent{})).ToNot(Succeed())
+		Expect(supervisor.Status().Agents).To(HaveKey("eu"))
+	})
+
+	It("stops when asked to without agents", func() {
+		supervisor := NewSupervisor(AdminSettings{})
+		quit := make(chan bool)
+		done := make(chan error)
+		go func() {
+			done <- supervisor.Run(quit, false)
+		}()
+
+		quit <- true
+		Expect(<-done).ToNot(HaveOccurred())
+	})
+
+	It("stops all agents when asked to", func() {
+		supervisor := NewSupervisor(AdminSettings{})
+		stopped := make(chan string, 2)
+		for _, name := range []string{"eu", "us"} {
+			name := name
+			Expect(supervisor.AddFakeAgent(name, func(quit chan bool) error {
+				<-quit
+				stopped <- name
+				return nil
+			})).To(Succeed())
+		}
+
+		quit := make(chan bool)
+		done := make(chan error)
+		go func() {
+			done <- supervisor.Run(quit, false)
+		}()
+
+		quit <- true
+		Expect(<-done).ToNot(HaveOccurred())
+		Expect([]string{<-stopped, <-stopped}).To(ConsistOf("eu", "us"))
+	})
+
+	It("stops the other agents if one fails", func() {
+		supervisor := NewSupervisor(AdminSettings{})
+		stopped := make(chan bool, 1)
+		Expect(supervisor.AddFakeAgent("healthy", func(quit chan bool) error {
+			<-quit
+			stopped <- true
+			return nil
+		})).To(Succeed())
+		Expect(supervisor.AddFakeAgent("broken", func(quit chan bool) error {
+			return errors.New("cursor died")
+		})).To(Succeed())
+
+		Expect(supervisor.Run(make(chan bool), false)).To(MatchError("cursor died"))
+		Expect(stopped).To(Receive())
+	})
+
+	It("reports agents that are not connected", func() {
+		supervisor := NewSupervisor(AdminSettings{})
+		Expect(supervisor.Add("eu", &TailAgent{})).To(Succeed())
+		Expect(supervisor.Run(make(chan bool), false)).To(MatchError("Agent is not connected"))
+	})
+})
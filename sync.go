@@ -0,0 +1,87 @@
+package redkeep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//SyncOnce writes the current tracked fields of the documents with ids
+//of the tracked collection of the watch with key to their targets. It
+//returns when all of them are written, with the first failed write or
+//when ctx is done. Ids that do not exist are reported after the other
+//documents were written.
+func (t *TailAgent) SyncOnce(ctx context.Context, key string, ids []interface{}) error {
+	if t.session == nil {
+		return errors.New("Agent is not connected")
+	}
+
+	var watch *Watch
+	for _, w := range t.watches.list() {
+		if w.Key() == key {
+			w := w
+			watch = &w
+		}
+	}
+
+	if watch == nil {
+		return fmt.Errorf("No watch %s configured", key)
+	}
+
+	session := t.session.Copy()
+	defer session.Close()
+
+	p := strings.Index(watch.TrackCollection, ".")
+	collection := session.DB(watch.TrackCollection[:p]).C(watch.TrackCollection[p+1:])
+	iter := collection.Find(bson.M{"_id": bson.M{"$in": ids}}).Iter()
+
+	found := map[string]bool{}
+	document := map[string]interface{}{}
+	for iter.Next(&document) {
+		if err := ctx.Err(); err != nil {
+			iter.Close()
+			return err
+		}
+
+		if err := t.syncDocument(*watch, document); err != nil {
+			iter.Close()
+			return err
+		}
+
+		found[fmt.Sprint(document["_id"])] = true
+		document = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	missing := []string{}
+	for _, id := range ids {
+		if !found[fmt.Sprint(id)] {
+			missing = append(missing, fmt.Sprint(id))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Documents %s of %s not found", strings.Join(missing, ", "), watch.TrackCollection)
+	}
+
+	return nil
+}
+
+//syncDocument writes the tracked fields of document like an update
+//that sets all of them
+func (t *TailAgent) syncDocument(w Watch, document map[string]interface{}) error {
+	command := backfillCommand(w, document)
+	selector := map[string]interface{}{"_id": document["_id"]}
+	if tracker, ok := t.tracker.(*changeTracker); ok {
+		return tracker.update(w, command, selector, 0)
+	}
+
+	t.tracker.HandleUpdate(w, command, selector)
+	return nil
+}
@@ -0,0 +1,52 @@
+package redkeep_test
+
+import (
+	"context"
+
+	"gopkg.in/mgo.v2"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type updateRecorder struct {
+	commands  []map[string]interface{}
+	selectors []map[string]interface{}
+}
+
+func (r *updateRecorder) HandleRemove(w Watch, command map[string]interface{}, selector map[string]interface{}) {
+}
+
+func (r *updateRecorder) HandleInsert(w Watch, command map[string]interface{}, originRef mgo.DBRef) {
+}
+
+func (r *updateRecorder) HandleUpdate(w Watch, command map[string]interface{}, selector map[string]interface{}) {
+	r.commands = append(r.commands, command)
+	r.selectors = append(r.selectors, selector)
+}
+
+var _ = Describe("SyncOnce", func() {
+	It("needs a connected agent", func() {
+		agent := &TailAgent{}
+		Expect(agent.SyncOnce(context.Background(), "userComments", []interface{}{"1"})).To(MatchError("Agent is not connected"))
+	})
+
+	It("writes the tracked fields of a document as update", func() {
+		recorder := &updateRecorder{}
+		w := Watch{TrackFields: []string{"name", "address.city"}}
+		document := map[string]interface{}{
+			"_id":     "1",
+			"name":    "Hans",
+			"age":     42,
+			"address": map[string]interface{}{"city": "Berlin"},
+		}
+
+		Expect(SyncDocument(recorder, w, document)).To(Succeed())
+		Expect(recorder.commands).To(Equal([]map[string]interface{}{
+			{"$set": map[string]interface{}{"name": "Hans", "address.city": "Berlin"}},
+		}))
+		Expect(recorder.selectors).To(Equal([]map[string]interface{}{{"_id": "1"}}))
+	})
+})
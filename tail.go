@@ -2,11 +2,13 @@ package redkeep
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -17,10 +19,48 @@ const requeryDuration = 1 * time.Second
 
 //TailAgent the worker that tails the database
 type TailAgent struct {
-	config    Configuration
-	session   *mgo.Session
-	tracker   Tracker
-	startTime time.Time
+	config     Configuration
+	watches    *watchSet
+	session    *mgo.Session
+	router     *mgo.Session
+	metadata   *mgo.Session
+	tracker    Tracker
+	hooks      *hookRegistry
+	transforms *watchTransforms
+	tenants    *tenantLimiter
+	sinks      *sinkDispatcher
+	admin      *adminServer
+	startTime  time.Time
+	//resumeAfter is the checkpoint the agent resumed from, the
+	//oplog is read after it instead of after the second of startTime
+	resumeAfter bson.MongoTimestamp
+
+	metricsServer *metricsServer
+
+	metrics       *metricRegistry
+	events        *eventLog
+	notifications *notificationCenter
+	lag           *lagHistory
+	graphql       *GraphQLBridge
+	chaos         *faultInjector
+	unknown       *operationTelemetry
+	indexBuilds   *indexBuilds
+	watermarks    *watermarks
+	checkpoint    *checkpoint
+	//watchCheckpoints are the positions of the watches, catchUps their running catch ups
+	watchCheckpoints *watchCheckpoints
+	catchUps         *catchUps
+	subscriptions    *subscriptions
+	features         *featureFlags
+	latencies        *latencyRecorder
+	references       *referenceCache
+	hotKeys          *hotKeys
+	dryRun           *dryRunReport
+	caches           *cacheInvalidation
+	lineage          *lineageEmitter
+	pause            *pauseSwitch
+	rescans          *watchRescans
+	created          time.Time
 }
 
 //Query represents a mongodb oplog query
@@ -83,23 +123,25 @@ func (m mongoTimestamp) MongoTimestamp() bson.MongoTimestamp {
 	return bson.MongoTimestamp(result)
 }
 
-func analyzeResult(dataset map[string]interface{}, w []Watch, s *mgo.Session) {
+func analyzeResult(dataset map[string]interface{}, w []Watch, t Tracker, sinks *sinkDispatcher, unknown *operationTelemetry, latencies *latencyRecorder) {
 	query, err := NewOplogQuery(dataset)
 	if err != nil {
-		log.Println(err)
+		logWarn("Oplog entry not analyzed", errorFields(err))
 		return
 	}
 
-	session := s.Copy()
-	defer session.Close()
-
-	t := NewChangeTracker(session)
 	watches := w
 	triggerDB := query.DB()
 	triggerCollection := query.C()
 	operationType := query.OP()
 	namespace := fmt.Sprintf("%s.%s", triggerDB, triggerCollection)
 
+	if !knownOperations[operationType] {
+		unknown.record(namespace, operationType, dataset)
+		return
+	}
+
+	generation, _ := dataset["ts"].(bson.MongoTimestamp)
 	if command, ok := dataset["o"].(map[string]interface{}); ok {
 		triggerID, _ := command["_id"].(bson.ObjectId)
 		triggerRef := mgo.DBRef{
@@ -112,7 +154,7 @@ func analyzeResult(dataset map[string]interface{}, w []Watch, s *mgo.Session) {
 			switch operationType {
 			case "i":
 				if w.TargetCollection == namespace {
-					t.HandleInsert(w, command, triggerRef)
+					handleInsert(t, w, command, triggerRef, generation)
 				}
 			case "u":
 				if w.TargetCollection == namespace {
@@ -122,30 +164,52 @@ func analyzeResult(dataset map[string]interface{}, w []Watch, s *mgo.Session) {
 						Id:         dataset["o2"].(map[string]interface{})["_id"].(bson.ObjectId),
 					}
 
-					t.HandleInsert(w, command, triggerRef)
+					handleInsert(t, w, command, triggerRef, generation)
+
+					policy := w.BehaviourSettings.ConflictPolicy
+					if conflicts, ok := t.(ConflictTracker); ok && policy != "" && writesNormalizedField(w, command) {
+						conflicts.HandleConflict(w, command, dataset["o2"].(map[string]interface{}), eventTime(dataset))
+					}
 				}
 
 				if w.TrackCollection == namespace {
 					if selector, ok := dataset["o2"].(map[string]interface{}); ok {
-						t.HandleUpdate(w, command, selector)
+						if conflicts, ok := t.(ConflictTracker); ok && w.BehaviourSettings.ConflictPolicy == ConflictMergeByTimestamp {
+							conflicts.TrackedChange(w, selector, eventTime(dataset))
+						}
+						handleUpdate(t, w, command, selector, generation)
 					}
 				}
 			case "d":
 				if w.TrackCollection == namespace {
-					if selector, ok := dataset["o2"].(map[string]interface{}); ok {
-						t.HandleRemove(w, command, selector)
-					}
+					//the entry of a delete only has the id of the document
+					t.HandleRemove(w, command, command)
+				}
+			case "c", "n":
+				//system commands and no-ops. We do not care.
+			}
+
+			if operationType != "c" && operationType != "n" &&
+				(w.TrackCollection == namespace || w.TargetCollection == namespace) {
+				latencies.observe(w, dataset)
+			}
+
+			if w.TrackCollection == namespace {
+				if event, ok := newChangeEvent(w, operationType, dataset); ok {
+					sinks.send(event)
 				}
-			case "c":
-				//system commands. We do not care.
-			default:
-				log.Printf("unsupported operation %s.\n", operationType)
-				return
 			}
 		}
 	}
 }
 
+//fromMigration is true for entries of chunk migrations between shards,
+//the documents only moved and did not change
+func fromMigration(entry map[string]interface{}) bool {
+	migrated, _ := entry["fromMigrate"].(bool)
+	return migrated
+}
+
 //getReference tries to create a reference from target
 //returns true if valid, false otherwise
 func getReference(target interface{}, originalDatabase string) (mgo.DBRef, bool) {
@@ -162,31 +226,153 @@ func getReference(target interface{}, originalDatabase string) (mgo.DBRef, bool)
 	return mgo.DBRef{Collection: col, Id: id, Database: db}, okID && okRef
 }
 
+//TailOptions change how TailContext starts
+type TailOptions struct {
+	//ForceRescan (Default false) will update anything from the lowest oplog timestamp
+	//again. Can cause many redundant writes depending on your oplog size.
+	ForceRescan bool
+	//Backfill (Default false) writes the tracked fields of every document
+	//to the targets before tailing, unlike a rescan it does not depend on
+	//what is left in the oplog. Tailing starts at the newest oplog entry
+	//before the backfill.
+	Backfill bool
+}
+
 //Tail will start an inifite look that tails the oplog
-//as long as the channel does not get any input
+//as long as the channel does not get any input, then it drains like
+//TailContext before it returns
 //forceRescan (Default false) will update anything from the lowest oplog timestamp
 //again. Can cause many redundant writes depending on your oplog size.
 func (t TailAgent) Tail(quit chan bool, forceRescan bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := t.TailContext(ctx, TailOptions{ForceRescan: forceRescan})
+	if err == context.Canceled {
+		return nil
+	}
+
+	return err
+}
+
+//TailContext tails the oplog until ctx is done, then the entries that
+//were already read are handled before it returns the error of ctx.
+//The cursor is closed right away, no entry is read after ctx is done.
+//The workers get ShutdownTimeout to finish their writes, then batches
+//are committed and the last checkpoint is stored, see components.
+func (t TailAgent) TailContext(ctx context.Context, opts TailOptions) (err error) {
+	if t.session == nil {
+		return errors.New("Agent is not connected")
+	}
+
+	if opts.ForceRescan && t.config.Source == SourceChangeStream {
+		return errors.New("Rescan needs the oplog source")
+	}
+
+	if opts.ForceRescan && opts.Backfill {
+		return errors.New("Rescan and backfill can not be combined")
+	}
+
 	session := t.session.Copy()
 	defer session.Close()
 
+	//change streams fail on their own if they can not resume
+	if !opts.ForceRescan && !opts.Backfill && t.config.Source != SourceChangeStream {
+		backfill, err := t.checkOplogGap(session.DB("local").C("oplog.rs"), t.startTimestamp())
+		if err != nil {
+			return err
+		}
+		opts.Backfill = backfill
+	}
+
+	workers := &sync.WaitGroup{}
+	components := t.components(workers)
+	if err := components.start(); err != nil {
+		return err
+	}
+	defer components.stop()
+	t.updateSchemaManifest()
+	t.lineage.startRuns(t.watches.list())
+	defer func() {
+		if err == ctx.Err() {
+			t.lineage.endRuns(nil)
+			return
+		}
+		t.lineage.endRuns(err)
+	}()
+
+	pool := newWorkerPool(t.config.Workers, t.tracker, workers, t.metrics)
+	defer pool.close()
+	t.rescans.usePool(pool)
+	defer t.rescans.usePool(nil)
+
+	if opts.Backfill {
+		handoff, err := t.backfillAll(ctx, session)
+		if err != nil {
+			return err
+		}
+
+		//the snapshot of the backfill has the changes up to the handoff
+		t.startTime = time.Unix(int64(handoff>>32), 0)
+		t.resumeAfter = handoff
+	}
+
+	if t.config.Source == SourceChangeStream {
+		//change streams start at the operation time they get
+		return t.tailChangeStream(ctx, session, t.startTimestamp()+1, workers, pool)
+	}
+
 	oplogCollection := session.DB("local").C("oplog.rs")
 
-	startTime := mongoTimestamp{t.startTime}
-	if forceRescan {
-		startTime = mongoTimestamp{time.Unix(0, 0)}
+	liveStart := t.startTimestamp()
+	if opts.ForceRescan {
+		liveStart = mongoTimestamp{time.Unix(0, 0)}.MongoTimestamp()
+	}
+
+	var backlog *catchUp
+	if t.config.CatchUp == CatchUpNewestFirst {
+		newest, err := newestOplogEntry(oplogCollection)
+		if err != nil {
+			logWarn("Catch up in order, newest oplog entry not found", errorFields(err))
+		} else if newest > liveStart {
+			backlog = newCatchUp()
+			//commands wait for the workers, not for the whole backlog
+			catchingUp := &sync.WaitGroup{}
+			defer catchingUp.Wait()
+			stop := make(chan bool)
+			defer close(stop)
+			catchingUp.Add(1)
+			go func(from bson.MongoTimestamp) {
+				defer catchingUp.Done()
+				t.catchUpBacklog(session.Copy(), from, newest, backlog, stop)
+			}(liveStart)
+			liveStart = newest
+		}
 	}
 
-	query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": startTime.MongoTimestamp()}})
+	query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": liveStart}})
 	iter := query.LogReplay().Sort("$natural").Tail(requeryDuration)
 
-	sessionCopy := session.Copy()
-	var lastTimestamp bson.MongoTimestamp
+	t.events.record(EventLifecycle, "", "Tailing the oplog")
+
+	//everything up to the start was handled, shards without changes are not held back
+	t.watermarks.advance(liveStart)
+	lastTimestamp := liveStart
 	for {
 		select {
-		case <-quit:
-			log.Println("Agent stopped.")
-			return nil
+		case <-ctx.Done():
+			iter.Close()
+			t.events.record(EventLifecycle, "", "Agent stopped")
+			logInfo("Agent stopped, draining handled entries", nil)
+			return ctx.Err()
 		default:
 		}
 
@@ -202,28 +388,217 @@ func (t TailAgent) Tail(quit chan bool, forceRescan bool) error {
 				copyResult[k] = v
 			}
 
-			go analyzeResult(copyResult, t.config.Watches[:], sessionCopy)
+			//an entry is only left unacknowledged once ctx is done
+			if err := t.dispatch(ctx, copyResult, workers, pool, backlog); err != nil {
+				break
+			}
+
+			if t.chaos.killCursor() {
+				iter.Close()
+				t.events.record(EventError, "", "Oplog cursor killed by fault injection")
+				break
+			}
+
+			if t.pause.paused() || ctx.Err() != nil {
+				break
+			}
+		}
+
+		//a busy oplog never ends the loop above on its own
+		if ctx.Err() != nil {
+			continue
+		}
+
+		//the cursor is closed while paused, it would time out
+		if t.pause.paused() {
+			iter.Close()
+			t.pause.wait(ctx)
+			if ctx.Err() != nil {
+				continue
+			}
+
+			query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": lastTimestamp}})
+			iter = query.LogReplay().Sort("$natural").Tail(requeryDuration)
+			continue
 		}
 
 		if iter.Err() != nil {
+			t.events.record(EventError, "", "Oplog cursor failed: "+iter.Err().Error())
 			return iter.Close()
 		}
 
 		if iter.Timeout() {
+			t.metrics.set(MetricLagSeconds, 0)
+			t.watermarks.advance(lastTimestamp)
 			continue
 		}
 
+		t.events.record(EventReconnect, "", fmt.Sprintf("Oplog cursor reopened after %d", lastTimestamp))
 		query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": lastTimestamp}})
 		iter = query.LogReplay().Sort("$natural").Tail(requeryDuration)
 	}
+}
+
+//dispatch hands a live oplog entry to the workers, commands
+//are handled right away. Entries of one partition, by default
+//one document, are handled one after another in oplog order.
+//With strict ordering it returns the error of ctx if the entry
+//was not acknowledged before ctx was done.
+func (t TailAgent) dispatch(ctx context.Context, entry map[string]interface{}, workers *sync.WaitGroup, pool *workerPool, backlog *catchUp) error {
+	ts := entry["ts"].(bson.MongoTimestamp)
+	t.metrics.set(MetricLagSeconds, time.Since(time.Unix(int64(ts>>32), 0)).Seconds())
+
+	if entry["op"] == "c" {
+		t.references.clear()
+		t.handleCommand(entry, workers)
+	}
+
+	if fromMigration(entry) {
+		t.metrics.add(MetricMigrationEntries, 1)
+		return nil
+	}
+
+	backlog.handledLive(entry)
+	t.catchUps.handledLive(entry)
+	if entry["op"] == "u" || entry["op"] == "d" {
+		t.references.invalidate(documentKey(entry))
+	}
+	t.metrics.add(labeled(MetricOperations, "op", fmt.Sprint(entry["op"])), 1)
+	t.watermarks.begin(ts)
+	if t.config.Ordering == OrderingStrict {
+		return t.handleStrict(ctx, entry, ts)
+	}
+
+	pool.submitPlaced(documentKey(entry), partitionKey(t.config.Workers.Partition, t.watches, entry), func(tracker Tracker) {
+		defer t.watermarks.end(ts)
+		started := time.Now()
+		analyzeResult(entry, t.watches.active(), tracker, t.sinks, t.unknown, t.latencies)
+		t.metrics.observe(MetricHandlerDuration, time.Since(started).Seconds())
+	})
+	return nil
+}
+
+//startTimestamp is the oplog position the agent continues after, the
+//checkpoint it resumed from or the start of the second of startTime
+func (t TailAgent) startTimestamp() bson.MongoTimestamp {
+	start := mongoTimestamp{t.startTime}.MongoTimestamp()
+	if t.resumeAfter > start {
+		return t.resumeAfter
+	}
+
+	return start
+}
+
+//documentKey identifies the document an oplog entry changes,
+//it is empty for entries without document like commands
+func documentKey(entry map[string]interface{}) string {
+	var id interface{}
+	switch entry["op"] {
+	case "i", "d":
+		command, _ := entry["o"].(map[string]interface{})
+		id = command["_id"]
+	case "u":
+		selector, _ := entry["o2"].(map[string]interface{})
+		id = selector["_id"]
+	}
+
+	if id == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v/%s", entry["ns"], idString(id))
+}
+
+//components returns the subsystems of the agent, Tail stops them after it
+//stopped reading the oplog. They are stopped in reverse order: the admin
+//server and observers first, then the handling of already read oplog
+//entries is awaited, a last watermark is sent and sinks are closed last. If the entries are not
+//handled within the timeout the sinks are left open.
+func (t TailAgent) components(workers *sync.WaitGroup) *lifecycle {
+	timeout := t.config.ShutdownTimeout.Duration
+	l := &lifecycle{}
+	l.add(component{name: "sinks", stop: t.sinks.close, timeout: timeout})
+	//writers are stopped before the components they write to
+	writersDependOn := []string{"sinks"}
+	if t.dryRun != nil {
+		l.add(component{name: "dryRun", start: t.dryRun.start, stop: t.dryRun.stop, timeout: timeout})
+		writersDependOn = append(writersDependOn, "dryRun")
+	}
+	if t.caches != nil {
+		l.add(component{name: "cacheInvalidation", stop: t.caches.stop, timeout: timeout})
+		writersDependOn = append(writersDependOn, "cacheInvalidation")
+	}
+	workersDependOn := append([]string{}, writersDependOn...)
+	if t.watermarks != nil {
+		l.add(component{name: "watermarks", dependsOn: []string{"sinks"}, start: t.watermarks.start, stop: t.watermarks.stop, timeout: timeout})
+		workersDependOn = append(workersDependOn, "watermarks")
+	}
+	if t.references != nil {
+		//primed before the oplog is read, lookup counts are stored after the workers stopped
+		l.add(component{name: "referenceCache", start: t.references.start, stop: t.references.stop, timeout: timeout})
+		workersDependOn = append(workersDependOn, "referenceCache")
+	}
+	//rescans write to the targets like the workers and are canceled before the sinks close
+	l.add(component{name: "rescans", dependsOn: writersDependOn, start: t.rescans.start, stop: t.rescans.stop, timeout: timeout})
+	if t.hotKeys != nil {
+		//updates of hot keys that wait are written after the workers
+		//stopped, they hold the watermarks until then
+		hotKeysDependOn := writersDependOn
+		if t.watermarks != nil {
+			hotKeysDependOn = append(append([]string{}, writersDependOn...), "watermarks")
+		}
+		start := func() error {
+			return t.hotKeys.start(t.watermarks)
+		}
+		l.add(component{name: "hotKeys", dependsOn: hotKeysDependOn, start: start, stop: t.hotKeys.stop, timeout: timeout})
+		workersDependOn = append(workersDependOn, "hotKeys")
+	}
+	if t.lineage != nil {
+		//the runs end before the components stop, their events are still sent
+		l.add(component{name: "lineage", start: t.lineage.start, stop: t.lineage.stop, timeout: timeout})
+	}
+	l.add(component{name: "workers", dependsOn: workersDependOn, stop: func() {
+		started := time.Now()
+		workers.Wait()
+		logInfo("Handled entries drained", Fields{"duration": time.Since(started)})
+	}, timeout: timeout})
+	l.add(component{
+		name:      "lagHistory",
+		dependsOn: []string{"workers"},
+		start: func() error {
+			t.lag.start()
+			return nil
+		},
+		stop:    t.lag.stop,
+		timeout: timeout,
+	})
+
+	if t.notifications != nil {
+		l.add(component{
+			name:      "notifications",
+			dependsOn: []string{"workers"},
+			start: func() error {
+				t.notifications.start()
+				return nil
+			},
+			stop:    t.notifications.stop,
+			timeout: timeout,
+		})
+	}
 
-	iter.Close()
+	if t.admin != nil {
+		l.add(component{name: "admin", dependsOn: []string{"workers"}, start: t.admin.start, stop: t.admin.stop, timeout: timeout})
+	}
+
+	if t.metricsServer != nil {
+		l.add(component{name: "metrics", dependsOn: []string{"workers"}, start: t.metricsServer.start, stop: t.metricsServer.stop, timeout: timeout})
+	}
 
-	return errors.New("Tailable cursor ended unexpectedly")
+	return l
 }
 
 func (t *TailAgent) connect() error {
-	log.Println("Connecting to", t.config.Mongo.ConnectionURI)
+	logInfo("Connecting", Fields{"uri": redactURI(t.config.Mongo.ConnectionURI)})
 	session, err := mgo.Dial(t.config.Mongo.ConnectionURI)
 
 	if err != nil {
@@ -232,16 +607,266 @@ func (t *TailAgent) connect() error {
 
 	session.SetMode(mgo.Strong, true)
 	t.session = session
-	t.tracker = NewChangeTracker(t.session)
+	t.router, err = dialRouter(t.config.Mongo, t.session)
+	if err != nil {
+		return err
+	}
+
+	t.metadata, err = DialMetadata(t.config, t.session)
+	if err != nil {
+		return err
+	}
+
+	t.references = newReferenceCache(t.config.ReferenceCache, t.metrics, t.session, t.metadata)
+	t.hotKeys = newHotKeys(t.config.HotKeys, t.metrics, t.events)
+	if t.config.Checkpoint.Watches {
+		t.watchCheckpoints = newWatchCheckpoints(t.config.Checkpoint, t.metadata, t.watches)
+	}
+	t.dryRun = newDryRunReport(t.config.DryRun, t.metrics, t.metadata)
+	t.caches = newCacheInvalidation(t.config.CacheInvalidation, t.metrics)
+	verifier := newWriteVerifier(t.config.Verify, t.metrics, t.events)
+	if t.config.MockTargets || t.dryRun != nil {
+		//discarded writes can not be read back
+		verifier = nil
+	}
+
+	t.tracker = &changeTracker{
+		session:     t.router,
+		retry:       trackerRetryPolicy(t.config),
+		mockTargets: t.config.MockTargets,
+		dryRun:      t.dryRun,
+		caches:      t.caches,
+		hooks:       t.hooks,
+		transforms:  t.transforms,
+		tenants:     t.tenants,
+		metrics:     t.metrics,
+		events:      t.events,
+		chaos:       t.chaos,
+		builds:      t.indexBuilds,
+		verifier:    verifier,
+		changes:     newTrackedChanges(),
+		pending:     newPendingTargets(t.config.PendingTargets, t.metrics),
+		deadLetters: newDeadLetters(t.config.DeadLetters, t.metrics, t.metadata),
+		orphans:     newOrphans(t.config.Orphans, t.metrics, t.metadata),
+		references:  t.references,
+		hotKeys:     t.hotKeys,
+		//failed writes hold the checkpoint of their watch
+		watchCheckpoints: t.watchCheckpoints,
+	}
+	t.unknown = newOperationTelemetry(t.config.Diagnostics, t.metrics, t.metadata)
+	//a dry run resumes from the checkpoints but does not move them
+	if t.config.Checkpoint.enabled() {
+		t.checkpoint = newCheckpoint(t.config.Checkpoint, t.metadata)
+		if t.dryRun == nil {
+			t.sinks.add(t.checkpoint)
+		}
+	}
+	if t.watchCheckpoints != nil && t.dryRun == nil {
+		t.sinks.add(t.watchCheckpoints)
+	}
 
-	log.Println("Connected.")
+	logInfo("Connected", nil)
 	return nil
 }
 
+//Close closes the sessions of the agent. They stay open when TailContext
+//returns, so the agent can tail again and its tools like ReplayDeadLetters
+//keep working until it is closed.
+func (t *TailAgent) Close() {
+	for _, session := range []*mgo.Session{t.router, t.metadata} {
+		if session != nil && session != t.session {
+			session.Close()
+		}
+	}
+
+	if t.session != nil {
+		t.session.Close()
+	}
+	t.session, t.router, t.metadata = nil, nil, nil
+}
+
+//RegisterHooks adds callbacks for the watch identified by key (see Watch.Key).
+//Hooks should be registered before Tail is called.
+func (t *TailAgent) RegisterHooks(key string, hooks WatchHooks) error {
+	for _, w := range t.watches.list() {
+		if w.Key() == key {
+			t.hooks.register(key, hooks)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No watch %s configured", key)
+}
+
+//Events returns up to limit significant events of kind, newest first.
+//An empty kind returns events of all kinds, limit <= 0 returns all kept events.
+func (t *TailAgent) Events(kind string, limit int) []AgentEvent {
+	return t.events.list(kind, limit)
+}
+
+//AgentStatus describes a running agent
+type AgentStatus struct {
+	Created   time.Time          `json:"created"`
+	Uptime    string             `json:"uptime"`
+	StartTime time.Time          `json:"startTime"`
+	Watches   []string           `json:"watches"`
+	Metrics   map[string]float64 `json:"metrics"`
+}
+
+//Status returns the current status of the agent
+func (t *TailAgent) Status() AgentStatus {
+	watches := []string{}
+	for _, watch := range t.watches.list() {
+		watches = append(watches, watch.Key())
+	}
+
+	return AgentStatus{
+		Created:   t.created,
+		Uptime:    time.Since(t.created).String(),
+		StartTime: t.startTime,
+		Watches:   watches,
+		Metrics:   t.metrics.snapshot(),
+	}
+}
+
+func (t *TailAgent) serveStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, t.Status())
+}
+
+//handlers returns the admin endpoints of the agent
+func (t *TailAgent) handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/graphql":      t.graphql,
+		"/graphql/":     t.graphql,
+		"/events":       t.events,
+		"/status":       http.HandlerFunc(t.serveStatus),
+		"/lag":          t.lag,
+		"/tenants":      http.HandlerFunc(t.serveTenants),
+		"/features":     http.HandlerFunc(t.serveFeatures),
+		"/metrics":      http.HandlerFunc(t.serveMetrics),
+		"/dead-letters": http.HandlerFunc(t.serveDeadLetters),
+		"/pause":        http.HandlerFunc(t.servePause),
+		"/resume":       http.HandlerFunc(t.serveResume),
+		"/checkpoint":   http.HandlerFunc(t.serveCheckpoint),
+		"/watches":      http.HandlerFunc(t.serveWatches),
+		"/rescan":       http.HandlerFunc(t.serveRescan),
+		"/hot-keys":     http.HandlerFunc(t.serveHotKeys),
+		"/schema":       http.HandlerFunc(t.serveSchema),
+	}
+}
+
+//AddSink adds a sink that will receive all change events.
+//Batch sinks commit with the watermarks, without watermarkInterval
+//they are sent every 10s.
+func (t *TailAgent) AddSink(s Sink) {
+	t.addSink(s, nil)
+}
+
+//addSink adds s for the events that match filter
+func (t *TailAgent) addSink(s Sink, filter *EventFilter) {
+	t.sinks.addFiltered(s, filter)
+	if t.watermarks == nil && t.sinks.batching() {
+		t.watermarks = newWatermarks(t.sinks, defaultBatchInterval)
+		t.watermarks.adaptive = newAdaptiveBatching(t.config.AdaptiveBatching, t.metrics)
+	}
+}
+
 //NewTailAgentWithStartDate will start
 func NewTailAgentWithStartDate(c Configuration, startTime time.Time) (*TailAgent, error) {
-	agent := &TailAgent{config: c, startTime: startTime}
-	err := agent.connect()
+	agent := &TailAgent{
+		config:      c,
+		watches:     newWatchSet(c.Watches),
+		startTime:   startTime,
+		hooks:       newHookRegistry(),
+		metrics:     newMetricRegistry(),
+		events:      newEventLog(c.Admin.EventLogSize),
+		created:     time.Now(),
+		chaos:       newFaultInjector(c.Chaos),
+		indexBuilds: newIndexBuilds(),
+		rescans:     newWatchRescans(),
+		catchUps:    &catchUps{},
+	}
+	agent.sinks = &sinkDispatcher{metrics: agent.metrics}
+	agent.pause = newPauseSwitch(agent.metrics)
+	agent.lag = newLagHistory(agent.metrics)
+	agent.latencies = newLatencyRecorder(agent.metrics)
+	agent.tenants = newTenantLimiter(c.Tenants, agent.metrics)
+	agent.watermarks = newWatermarks(agent.sinks, watermarkInterval(c))
+	if agent.watermarks != nil {
+		agent.watermarks.adaptive = newAdaptiveBatching(c.AdaptiveBatching, agent.metrics)
+		agent.watermarks.entries = c.Checkpoint.Entries
+	}
+
+	agent.lineage = newLineageEmitter(c.Lineage, c.Mongo, agent.metrics)
+	agent.features = newFeatureFlags(c.Features)
+	transforms, err := newWatchTransforms(c.Watches, agent.features)
+	if err != nil {
+		return nil, err
+	}
+	agent.transforms = transforms
+
+	for _, sc := range c.Sinks {
+		sink, err := NewSink(sc)
+		if err != nil {
+			agent.sinks.close()
+			return nil, err
+		}
+
+		agent.addSink(sink, sc.Filter)
+	}
+
+	if c.Elasticsearch != nil {
+		agent.addSink(newElasticsearchSink(*c.Elasticsearch, agent.watches), nil)
+	}
+
+	agent.graphql = NewGraphQLBridge(c.Watches)
+	agent.sinks.add(agent.graphql)
+	agent.subscriptions = newSubscriptions()
+	agent.sinks.add(agent.subscriptions)
+
+	if c.Admin.Listen != "" {
+		agent.admin = newAdminServer(c.Admin)
+		for pattern, handler := range agent.handlers() {
+			agent.admin.handle(pattern, handler)
+		}
+		agent.admin.handle("/debug/goroutines", http.HandlerFunc(serveGoroutines))
+	}
+
+	if c.Metrics.Listen != "" {
+		agent.metricsServer = &metricsServer{settings: c.Metrics, handler: http.HandlerFunc(agent.serveMetrics)}
+	}
+
+	if len(c.Notifications.Conditions) > 0 || len(c.Notifications.Rules) > 0 || len(c.OplogGap.Notify) > 0 {
+		center, err := newNotificationCenter(c.Notifications, agent.metrics, agent.events)
+		if err != nil {
+			agent.sinks.close()
+			return nil, err
+		}
+
+		agent.notifications = center
+	}
+
+	if agent.chaos != nil {
+		logWarn("Fault injection is enabled, do not use this configuration in production", nil)
+		agent.events.record(EventLifecycle, "", "Fault injection enabled")
+	}
+
+	if c.DryRun != nil {
+		logWarn("Dry run, the intended writes to targets are reported instead of sent", nil)
+		agent.events.record(EventLifecycle, "", "Dry run")
+	}
+
+	if c.MockTargets {
+		logWarn("Target writes are discarded, the targets are not updated", nil)
+		agent.events.record(EventLifecycle, "", "Target writes discarded")
+	}
+
+	err = agent.connect()
+	if err != nil {
+		agent.sinks.close()
+		agent.Close()
+	}
+
 	return agent, err
 }
 
@@ -2,8 +2,11 @@ package redkeep_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -53,6 +56,14 @@ var testConfigurationTemplate = `{
       "behaviourSettings": {
         "cascadeDelete": false
       }
+    },
+    {
+      "name": "reviewedItems",
+      "trackCollection": "{{.Database}}.item",
+      "trackFields": ["name"],
+      "targetCollection": "{{.Database}}.review",
+      "targetNormalizedField": "information",
+      "triggerReference": "item"
     }
   ]
 }`
@@ -62,9 +73,16 @@ var _ = Describe("Tail", func() {
 		running      chan bool
 		database     string
 		answerString string
+		agent        *TailAgent
+		hookCalls    chan string
+		hookTriggers chan map[string]interface{}
+		writeErrors  chan error
 	)
 
 	BeforeSuite(func() {
+		if noMongo() {
+			return
+		}
 		rndDB := func() string {
 			return fmt.Sprintf("redkeep_tests_%d", time.Now().UnixNano())
 		}()
@@ -88,7 +106,40 @@ var _ = Describe("Tail", func() {
 		config, err := NewConfiguration(data)
 		Expect(err).ToNot(HaveOccurred())
 
-		agent, err := NewTailAgent(*config)
+		agent, err = NewTailAgent(*config)
+		Expect(err).ToNot(HaveOccurred())
+
+		hookCalls = make(chan string, 10)
+		hookTriggers = make(chan map[string]interface{}, 10)
+		writeErrors = make(chan error, 10)
+		err = agent.RegisterHooks("reviewedItems", WatchHooks{
+			BeforeWrite: func(w Watch, trigger map[string]interface{}, update bson.M) error {
+				hookCalls <- "first"
+				select {
+				case hookTriggers <- trigger:
+				default:
+				}
+				switch trigger["text"] {
+				case "skip":
+					return errors.New("skipped")
+				case "conflict":
+					update["$unset"] = bson.M{"information.name": ""}
+				}
+
+				return nil
+			},
+			AfterWrite: func(w Watch, trigger map[string]interface{}, update bson.M, err error) {
+				writeErrors <- err
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = agent.RegisterHooks("reviewedItems", WatchHooks{
+			BeforeWrite: func(w Watch, trigger map[string]interface{}, update bson.M) error {
+				hookCalls <- "second"
+				return nil
+			},
+		})
 		Expect(err).ToNot(HaveOccurred())
 
 		go agent.Tail(running, false)
@@ -450,6 +501,140 @@ var _ = Describe("Tail", func() {
 				Expect(actualComment.Meta["gender"]).To(Equal("confidential"))
 			}
 		})
+
+		It("Should collect orphaned targets of references without a database like inserts", func() {
+			targets := database + "_targets"
+			w := Watch{
+				TrackCollection:       database + ".user",
+				TrackFields:           []string{"username"},
+				TargetCollection:      targets + ".comment",
+				TargetNormalizedField: "meta",
+				TriggerReference:      "user",
+				BehaviourSettings:     BehaviourSettings{OnDelete: OnDeleteDelete},
+			}
+
+			local, tracked := bson.NewObjectId(), bson.NewObjectId()
+			Expect(db.DB(targets).C("comment").Insert(
+				bson.M{"text": "user of the target database", "user": mgo.DBRef{Collection: "user", Id: local}},
+				bson.M{"text": "deleted tracked user", "user": mgo.DBRef{Collection: "user", Id: tracked, Database: database}},
+			)).To(Succeed())
+
+			result, err := CollectOrphanedTargets(context.Background(), db, w, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Checked).To(Equal(1))
+			Expect(result.Missing).To(Equal(1))
+			Expect(result.Targets).To(Equal(1))
+
+			count, err := db.DB(targets).C("comment").Find(bson.M{"user.$id": local}).Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+			Expect(db.DB(targets).DropDatabase()).To(Succeed())
+		})
+	})
+
+	Context("Database testcases with hooks", func() {
+		var (
+			db     *mgo.Session
+			itemID bson.ObjectId
+		)
+
+		BeforeEach(func() {
+			var err error
+			db, err = mgo.Dial("localhost:30000,localhost:30001,localhost:30002")
+			Expect(err).ToNot(HaveOccurred())
+
+			itemID = bson.NewObjectId()
+			db.DB(database).C("item").Insert(bson.M{"_id": itemID, "name": "towel"})
+		})
+
+		AfterEach(func() {
+			db.Close()
+		})
+
+		review := func(text string) map[string]interface{} {
+			db.DB(database).C("review").Insert(bson.M{
+				"text": text,
+				"item": mgo.DBRef{Database: database, Collection: "item", Id: itemID},
+			})
+
+			result := map[string]interface{}{}
+			time.Sleep(sleepDuration)
+			db.Copy().DB(database).C("review").Find(bson.M{"text": text}).One(&result)
+			return result
+		}
+
+		It("will not accept hooks for unknown watches", func() {
+			Expect(agent.RegisterHooks("unknown", WatchHooks{})).ToNot(Succeed())
+		})
+
+		It("will run all hooks around the write", func() {
+			result := review("useful")
+			Expect(result["information"]).To(Equal(map[string]interface{}{"name": "towel"}))
+			Expect(hookCalls).To(Receive(Equal("first")))
+			Expect(hookCalls).To(Receive(Equal("second")))
+			Expect(writeErrors).To(Receive(BeNil()))
+		})
+
+		It("will skip the write and the remaining hooks on errors", func() {
+			result := review("skip")
+			Expect(result).ToNot(HaveKey("information"))
+			Expect(hookCalls).To(Receive(Equal("first")))
+			Expect(hookCalls).ToNot(Receive())
+			Expect(writeErrors).ToNot(Receive())
+		})
+
+		It("will pass the updated tracked document to hooks", func() {
+			review("useful")
+			for len(hookTriggers) > 0 {
+				<-hookTriggers
+			}
+			Expect(hookCalls).To(Receive(Equal("first")))
+			Expect(hookCalls).To(Receive(Equal("second")))
+			Expect(writeErrors).To(Receive(BeNil()))
+
+			db.DB(database).C("item").UpdateId(itemID, bson.M{"$set": bson.M{"name": "blanket"}})
+			var trigger map[string]interface{}
+			Eventually(hookTriggers).Should(Receive(&trigger))
+			Expect(trigger["_id"]).To(Equal(itemID))
+			Expect(trigger["name"]).To(Equal("blanket"))
+			Eventually(writeErrors).Should(Receive(BeNil()))
+			Expect(hookCalls).To(Receive(Equal("first")))
+			Expect(hookCalls).To(Receive(Equal("second")))
+		})
+
+		It("will pass write errors to after write hooks", func() {
+			review("conflict")
+			Expect(hookCalls).To(Receive(Equal("first")))
+			Expect(hookCalls).To(Receive(Equal("second")))
+			Expect(writeErrors).To(Receive(HaveOccurred()))
+		})
+	})
+
+	Context("test TailContext", func() {
+		It("needs a connected agent", func() {
+			agent := &TailAgent{}
+			Expect(agent.TailContext(context.Background(), TailOptions{})).To(MatchError("Agent is not connected"))
+			Expect(agent.Tail(make(chan bool), false)).To(MatchError("Agent is not connected"))
+		})
+
+		It("keeps its sessions for the next run until it is closed", func() {
+			config, err := NewConfiguration([]byte(strings.Replace(templateForTestsConfig, `"watches"`, `
+				"metadata": { "connectionURI": "localhost:30000,localhost:30001,localhost:30002" },
+				"checkpoint": { "collection": "`+database+`.checkpoints" },
+				"watches"`, 1)))
+			Expect(err).ToNot(HaveOccurred())
+			closing, err := NewTailAgent(*config)
+			Expect(err).ToNot(HaveOccurred())
+
+			for run := 0; run < 2; run++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+				Expect(closing.TailContext(ctx, TailOptions{})).To(Equal(context.DeadlineExceeded))
+				cancel()
+			}
+
+			closing.Close()
+			Expect(closing.TailContext(context.Background(), TailOptions{})).To(MatchError("Agent is not connected"))
+		})
 	})
 
 	Context("test GetValue", func() {
@@ -493,6 +678,31 @@ var _ = Describe("Tail", func() {
 			actual = GetValue("fish", toTest)
 			Expect(actual).To(Equal(map[string]interface{}{"dog": "cat"}))
 		})
+
+		It("will follow arbitrarily deep paths", func() {
+			toTest := map[string]interface{}{
+				"meta": bson.M{
+					"owner": map[string]interface{}{"ref": "owner"},
+				},
+				"comments": []interface{}{
+					map[string]interface{}{"user": "first"},
+					bson.M{"user": bson.M{"name": "second"}},
+				},
+				"$set": map[string]interface{}{
+					"meta.owner.ref": "set",
+					"meta.editor":    map[string]interface{}{"ref": "editor"},
+				},
+			}
+
+			Expect(GetValue("meta.owner.ref", toTest)).To(Equal("owner"))
+			Expect(GetValue("comments.0.user", toTest)).To(Equal("first"))
+			Expect(GetValue("comments.1.user.name", toTest)).To(Equal("second"))
+			Expect(GetValue("comments.2.user", toTest)).To(BeNil())
+			Expect(GetValue("comments.user", toTest)).To(BeNil())
+			Expect(GetValue("$set.meta.owner.ref", toTest)).To(Equal("set"))
+			Expect(GetValue("$set.meta.editor.ref", toTest)).To(Equal("editor"))
+			Expect(GetValue("$set.meta.owner", toTest)).To(BeNil())
+		})
 	})
 	Context("Bulk testcases", func() {
 		var (
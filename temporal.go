@@ -0,0 +1,118 @@
+package redkeep
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//TemporalSettings keep every value of the tracked fields of a watch with
+//the time it was valid in Collection (database.collection), so consumers
+//can ask which values were embedded at any time, see TemporalValues
+type TemporalSettings struct {
+	Collection string `json:"collection"`
+}
+
+func checkTemporalSettings(w Watch) error {
+	if w.Temporal == nil {
+		return nil
+	}
+
+	if c := w.Temporal.Collection; strings.Index(c, ".") < 1 {
+		return fmt.Errorf("Temporal collection %s must be database.collection", c)
+	}
+
+	return nil
+}
+
+//TemporalValue is the value of Field of the tracked document Reference of
+//Watch from ValidFrom until ValidTo, ValidTo is nil while it is current.
+//Recorded is the time the agent stored it. Removed fields have no value.
+type TemporalValue struct {
+	Watch     string      `bson:"watch" json:"watch"`
+	Reference interface{} `bson:"ref" json:"ref"`
+	Field     string      `bson:"field" json:"field"`
+	Value     interface{} `bson:"value" json:"value"`
+	ValidFrom time.Time   `bson:"validFrom" json:"validFrom"`
+	ValidTo   *time.Time  `bson:"validTo" json:"validTo"`
+	Recorded  time.Time   `bson:"recorded" json:"recorded"`
+}
+
+//temporalSelector selects the value of field of the tracked document
+//with id of w that is current
+func temporalSelector(w Watch, id interface{}, field string) bson.M {
+	return bson.M{"watch": w.Key(), "ref": id, "field": field, "validTo": nil}
+}
+
+//recordTemporal ends the current values of the fields of update of the
+//tracked document with id at validFrom and stores the new ones. A change
+//that is not newer than the current value, like a replayed one, is ignored.
+func recordTemporal(session *mgo.Session, w Watch, id interface{}, update bson.M, validFrom time.Time) error {
+	if w.Temporal == nil {
+		return nil
+	}
+
+	p := strings.Index(w.Temporal.Collection, ".")
+	collection := session.DB(w.Temporal.Collection[:p]).C(w.Temporal.Collection[p+1:])
+	for field, value := range historyFields(w, update) {
+		current := temporalSelector(w, id, field)
+		current["validFrom"] = bson.M{"$gte": validFrom}
+		newer, err := collection.Find(current).Count()
+		if err != nil {
+			return err
+		}
+		if newer > 0 {
+			continue
+		}
+
+		if _, err := collection.UpdateAll(temporalSelector(w, id, field), bson.M{"$set": bson.M{"validTo": validFrom}}); err != nil {
+			return err
+		}
+
+		if value == nil {
+			continue
+		}
+
+		version := TemporalValue{Watch: w.Key(), Reference: id, Field: field, Value: value, ValidFrom: validFrom, Recorded: time.Now()}
+		if err := collection.Insert(version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//temporalQuery selects the values of the tracked document with id of w that were valid at
+func temporalQuery(w Watch, id interface{}, at time.Time) bson.M {
+	return bson.M{
+		"watch":     w.Key(),
+		"ref":       id,
+		"validFrom": bson.M{"$lte": at},
+		"$or":       []bson.M{{"validTo": nil}, {"validTo": bson.M{"$gt": at}}},
+	}
+}
+
+//TemporalValues returns the values of the tracked fields of the document
+//with id that the targets of w embedded at the time at, by field. Only
+//values that were written since the watch has temporal settings are known.
+func TemporalValues(session *mgo.Session, w Watch, id interface{}, at time.Time) (map[string]interface{}, error) {
+	if w.Temporal == nil {
+		return nil, fmt.Errorf("Watch %s keeps no temporal values", w.Key())
+	}
+
+	p := strings.Index(w.Temporal.Collection, ".")
+	versions := []TemporalValue{}
+	if err := session.DB(w.Temporal.Collection[:p]).C(w.Temporal.Collection[p+1:]).Find(temporalQuery(w, id, at)).All(&versions); err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	for _, version := range versions {
+		values[version.Field] = version.Value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,48 @@
+package redkeep_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Temporal values", func() {
+	w := Watch{
+		Name:                  "commentAuthors",
+		TrackCollection:       "app.user",
+		TrackFields:           []string{"name"},
+		TargetCollection:      "app.comments",
+		TargetNormalizedField: "author",
+		TriggerReference:      "user",
+	}
+
+	It("selects the values that were valid at a time", func() {
+		at := time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC)
+		Expect(TemporalQuery(w, "u1", at)).To(Equal(bson.M{
+			"watch":     "commentAuthors",
+			"ref":       "u1",
+			"validFrom": bson.M{"$lte": at},
+			"$or":       []bson.M{{"validTo": nil}, {"validTo": bson.M{"$gt": at}}},
+		}))
+	})
+
+	It("needs temporal settings to look up values", func() {
+		_, err := TemporalValues(nil, w, "u1", time.Now())
+		Expect(err).To(MatchError("Watch commentAuthors keeps no temporal values"))
+	})
+
+	It("checks the temporal collection", func() {
+		config := strings.Replace(templateForTestsConfig, `"trackCollection"`, `"temporal": { "collection": "names" }, "trackCollection"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Temporal collection names must be database.collection"))
+
+		config = strings.Replace(templateForTestsConfig, `"trackCollection"`, `"temporal": { "collection": "audit.names" }, "trackCollection"`, 1)
+		_, err = NewConfiguration([]byte(config))
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
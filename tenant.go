@@ -0,0 +1,402 @@
+package redkeep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//tenantPlaceholder is replaced by the tenant name in tenant watches
+const tenantPlaceholder = "{tenant}"
+
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+//TenantSettings limit the target writes of all watches of one tenant.
+//RateLimit is the number of writes per second, zero means unlimited.
+//Burst is the number of writes that may happen at once (default 1).
+type TenantSettings struct {
+	RateLimit float64 `json:"rateLimit"`
+	Burst     int     `json:"burst"`
+}
+
+func checkTenantSettings(tenants map[string]TenantSettings) error {
+	for name, settings := range tenants {
+		if settings.RateLimit < 0 || settings.Burst < 0 {
+			return fmt.Errorf("Rate limit and burst of tenant %s must not be negative", name)
+		}
+	}
+
+	return nil
+}
+
+func checkTenantWatches(templates []Watch) error {
+	for _, w := range templates {
+		if !strings.Contains(w.TrackCollection, tenantPlaceholder) || !strings.Contains(w.TargetCollection, tenantPlaceholder) {
+			return fmt.Errorf("Tenant watch %s needs %s in its collections", w.Key(), tenantPlaceholder)
+		}
+	}
+
+	return nil
+}
+
+//expandTenantWatches creates the watches of tenant from the templates
+func expandTenantWatches(templates []Watch, tenant string) []Watch {
+	watches := []Watch{}
+	for _, w := range templates {
+		w.Name = strings.Replace(w.Name, tenantPlaceholder, tenant, -1)
+		w.TrackCollection = strings.Replace(w.TrackCollection, tenantPlaceholder, tenant, -1)
+		w.TargetCollection = strings.Replace(w.TargetCollection, tenantPlaceholder, tenant, -1)
+		w.Tenant = tenant
+		if w.Elasticsearch != nil {
+			index := *w.Elasticsearch
+			index.Index = strings.Replace(index.Index, tenantPlaceholder, strings.ToLower(tenant), -1)
+			w.Elasticsearch = &index
+		}
+		watches = append(watches, w)
+	}
+
+	return watches
+}
+
+//tenantMetric is the name of metric for one tenant, like
+//write_failures_total{tenant="shop"}
+func tenantMetric(name, tenant string) string {
+	return labeled(name, "tenant", tenant)
+}
+
+//tokenBucket is a rate limiter that hands out reservations,
+//tokens may become negative while callers wait for them
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(settings TenantSettings) *tokenBucket {
+	burst := float64(settings.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{rate: settings.RateLimit, burst: burst, tokens: burst}
+}
+
+//reserve takes a token and returns how long to wait until it is valid
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens += b.rate * now.Sub(b.last).Seconds()
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+//tenantLimiter throttles the writes of every tenant on its own,
+//all methods can be called on nil
+type tenantLimiter struct {
+	buckets map[string]*tokenBucket
+	metrics *metricRegistry
+}
+
+func newTenantLimiter(tenants map[string]TenantSettings, metrics *metricRegistry) *tenantLimiter {
+	limiter := &tenantLimiter{buckets: map[string]*tokenBucket{}, metrics: metrics}
+	for name, settings := range tenants {
+		if settings.RateLimit > 0 {
+			limiter.buckets[name] = newTokenBucket(settings)
+		}
+	}
+
+	return limiter
+}
+
+//wait blocks until the tenant may write, only
+//the workers of that tenant are delayed
+func (l *tenantLimiter) wait(tenant string) {
+	if l == nil {
+		return
+	}
+
+	bucket, ok := l.buckets[tenant]
+	if !ok {
+		return
+	}
+
+	if delay := bucket.reserve(time.Now()); delay > 0 {
+		l.metrics.add(tenantMetric(MetricThrottledSeconds, tenant), delay.Seconds())
+		time.Sleep(delay)
+	}
+}
+
+//watchSet holds the watches of an agent, the watches of tenants are
+//added and the configured watches are reloaded while it runs. The list
+//is replaced and never changed in place, so callers of list can keep
+//using it without lock. list can be called on nil.
+type watchSet struct {
+	sync.RWMutex
+	watches []Watch
+	tenants map[string]bool
+	//added are the keys of the watches of tenants added at runtime
+	added map[string]bool
+	//pausedKeys are the keys of the watches that are not tracked for now
+	pausedKeys map[string]bool
+}
+
+func newWatchSet(watches []Watch) *watchSet {
+	s := &watchSet{watches: watches, tenants: map[string]bool{}, added: map[string]bool{}, pausedKeys: map[string]bool{}}
+	for _, w := range watches {
+		if w.Tenant != "" {
+			s.tenants[w.Tenant] = true
+		}
+	}
+
+	return s
+}
+
+//reload replaces the configured watches and keeps the ones of tenants
+//added at runtime, it returns the configured watches it replaced
+func (s *watchSet) reload(watches []Watch) ([]Watch, error) {
+	s.Lock()
+	defer s.Unlock()
+	configured, added := []Watch{}, []Watch{}
+	tenants := map[string]bool{}
+	for _, w := range s.watches {
+		if !s.added[w.Key()] {
+			configured = append(configured, w)
+			continue
+		}
+
+		added = append(added, w)
+		tenants[w.Tenant] = true
+	}
+
+	keys := map[string]bool{}
+	for _, w := range append(append([]Watch{}, added...), watches...) {
+		if keys[w.Key()] {
+			return nil, fmt.Errorf("Watch %s is already configured", w.Key())
+		}
+		keys[w.Key()] = true
+	}
+
+	for _, w := range watches {
+		if w.Tenant != "" {
+			tenants[w.Tenant] = true
+		}
+	}
+
+	list := make([]Watch, 0, len(watches)+len(added))
+	s.watches = append(append(list, watches...), added...)
+	s.tenants = tenants
+	return configured, nil
+}
+
+func (s *watchSet) list() []Watch {
+	if s == nil {
+		return nil
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	return s.watches
+}
+
+//active returns the watches that are not paused
+func (s *watchSet) active() []Watch {
+	if s == nil {
+		return nil
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	if len(s.pausedKeys) == 0 {
+		return s.watches
+	}
+
+	active := []Watch{}
+	for _, w := range s.watches {
+		if !s.pausedKeys[w.Key()] {
+			active = append(active, w)
+		}
+	}
+
+	return active
+}
+
+func (s *watchSet) pause(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.pausedKeys[key] = true
+}
+
+func (s *watchSet) resume(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pausedKeys, key)
+}
+
+func (s *watchSet) paused(key string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.pausedKeys[key]
+}
+
+func (s *watchSet) tenantNames() []string {
+	s.RLock()
+	defer s.RUnlock()
+	names := []string{}
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//check returns an error if tenant or the key of one of watches is taken
+func (s *watchSet) check(tenant string, watches []Watch) error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.checkLocked(tenant, watches)
+}
+
+func (s *watchSet) checkLocked(tenant string, watches []Watch) error {
+	if s.tenants[tenant] {
+		return fmt.Errorf("Tenant %s is already registered", tenant)
+	}
+
+	keys := map[string]bool{}
+	for _, w := range s.watches {
+		keys[w.Key()] = true
+	}
+
+	for _, w := range watches {
+		if keys[w.Key()] {
+			return fmt.Errorf("Watch %s is already configured", w.Key())
+		}
+		keys[w.Key()] = true
+	}
+
+	return nil
+}
+
+func (s *watchSet) addTenant(tenant string, watches []Watch) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.checkLocked(tenant, watches); err != nil {
+		return err
+	}
+
+	list := make([]Watch, 0, len(s.watches)+len(watches))
+	s.watches = append(append(list, s.watches...), watches...)
+	s.tenants[tenant] = true
+	for _, w := range watches {
+		s.added[w.Key()] = true
+	}
+
+	return nil
+}
+
+//AddTenant creates the watches of tenant from the tenant watches of the
+//configuration. They are tracked from now on, the documents that already
+//exist are backfilled in the background by the workers of the tailing
+//agent. Failed backfills are recorded in the event log.
+func (t *TailAgent) AddTenant(tenant string) error {
+	if !tenantNamePattern.MatchString(tenant) {
+		return fmt.Errorf("Invalid tenant name %q, only letters, digits, _ and - are allowed", tenant)
+	}
+
+	if len(t.config.TenantWatches) == 0 {
+		return errors.New("No tenant watches configured")
+	}
+
+	watches := expandTenantWatches(t.config.TenantWatches, tenant)
+	if err := t.watches.check(tenant, watches); err != nil {
+		return err
+	}
+
+	//transforms have to exist before the watches are tracked
+	if err := t.transforms.add(watches); err != nil {
+		return err
+	}
+
+	if err := t.watches.addTenant(tenant, watches); err != nil {
+		return err
+	}
+
+	t.events.record(EventLifecycle, "", "Tenant "+tenant+" added")
+	t.updateSchemaManifest()
+	t.graphql.reload(t.watches.list())
+	t.lineage.startRuns(watches)
+	err := t.rescans.run("tenant "+tenant, func(ctx context.Context) error {
+		err := t.backfill(ctx, watches, t.submitBackfill)
+		if err != nil {
+			t.events.record(EventError, "", "Backfill of tenant "+tenant+" failed: "+err.Error())
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Tenant %s was added but not backfilled: %s", tenant, err.Error())
+	}
+
+	return nil
+}
+
+//submitBackfill hands the backfill entry of a document to the workers,
+//it is ordered with the live entries of the same document
+func (t *TailAgent) submitBackfill(entry map[string]interface{}, job func(Tracker)) bool {
+	return t.rescans.submit(documentKey(entry), partitionKey(t.config.Workers.Partition, t.watches, entry), job)
+}
+
+//backfillCommand is an update command that sets the tracked fields of document
+func backfillCommand(w Watch, document map[string]interface{}) map[string]interface{} {
+	set := map[string]interface{}{}
+	for _, field := range w.TrackFields {
+		if value := GetValue(field, document); value != nil {
+			set[field] = value
+		}
+	}
+
+	return map[string]interface{}{"$set": set}
+}
+
+//serveTenants lists the tenants, a POST with {"name": "..."} adds one
+func (t *TailAgent) serveTenants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusOK, t.watches.tenantNames())
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid json: " + err.Error()})
+		return
+	}
+
+	if err := t.AddTenant(request.Name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"name": request.Name})
+}
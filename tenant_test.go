@@ -0,0 +1,133 @@
+package redkeep_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Tenants", func() {
+	It("lets writes within the burst pass and delays the rest", func() {
+		delays := ReserveTenantWrites(TenantSettings{RateLimit: 2, Burst: 2}, []time.Duration{0, 0, 0, 0})
+		Expect(delays).To(Equal([]time.Duration{0, 0, 500 * time.Millisecond, time.Second}))
+	})
+
+	It("refills tokens over time up to the burst", func() {
+		delays := ReserveTenantWrites(TenantSettings{RateLimit: 10}, []time.Duration{0, 100 * time.Millisecond, 10 * time.Second, 10 * time.Second})
+		Expect(delays).To(Equal([]time.Duration{0, 0, 0, 100 * time.Millisecond}))
+	})
+
+	It("labels metrics with the tenant", func() {
+		Expect(TenantMetric(MetricWriteFailures, "shop")).To(Equal(`write_failures_total{tenant="shop"}`))
+	})
+
+	It("rejects negative rate limits", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": { "connectionURI": "localhost:30000" },
+			"tenants": { "shop": { "rateLimit": -1 } },
+			"watches": [{
+				"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+				"targetNormalizedField": "meta", "triggerReference": "user", "tenant": "shop"
+			}]
+		}`))
+		Expect(err).To(MatchError("Rate limit and burst of tenant shop must not be negative"))
+	})
+
+	Context("onboarding", func() {
+		config := func(tenantWatches string) (*Configuration, error) {
+			return NewConfiguration([]byte(`{
+				"mongo": { "connectionURI": "localhost:30000" },
+				"watches": [{
+					"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+					"targetNormalizedField": "meta", "triggerReference": "user"
+				}],
+				"tenantWatches": ` + tenantWatches + `
+			}`))
+		}
+
+		It("expands the tenant watches for every tenant", func() {
+			c, err := config(`[{
+				"trackCollection": "{tenant}_shop.user", "trackFields": ["username"], "targetCollection": "{tenant}_shop.order",
+				"targetNormalizedField": "customer", "triggerReference": "user"
+			}]`)
+			Expect(err).ToNot(HaveOccurred())
+
+			keys, err := AddTenantWatches(*c, "acme", "globex")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(Equal([]string{
+				"live.user->live.comment.meta",
+				"acme_shop.user->acme_shop.order.customer",
+				"globex_shop.user->globex_shop.order.customer",
+			}))
+		})
+
+		It("rejects tenants that are already registered", func() {
+			c, err := config(`[{
+				"trackCollection": "{tenant}.user", "trackFields": ["username"], "targetCollection": "{tenant}.order",
+				"targetNormalizedField": "customer", "triggerReference": "user"
+			}]`)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = AddTenantWatches(*c, "acme", "acme")
+			Expect(err).To(MatchError("Tenant acme is already registered"))
+		})
+
+		It("rejects tenant watches without placeholder", func() {
+			_, err := config(`[{
+				"trackCollection": "shop.user", "trackFields": ["username"], "targetCollection": "{tenant}.order",
+				"targetNormalizedField": "customer", "triggerReference": "user"
+			}]`)
+			Expect(err).To(MatchError("Tenant watch shop.user->{tenant}.order.customer needs {tenant} in its collections"))
+		})
+
+		It("backfills the tracked fields of a document", func() {
+			w := Watch{TrackFields: []string{"username", "address.city", "missing"}}
+			document := map[string]interface{}{
+				"_id":      "1",
+				"username": "alice",
+				"address":  map[string]interface{}{"city": "Berlin"},
+			}
+			Expect(BackfillCommand(w, document)).To(Equal(map[string]interface{}{
+				"$set": map[string]interface{}{"username": "alice", "address.city": "Berlin"},
+			}))
+		})
+
+		It("reads backfills from tagged members", func() {
+			Expect(TagSets([]map[string]string{{"workload": "analytics", "dc": "east"}, {}})).To(Equal([]bson.D{
+				{{Name: "dc", Value: "east"}, {Name: "workload", Value: "analytics"}},
+				{},
+			}))
+
+			config := strings.Replace(templateForTestsConfig, `"watches"`, `"backfill": { "tags": [{ "workload": "analytics" }] }, "watches"`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(MatchError("Backfill tags need a read preference other than primary"))
+
+			config = strings.Replace(config, `"tags"`, `"readPreference": "secondaryPreferred", "tags"`, 1)
+			_, err = NewConfiguration([]byte(config))
+			Expect(err).ToNot(HaveOccurred())
+
+			config = strings.Replace(config, `secondaryPreferred`, `analytics`, 1)
+			_, err = NewConfiguration([]byte(config))
+			Expect(err).To(MatchError(ContainSubstring("Unknown read preference analytics")))
+		})
+
+		It("reads backfills in batches", func() {
+			Expect(BackfillBatchSize(BackfillSettings{})).To(Equal(1000))
+			Expect(BackfillBatchSize(BackfillSettings{BatchSize: 50})).To(Equal(50))
+
+			config := strings.Replace(templateForTestsConfig, `"watches"`, `"backfill": { "batchSize": -1 }, "watches"`, 1)
+			_, err := NewConfiguration([]byte(config))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("reports the cluster time of snapshot backfills", func() {
+			Expect(BackfillDone(3, 0)).To(Equal("Backfill of 3 documents done"))
+			Expect(BackfillDone(3, 42)).To(Equal("Backfill of 3 documents at cluster time 42 done"))
+		})
+	})
+})
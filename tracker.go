@@ -1,8 +1,8 @@
 package redkeep
 
 import (
-	"log"
 	"strings"
+	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -46,12 +46,113 @@ type InsertTracker interface {
 }
 
 type changeTracker struct {
-	session *mgo.Session
+	session    *mgo.Session
+	hooks      *hookRegistry
+	transforms *watchTransforms
+	tenants    *tenantLimiter
+	metrics    *metricRegistry
+	events     *eventLog
+	chaos      *faultInjector
+	builds     *indexBuilds
+	verifier   *writeVerifier
+	changes    *trackedChanges
+	pending    *pendingTargets
+	//deadLetters stores the changes whose writes failed
+	deadLetters *deadLetters
+	//references caches looked up tracked documents
+	references *referenceCache
+	//hotKeys records the updates with the largest fan-outs
+	hotKeys *hotKeys
+	//watchCheckpoints hold the position of watches whose writes failed
+	watchCheckpoints *watchCheckpoints
+	//retry sends writes again that failed, watches may have their own policy
+	retry *RetryPolicy
+	//reuseSession writes with session instead of a copy per entry
+	reuseSession bool
+	//mockTargets discards the writes to targets, see Configuration.MockTargets
+	mockTargets bool
+	//dryRun reports the writes to targets instead of sending them
+	dryRun *dryRunReport
+	//caches are invalidated after writes to targets
+	caches *cacheInvalidation
+	//orphans count the references to documents that do not exist
+	orphans *orphans
+}
+
+//transform applies the transforms of w to update of the tracked document
+//with id, it returns false if the write has to be skipped
+func (c changeTracker) transform(w Watch, id interface{}, update bson.M) bool {
+	if err := c.transforms.apply(w, id, update); err != nil {
+		c.events.record(EventError, w.Key(), "Transform failed: "+err.Error())
+		logWarn("Write skipped by transform", watchFields(w).withError(err))
+		return false
+	}
+
+	return len(update) > 0
+}
+
+//countWrite updates the write metrics of all watches, of w and of the tenant of w
+func (c changeTracker) countWrite(w Watch, err error) {
+	name := MetricWrites
+	if err != nil {
+		name = MetricWriteFailures
+		c.watchCheckpoints.hold(w.Key())
+	}
+
+	c.metrics.add(name, 1)
+	c.metrics.add(labeled(name, "watch", w.Key()), 1)
+	if w.Tenant != "" {
+		c.metrics.add(tenantMetric(name, w.Tenant), 1)
+	}
 }
 
 func (c changeTracker) HandleUpdate(w Watch, command map[string]interface{}, selector map[string]interface{}) {
-	session := c.session.Copy()
-	defer session.Close()
+	c.handleUpdate(w, command, selector, 0, 0)
+}
+
+func (c changeTracker) HandleUpdateGeneration(w Watch, command map[string]interface{}, selector map[string]interface{}, generation bson.MongoTimestamp) {
+	c.handleUpdate(w, command, selector, generation, generation)
+}
+
+//handleUpdate writes an update of the oplog entry with ts, updates
+//of hot keys may be written later and hold the watermarks until then
+func (c changeTracker) handleUpdate(w Watch, command map[string]interface{}, selector map[string]interface{}, generation, ts bson.MongoTimestamp) {
+	write := func() {
+		if err := c.update(w, command, selector, generation); err != nil {
+			c.deadLetters.add(w, "u", w.TrackCollection, command, selector, generation, err)
+		}
+	}
+
+	id := selector["_id"]
+	if !c.hotKeys.divert(w, id, ts, write, func() { c.refresh(w, id) }) {
+		write()
+	}
+}
+
+//refresh writes the current tracked fields of the tracked document with id
+//of w to the targets, like a backfill of the document
+func (c changeTracker) refresh(w Watch, id interface{}) {
+	session, done := c.useSession()
+	document := map[string]interface{}{}
+	err := backfillCollection(session, w).FindId(id).One(&document)
+	done()
+	if err != nil {
+		logWarn("Tracked document could not be refreshed", watchFields(w).withError(err))
+		return
+	}
+
+	selector := map[string]interface{}{"_id": id}
+	command := backfillCommand(w, document)
+	if err := c.update(w, command, selector, 0); err != nil {
+		c.deadLetters.add(w, "u", w.TrackCollection, command, selector, 0, err)
+	}
+}
+
+//update writes the tracked fields of an update to the targets,
+//it returns the error of the write
+func (c changeTracker) update(w Watch, command map[string]interface{}, selector map[string]interface{}, generation bson.MongoTimestamp) error {
+	session, done := c.useSession()
+	defer done()
 	p := strings.Index(w.TargetCollection, ".")
 	targetDB := w.TargetCollection[:p]
 	targetCollection := w.TargetCollection[p+1:]
@@ -59,69 +160,244 @@ func (c changeTracker) HandleUpdate(w Watch, command map[string]interface{}, sel
 
 	refID, ok := selector["_id"]
 	if !ok {
-		log.Println("No id found.")
-		return
+		logWarn("No id found", watchFields(w))
+		return nil
 	}
 
 	updateQuery := BuildUpdateQuery(w, command)
 	if updateQuery == nil {
-		return
+		return nil
+	}
+
+	c.completeFields(session, w, refID, updateQuery)
+	if !c.transform(w, refID, updateQuery) {
+		return nil
 	}
 
+	trigger := c.trigger(session, w, refID)
+	if err := c.hooks.beforeWrite(w, trigger, updateQuery); err != nil {
+		logInfo("Write skipped by hook", watchFields(w).withError(err))
+		return nil
+	}
+
+	c.builds.wait(w)
+	c.tenants.wait(w.Tenant)
+	changed := time.Now()
 	selectQuery := bson.M{w.TriggerReference + ".$id": refID}
-	_, err := collection.UpdateAll(selectQuery, updateQuery)
+	withHistory(w, updateQuery, changed)
+	withGeneration(w, selectQuery, updateQuery, generation)
+	writeQuery := updateQuery
+	if w.BehaviourSettings.ReferenceArray {
+		writeQuery = elementQuery(w, updateQuery, "$")
+	}
+
+	err := errInjectedFault
+	if !c.chaos.dropWrite() {
+		var info *mgo.ChangeInfo
+		writeSelector, _ := historySelector(w, selectQuery, writeQuery)
+		intended := IntendedWrite{Namespace: collection.FullName, Selector: writeSelector, Update: writeQuery, Multi: true}
+		updateAll := func() (err error) {
+			info, err = collection.UpdateAll(writeSelector, writeQuery)
+			return err
+		}
+		err = c.write(w, session, intended, func() error {
+			if w.BehaviourSettings.ReferenceArray {
+				//positional fields can not be selected
+				return updateAll()
+			}
+
+			return withIntermediates(collection, writeSelector, writeQuery, updateAll)
+		})
+		if err == nil && info != nil && info.Matched == 0 && w.BehaviourSettings.QueueMissingTargets {
+			c.pending.add(w, refID, updateQuery, generation, time.Now())
+		}
+		if err == nil && info != nil {
+			c.hotKeys.record(w, refID, info.Matched)
+		}
+		if err == nil {
+			c.history(session, w, refID, updateQuery, changed)
+			c.invalidateCaches(w, refID, nil, updateQuery)
+		}
+	}
+	c.hooks.afterWrite(w, trigger, updateQuery, err)
+	c.countWrite(w, err)
 	if err != nil {
-		log.Println("Query could not be executed successfully.")
+		c.events.record(EventError, w.Key(), "Update of "+w.TargetCollection+" failed: "+err.Error())
+		logError("Query could not be executed successfully", watchFields(w).withError(err))
+		return err
+	}
+
+	if !w.BehaviourSettings.ReferenceArray {
+		//positional writes can not be compared with the targets
+		c.verifier.verify(w, collection, selectQuery, updateQuery)
 	}
+
+	return nil
 }
 
-func (c changeTracker) HandleRemove(w Watch, command map[string]interface{}, selector map[string]interface{}) {
-	log.Println("-- Remove is not yet implemented")
+//trigger looks up the tracked document with id of an update for the
+//hooks of w, it only has the id if the document is gone meanwhile
+func (c changeTracker) trigger(session *mgo.Session, w Watch, id interface{}) map[string]interface{} {
+	document := map[string]interface{}{"_id": id}
+	if len(c.hooks.get(w)) == 0 {
+		return document
+	}
+
+	if err := backfillCollection(session, w).FindId(id).One(&document); err != nil {
+		logWarn("Tracked document could not be looked up for hooks", watchFields(w).withError(err))
+	}
+
+	return document
 }
 
 func (c changeTracker) HandleInsert(w Watch, command map[string]interface{}, originRef mgo.DBRef) {
+	c.HandleInsertGeneration(w, command, originRef, 0)
+}
+
+func (c changeTracker) HandleInsertGeneration(w Watch, command map[string]interface{}, originRef mgo.DBRef, generation bson.MongoTimestamp) {
+	if err := c.insert(w, command, originRef, generation); err != nil {
+		ns := originRef.Database + "." + originRef.Collection
+		c.deadLetters.add(w, "i", ns, command, map[string]interface{}{"_id": originRef.Id}, generation, err)
+	}
+}
+
+//insert writes the looked up tracked fields to the target originRef,
+//it returns the error of the write
+func (c changeTracker) insert(w Watch, command map[string]interface{}, originRef mgo.DBRef, generation bson.MongoTimestamp) error {
 	reference := GetValue(w.TriggerReference, command)
 	if reference == nil {
 		reference = GetValue("$set."+w.TriggerReference, command)
 	}
 
 	if reference == nil {
-		return
+		return nil
+	}
+
+	if references, ok := reference.([]interface{}); ok && w.BehaviourSettings.ReferenceArray {
+		return c.insertReferenceArray(w, command, originRef, references)
 	}
 
 	ref, ok := getReference(reference, originRef.Database)
 	if !ok {
-		return
+		return nil
 	}
 
-	session := c.session.Copy()
-	defer session.Close()
-
-	user := map[string]interface{}{}
+	session, done := c.useSession()
+	defer done()
 
-	collection := session.DB(ref.Database).C(ref.Collection)
-	err := collection.FindId(ref.Id).One(&user)
+	c.applyPending(w, session.DB(originRef.Database).C(originRef.Collection), ref.Id, originRef.Id)
 
+	user, err := c.lookup(session, ref)
 	if err != nil {
-		log.Println("User not found for update")
-		return
+		c.lookupFailed(w, originRef, ref, err)
+		logWarn("Referenced document not found for update", watchFields(w).withError(err))
+		return nil
 	}
 
 	query := BuildInsertQuery(w, user)
 	if query == nil {
-		log.Println("Empty query, need an update")
-		return
+		logDebug("Empty query, need an update", watchFields(w))
+		return nil
 	}
 
-	collection = session.DB(originRef.Database).C(originRef.Collection)
-	err = collection.Update(bson.M{"_id": originRef.Id.(bson.ObjectId)}, query)
+	if !c.transform(w, ref.Id, query) {
+		return nil
+	}
+
+	if err := c.hooks.beforeWrite(w, command, query); err != nil {
+		logInfo("Write skipped by hook", watchFields(w).withError(err))
+		return nil
+	}
+
+	c.builds.wait(w)
+	c.tenants.wait(w.Tenant)
+	collection := session.DB(originRef.Database).C(originRef.Collection)
+	selectQuery := bson.M{"_id": originRef.Id.(bson.ObjectId)}
+	withHistory(w, query, time.Now())
+	withGeneration(w, selectQuery, query, generation)
+	err = errInjectedFault
+	if !c.chaos.dropWrite() {
+		writeSelector, update := historyUpdate(collection, w, selectQuery, query)
+		intended := IntendedWrite{Namespace: collection.FullName, Selector: writeSelector, Update: query}
+		err = c.write(w, session, intended, func() error {
+			return withIntermediates(collection, writeSelector, query, update)
+		})
+	}
+	if err == mgo.ErrNotFound && generation > 0 {
+		//the target has a newer generation
+		return nil
+	}
+	c.hooks.afterWrite(w, command, query, err)
+	c.countWrite(w, err)
 	if err != nil {
-		log.Println("Query could not be executed successfully." + err.Error())
-		return
+		c.events.record(EventError, w.Key(), "Update of "+originRef.Database+"."+originRef.Collection+" failed: "+err.Error())
+		logError("Query could not be executed successfully", watchFields(w).withError(err))
+		return err
+	}
+
+	c.invalidateCaches(w, ref.Id, originRef.Id, query)
+	c.verifier.verify(w, collection, selectQuery, query)
+	return nil
+}
+
+//applyPending writes the queued updates of the tracked document reference
+//to the new target, before its values are looked up
+func (c changeTracker) applyPending(w Watch, collection *mgo.Collection, reference interface{}, target interface{}) {
+	for _, pending := range c.pending.take(w, reference, time.Now()) {
+		selector := bson.M{"_id": target}
+		withGeneration(w, selector, pending.update, pending.generation)
+		writeSelector, update := historyUpdate(collection, w, selector, pending.update)
+		intended := IntendedWrite{Namespace: collection.FullName, Selector: writeSelector, Update: pending.update}
+		err := c.write(w, collection.Database.Session, intended, update)
+		if err == mgo.ErrNotFound && pending.generation > 0 {
+			continue
+		}
+
+		c.countWrite(w, err)
+		if err != nil {
+			c.events.record(EventError, w.Key(), "Queued update of "+w.TargetCollection+" failed: "+err.Error())
+		}
 	}
 }
 
+//codePathNotViable is the error of writes below a field that is null
+const codePathNotViable = 28
+
+func pathNotViable(err error) bool {
+	switch e := err.(type) {
+	case *mgo.LastError:
+		return e.Code == codePathNotViable
+	case *mgo.QueryError:
+		return e.Code == codePathNotViable
+	}
+
+	return false
+}
+
+//withIntermediates sends write again after replacing null parents of the
+//fields set by update in the documents of selector with empty documents.
+//Missing parents are created by mongodb itself.
+func withIntermediates(collection *mgo.Collection, selector, update bson.M, write func() error) error {
+	err := write()
+	if !pathNotViable(err) {
+		return err
+	}
+
+	for _, path := range intermediatePaths(update) {
+		query := bson.M{path: bson.M{"$type": 10}}
+		for key, value := range selector {
+			query[key] = value
+		}
+
+		if _, err := collection.UpdateAll(query, bson.M{"$set": bson.M{path: bson.M{}}}); err != nil {
+			return err
+		}
+	}
+
+	return write()
+}
+
 //NewChangeTracker is the default tracker implementation of redkeep
 func NewChangeTracker(session *mgo.Session) Tracker {
-	return &changeTracker{session: session}
+	return &changeTracker{session: session, hooks: newHookRegistry()}
 }
@@ -0,0 +1,222 @@
+package redkeep
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//Transform changes the values of a watch before they are written to the
+//target documents. fields contains the changed tracked fields by their
+//path in the tracked document, the returned fields are written instead.
+//Returning an error skips the write.
+type Transform interface {
+	Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error)
+}
+
+//TransformFactory creates a transform from the options of its configuration
+type TransformFactory func(options json.RawMessage) (Transform, error)
+
+//TransformConfig configures one transform of a watch, options depend on the type.
+//With a Feature the transform only changes the documents the feature
+//is rolled out to, see FeatureFlag.
+type TransformConfig struct {
+	Type    string          `json:"type" validate:"required,min=1"`
+	Options json.RawMessage `json:"options"`
+	Feature string          `json:"feature"`
+}
+
+var (
+	transformTypesMutex sync.RWMutex
+	transformTypes      = map[string]TransformFactory{}
+)
+
+//RegisterTransformType makes a transform available under name
+//for the configuration
+func RegisterTransformType(name string, factory TransformFactory) {
+	transformTypesMutex.Lock()
+	defer transformTypesMutex.Unlock()
+	transformTypes[name] = factory
+}
+
+func getTransformFactory(name string) (TransformFactory, bool) {
+	transformTypesMutex.RLock()
+	defer transformTypesMutex.RUnlock()
+	factory, ok := transformTypes[name]
+	return factory, ok
+}
+
+//NewTransform creates a transform from the given configuration
+func NewTransform(c TransformConfig) (Transform, error) {
+	factory, ok := getTransformFactory(c.Type)
+	if !ok {
+		return nil, fmt.Errorf("Unknown transform type %s", c.Type)
+	}
+
+	return factory(c.Options)
+}
+
+//watchTransforms holds the transforms of all watches by watch key,
+//all methods can be called on nil
+type watchTransforms struct {
+	sync.RWMutex
+	chains   map[string][]gatedTransform
+	features *featureFlags
+}
+
+//gatedTransform is a transform that only runs if its feature is enabled
+type gatedTransform struct {
+	transform Transform
+	feature   string
+}
+
+func newWatchTransforms(watches []Watch, features *featureFlags) (*watchTransforms, error) {
+	transforms := &watchTransforms{chains: map[string][]gatedTransform{}, features: features}
+	return transforms, transforms.add(watches)
+}
+
+//add creates the transforms of watches that were added at runtime
+func (t *watchTransforms) add(watches []Watch) error {
+	chains := map[string][]gatedTransform{}
+	for _, w := range watches {
+		for _, c := range w.Transforms {
+			transform, err := NewTransform(c)
+			if err != nil {
+				return fmt.Errorf("Transform %s of watch %s could not be created: %s", c.Type, w.Key(), err.Error())
+			}
+
+			chains[w.Key()] = append(chains[w.Key()], gatedTransform{transform, c.Feature})
+		}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	for key, chain := range chains {
+		t.chains[key] = chain
+	}
+
+	return nil
+}
+
+//remove drops the transforms of the watches with keys
+func (t *watchTransforms) remove(keys []string) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	for _, key := range keys {
+		delete(t.chains, key)
+	}
+}
+
+func (t *watchTransforms) chain(w Watch) []gatedTransform {
+	if t == nil {
+		return nil
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+	return t.chains[w.Key()]
+}
+
+//required returns the fields the transforms of w that implement
+//FieldsTransform need
+func (t *watchTransforms) required(w Watch) map[string]bool {
+	required := map[string]bool{}
+	for _, gated := range t.chain(w) {
+		if transform, ok := gated.transform.(FieldsTransform); ok {
+			for _, field := range transform.Fields() {
+				required[field] = true
+			}
+		}
+	}
+
+	return required
+}
+
+//completeFields adds the fields the transforms of w need but update does
+//not set from the tracked document with id, if update sets one of them
+func (c changeTracker) completeFields(session *mgo.Session, w Watch, id interface{}, update bson.M) {
+	required := c.transforms.required(w)
+	set, ok := update["$set"].(bson.M)
+	if len(required) == 0 || !ok {
+		return
+	}
+
+	prefix := w.TargetNormalizedField + "."
+	missing, changed := []string{}, false
+	for _, field := range w.TrackFields {
+		if !required[w.targetField(field)] {
+			continue
+		}
+
+		if _, ok := set[prefix+w.targetField(field)]; ok {
+			changed = true
+		} else {
+			missing = append(missing, field)
+		}
+	}
+
+	if !changed || len(missing) == 0 {
+		return
+	}
+
+	p := strings.Index(w.TrackCollection, ".")
+	document := map[string]interface{}{}
+	if err := session.DB(w.TrackCollection[:p]).C(w.TrackCollection[p+1:]).FindId(id).One(&document); err != nil {
+		logWarn("Tracked document could not be read for transforms", watchFields(w).withError(err))
+		return
+	}
+
+	for _, field := range missing {
+		if value, ok := lookupValue(field, document); ok {
+			set[prefix+w.targetField(field)] = value
+		}
+	}
+}
+
+//apply runs the transforms of w in order on the fields set by update for
+//the tracked document with id, a $set without fields left is removed from update
+func (t *watchTransforms) apply(w Watch, id interface{}, update bson.M) error {
+	chain := t.chain(w)
+	set, ok := update["$set"].(bson.M)
+	if len(chain) == 0 || !ok {
+		return nil
+	}
+
+	prefix := w.TargetNormalizedField + "."
+	fields := map[string]interface{}{}
+	for key, value := range set {
+		fields[strings.TrimPrefix(key, prefix)] = value
+	}
+
+	for _, gated := range chain {
+		if !t.features.enabled(gated.feature, w, id) {
+			continue
+		}
+
+		var err error
+		if fields, err = gated.transform.Transform(w, fields); err != nil {
+			return err
+		}
+	}
+
+	if len(fields) == 0 {
+		delete(update, "$set")
+		return nil
+	}
+
+	set = bson.M{}
+	for key, value := range fields {
+		set[prefix+key] = value
+	}
+	update["$set"] = set
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package redkeep_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+//upperTransform upper cases strings and drops the fields of its options
+type upperTransform struct {
+	drop []string
+}
+
+func (u upperTransform) Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for key, value := range fields {
+		if s, ok := value.(string); ok {
+			value = strings.ToUpper(s)
+		}
+		result[key] = value
+	}
+
+	for _, key := range u.drop {
+		delete(result, key)
+	}
+
+	return result, nil
+}
+
+func init() {
+	RegisterTransformType("upper", func(options json.RawMessage) (Transform, error) {
+		var drop []string
+		if options != nil {
+			if err := json.Unmarshal(options, &drop); err != nil {
+				return nil, err
+			}
+		}
+
+		return upperTransform{drop}, nil
+	})
+
+	RegisterTransformType("refusing", func(options json.RawMessage) (Transform, error) {
+		return refusingTransform{}, nil
+	})
+}
+
+type refusingTransform struct{}
+
+func (refusingTransform) Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error) {
+	return nil, errors.New("refused")
+}
+
+var _ = Describe("Transforms", func() {
+	watch := func(transforms ...TransformConfig) Watch {
+		return Watch{
+			TrackCollection:       "live.user",
+			TrackFields:           []string{"username", "name"},
+			TargetCollection:      "live.comment",
+			TargetNormalizedField: "meta",
+			TriggerReference:      "user",
+			Transforms:            transforms,
+		}
+	}
+
+	It("changes the values that are set", func() {
+		update := bson.M{"$set": bson.M{"meta.username": "alice", "meta.name": "Alice"}}
+		Expect(ApplyTransforms(watch(TransformConfig{Type: "upper"}), update)).To(Succeed())
+		Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.username": "ALICE", "meta.name": "ALICE"}}))
+	})
+
+	It("runs the transforms in order and removes empty updates", func() {
+		update := bson.M{"$set": bson.M{"meta.username": "alice"}}
+		w := watch(
+			TransformConfig{Type: "upper"},
+			TransformConfig{Type: "upper", Options: json.RawMessage(`["username"]`)},
+		)
+		Expect(ApplyTransforms(w, update)).To(Succeed())
+		Expect(update).To(BeEmpty())
+	})
+
+	It("leaves unsets alone", func() {
+		update := bson.M{"$unset": bson.M{"meta.username": ""}}
+		Expect(ApplyTransforms(watch(TransformConfig{Type: "upper"}), update)).To(Succeed())
+		Expect(update).To(Equal(bson.M{"$unset": bson.M{"meta.username": ""}}))
+	})
+
+	It("returns errors of transforms", func() {
+		update := bson.M{"$set": bson.M{"meta.username": "alice"}}
+		Expect(ApplyTransforms(watch(TransformConfig{Type: "refusing"}), update)).To(MatchError("refused"))
+	})
+
+	It("lowercases and truncates the selected strings", func() {
+		update := bson.M{"$set": bson.M{"meta.username": "AliceWonder", "meta.name": "Alice Liddell"}}
+		w := watch(
+			TransformConfig{Type: "lowercase", Options: json.RawMessage(`{"fields": ["username"]}`)},
+			TransformConfig{Type: "truncate", Options: json.RawMessage(`{"length": 5}`)},
+		)
+		Expect(ApplyTransforms(w, update)).To(Succeed())
+		Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.username": "alice", "meta.name": "Alice"}}))
+	})
+
+	It("formats dates", func() {
+		born := time.Date(2024, 5, 2, 23, 30, 0, 0, time.UTC)
+		update := bson.M{"$set": bson.M{"meta.name": born, "meta.username": "alice"}}
+		w := watch(TransformConfig{Type: "dateFormat", Options: json.RawMessage(`{"layout": "2006-01-02", "location": "Europe/Berlin"}`)})
+		Expect(ApplyTransforms(w, update)).To(Succeed())
+		Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.name": "2024-05-03", "meta.username": "alice"}}))
+	})
+
+	It("concats fields into a target", func() {
+		update := bson.M{"$set": bson.M{"meta.username": "alice", "meta.name": "Alice"}}
+		w := watch(TransformConfig{Type: "concat", Options: json.RawMessage(`{"fields": ["name", "username"], "separator": " / ", "target": "display"}`)})
+		Expect(ApplyTransforms(w, update)).To(Succeed())
+		Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.display": "Alice / alice"}}))
+	})
+
+	It("keeps the concatenated fields if asked to", func() {
+		update := bson.M{"$set": bson.M{"meta.name": "Alice"}}
+		w := watch(TransformConfig{Type: "concat", Options: json.RawMessage(`{"fields": ["name", "username"], "target": "display", "keep": true}`)})
+		Expect(ApplyTransforms(w, update)).To(Succeed())
+		Expect(update).To(Equal(bson.M{"$set": bson.M{"meta.name": "Alice", "meta.display": "Alice"}}))
+	})
+
+	It("rejects builtin transforms without their options", func() {
+		_, err := NewTransform(TransformConfig{Type: "truncate"})
+		Expect(err).To(MatchError("Truncate needs a length"))
+		_, err = NewTransform(TransformConfig{Type: "dateFormat"})
+		Expect(err).To(MatchError("Date formats need a layout"))
+		_, err = NewTransform(TransformConfig{Type: "concat", Options: json.RawMessage(`{"fields": ["name"]}`)})
+		Expect(err).To(MatchError("Concat needs fields and a target"))
+	})
+
+	It("rejects unknown transform types in the configuration", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": { "connectionURI": "localhost:30000" },
+			"watches": [{
+				"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+				"targetNormalizedField": "meta", "triggerReference": "user", "transforms": [{ "type": "magic" }]
+			}]
+		}`))
+		Expect(err).To(MatchError("Unknown transform type magic"))
+	})
+
+	It("fails on plugins that cannot be loaded", func() {
+		_, err := NewConfiguration([]byte(`{
+			"mongo": { "connectionURI": "localhost:30000" },
+			"plugins": ["/does/not/exist.so"],
+			"watches": [{
+				"trackCollection": "live.user", "trackFields": ["username"], "targetCollection": "live.comment",
+				"targetNormalizedField": "meta", "triggerReference": "user"
+			}]
+		}`))
+		Expect(err).To(MatchError(HavePrefix("Plugin /does/not/exist.so could not be loaded")))
+	})
+})
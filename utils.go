@@ -1,6 +1,12 @@
 package redkeep
 
-import "strings"
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
 
 //GetValue works like this:
 //from must be a selector like user.comment.author
@@ -10,15 +16,66 @@ import "strings"
 //nil
 //string
 //or basic mongodb types
+//paths can be arbitrarily deep, numbers select elements of arrays like in
+//comments.0.user and keys with dots like those of $set are found as well
 func GetValue(from string, ds interface{}) interface{} {
-	data, ok := ds.(map[string]interface{})
-	if !ok {
-		return nil
+	value, _ := lookupValue(from, ds)
+	return value
+}
+
+//lookupValue returns the value of the path from in ds and whether it exists.
+//The longest key of a document that matches the start of the path wins, so
+//$set.meta.owner finds the key meta.owner of $set.
+func lookupValue(from string, ds interface{}) (interface{}, bool) {
+	switch data := ds.(type) {
+	case map[string]interface{}:
+		return lookupKey(from, data)
+	case bson.M:
+		return lookupKey(from, data)
+	case []interface{}:
+		index, rest := from, ""
+		if p := strings.Index(from, "."); p != -1 {
+			index, rest = from[:p], from[p+1:]
+		}
+
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(data) {
+			return nil, false
+		}
+
+		if rest == "" {
+			return data[i], true
+		}
+
+		return lookupValue(rest, data[i])
+	}
+
+	return nil, false
+}
+
+func lookupKey(from string, data map[string]interface{}) (interface{}, bool) {
+	if value, ok := data[from]; ok {
+		return value, true
 	}
 
-	if index := strings.Index(from, "."); index != -1 {
-		return GetValue(from[index+1:], data[from[:index]])
+	for p := strings.LastIndex(from, "."); p != -1; p = strings.LastIndex(from[:p], ".") {
+		if value, ok := data[from[:p]]; ok {
+			if found, ok := lookupValue(from[p+1:], value); ok {
+				return found, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+//sortedKeys returns the keys of m in ascending order
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	return data[from]
+	return keys
 }
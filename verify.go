@@ -0,0 +1,119 @@
+package redkeep
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultVerifyMaxDocuments = 10
+
+//VerifySettings enable reading back target documents after a write.
+//SamplePercent of the writes are verified, 100 verifies every write and
+//zero disables verification. At most MaxDocuments (default 10) documents
+//of one write are read back.
+type VerifySettings struct {
+	SamplePercent float64 `json:"samplePercent" validate:"min=0,max=100"`
+	MaxDocuments  int     `json:"maxDocuments" validate:"min=0"`
+}
+
+//writeVerifier reads back written target documents and reports tracked
+//fields that do not have the written value, all methods can be called on nil
+type writeVerifier struct {
+	sync.Mutex
+	settings VerifySettings
+	random   *rand.Rand
+	metrics  *metricRegistry
+	events   *eventLog
+}
+
+func newWriteVerifier(settings VerifySettings, metrics *metricRegistry, events *eventLog) *writeVerifier {
+	if settings.SamplePercent <= 0 {
+		return nil
+	}
+
+	if settings.MaxDocuments == 0 {
+		settings.MaxDocuments = defaultVerifyMaxDocuments
+	}
+
+	return &writeVerifier{
+		settings: settings,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		metrics:  metrics,
+		events:   events,
+	}
+}
+
+func (v *writeVerifier) sample() bool {
+	v.Lock()
+	defer v.Unlock()
+	return v.random.Float64()*100 < v.settings.SamplePercent
+}
+
+//verify reads back the documents of selector after update was written
+func (v *writeVerifier) verify(w Watch, collection *mgo.Collection, selector bson.M, update bson.M) {
+	if v == nil || !v.sample() {
+		return
+	}
+
+	documents := []map[string]interface{}{}
+	if err := collection.Find(selector).Limit(v.settings.MaxDocuments).All(&documents); err != nil {
+		logWarn("Write could not be verified", errorFields(err))
+		return
+	}
+
+	v.metrics.add(MetricVerifiedWrites, 1)
+	for _, document := range documents {
+		v.check(w, document, update)
+	}
+}
+
+//check counts a divergence if document does not contain the values of update
+func (v *writeVerifier) check(w Watch, document map[string]interface{}, update bson.M) {
+	fields := divergentFields(update, document)
+	if len(fields) == 0 {
+		return
+	}
+
+	v.metrics.add(MetricWriteDivergences, 1)
+	v.events.record(EventAlert, w.Key(), fmt.Sprintf("Target %v of %s diverged after write in %v", document["_id"], w.TargetCollection, fields))
+}
+
+//divergentFields returns the fields of a $set or $unset update that
+//document does not match, other update operators are not checked
+func divergentFields(update bson.M, document map[string]interface{}) []string {
+	fields := []string{}
+	for operator, values := range update {
+		var changes map[string]interface{}
+		switch typed := values.(type) {
+		case bson.M:
+			changes = typed
+		case map[string]interface{}:
+			changes = typed
+		default:
+			continue
+		}
+
+		for field, value := range changes {
+			current := GetValue(field, document)
+			switch operator {
+			case "$set":
+				if fmt.Sprint(current) != fmt.Sprint(value) {
+					fields = append(fields, field)
+				}
+			case "$unset":
+				if current != nil {
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
@@ -0,0 +1,40 @@
+package redkeep_test
+
+import (
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Write verification", func() {
+	update := bson.M{
+		"$set":   bson.M{"meta.name": "Hans", "meta.age": 42},
+		"$unset": bson.M{"meta.email": ""},
+	}
+
+	It("finds the fields that do not have the written value", func() {
+		Expect(DivergentFields(update, map[string]interface{}{
+			"meta": map[string]interface{}{"name": "Hans", "age": int64(42)},
+		})).To(BeEmpty())
+
+		Expect(DivergentFields(update, map[string]interface{}{
+			"meta": map[string]interface{}{"name": "Peter", "age": 42, "email": "peter@example.com"},
+		})).To(Equal([]string{"meta.email", "meta.name"}))
+	})
+
+	It("ignores update operators it can not check", func() {
+		Expect(DivergentFields(bson.M{"$inc": bson.M{"meta.count": 1}}, map[string]interface{}{})).To(BeEmpty())
+	})
+
+	It("counts every diverged document", func() {
+		metrics := VerifyDocuments(Watch{Name: "userComments", TargetCollection: "app.comment"}, update, []map[string]interface{}{
+			{"_id": "1", "meta": map[string]interface{}{"name": "Hans", "age": 42}},
+			{"_id": "2", "meta": map[string]interface{}{"name": "Peter", "age": 42}},
+		})
+
+		Expect(metrics[MetricWriteDivergences]).To(Equal(1.0))
+	})
+})
@@ -0,0 +1,171 @@
+package redkeep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	defaultWASMTimeout = 100 * time.Millisecond
+	//defaultWASMMemoryLimitPages is 16 MiB in pages of 64 KiB
+	defaultWASMMemoryLimitPages = 256
+
+	wasmAlloc     = "redkeep_alloc"
+	wasmTransform = "redkeep_transform"
+)
+
+//WASMTransformSettings configures a transform that runs a WebAssembly module.
+//The module gets no imports, so it cannot reach files, the network or clocks.
+//It has to export its memory and the functions
+//
+//  redkeep_alloc(size i32) i32
+//  redkeep_transform(pointer i32, length i32) i64
+//
+//The input {"watch": "<key>", "fields": {...}} is written as json to the memory
+//returned by redkeep_alloc, then redkeep_transform is called. It returns the
+//pointer of its json output in the upper and the length in the lower 32 bits.
+//The output is {"fields": {...}} with the fields to write or {"error": "..."}
+//to skip the write. Every call runs in a new instance of the module and
+//is stopped after Timeout (default 100ms).
+type WASMTransformSettings struct {
+	Module           string   `json:"module" validate:"required,min=1"`
+	Timeout          Duration `json:"timeout"`
+	MemoryLimitPages uint32   `json:"memoryLimitPages"`
+}
+
+type wasmInput struct {
+	Watch  string                 `json:"watch"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+type wasmOutput struct {
+	Fields map[string]interface{} `json:"fields"`
+	Error  string                 `json:"error"`
+}
+
+type wasmTransformer struct {
+	settings WASMTransformSettings
+	runtime  wazero.Runtime
+	module   wazero.CompiledModule
+}
+
+func init() {
+	RegisterTransformType("wasm", func(options json.RawMessage) (Transform, error) {
+		var settings WASMTransformSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewWASMTransform(settings)
+	})
+}
+
+//NewWASMTransform compiles the module of the settings
+func NewWASMTransform(settings WASMTransformSettings) (Transform, error) {
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, errors.New("WASM transforms need a module")
+	}
+
+	if settings.Timeout.Duration <= 0 {
+		settings.Timeout.Duration = defaultWASMTimeout
+	}
+
+	if settings.MemoryLimitPages == 0 {
+		settings.MemoryLimitPages = defaultWASMMemoryLimitPages
+	}
+
+	binary, err := ioutil.ReadFile(settings.Module)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(settings.MemoryLimitPages).
+		WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+
+	module, err := runtime.CompileModule(ctx, binary)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("WASM module %s could not be compiled: %s", settings.Module, err.Error())
+	}
+
+	return &wasmTransformer{settings: settings, runtime: runtime, module: module}, nil
+}
+
+func (t *wasmTransformer) Transform(w Watch, fields map[string]interface{}) (map[string]interface{}, error) {
+	input, err := json.Marshal(wasmInput{Watch: w.Key(), Fields: fields})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.settings.Timeout.Duration)
+	defer cancel()
+
+	instance, err := t.runtime.InstantiateModule(ctx, t.module, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Close(context.Background())
+
+	alloc := instance.ExportedFunction(wasmAlloc)
+	transform := instance.ExportedFunction(wasmTransform)
+	memory := instance.Memory()
+	if alloc == nil || transform == nil || memory == nil {
+		return nil, fmt.Errorf("WASM module %s must export memory, %s and %s", t.settings.Module, wasmAlloc, wasmTransform)
+	}
+
+	results, err := alloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+
+	pointer := uint32(results[0])
+	if !memory.Write(pointer, input) {
+		return nil, fmt.Errorf("WASM module %s allocated memory out of range", t.settings.Module)
+	}
+
+	results, err = transform.Call(ctx, uint64(pointer), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+
+	output, ok := memory.Read(unpackWASMResult(results[0]))
+	if !ok {
+		return nil, fmt.Errorf("WASM module %s returned output out of range", t.settings.Module)
+	}
+
+	return decodeWASMOutput(output)
+}
+
+//unpackWASMResult splits the result of redkeep_transform
+//into pointer and length of the output
+func unpackWASMResult(result uint64) (uint32, uint32) {
+	return uint32(result >> 32), uint32(result)
+}
+
+func decodeWASMOutput(data []byte) (map[string]interface{}, error) {
+	var output wasmOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("WASM transform returned invalid json: %s", err.Error())
+	}
+
+	if output.Error != "" {
+		return nil, fmt.Errorf("WASM transform failed: %s", output.Error)
+	}
+
+	if output.Fields == nil {
+		output.Fields = map[string]interface{}{}
+	}
+
+	return output.Fields, nil
+}
@@ -0,0 +1,54 @@
+package redkeep_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WASM transforms", func() {
+	It("needs a module", func() {
+		_, err := NewWASMTransform(WASMTransformSettings{})
+		Expect(err).To(MatchError("WASM transforms need a module"))
+
+		_, err = NewWASMTransform(WASMTransformSettings{Module: "/does/not/exist.wasm"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects modules that cannot be compiled", func() {
+		file, err := ioutil.TempFile("", "redkeep-wasm")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(file.Name())
+		file.WriteString("not webassembly")
+		file.Close()
+
+		_, err = NewWASMTransform(WASMTransformSettings{Module: file.Name()})
+		Expect(err).To(MatchError(HavePrefix("WASM module " + file.Name() + " could not be compiled")))
+	})
+
+	It("unpacks pointer and length of the output", func() {
+		pointer, length := UnpackWASMResult(1024<<32 | 17)
+		Expect(pointer).To(Equal(uint32(1024)))
+		Expect(length).To(Equal(uint32(17)))
+	})
+
+	It("decodes the output", func() {
+		fields, err := DecodeWASMOutput([]byte(`{"fields": {"username": "ALICE"}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal(map[string]interface{}{"username": "ALICE"}))
+
+		fields, err = DecodeWASMOutput([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(BeEmpty())
+
+		_, err = DecodeWASMOutput([]byte(`{"error": "no thanks"}`))
+		Expect(err).To(MatchError("WASM transform failed: no thanks"))
+
+		_, err = DecodeWASMOutput([]byte(`fields`))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,375 @@
+package redkeep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//watchCheckpoints store the position of every watch next to the checkpoint
+//of the agent, in the documents <name>/<watch key> or in <file>.watches.
+//The position of a watch follows the watermarks until the watch is paused
+//or one of its writes failed, then it stays until the watch caught up.
+type watchCheckpoints struct {
+	sync.Mutex
+	settings  CheckpointSettings
+	session   *mgo.Session
+	watches   *watchSet
+	positions map[string]checkpointDocument
+	//held are the watches whose position does not follow the watermarks
+	held map[string]bool
+}
+
+func newWatchCheckpoints(settings CheckpointSettings, session *mgo.Session, watches *watchSet) *watchCheckpoints {
+	if settings.Name == "" {
+		settings.Name = defaultCheckpointName
+	}
+
+	return &watchCheckpoints{
+		settings:  settings,
+		session:   session,
+		watches:   watches,
+		positions: map[string]checkpointDocument{},
+		held:      map[string]bool{},
+	}
+}
+
+//Send ignores change events, only watermarks are stored
+func (c *watchCheckpoints) Send(e ChangeEvent) error {
+	return nil
+}
+
+//Close does nothing, the last watermark was stored before
+func (c *watchCheckpoints) Close() error {
+	return nil
+}
+
+//Watermark moves the positions of all watches that are not held to ts
+func (c *watchCheckpoints) Watermark(ts bson.MongoTimestamp) error {
+	c.Lock()
+	defer c.Unlock()
+	changed := map[string]checkpointDocument{}
+	for _, w := range c.watches.list() {
+		if c.held[w.Key()] {
+			continue
+		}
+
+		document := checkpointDocument{Timestamp: ts, Updated: time.Now()}
+		c.positions[w.Key()] = document
+		changed[w.Key()] = document
+	}
+
+	if c.settings.File != "" {
+		return c.write()
+	}
+
+	session := checkpointSession(c.settings, c.session)
+	defer session.Close()
+	collection := (&checkpoint{settings: c.settings}).collection(session)
+	for key, document := range changed {
+		if _, err := collection.UpsertId(c.settings.Name+"/"+key, document); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//write stores all positions in the file, c is locked
+func (c *watchCheckpoints) write() error {
+	data, err := json.Marshal(c.positions)
+	if err != nil {
+		return err
+	}
+
+	return writeCheckpointFile(c.settings.File+".watches", data, c.settings.Durable)
+}
+
+//load reads the stored positions, watches that are behind
+//agent, the checkpoint of the agent, are held
+func (c *watchCheckpoints) load(agent bson.MongoTimestamp) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.settings.File != "" {
+		data, err := ioutil.ReadFile(c.settings.File + ".watches")
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &c.positions); err != nil {
+			return err
+		}
+	} else {
+		session := c.session.Copy()
+		defer session.Close()
+		collection := (&checkpoint{settings: c.settings}).collection(session)
+		for _, w := range c.watches.list() {
+			var document checkpointDocument
+			err := collection.FindId(c.settings.Name + "/" + w.Key()).One(&document)
+			if err == mgo.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			c.positions[w.Key()] = document
+		}
+	}
+
+	for key, document := range c.positions {
+		if document.Timestamp < agent {
+			c.held[key] = true
+		}
+	}
+
+	return nil
+}
+
+//hold keeps the position of the watch with key, it is called
+//on failed writes and can be called on nil
+func (c *watchCheckpoints) hold(key string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.held[key] = true
+}
+
+//release lets the position of the watch with key follow
+//the watermarks again, it can be called on nil
+func (c *watchCheckpoints) release(key string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	delete(c.held, key)
+}
+
+//position is the stored position of the watch with key and whether it is held
+func (c *watchCheckpoints) position(key string) (bson.MongoTimestamp, bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.positions[key].Timestamp, c.held[key]
+}
+
+//WatchCheckpoint is the position of a watch in the oplog, Held
+//watches are paused or failed and wait to catch up
+type WatchCheckpoint struct {
+	Checkpoint bson.MongoTimestamp `json:"checkpoint"`
+	Held       bool                `json:"held"`
+	Paused     bool                `json:"paused"`
+}
+
+//WatchCheckpoints returns the positions of all watches, it
+//is empty without watch checkpoints
+func (t *TailAgent) WatchCheckpoints() map[string]WatchCheckpoint {
+	positions := map[string]WatchCheckpoint{}
+	if t.watchCheckpoints == nil {
+		return positions
+	}
+
+	for _, w := range t.watches.list() {
+		ts, held := t.watchCheckpoints.position(w.Key())
+		positions[w.Key()] = WatchCheckpoint{Checkpoint: ts, Held: held, Paused: t.watches.paused(w.Key())}
+	}
+
+	return positions
+}
+
+//watch returns the watch with key
+func (t *TailAgent) watch(key string) (Watch, error) {
+	for _, w := range t.watches.list() {
+		if w.Key() == key {
+			return w, nil
+		}
+	}
+
+	return Watch{}, fmt.Errorf("Unknown watch %s", key)
+}
+
+//PauseWatch stops tracking the watch with key while the other watches go
+//on, its checkpoint stays where it is. It needs watch checkpoints.
+func (t *TailAgent) PauseWatch(key string) error {
+	if t.watchCheckpoints == nil {
+		return errors.New("Pausing a watch needs checkpoint.watches")
+	}
+
+	if _, err := t.watch(key); err != nil {
+		return err
+	}
+
+	t.watchCheckpoints.hold(key)
+	t.watches.pause(key)
+	t.events.record(EventLifecycle, key, "Watch paused")
+	return nil
+}
+
+//ResumeWatch tracks the paused watch with key again and catches it up
+//from its checkpoint, see CatchUpWatch
+func (t *TailAgent) ResumeWatch(key string) error {
+	if _, err := t.watch(key); err != nil {
+		return err
+	}
+
+	t.watches.resume(key)
+	t.events.record(EventLifecycle, key, "Watch resumed")
+	return t.CatchUpWatch(key)
+}
+
+//CatchUpWatch handles the oplog entries since the checkpoint of the watch
+//with key for this watch only, in the background. Entries of documents that
+//are changed live meanwhile are skipped. Once it is done the checkpoint of
+//the watch follows the watermarks again. A watch without checkpoint or whose
+//checkpoint is older than the oldest oplog entry is rescanned instead.
+func (t *TailAgent) CatchUpWatch(key string) error {
+	if t.watchCheckpoints == nil {
+		return errors.New("Catching up a watch needs checkpoint.watches")
+	}
+
+	w, err := t.watch(key)
+	if err != nil {
+		return err
+	}
+
+	from, held := t.watchCheckpoints.position(key)
+	if !held {
+		return fmt.Errorf("Watch %s is not behind", key)
+	}
+
+	if from == 0 {
+		return t.rescanBehind(key, "The watch has no checkpoint")
+	}
+
+	session := t.session.Copy()
+	oplog := session.DB("local").C("oplog.rs")
+	oldest, err := oldestOplogEntry(oplog)
+	if err != nil {
+		session.Close()
+		return err
+	}
+
+	if oldest > from {
+		session.Close()
+		return t.rescanBehind(key, fmt.Sprintf("The oplog rotated past the checkpoint %d of the watch", from))
+	}
+
+	to, err := newestOplogEntry(oplog)
+	if err != nil {
+		session.Close()
+		return err
+	}
+
+	backlog := newCatchUp()
+	t.catchUps.add(backlog)
+	err = t.rescans.run(key, func(ctx context.Context) error {
+		defer session.Close()
+		defer t.catchUps.remove(backlog)
+		defer backlog.finish()
+		return t.catchUpWatch(ctx, session, w, from, to, backlog)
+	})
+	if err != nil {
+		t.catchUps.remove(backlog)
+		session.Close()
+	}
+
+	return err
+}
+
+//rescanBehind rescans the watch with key whose missed changes
+//are not in the oplog, see RescanWatch
+func (t *TailAgent) rescanBehind(key, reason string) error {
+	t.events.record(EventLifecycle, key, reason+", rescanning it")
+	return t.RescanWatch(key)
+}
+
+//catchUpWatch handles the entries after from up to to for w
+func (t *TailAgent) catchUpWatch(ctx context.Context, session *mgo.Session, w Watch, from, to bson.MongoTimestamp, backlog *catchUp) error {
+	t.events.record(EventLifecycle, w.Key(), fmt.Sprintf("Catching up the watch from %d to %d", from, to))
+	query := session.DB("local").C("oplog.rs").Find(bson.M{"ts": bson.M{"$gt": from, "$lte": to}})
+	iter := query.LogReplay().Sort("$natural").Iter()
+
+	handled, skipped := 0, 0
+	entry := map[string]interface{}{}
+	for iter.Next(&entry) {
+		if ctx.Err() != nil {
+			iter.Close()
+			return ctx.Err()
+		}
+
+		if entry["op"] != "c" && !fromMigration(entry) {
+			if backlog.handleBacklog(entry, func(current map[string]interface{}) {
+				analyzeResult(current, []Watch{w}, t.tracker, t.sinks, t.unknown, nil)
+			}) {
+				handled++
+			} else {
+				skipped++
+			}
+		}
+
+		entry = map[string]interface{}{}
+	}
+
+	if err := iter.Close(); err != nil {
+		t.events.record(EventError, w.Key(), "Catch up failed: "+err.Error())
+		return err
+	}
+
+	t.watchCheckpoints.release(w.Key())
+	t.events.record(EventLifecycle, w.Key(), fmt.Sprintf("Catch up done, %d oplog entries handled, %d skipped", handled, skipped))
+	return nil
+}
+
+//catchUps are the running catch ups of watches, the live
+//tail marks the documents it handles in all of them
+type catchUps struct {
+	sync.Mutex
+	running []*catchUp
+}
+
+func (c *catchUps) add(backlog *catchUp) {
+	c.Lock()
+	defer c.Unlock()
+	c.running = append(c.running, backlog)
+}
+
+func (c *catchUps) remove(backlog *catchUp) {
+	c.Lock()
+	defer c.Unlock()
+	running := []*catchUp{}
+	for _, r := range c.running {
+		if r != backlog {
+			running = append(running, r)
+		}
+	}
+	c.running = running
+}
+
+//handledLive marks the document of a live entry in all catch ups,
+//it can be called on nil
+func (c *catchUps) handledLive(entry map[string]interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for _, backlog := range c.running {
+		backlog.handledLive(entry)
+	}
+}
@@ -0,0 +1,88 @@
+package redkeep_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Watch checkpoints", func() {
+	var directory string
+
+	watch := func(name string) Watch {
+		return Watch{
+			Name:                  name,
+			TrackCollection:       "app.user",
+			TrackFields:           []string{"username"},
+			TargetCollection:      "app." + name,
+			TargetNormalizedField: "meta.user",
+			TriggerReference:      "user",
+		}
+	}
+	watches := []Watch{watch("comments"), watch("reviews")}
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "redkeep-watch-checkpoint")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	It("will hold the checkpoint of a watch whose write failed", func() {
+		file := filepath.Join(directory, "checkpoint.json")
+		agent, _, _, _ := ControlledAgent(watches)
+		UseWatchCheckpoints(agent, file)
+
+		Expect(WatchCheckpointSink(agent).Watermark(10)).To(Succeed())
+		FailWatchWrite(agent, "reviews")
+		Expect(WatchCheckpointSink(agent).Watermark(20)).To(Succeed())
+		Expect(agent.WatchCheckpoints()).To(Equal(map[string]WatchCheckpoint{
+			"comments": {Checkpoint: 20},
+			"reviews":  {Checkpoint: 10, Held: true},
+		}))
+
+		restarted, _, _, _ := ControlledAgent(watches)
+		Expect(LoadWatchCheckpoints(restarted, file, bson.MongoTimestamp(20))).To(Succeed())
+		Expect(restarted.WatchCheckpoints()["reviews"]).To(Equal(WatchCheckpoint{Checkpoint: 10, Held: true}))
+		Expect(restarted.WatchCheckpoints()["comments"].Held).To(BeFalse())
+	})
+
+	It("will stop tracking a paused watch", func() {
+		agent, _, _, _ := ControlledAgent(watches)
+		Expect(agent.PauseWatch("reviews")).To(MatchError("Pausing a watch needs checkpoint.watches"))
+
+		UseWatchCheckpoints(agent, filepath.Join(directory, "checkpoint.json"))
+		Expect(agent.PauseWatch("orders")).To(MatchError("Unknown watch orders"))
+		Expect(agent.PauseWatch("reviews")).To(Succeed())
+		Expect(ActiveWatches(agent)).To(Equal([]string{"comments"}))
+		Expect(agent.WatchCheckpoints()["reviews"]).To(Equal(WatchCheckpoint{Held: true, Paused: true}))
+		Expect(agent.CatchUpWatch("comments")).To(MatchError("Watch comments is not behind"))
+	})
+
+	It("will rescan a resumed watch without checkpoint", func() {
+		agent, _, _, _ := ControlledAgent(watches)
+		UseWatchCheckpoints(agent, filepath.Join(directory, "checkpoint.json"))
+		Expect(agent.PauseWatch("reviews")).To(Succeed())
+
+		//the agent does not tail, the rescan is not started
+		Expect(agent.ResumeWatch("reviews")).To(MatchError("The agent is not tailing"))
+		Expect(ActiveWatches(agent)).To(Equal([]string{"comments", "reviews"}))
+		Expect(agent.Events(EventLifecycle, 1)[0].Message).To(Equal("The watch has no checkpoint, rescanning it"))
+	})
+
+	It("checks the watch checkpoints", func() {
+		config := strings.Replace(templateForTestsConfig, `"watches"`, `"checkpoint": { "watches": true }, "watches"`, 1)
+		_, err := NewConfiguration([]byte(config))
+		Expect(err).To(MatchError("Watch checkpoints need a checkpoint collection or file"))
+	})
+})
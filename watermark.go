@@ -0,0 +1,206 @@
+package redkeep
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//WatermarkSink is implemented by sinks that want to know up to which
+//oplog time all changes were handled. A watermark ts means that every
+//change event with a timestamp up to ts was sent, so downstream stream
+//processors can close their windows. Watermarks only increase.
+type WatermarkSink interface {
+	Watermark(ts bson.MongoTimestamp) error
+}
+
+//watermarks tracks the oplog entries that are handled right now
+//to find the low watermark, all methods can be called on nil. With
+//entries the watermark is also sent once as many entries were handled
+//since the last one.
+type watermarks struct {
+	sync.Mutex
+	sinks    *sinkDispatcher
+	interval time.Duration
+	adaptive *adaptiveBatching
+	inflight map[bson.MongoTimestamp]int
+	last     bson.MongoTimestamp
+	emitted  bson.MongoTimestamp
+	entries  int
+	handled  int
+	due      chan bool
+	quit     chan bool
+	done     chan bool
+}
+
+func newWatermarks(sinks *sinkDispatcher, interval time.Duration) *watermarks {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &watermarks{sinks: sinks, interval: interval, inflight: map[bson.MongoTimestamp]int{}, due: make(chan bool, 1)}
+}
+
+//begin marks the entry with ts as read, it is handled until end is called
+func (w *watermarks) begin(ts bson.MongoTimestamp) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	w.inflight[ts]++
+	if ts > w.last {
+		w.last = ts
+	}
+}
+
+//advance marks the oplog as read up to ts without entries to handle,
+//so the watermarks of idle oplogs move on as well
+func (w *watermarks) advance(ts bson.MongoTimestamp) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if ts > w.last {
+		w.last = ts
+	}
+}
+
+func (w *watermarks) end(ts bson.MongoTimestamp) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	w.remove(ts)
+	w.handled++
+	if w.entries > 0 && w.handled >= w.entries {
+		select {
+		case w.due <- true:
+		default:
+		}
+	}
+}
+
+//hold keeps the watermark before the entry with ts until release is
+//called, for writes of the entry that are done after it was handled
+func (w *watermarks) hold(ts bson.MongoTimestamp) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	w.inflight[ts]++
+}
+
+func (w *watermarks) release(ts bson.MongoTimestamp) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	w.remove(ts)
+}
+
+//remove ends one handling of the entry with ts, w is locked
+func (w *watermarks) remove(ts bson.MongoTimestamp) {
+	w.inflight[ts]--
+	if w.inflight[ts] <= 0 {
+		delete(w.inflight, ts)
+	}
+}
+
+//low is the latest timestamp up to which all read entries were handled
+func (w *watermarks) low() bson.MongoTimestamp {
+	w.Lock()
+	defer w.Unlock()
+	low := w.last
+	for ts := range w.inflight {
+		if ts-1 < low {
+			low = ts - 1
+		}
+	}
+
+	return low
+}
+
+//emit sends the low watermark to the sinks if it advanced
+func (w *watermarks) emit() {
+	w.Lock()
+	w.handled = 0
+	w.Unlock()
+	low := w.sinks.held(w.low())
+	if low <= w.emitted {
+		return
+	}
+
+	if w.sinks.watermark(low) {
+		w.emitted = low
+	}
+}
+
+func (w *watermarks) start() error {
+	w.quit = make(chan bool)
+	w.done = make(chan bool)
+
+	go func() {
+		timer := time.NewTimer(w.interval)
+		defer timer.Stop()
+		defer close(w.done)
+
+		for {
+			select {
+			case <-w.quit:
+				return
+			case <-w.due:
+				w.emit()
+			case <-timer.C:
+				w.emit()
+				factor := w.adaptive.adjust(w.adaptive.lag())
+				w.sinks.scaleBatches(factor)
+				timer.Reset(w.interval * time.Duration(factor))
+			}
+		}
+	}()
+
+	return nil
+}
+
+//stop sends a last watermark, it is stopped after the workers
+func (w *watermarks) stop() {
+	close(w.quit)
+	<-w.done
+	w.emit()
+}
+
+//watermark commits the batches and sends ts to the sinks, false if
+//a batch failed and ts is held back until the next try
+func (d *sinkDispatcher) watermark(ts bson.MongoTimestamp) bool {
+	d.RLock()
+	defer d.RUnlock()
+	for _, s := range d.sinks {
+		if batch, ok := s.(*batchSink); ok {
+			if err := batch.flush(); err != nil {
+				logWarn("Batch could not be committed, watermark held back", errorFields(err))
+				return false
+			}
+		}
+	}
+
+	for _, s := range d.sinks {
+		if sink, ok := s.(WatermarkSink); ok {
+			if err := sink.Watermark(ts); err != nil {
+				logError("Sink could not handle watermark", errorFields(err))
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,107 @@
+package redkeep_test
+
+import (
+	"gopkg.in/mgo.v2/bson"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type watermarkRecorder struct {
+	watermarks []bson.MongoTimestamp
+}
+
+func (r *watermarkRecorder) Send(e ChangeEvent) error {
+	return nil
+}
+
+func (r *watermarkRecorder) Close() error {
+	return nil
+}
+
+func (r *watermarkRecorder) Watermark(ts bson.MongoTimestamp) error {
+	r.watermarks = append(r.watermarks, ts)
+	return nil
+}
+
+//fullSink has no room for events with the id dropped
+type fullSink struct {
+	watermarkRecorder
+}
+
+func (s *fullSink) Send(e ChangeEvent) error {
+	if e.ID == "dropped" {
+		return ErrSinkFull
+	}
+
+	return nil
+}
+
+var _ = Describe("Watermarks", func() {
+	It("stays behind the oldest entry that is handled", func() {
+		recorder := &watermarkRecorder{}
+		tracker := NewWatermarkTracker(recorder)
+
+		tracker.Begin(10)
+		tracker.Begin(11)
+		tracker.Begin(12)
+		tracker.End(11)
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{9}))
+
+		tracker.End(10)
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{9, 11}))
+
+		tracker.End(12)
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{9, 11, 12}))
+	})
+
+	It("stays before the first event a sink dropped", func() {
+		recorder := &watermarkRecorder{}
+		tracker := NewWatermarkTracker(recorder, &fullSink{})
+
+		for i, id := range []string{"kept", "dropped", "kept"} {
+			ts := bson.MongoTimestamp(10 + i)
+			tracker.Begin(ts)
+			tracker.Send(ChangeEvent{ID: id, Timestamp: ts})
+			tracker.End(ts)
+		}
+		tracker.Emit()
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{10}))
+	})
+
+	It("only sends increasing watermarks", func() {
+		recorder := &watermarkRecorder{}
+		tracker := NewWatermarkTracker(recorder)
+
+		tracker.Begin(5)
+		tracker.End(5)
+		tracker.Emit()
+		tracker.Emit()
+		Expect(recorder.watermarks).To(Equal([]bson.MongoTimestamp{5}))
+	})
+
+	It("is due after the configured number of entries", func() {
+		recorder := &watermarkRecorder{}
+		tracker := NewWatermarkTracker(recorder)
+		tracker.EmitAfter(2)
+
+		tracker.Begin(1)
+		tracker.End(1)
+		Expect(tracker.Due()).To(BeFalse())
+
+		tracker.Begin(2)
+		tracker.End(2)
+		Expect(tracker.Due()).To(BeTrue())
+
+		tracker.Emit()
+		tracker.Begin(3)
+		tracker.End(3)
+		Expect(tracker.Due()).To(BeFalse())
+	})
+})
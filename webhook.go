@@ -0,0 +1,163 @@
+package redkeep
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	//WebhookSignatureHeader has the hex encoded HMAC-SHA256 of the
+	//timestamp, a dot and the body, prefixed with sha256=
+	WebhookSignatureHeader = "X-Redkeep-Signature"
+	//WebhookTimestampHeader has the unix time the request was signed at
+	WebhookTimestampHeader = "X-Redkeep-Timestamp"
+	defaultWebhookTimeout  = 5 * time.Second
+)
+
+//WebhookSinkSettings configures a sink that posts every change event as
+//json to URL. Requests are signed with Secret, see WebhookSignature. Retry
+//sends requests again that failed with a network error, a 5xx or a 429
+//answer, by default 3 attempts without backoff.
+type WebhookSinkSettings struct {
+	URL     string            `json:"url" validate:"required,url"`
+	Secret  string            `json:"secret" validate:"required,min=1"`
+	Headers map[string]string `json:"headers"`
+	Timeout Duration          `json:"timeout"`
+	Retry   RetryPolicy       `json:"retry"`
+}
+
+//webhookError is an answer of the endpoint that is not a success
+type webhookError struct {
+	status  int
+	message string
+}
+
+func (e webhookError) Error() string {
+	return e.message
+}
+
+//webhookRetryable is true for network errors and answers that may
+//succeed later
+func webhookRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if e, ok := err.(webhookError); ok {
+		return e.status >= 500 || e.status == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+type webhookSink struct {
+	settings WebhookSinkSettings
+	client   *http.Client
+}
+
+func init() {
+	RegisterSinkType("webhook", func(options json.RawMessage) (Sink, error) {
+		var settings WebhookSinkSettings
+		if err := json.Unmarshal(options, &settings); err != nil {
+			return nil, err
+		}
+
+		return NewWebhookSink(settings)
+	})
+}
+
+//NewWebhookSink creates a sink that posts every change to a webhook
+func NewWebhookSink(settings WebhookSinkSettings) (Sink, error) {
+	validate := validator.New(&validator.Config{TagName: "validate"})
+	if err := validate.Struct(settings); err != nil {
+		return nil, err
+	}
+
+	if err := checkRetryPolicy(&settings.Retry); err != nil {
+		return nil, err
+	}
+
+	if settings.Retry.Retryable == nil {
+		settings.Retry.Retryable = webhookRetryable
+	}
+
+	timeout := settings.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &webhookSink{settings: settings, client: &http.Client{Timeout: timeout}}, nil
+}
+
+//WebhookSignature returns the signature of body sent at timestamp with
+//secret, receivers compare it to the WebhookSignatureHeader with
+//hmac.Equal and reject old timestamps
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSink) Send(e ChangeEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	policy := s.settings.Retry
+	err = s.post(body)
+	for attempt := 2; attempt <= policy.attempts() && policy.retryable(err); attempt++ {
+		time.Sleep(policy.backoff(attempt, rand.Float64))
+		err = s.post(body)
+	}
+
+	return err
+}
+
+//post signs body and sends it, every attempt is signed on its own
+func (s *webhookSink) post(body []byte) error {
+	request, err := http.NewRequest("POST", s.settings.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range s.settings.Headers {
+		request.Header.Set(k, v)
+	}
+	request.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(WebhookSignatureHeader, WebhookSignature(s.settings.Secret, timestamp, body))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return webhookError{
+			status:  response.StatusCode,
+			message: fmt.Sprintf("Webhook answered with %s: %s", response.Status, bytes.TrimSpace(message)),
+		}
+	}
+
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package redkeep_test
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	. "github.com/manyminds/redkeep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhook sink", func() {
+	type received struct {
+		body, signature, timestamp, token string
+	}
+
+	var (
+		server   *httptest.Server
+		mutex    sync.Mutex
+		requests []received
+		answers  []int
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		answers = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, received{
+				body:      string(body),
+				signature: r.Header.Get(WebhookSignatureHeader),
+				timestamp: r.Header.Get(WebhookTimestampHeader),
+				token:     r.Header.Get("X-Token"),
+			})
+
+			if len(answers) > 0 {
+				w.WriteHeader(answers[0])
+				answers = answers[1:]
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	event := ChangeEvent{Watch: "userComments", Operation: "u", Namespace: "app.user", ID: "1", Fields: map[string]interface{}{"name": "Anna"}, Timestamp: 7}
+
+	It("will post signed change events", func() {
+		sink, err := NewWebhookSink(WebhookSinkSettings{URL: server.URL, Secret: "secret", Headers: map[string]string{"X-Token": "t"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sink.Send(event)).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		posted := ChangeEvent{}
+		Expect(json.Unmarshal([]byte(requests[0].body), &posted)).To(Succeed())
+		Expect(posted.Watch).To(Equal("userComments"))
+		Expect(posted.Fields).To(Equal(map[string]interface{}{"name": "Anna"}))
+		Expect(requests[0].token).To(Equal("t"))
+
+		timestamp, err := strconv.ParseInt(requests[0].timestamp, 10, 64)
+		Expect(err).ToNot(HaveOccurred())
+		expected := WebhookSignature("secret", timestamp, []byte(requests[0].body))
+		Expect(hmac.Equal([]byte(requests[0].signature), []byte(expected))).To(BeTrue())
+		Expect(requests[0].signature).ToNot(Equal(WebhookSignature("other", timestamp, []byte(requests[0].body))))
+	})
+
+	It("will retry failed requests", func() {
+		sink, err := NewWebhookSink(WebhookSinkSettings{URL: server.URL, Secret: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+
+		answers = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		Expect(sink.Send(event)).To(Succeed())
+		Expect(requests).To(HaveLen(3))
+
+		answers = []int{http.StatusBadRequest}
+		Expect(sink.Send(event)).To(MatchError(ContainSubstring("Webhook answered with 400")))
+		Expect(requests).To(HaveLen(4))
+
+		answers = []int{http.StatusBadGateway, http.StatusBadGateway}
+		sink, err = NewWebhookSink(WebhookSinkSettings{URL: server.URL, Secret: "secret", Retry: RetryPolicy{MaxAttempts: 2}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sink.Send(event)).To(MatchError(ContainSubstring("Webhook answered with 502")))
+		Expect(requests).To(HaveLen(6))
+	})
+
+	It("will be configured as sink", func() {
+		_, err := NewSink(SinkConfig{Type: "webhook", Options: json.RawMessage(`{"url": "http://localhost/hook", "secret": "s", "timeout": "2s"}`)})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = NewSink(SinkConfig{Type: "webhook", Options: json.RawMessage(`{"url": "http://localhost/hook"}`)})
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewSink(SinkConfig{Type: "webhook", Options: json.RawMessage(`{"url": "http://localhost/hook", "secret": "s", "retry": {"multiplier": 0.5}}`)})
+		Expect(err).To(MatchError("Retry multiplier must be at least 1"))
+	})
+})